@@ -1,71 +1,164 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"fmt"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
+	"time"
 
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+	"github.com/LuD1161/restructuring-tnbt/pkg/blob"
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
 	"github.com/LuD1161/restructuring-tnbt/pkg/database/postgres"
+	"github.com/LuD1161/restructuring-tnbt/pkg/eventbus"
+	"github.com/LuD1161/restructuring-tnbt/pkg/graphqlserver"
+	"github.com/LuD1161/restructuring-tnbt/pkg/grpcserver"
+	"github.com/LuD1161/restructuring-tnbt/pkg/health"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/mailer"
+	"github.com/LuD1161/restructuring-tnbt/pkg/membership"
+	"github.com/LuD1161/restructuring-tnbt/pkg/metrics"
 	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/idempotency"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/tenancy"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/versioning"
+	"github.com/LuD1161/restructuring-tnbt/pkg/org"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/LuD1161/restructuring-tnbt/pkg/scim"
+	"github.com/LuD1161/restructuring-tnbt/pkg/tlsconfig"
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
 	"github.com/LuD1161/restructuring-tnbt/pkg/user"
-	runtime "github.com/banzaicloud/logrus-runtime-formatter"
+	"github.com/LuD1161/restructuring-tnbt/pkg/userpb"
+	hashing "github.com/LuD1161/restructuring-tnbt/pkg/utils"
+	"github.com/LuD1161/restructuring-tnbt/pkg/webhook"
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 
 	// _ "github.com/jinzhu/gorm/dialects/mysql"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 
-	_ "github.com/LuD1161/restructuring-tnbt/cmd/tnbt/docs"
+	_ "github.com/LuD1161/restructuring-tnbt/cmd/tnbt/docs/v1"
 )
 
-// @title TNBT Swagger API
-// @version 1.0
-// @description Swagger API for TNBT
-// @termsOfService http://swagger.io/terms/
+// memorySearchTTL : How stale the in-memory user search may get, for the backends without a search index
+const memorySearchTTL = 30 * time.Second
 
-// @contact.name API Support
-// @contact.email aseemshrey@gmail.com
+// apiVersions : Versions served under /<version>, see v1.go
+var apiVersions = []string{"v1"}
 
-// @license.name MIT
-// @license.url https://github.com/github.com/LuD1161/restructuring-tnbt
+// unversionedPaths : Routes outside of the API, never rewritten to a version whatever the Accept header says
+var unversionedPaths = []string{"/healthz", "/readyz", "/metrics", "/swagger", scimBasePath}
 
-// @BasePath /
 func main() {
-	var server bool
-	var dbType, dbURL, serverPort, logLevel string
-	var err error
-
-	flag.StringVar(&dbType, "database", "postgres", "database type [redis, postgres]")
-	flag.StringVar(&serverPort, "port", "3000", "server port to run on")
-	flag.StringVar(&logLevel, "loglevel", "debug", "log level [trace, debug, info, warn, error, fatal, panic], default : debug")
-	flag.BoolVar(&server, "server", false, "run in server mode")
-	flag.Parse()
-
-	log := NewLogger(logLevel)
-	err = godotenv.Load()
-	// Todo : Maybe integrate vault here
-	dbURL = fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"), os.Getenv("DB_NAME"), os.Getenv("DB_PASSWORD"))
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		logrus.Fatalf("Error loading configuration : %v", err)
+	}
+
+	log := logging.New(cfg.Log.Level)
+	logging.SetDefault(log)
+	log.WithField("config", cfg.Redacted()).Info("Effective configuration")
+
+	shutdownTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Error setting up tracing : %v", err)
+	}
+	broker, err := eventbus.Open(cfg.Events)
 	if err != nil {
-		logrus.Fatalf("Error getting env, not comint through %v", err)
-	} else {
-		logrus.Info("We are getting the env values")
+		log.Fatalf("Error connecting to the event broker : %v", err)
 	}
 
+	auth.Configure(cfg.Auth.APISecret, cfg.Auth.TokenExpiry)
+	user.ConfigureCursors(cfg.Auth.APISecret)
+	if err := requestctx.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Error parsing the trusted proxies : %v", err)
+	}
+	hashing.SetHashCost(cfg.Auth.BcryptCost)
+
 	var userRepo user.Repository
+	var searcher user.Searcher
+	var idempotencyStore idempotency.Store
+	var groupRepo scim.GroupRepository
+	var webhookRepo webhook.Repository
+	var outbox user.Outbox
+	var auditStore audit.Store
+	var orgRepo org.Repository
+	var membershipRepo membership.Repository
+	var closeDB func() error
+	checker := health.NewChecker()
+
+	blobStore, err := blob.Open(cfg.Blob)
+	if err != nil {
+		log.Fatalf("Error opening the blob store : %v", err)
+	}
+	checker.Register("blob", cfg.Health.CheckTimeout, health.PingCheck(blobStore))
+	if broker != nil {
+		checker.Register("events", cfg.Health.CheckTimeout, health.PingCheck(broker))
+	}
+	profiles := user.Profiles{Avatars: blobStore, AvatarMaxSize: cfg.Profiles.AvatarMaxSize}
+	if cfg.Profiles.AttributesSchema != "" {
+		if profiles.Attributes, err = user.LoadAttributesSchema(cfg.Profiles.AttributesSchema); err != nil {
+			log.Fatalf("Error loading the attributes schema : %v", err)
+		}
+	}
 
-	switch dbType {
+	switch cfg.Database.Type {
 	case "postgres":
-		pconn := postgresConnection(dbURL)
-		defer pconn.Close()
-		userRepo = postgres.NewPostgresUserRepository(pconn)
-		seedData(pconn)
+		pconn := postgresConnection(cfg.Database.DSN(), log)
+		closeDB = pconn.Close
+		checker.Register("database", cfg.Health.CheckTimeout, health.PingCheck(pconn.DB()))
+		metrics.InstrumentGorm(pconn, cfg.Database.Name)
+		tracing.InstrumentGorm(pconn)
+		userRepo = postgres.NewPostgresUserRepository(pconn, cfg.Database.QueryTimeout)
+		if cfg.Database.Seed {
+			seedData(pconn, log)
+		}
+		if err := postgres.Migrate(pconn); err != nil {
+			log.Fatalf("Error migrating the database : %v", err)
+		}
+		if err := postgres.MigrateSearch(pconn); err != nil {
+			log.WithError(err).Warn("pg_trgm unavailable, falling back to in-memory user search")
+		} else {
+			searcher = postgres.NewPostgresUserSearcher(pconn, cfg.Database.QueryTimeout)
+		}
+		if cfg.Idempotency.Store == "database" {
+			if err := postgres.MigrateIdempotency(pconn); err != nil {
+				log.Fatalf("Error migrating the database : %v", err)
+			}
+			idempotencyStore = postgres.NewPostgresIdempotencyStore(pconn, cfg.Database.QueryTimeout)
+		}
+		if err := postgres.MigrateSCIM(pconn); err != nil {
+			log.Fatalf("Error migrating the database : %v", err)
+		}
+		groupRepo = postgres.NewPostgresGroupRepository(pconn, cfg.Database.QueryTimeout)
+		if err := postgres.MigrateWebhooks(pconn); err != nil {
+			log.Fatalf("Error migrating the database : %v", err)
+		}
+		webhookRepo = postgres.NewPostgresWebhookRepository(pconn, cfg.Database.QueryTimeout)
+		outbox = postgres.NewPostgresOutbox(pconn, cfg.Database.QueryTimeout)
+		if err := postgres.MigrateAudit(pconn); err != nil {
+			log.Fatalf("Error migrating the database : %v", err)
+		}
+		auditStore = postgres.NewPostgresAuditStore(pconn, cfg.Database.QueryTimeout)
+		if err := postgres.MigrateOrganizations(pconn); err != nil {
+			log.Fatalf("Error migrating the database : %v", err)
+		}
+		orgRepo = postgres.NewPostgresOrganizationRepository(pconn, cfg.Database.QueryTimeout)
+		membershipRepo = postgres.NewPostgresMembershipRepository(pconn, cfg.Database.QueryTimeout)
+		if cfg.Tenancy.RowLevelSecurity {
+			if err := postgres.EnableRowLevelSecurity(pconn); err != nil {
+				log.Fatalf("Error enabling row level security : %v", err)
+			}
+		}
 	// case "redis":
 	// 	dbURL = env.EnvString("DATABASE_URL", DefaultRedisUrl)
 	// 	redisPassword = env.EnvString("REDIS_PASSWORD", DefaultRedisPassword)
@@ -76,89 +169,182 @@ func main() {
 		panic("Unknown database")
 	}
 
-	userService := user.NewService(userRepo, log)
-	userHandler := user.NewHandler(userService, log)
+	if searcher == nil {
+		searcher = user.NewMemorySearcher(userRepo, memorySearchTTL)
+	}
+	if idempotencyStore == nil {
+		idempotencyStore = idempotency.NewMemoryStore()
+	}
+	recorder := audit.NewRecorder(auditStore)
+	auth.ConfigureAudit(recorder)
+	userService := user.NewTracedService(user.NewAuditedService(
+		user.NewService(userRepo, searcher, cfg.Users.DeletionGracePeriod, profiles), recorder,
+	))
+	userHandler := user.NewHandler(userService)
+	orgService := org.NewAuditedService(org.NewService(orgRepo), recorder)
+	var invitationMailer membership.Mailer
+	if cfg.SMTP.Host != "" {
+		invitationMailer = membership.NewEmailMailer(mailer.NewSMTPSender(cfg.SMTP), orgService)
+	}
+	membershipService := membership.NewAuditedService(
+		membership.NewService(membershipRepo, userService, cfg.Orgs.InvitationTTL, invitationMailer), recorder,
+	)
+	userSchema, err := graphqlserver.New(user.GraphQLSchema, user.NewGraphQLResolver(userService))
+	if err != nil {
+		log.Fatalf("Error parsing the GraphQL schema : %v", err)
+	}
 
-	router := gin.Default()
+	gin.SetMode(cfg.Server.Mode)
+	router := gin.New()
+	// requestctx.ClientIP tells the client's address, only believing the trusted proxies
+	router.ForwardedByClientIP = false
+	router.Use(
+		requestctx.Middleware(),
+		logging.Middleware(log),
+		logging.Recovery(log),
+		metrics.Middleware(),
+		tracing.Middleware(),
+		problem.Middleware(),
+	)
 
-	url := ginSwagger.URL("http://localhost:" + serverPort + "/swagger/doc.json")
-	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, url))
+	url := ginSwagger.URL("http://localhost:" + cfg.Server.Port + "/swagger/v1/doc.json")
+	router.GET("/swagger/v1/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, url))
+	router.GET("/swagger/index.html", func(c *gin.Context) {
+		c.Redirect(http.StatusMovedPermanently, "/swagger/v1/index.html")
+	})
 
-	router.POST("/login", userHandler.Login)
-	router.POST("/user", userHandler.CreateUser)
+	router.GET("/healthz", checker.Liveness)
+	router.GET("/readyz", checker.Readiness)
+	router.GET("/metrics", metrics.Handler())
 
-	authorized := router.Group("/")
-	authorized.Use(auth.SetMiddleWareAuthentication())
-	authorized.GET("/user/:id", userHandler.GetUserByID)
-	authorized.PUT("/user", userHandler.UpdateUser)
+	idempotent := idempotency.Middleware(idempotencyStore, cfg.Idempotency.KeyTTL, cfg.Idempotency.MaxBodySize)
+	tenants := tenancy.NewResolver(cfg.Tenancy)
+	tenant := tenancy.Middleware(tenants)
+	v1 := router.Group("/v1")
+	v1.Use(versioning.Version("v1"))
+	// The operators' routes below are deployment wide, the users' ones act in a tenant
+	api := v1.Group("/")
+	api.Use(tenant)
+	registerV1(api, userHandler, membershipService, idempotent)
+	registerGraphQL(api, userService, membershipService, userSchema)
+	registerOrgs(api, org.NewHandler(orgService), membership.NewHandler(membershipService, orgService), membershipService, idempotent)
+	if cfg.Admin.Token != "" {
+		registerWebhooks(v1, webhook.NewHandler(webhookRepo), cfg.Admin.Token, idempotent)
+		registerAudit(v1, audit.NewHandler(auditStore), cfg.Admin.Token)
+		registerUserAdmin(api, userHandler, cfg.Admin.Token)
+	}
+	registerLegacy(router, cfg.API, userHandler, membershipService, idempotent, tenant)
+	if cfg.SCIM.Token != "" {
+		registerSCIM(router, scim.NewHandler(userRepo, groupRepo, scimBasePath, recorder), cfg.SCIM.Token, recorder, idempotent, tenant)
+	}
 
 	// http.Handle("/", accessControl(middleware.Authenticate(router)))
 
-	errs := make(chan error, 2)
-	var docker = "false"
-	go func() {
-		if server || docker == "true" {
-			logrus.Info("Listening server mode on port :3001")
-			errs <- http.ListenAndServe(":3001", nil)
-		} else {
-			logrus.Info("Listening locally on port :" + serverPort)
-			errs <- router.Run(":" + serverPort)
+	// The admin methods skip the users' authentication, the admin token stands for it
+	unauthenticated := append(append([]string{}, user.PublicGRPCMethods...), user.AdminGRPCMethods...)
+	grpcSrv := grpcserver.New(log,
+		tenancy.UnaryServerInterceptor(tenants),
+		auth.StaticTokenInterceptor(cfg.Admin.Token, user.AdminGRPCMethods...),
+		auth.UnaryServerInterceptor(unauthenticated...),
+	)
+	userpb.RegisterUserServiceServer(grpcSrv, user.NewGRPCServer(userService))
+
+	srv := newHTTPServer(cfg.Server, grpcserver.Handler(grpcSrv, versioning.Negotiate(router, apiVersions, unversionedPaths...)))
+	purger := user.NewPurger(userRepo, cfg.Users.DeletionGracePeriod,
+		groupRepo.RemoveMembers, membershipRepo.RemoveUsers, user.RemoveAvatars(blobStore))
+	go purger.Run(cfg.Users.PurgeInterval, log)
+	sweeper := idempotency.NewSweeper(idempotencyStore)
+	go sweeper.Run(cfg.Idempotency.SweepInterval, log)
+	dispatcher := webhook.NewDispatcher(webhookRepo, webhook.Options{
+		Timeout:        cfg.Webhooks.Timeout,
+		MaxAttempts:    cfg.Webhooks.MaxAttempts,
+		InitialBackoff: cfg.Webhooks.InitialBackoff,
+		MaxBackoff:     cfg.Webhooks.MaxBackoff,
+	}, log)
+	go dispatcher.Run(cfg.Webhooks.PollInterval)
+	publishers := []user.Publisher{webhook.NewPublisher(webhookRepo)}
+	if broker != nil {
+		publishers = append(publishers, eventbus.NewPublisher(broker))
+	}
+	relay := user.NewRelay(outbox, user.MultiPublisher(publishers...), cfg.Events.PublishTimeout, cfg.Events.Retention)
+	go relay.Run(cfg.Events.RelayInterval, log)
+	onShutdown := []func() error{func() error {
+		purger.Stop()
+		sweeper.Stop()
+		dispatcher.Stop()
+		relay.Stop()
+		if broker != nil {
+			log.Info("Closing event broker connection")
+			return broker.Close()
+		}
+		return nil
+	}}
+	if cfg.Server.TLS.Enabled {
+		tlsCfg, reloader, err := tlsconfig.New(cfg.Server.TLS)
+		if err != nil {
+			log.Fatalf("Error setting up TLS : %v", err)
+		}
+		srv.TLSConfig = tlsCfg
+		go reloader.Watch(cfg.Server.TLS.ReloadInterval, log)
+		onShutdown = append(onShutdown, func() error {
+			reloader.Stop()
+			return nil
+		})
+		if cfg.Server.TLS.ClientAuth != "none" {
+			auth.ConfigureClientCertAuth(cfg.Server.TLS.ClientIdentity, clientIdentityResolver(userRepo))
 		}
-	}()
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT)
-		errs <- fmt.Errorf("%s", <-c)
-	}()
+	}
+	onShutdown = append(onShutdown, func() error {
+		log.Info("Flushing traces")
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		return shutdownTracing(ctx)
+	}, func() error {
+		log.Info("Closing database connection")
+		return closeDB()
+	})
+	err = runServer(srv, cfg.Server, log, checker.SetShuttingDown, onShutdown...)
+	if err != nil {
+		log.Fatalf("terminated : %v", err)
+	}
+	log.Info("Server exited")
+}
 
-	logrus.Errorf("terminated %s", <-errs)
+// clientIdentityResolver : Client certificate identities are emails when they contain an @, usernames otherwise.
+// The certificates of disabled accounts are rejected.
+func clientIdentityResolver(repo user.Repository) auth.IdentityResolver {
+	return func(ctx context.Context, identity string) (uint64, error) {
+		lookup := repo.GetUserByUsername
+		if strings.Contains(identity, "@") {
+			lookup = repo.GetUserByEmail
+		}
+		u, err := lookup(ctx, identity)
+		if err != nil {
+			return 0, err
+		}
+		if u.Status == user.StatusDisabled {
+			return 0, user.ErrAccountDisabled
+		}
+		return u.ID, nil
+	}
 }
 
-func postgresConnection(database string) *gorm.DB {
-	logrus.Info("Connecting to PostgreSQL DB")
+func postgresConnection(database string, log *logrus.Logger) *gorm.DB {
+	log.Info("Connecting to PostgreSQL DB")
 	db, err := gorm.Open("postgres", database)
 	if err != nil {
-		logrus.Fatal(err)
-		panic(err)
+		log.Fatal(err)
 	}
+	// Queries are only written out at the debug level, without their bound values
+	db.SetLogger(logging.GormLogger{Log: log})
+	db.LogMode(log.IsLevelEnabled(logrus.DebugLevel))
 	return db
 }
 
-// NewLogger : Logger for injecting into all the dependencies
-func NewLogger(logLevel string) *logrus.Logger {
-	var level logrus.Level
-	switch logLevel {
-	case "trace":
-		level = logrus.TraceLevel
-	case "info":
-		level = logrus.InfoLevel
-	case "warn":
-		level = logrus.WarnLevel
-	case "error":
-		level = logrus.ErrorLevel
-	case "fatal":
-		level = logrus.FatalLevel
-	case "panic":
-		level = logrus.PanicLevel
-	default:
-		level = logrus.DebugLevel
-	}
-	return &logrus.Logger{
-		Out: os.Stderr,
-		Formatter: &runtime.Formatter{
-			ChildFormatter: &logrus.JSONFormatter{},
-			Package:        true,
-			Line:           true,
-			File:           true,
-		},
-		Level: level,
-	}
-}
-
-func seedData(db *gorm.DB) {
-	err := db.Debug().DropTableIfExists(&user.User{}).Error
+func seedData(db *gorm.DB, log *logrus.Logger) {
+	err := db.DropTableIfExists(&user.User{}).Error
 	if err != nil {
-		logrus.Fatalf("cannot drop table: %v", err)
+		log.Fatalf("cannot drop table: %v", err)
 	}
 	var users = []user.User{
 		user.User{
@@ -176,14 +362,14 @@ func seedData(db *gorm.DB) {
 			Password: "$2a$10$LRazVeYPrx6MOYCZAGrSZ.CCD7d3qYUn4T5CbqZSZ37Pe28pvaBkq",
 		},
 	}
-	err = db.Debug().AutoMigrate(&user.User{}).Error
+	err = db.AutoMigrate(&user.User{}).Error
 	if err != nil {
-		logrus.Fatalf("cannot migrate table: %v", err)
+		log.Fatalf("cannot migrate table: %v", err)
 	}
 	for i := range users {
-		err = db.Debug().Model(&user.User{}).Create(&users[i]).Error
+		err = db.Model(&user.User{}).Create(&users[i]).Error
 		if err != nil {
-			logrus.Fatalf("cannot seed users table: %v", err)
+			log.Fatalf("cannot seed users table: %v", err)
 		}
 	}
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+	"github.com/LuD1161/restructuring-tnbt/pkg/scim"
+	"github.com/gin-gonic/gin"
+)
+
+// scimBasePath : Root of the SCIM endpoints, versioned by SCIM itself rather than by the API
+const scimBasePath = "/scim/v2"
+
+// registerSCIM : SCIM provisioning endpoints, for the identity providers syncing the users (Okta, Azure AD ...).
+// They are authenticated by the SCIM token alone, the users' JWTs aren't accepted. The users are provisioned in the
+// tenant of the request, and so are the groups. The POSTs creating resources are idempotent.
+func registerSCIM(r gin.IRouter, h scim.Handler, token string, recorder audit.Recorder, idempotent, tenant gin.HandlerFunc) {
+	g := r.Group(scimBasePath)
+	g.Use(scim.Authentication(token, recorder), tenant)
+	g.GET("/ServiceProviderConfig", h.ServiceProviderConfig)
+	g.GET("/ResourceTypes", h.ResourceTypes)
+	g.GET("/Schemas", h.Schemas)
+	g.GET("/Schemas/:id", h.Schema)
+
+	g.GET("/Users", h.ListUsers)
+	g.POST("/Users", idempotent, h.CreateUser)
+	g.GET("/Users/:id", h.GetUser)
+	g.PUT("/Users/:id", h.ReplaceUser)
+	g.PATCH("/Users/:id", h.PatchUser)
+	g.DELETE("/Users/:id", h.DeleteUser)
+
+	g.GET("/Groups", h.ListGroups)
+	g.POST("/Groups", idempotent, h.CreateGroup)
+	g.GET("/Groups/:id", h.GetGroup)
+	g.PUT("/Groups/:id", h.ReplaceGroup)
+	g.PATCH("/Groups/:id", h.PatchGroup)
+	g.DELETE("/Groups/:id", h.DeleteGroup)
+}
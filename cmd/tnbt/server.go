@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// newHTTPServer : http.Server serving handler with the configured address and timeouts
+func newHTTPServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// runServer : Serves until SIGINT / SIGTERM is received or the listener fails. onDrain is called first (readiness
+// starts failing), then after cfg.DrainDelay the in-flight requests get cfg.ShutdownTimeout to finish and the
+// onShutdown hooks run in order
+func runServer(srv *http.Server, cfg config.ServerConfig, log *logrus.Logger, onDrain func(), onShutdown ...func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			log.Infof("Listening on %s (TLS) in %s mode", srv.Addr, cfg.Mode)
+			// Certificates come from srv.TLSConfig, see pkg/tlsconfig
+			errs <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		log.Infof("Listening on %s in %s mode", srv.Addr, cfg.Mode)
+		errs <- srv.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var serveErr error
+	select {
+	case sig := <-signals:
+		log.Infof("Received %s, shutting down", sig)
+		onDrain()
+		time.Sleep(cfg.DrainDelay)
+	case serveErr = <-errs:
+		log.Errorf("Server stopped : %v", serveErr)
+		onDrain()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Errorf("Graceful shutdown failed, closing remaining connections : %v", err)
+		srv.Close()
+	}
+
+	for _, hook := range onShutdown {
+		if err := hook(); err != nil {
+			log.Errorf("Shutdown hook failed : %v", err)
+		}
+	}
+
+	if serveErr == http.ErrServerClosed {
+		serveErr = nil
+	}
+	return errors.Wrap(serveErr, "cmd.tnbt.runServer")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/LuD1161/restructuring-tnbt/pkg/graphqlserver"
+	"github.com/LuD1161/restructuring-tnbt/pkg/membership"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/versioning"
+	"github.com/LuD1161/restructuring-tnbt/pkg/org"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/LuD1161/restructuring-tnbt/pkg/webhook"
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// Swagger docs of the v1 API, generated with :
+// swag init -g cmd/tnbt/v1.go -o cmd/tnbt/docs/v1
+
+// @title TNBT Swagger API
+// @version 1.0
+// @description Swagger API for TNBT, v1
+// @termsOfService http://swagger.io/terms/
+
+// @contact.name API Support
+// @contact.email aseemshrey@gmail.com
+
+// @license.name MIT
+// @license.url https://github.com/github.com/LuD1161/restructuring-tnbt
+
+// @BasePath /v1
+
+// registerV1 : Routes of the v1 API, the authenticated ones act in the organization the request selects
+func registerV1(r gin.IRouter, userHandler user.Handler, memberships membership.Service, idempotent gin.HandlerFunc) {
+	// Login isn't idempotent on purpose, its responses are tokens which must not be stored
+	r.POST("/login", userHandler.Login)
+	r.POST("/user", idempotent, userHandler.CreateUser)
+	r.POST("/user/restore", idempotent, userHandler.RestoreUser)
+
+	authorized := r.Group("/")
+	authorized.Use(auth.SetMiddleWareAuthentication(), membership.ActiveOrganization(memberships))
+	authorized.GET("/user/:id", userHandler.GetUserByID)
+	authorized.GET("/users/autocomplete", userHandler.AutocompleteUsers)
+	authorized.PUT("/user", userHandler.UpdateUser)
+	authorized.PATCH("/user/:id", userHandler.PatchUser)
+	authorized.DELETE("/user/:id", userHandler.DeleteUser)
+	authorized.GET("/user/:id/avatar", userHandler.GetAvatar)
+	authorized.PUT("/user/:id/avatar", userHandler.UploadAvatar)
+	authorized.DELETE("/user/:id/avatar", userHandler.DeleteAvatar)
+}
+
+// registerGraphQL : GraphQL endpoint of v1, not served on the unversioned paths. Anonymous requests reach it so that
+// createUser stays public, the resolvers require authentication for everything else.
+func registerGraphQL(r gin.IRouter, userService user.Service, memberships membership.Service, schema *graphql.Schema) {
+	r.POST("/graphql", auth.OptionalAuthentication(), membership.ActiveOrganization(memberships),
+		user.LoaderMiddleware(userService), graphqlserver.Handler(schema))
+}
+
+// registerOrgs : Organizations of v1, the routes of an organization require the caller's role in it and act in it,
+// the others act in the organization the request selects. The POSTs creating something are idempotent, the tokens
+// aren't stored.
+func registerOrgs(r gin.IRouter, orgHandler org.Handler, h membership.Handler, memberships membership.Service, idempotent gin.HandlerFunc) {
+	member := membership.RequireRole(memberships, membership.RoleMember)
+	admin := membership.RequireRole(memberships, membership.RoleAdmin)
+	owner := membership.RequireRole(memberships, membership.RoleOwner)
+
+	g := r.Group("/")
+	g.Use(auth.SetMiddleWareAuthentication(), membership.ActiveOrganization(memberships))
+	g.POST("/orgs", idempotent, orgHandler.CreateOrganization)
+	g.GET("/orgs/:id", member, orgHandler.GetOrganization)
+	g.PATCH("/orgs/:id", admin, orgHandler.UpdateOrganization)
+	g.DELETE("/orgs/:id", owner, orgHandler.DeleteOrganization)
+	g.POST("/orgs/:id/token", member, h.IssueToken)
+	g.GET("/orgs/:id/members", member, h.ListMembers)
+	g.PATCH("/orgs/:id/members/:user_id", admin, h.ChangeRole)
+	// Members leave by removing themselves, the service checks who may remove whom
+	g.DELETE("/orgs/:id/members/:user_id", member, h.RemoveMember)
+	g.POST("/orgs/:id/transfer", owner, idempotent, h.TransferOwnership)
+	g.POST("/orgs/:id/invitations", admin, idempotent, h.Invite)
+	g.GET("/orgs/:id/invitations", admin, h.ListInvitations)
+	g.DELETE("/orgs/:id/invitations/:invitation_id", admin, h.RevokeInvitation)
+
+	g.GET("/memberships", h.ListMemberships)
+	g.GET("/memberships/active", h.ActiveMembership)
+	g.GET("/invitations", h.ListOwnInvitations)
+	g.POST("/invitations/accept", idempotent, h.AcceptInvitation)
+	g.POST("/invitations/decline", h.DeclineInvitation)
+}
+
+// registerWebhooks : Webhooks management of v1, authenticated by the admin token rather than as a user
+func registerWebhooks(r gin.IRouter, h webhook.Handler, adminToken string, idempotent gin.HandlerFunc) {
+	g := r.Group("/webhooks")
+	g.Use(auth.StaticToken(adminToken))
+	g.POST("", idempotent, h.CreateWebhook)
+	g.GET("", h.ListWebhooks)
+	g.GET("/:id", h.GetWebhook)
+	g.PATCH("/:id", h.UpdateWebhook)
+	g.DELETE("/:id", h.DeleteWebhook)
+	g.GET("/:id/deliveries", h.ListDeliveries)
+	g.GET("/:id/deliveries/:delivery_id", h.GetDelivery)
+	g.POST("/:id/deliveries/:delivery_id/redeliver", idempotent, h.Redeliver)
+}
+
+// registerUserAdmin : Admin console of v1, listing and searching the accounts of the request's tenant along with their
+// emails. Authenticated by the admin token like the webhooks, the users' JWTs aren't accepted.
+func registerUserAdmin(r gin.IRouter, userHandler user.Handler, adminToken string) {
+	admin := auth.StaticToken(adminToken)
+	r.GET("/users", admin, userHandler.ListUsers)
+	r.GET("/users/search", admin, userHandler.SearchUsers)
+}
+
+// registerAudit : Audit log of v1, read with the admin token like the webhooks
+func registerAudit(r gin.IRouter, h audit.Handler, adminToken string) {
+	g := r.Group("/audit")
+	g.Use(auth.StaticToken(adminToken))
+	g.GET("/records", h.ListRecords)
+	g.GET("/records/export", h.ExportRecords)
+	g.GET("/verify", h.VerifyChain)
+}
+
+// registerLegacy : The unversioned routes serve v1, announcing their deprecation in favor of /v1
+func registerLegacy(r gin.IRouter, cfg config.APIConfig, userHandler user.Handler, memberships membership.Service, idempotent, tenant gin.HandlerFunc) {
+	legacy := r.Group("/")
+	legacy.Use(versioning.Deprecated(versioning.Deprecation{
+		Since:     cfg.LegacyDeprecatedAt(),
+		Sunset:    cfg.LegacySunsetAt(),
+		Successor: "/v1",
+	}), tenant)
+	registerV1(legacy, userHandler, memberships, idempotent)
+}
@@ -0,0 +1,178 @@
+package apperrors
+
+import (
+	stderrors "errors"
+	"net/http"
+)
+
+// Kind : Category of a domain error, decides the HTTP status it is rendered with
+type Kind int
+
+// Kinds of domain errors. Any error which isn't an *Error is KindInternal.
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindConflict
+	KindUnauthorized
+	KindForbidden
+	KindValidation
+	KindUnsupportedMediaType
+	KindPreconditionFailed
+	KindNotAcceptable
+	KindPayloadTooLarge
+)
+
+// String : Slug used in the problem type
+func (k Kind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not-found"
+	case KindConflict:
+		return "conflict"
+	case KindUnauthorized:
+		return "unauthorized"
+	case KindForbidden:
+		return "forbidden"
+	case KindValidation:
+		return "validation"
+	case KindUnsupportedMediaType:
+		return "unsupported-media-type"
+	case KindPreconditionFailed:
+		return "precondition-failed"
+	case KindNotAcceptable:
+		return "not-acceptable"
+	case KindPayloadTooLarge:
+		return "payload-too-large"
+	default:
+		return "internal"
+	}
+}
+
+// Status : HTTP status matching the kind
+func (k Kind) Status() int {
+	switch k {
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindValidation:
+		return http.StatusUnprocessableEntity
+	case KindUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case KindPreconditionFailed:
+		return http.StatusPreconditionFailed
+	case KindNotAcceptable:
+		return http.StatusNotAcceptable
+	case KindPayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// FieldError : Problem with a single field of the request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error : Domain error returned by the services and repositories. Message is safe to show to clients,
+// the wrapped Err (if any) is only meant for the logs.
+type Error struct {
+	Kind    Kind
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+// Error : Implements error
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + " : " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap : Cause of the error, for errors.Is / errors.As
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap : Copy of e caused by err
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// Is : Errors of the same kind with the same message match, so sentinels still compare after a Wrap
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Message == e.Message
+}
+
+// NotFound : The requested resource doesn't exist
+func NotFound(message string) *Error {
+	return &Error{Kind: KindNotFound, Message: message}
+}
+
+// Conflict : The request clashes with the current state, e.g. a duplicate username
+func Conflict(message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindConflict, Message: message, Fields: fields}
+}
+
+// Unauthorized : Missing or invalid credentials
+func Unauthorized(message string) *Error {
+	return &Error{Kind: KindUnauthorized, Message: message}
+}
+
+// Forbidden : Authenticated, but not allowed to do this
+func Forbidden(message string) *Error {
+	return &Error{Kind: KindForbidden, Message: message}
+}
+
+// Validation : Invalid input, with the details per field
+func Validation(message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Message: message, Fields: fields}
+}
+
+// UnsupportedMediaType : The request body's Content-Type isn't one the endpoint accepts
+func UnsupportedMediaType(message string) *Error {
+	return &Error{Kind: KindUnsupportedMediaType, Message: message}
+}
+
+// PreconditionFailed : A conditional request (If-Match) doesn't match the current state
+func PreconditionFailed(message string) *Error {
+	return &Error{Kind: KindPreconditionFailed, Message: message}
+}
+
+// NotAcceptable : None of the representations asked for in the Accept header can be served
+func NotAcceptable(message string) *Error {
+	return &Error{Kind: KindNotAcceptable, Message: message}
+}
+
+// PayloadTooLarge : The request body is larger than the endpoint accepts
+func PayloadTooLarge(message string) *Error {
+	return &Error{Kind: KindPayloadTooLarge, Message: message}
+}
+
+// As : Domain error found in err's chain
+func As(err error) (*Error, bool) {
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// KindOf : Kind of the domain error found in err's chain, KindInternal if there's none
+func KindOf(err error) Kind {
+	if e, ok := As(err); ok {
+		return e.Kind
+	}
+	return KindInternal
+}
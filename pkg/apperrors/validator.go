@@ -0,0 +1,38 @@
+package apperrors
+
+import (
+	"fmt"
+
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// FromValidator : Validation error listing every field rejected by validator.Struct.
+// Field names are the ones reported by the validator, register a tag name func to get the JSON names.
+func FromValidator(err error) error {
+	verrs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return Validation("Invalid request").Wrap(err)
+	}
+	fields := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		fields = append(fields, FieldError{Field: fe.Field(), Message: fieldMessage(fe)})
+	}
+	return Validation("Invalid request", fields...).Wrap(err)
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		return fmt.Sprintf("must be at least %s characters long", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s characters long", fe.Param())
+	case "alphanum":
+		return "must only contain letters and digits"
+	default:
+		return fmt.Sprintf("failed the %s check", fe.Tag())
+	}
+}
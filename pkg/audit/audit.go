@@ -0,0 +1,210 @@
+// Package audit records who did what to whom, from where, in a hash chained log : every record carries the hash of
+// the previous one, so that altering, inserting or deleting a record breaks the chain, see Verify.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+)
+
+// Actions
+const (
+	ActionUserCreate  = "user.create"
+	ActionUserUpdate  = "user.update"
+	ActionUserDelete  = "user.delete"
+	ActionUserRestore = "user.restore"
+	ActionLogin       = "auth.login"
+	ActionAuthReject  = "auth.reject" // Credentials presented to an authenticated route were rejected
+
+	ActionOrgCreate = "org.create"
+	ActionOrgUpdate = "org.update"
+	ActionOrgDelete = "org.delete"
+
+	ActionMemberChangeRole        = "membership.change_role"
+	ActionMemberRemove            = "membership.remove"
+	ActionMemberTransferOwnership = "membership.transfer_ownership"
+	ActionMemberInvite            = "membership.invite"
+	ActionMemberRevokeInvitation  = "membership.revoke_invitation"
+	ActionMemberAcceptInvitation  = "membership.accept_invitation"
+)
+
+// Actor types
+const (
+	ActorUser      = "user"
+	ActorSCIM      = "scim"      // The identity provider, through the SCIM token
+	ActorAnonymous = "anonymous" // e.g. a signup or a login
+)
+
+// Outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Target types
+const (
+	TargetUser         = "user"
+	TargetOrganization = "organization" // Of the org.* and membership.* actions
+)
+
+// Redacted : Replaces the values of the secret fields in the Changes
+const Redacted = "[REDACTED]"
+
+// redactedFields : JSON fields whose values never reach the log, only the fact that they changed
+var redactedFields = map[string]bool{
+	"password":         true,
+	"current_password": true,
+	"secret":           true,
+	"token":            true,
+}
+
+// Record : Entry of the audit log
+type Record struct {
+	Seq        uint64    `json:"seq"` // Position in the chain, from 1
+	OccurredAt time.Time `json:"occurred_at"`
+	ActorType  string    `json:"actor_type"`
+	ActorID    uint64    `json:"actor_id,omitempty"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type,omitempty"`
+	TargetID   uint64    `json:"target_id,omitempty"`
+	TargetName string    `json:"target_name,omitempty"` // e.g. the username, known even when the ID isn't
+	Outcome    string    `json:"outcome"`
+	Reason     string    `json:"reason,omitempty"` // Why it failed
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Changes    Changes   `json:"changes,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// Change : Value of a field before and after the action, nil when absent
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Changes : By JSON field name
+type Changes map[string]Change
+
+// Diff : Fields differing between the JSON encodings of before and after, either of which can be nil. The values of
+// the secret fields (e.g. password) are Redacted.
+func Diff(before, after interface{}) Changes {
+	b, a := fieldsOf(before), fieldsOf(after)
+	changes := make(Changes)
+	for field, value := range b {
+		if other, ok := a[field]; !ok || !reflect.DeepEqual(value, other) {
+			changes[field] = Change{Before: value, After: a[field]}
+		}
+	}
+	for field, value := range a {
+		if _, ok := b[field]; !ok {
+			changes[field] = Change{After: value}
+		}
+	}
+	for field, change := range changes {
+		if redactedFields[field] {
+			changes[field] = Change{Before: redact(change.Before), After: redact(change.After)}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// fieldsOf : JSON fields of v, none for nil
+func fieldsOf(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	_ = json.Unmarshal(data, &fields)
+	return fields
+}
+
+func redact(v interface{}) interface{} {
+	if v == nil || v == "" {
+		return v
+	}
+	return Redacted
+}
+
+// Reason : Reason of a failed Record, the message of an apperrors.Error since the others may leak internals
+func Reason(err error) string {
+	if e, ok := apperrors.As(err); ok && e.Kind != apperrors.KindInternal {
+		return e.Message
+	}
+	return "internal error"
+}
+
+// Filter : Criteria of Store.List, the zero values match everything
+type Filter struct {
+	ActorType  string
+	ActorID    uint64
+	Action     string
+	TargetID   uint64
+	TargetName string
+	Outcome    string
+	RequestID  string
+	IP         string
+	Since      time.Time // Inclusive
+	Until      time.Time // Exclusive
+	BeforeSeq  uint64    // Keyset pagination, the records before this one
+	Limit      int
+}
+
+// Store : Where the records are chained. Appends are serialized so that the chain stays linear.
+type Store interface {
+	Append(context.Context, *Record) error                                   // Sets Seq, PrevHash and Hash
+	List(context.Context, Filter) ([]Record, error)                          // Latest first
+	Range(ctx context.Context, afterSeq uint64, limit int) ([]Record, error) // In chain order, for Verify
+	Head(context.Context) (seq uint64, hash string, err error)               // Last record, 0 and "" for an empty chain
+}
+
+// Recorder : Records the audited actions
+type Recorder interface {
+	// Record : Fills in the request's details (request ID, client, and the authenticated user as actor unless an actor
+	// is set) and appends r. A failure is logged rather than returned, the action already happened.
+	Record(ctx context.Context, r Record)
+}
+
+type recorder struct {
+	store Store
+}
+
+// NewRecorder : Recorder appending to store
+func NewRecorder(store Store) Recorder {
+	return &recorder{store}
+}
+
+func (rec *recorder) Record(ctx context.Context, r Record) {
+	if r.OccurredAt.IsZero() {
+		r.OccurredAt = time.Now()
+	}
+	if r.ActorType == "" {
+		r.ActorType = ActorAnonymous
+		if uid, ok := requestctx.UserID(ctx); ok {
+			r.ActorType, r.ActorID = ActorUser, uid
+		}
+	}
+	if r.Outcome == "" {
+		r.Outcome = OutcomeSuccess
+	}
+	client := requestctx.ClientOf(ctx)
+	r.IP, r.UserAgent, r.RequestID = client.IP, client.UserAgent, requestctx.RequestID(ctx)
+	// Recorded even when the request is cancelled meanwhile
+	if err := rec.store.Append(context.Background(), &r); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("action", r.Action).Error("Recording the audit record failed")
+	}
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+)
+
+func TestDiff(t *testing.T) {
+	type profile struct {
+		Username string `json:"username"`
+		Bio      string `json:"bio,omitempty"`
+		Password string `json:"password,omitempty"`
+	}
+	for _, tc := range []struct {
+		name          string
+		before, after interface{}
+		want          Changes
+	}{
+		{"unchanged", profile{Username: "jdoe"}, profile{Username: "jdoe"}, nil},
+		{"changed", profile{Username: "jdoe"}, profile{Username: "john"}, Changes{"username": {Before: "jdoe", After: "john"}}},
+		{"added", profile{Username: "jdoe"}, profile{Username: "jdoe", Bio: "hi"}, Changes{"bio": {After: "hi"}}},
+		{"removed", profile{Username: "jdoe", Bio: "hi"}, profile{Username: "jdoe"}, Changes{"bio": {Before: "hi"}}},
+		{"created", nil, profile{Username: "jdoe"}, Changes{"username": {After: "jdoe"}}},
+		{"deleted", &profile{Username: "jdoe"}, (*profile)(nil), Changes{"username": {Before: "jdoe"}}},
+		{
+			"redacted", profile{Username: "jdoe", Password: "old secret"}, profile{Username: "jdoe", Password: "new secret"},
+			Changes{"password": {Before: Redacted, After: Redacted}},
+		},
+		{"redacted when set", profile{Username: "jdoe"}, profile{Username: "jdoe", Password: "secret"}, Changes{"password": {After: Redacted}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Diff(tc.before, tc.after); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Diff() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	s := new(memoryStore)
+	rec := NewRecorder(s)
+	ctx := requestctx.WithRequestID(context.Background(), "req-1")
+	ctx = requestctx.WithClient(ctx, requestctx.Client{IP: "203.0.113.7", UserAgent: "curl/7.68.0"})
+
+	rec.Record(ctx, Record{Action: ActionLogin, TargetType: TargetUser, TargetName: "jdoe"})
+	rec.Record(requestctx.WithUserID(ctx, 42), Record{Action: ActionUserUpdate, TargetType: TargetUser, TargetID: 42})
+	rec.Record(requestctx.WithUserID(ctx, 42), Record{ActorType: ActorSCIM, Action: ActionUserDelete, TargetID: 7, Outcome: OutcomeFailure})
+
+	if len(s.records) != 3 {
+		t.Fatalf("%d records, want 3", len(s.records))
+	}
+	for i, want := range []struct {
+		actorType string
+		actorID   uint64
+		outcome   string
+	}{
+		{ActorAnonymous, 0, OutcomeSuccess},
+		{ActorUser, 42, OutcomeSuccess},
+		{ActorSCIM, 0, OutcomeFailure},
+	} {
+		r := s.records[i]
+		if r.ActorType != want.actorType || r.ActorID != want.actorID || r.Outcome != want.outcome {
+			t.Errorf("record %d actor %s %d, outcome %s, want %s %d, %s", r.Seq, r.ActorType, r.ActorID, r.Outcome, want.actorType, want.actorID, want.outcome)
+		}
+		if r.RequestID != "req-1" || r.IP != "203.0.113.7" || r.UserAgent != "curl/7.68.0" || r.OccurredAt.IsZero() {
+			t.Errorf("record %d = %+v, want the request's details", r.Seq, r)
+		}
+	}
+	if v, err := Verify(context.Background(), s); err != nil || !v.Valid || v.Records != 3 {
+		t.Errorf("Verify() = %+v, %v, want 3 valid records", v, err)
+	}
+}
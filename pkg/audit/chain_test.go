@@ -0,0 +1,206 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore : Store keeping the chain in a slice, in chain order, and its head apart as the databases do
+type memoryStore struct {
+	mu       sync.Mutex
+	records  []Record
+	headSeq  uint64
+	headHash string
+}
+
+func (s *memoryStore) Append(_ context.Context, r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Hashed as it'll be read back
+	if len(r.Changes) > 0 {
+		data, err := json.Marshal(r.Changes)
+		if err != nil {
+			return err
+		}
+		r.Changes = nil
+		if err := json.Unmarshal(data, &r.Changes); err != nil {
+			return err
+		}
+	}
+	r.Normalize()
+	r.Seq, r.PrevHash = s.headSeq+1, s.headHash
+	r.Hash = r.ComputeHash()
+	s.records = append(s.records, *r)
+	s.headSeq, s.headHash = r.Seq, r.Hash
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context, f Filter) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []Record
+	for i := len(s.records) - 1; i >= 0 && (f.Limit == 0 || len(records) < f.Limit); i-- {
+		r := s.records[i]
+		if (f.BeforeSeq == 0 || r.Seq < f.BeforeSeq) && (f.Action == "" || r.Action == f.Action) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (s *memoryStore) Range(_ context.Context, afterSeq uint64, limit int) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.records), func(i int) bool { return s.records[i].Seq > afterSeq })
+	var records []Record
+	for ; i < len(s.records) && len(records) < limit; i++ {
+		records = append(records, s.records[i])
+	}
+	return records, nil
+}
+
+func (s *memoryStore) Head(context.Context) (uint64, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.headSeq, s.headHash, nil
+}
+
+// newTestChain : Store chaining n records
+func newTestChain(t *testing.T, n int) *memoryStore {
+	s := new(memoryStore)
+	start := time.Date(2020, 4, 1, 12, 0, 0, 123456789, time.UTC)
+	for i := 0; i < n; i++ {
+		r := &Record{
+			OccurredAt: start.Add(time.Duration(i) * time.Minute),
+			ActorType:  ActorUser,
+			ActorID:    1,
+			Action:     ActionUserUpdate,
+			TargetType: TargetUser,
+			TargetID:   uint64(i%3 + 1),
+			Outcome:    OutcomeSuccess,
+			Changes:    Diff(map[string]interface{}{"bio": "before"}, map[string]interface{}{"bio": fmt.Sprint("after ", i)}),
+		}
+		if err := s.Append(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// rehash : Recomputes the hashes of the records from the i-th one on, chaining each to the previous one, as someone
+// covering up a change would
+func (s *memoryStore) rehash(i int) {
+	for ; i < len(s.records); i++ {
+		r := &s.records[i]
+		r.PrevHash = ""
+		if i > 0 {
+			r.PrevHash = s.records[i-1].Hash
+		}
+		r.Hash = r.ComputeHash()
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		records  int
+		tamper   func(s *memoryStore)
+		brokenAt uint64 // 0 when the chain is valid
+		problem  string
+	}{
+		{"empty chain", 0, nil, 0, ""},
+		{"intact chain", 5, nil, 0, ""},
+		{"intact chain over several batches", verifyBatchSize + 2, nil, 0, ""},
+		{
+			"edited record", 5,
+			func(s *memoryStore) { s.records[2].Outcome = OutcomeFailure },
+			3, "content doesn't match its hash",
+		},
+		{
+			"edited changes", 5,
+			func(s *memoryStore) { s.records[1].Changes["bio"] = Change{Before: "before", After: "edited"} },
+			2, "content doesn't match its hash",
+		},
+		{
+			"edited and rehashed record", 5,
+			func(s *memoryStore) {
+				s.records[2].ActorID = 2
+				s.records[2].Hash = s.records[2].ComputeHash()
+			},
+			4, "not linked to the previous record",
+		},
+		{
+			"deleted middle record", 5,
+			func(s *memoryStore) { s.records = append(s.records[:2], s.records[3:]...) },
+			3, "record 3 is missing",
+		},
+		{
+			"deleted first record", 5,
+			func(s *memoryStore) { s.records = s.records[1:] },
+			1, "record 1 is missing",
+		},
+		{
+			"re-linked record", 5,
+			// The record after the deleted one is linked to the record before it
+			func(s *memoryStore) {
+				s.records = append(s.records[:2], s.records[3:]...)
+				s.records[2].PrevHash = s.records[1].Hash
+				s.records[2].Hash = s.records[2].ComputeHash()
+			},
+			3, "record 3 is missing",
+		},
+		{
+			"re-linked and renumbered records", 5,
+			// The records after the deleted one renumbered and rehashed, only the head tells
+			func(s *memoryStore) {
+				s.records = append(s.records[:2], s.records[3:]...)
+				for i := 2; i < len(s.records); i++ {
+					s.records[i].Seq--
+				}
+				s.rehash(2)
+			},
+			5, "the chain ends at record 4 instead of 5",
+		},
+		{
+			"rewritten chain", 5,
+			func(s *memoryStore) {
+				s.records[1].TargetName = "someone else"
+				s.rehash(1)
+			},
+			5, "doesn't match the head of the chain",
+		},
+		{
+			"deleted tail record", 5,
+			func(s *memoryStore) { s.records = s.records[:4] },
+			5, "the chain ends at record 4 instead of 5",
+		},
+		{
+			"deleted chain", 5,
+			func(s *memoryStore) { s.records = nil },
+			1, "the chain ends at record 0 instead of 5",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestChain(t, tc.records)
+			if tc.tamper != nil {
+				tc.tamper(s)
+			}
+			v, err := Verify(context.Background(), s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Verification{Valid: tc.brokenAt == 0, BrokenAt: tc.brokenAt, Problem: tc.problem, Records: v.Records}
+			if *v != want {
+				t.Errorf("Verify() = %+v, want %+v", *v, want)
+			}
+			if tc.brokenAt == 0 && v.Records != uint64(tc.records) {
+				t.Errorf("Verify() checked %d records, want %d", v.Records, tc.records)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultPageSize, maxPageSize : Records listed at once
+	defaultPageSize = 50
+	maxPageSize     = 500
+	// exportBatchSize : Records read at once while exporting
+	exportBatchSize = 500
+)
+
+// csvHeader : Columns of the CSV export, the changes are JSON
+var csvHeader = []string{
+	"seq", "occurred_at", "actor_type", "actor_id", "action", "target_type", "target_id", "target_name",
+	"outcome", "reason", "ip", "user_agent", "request_id", "changes", "prev_hash", "hash",
+}
+
+// Handler : Read access to the audit log. Errors are rendered by problem.Middleware
+type Handler interface {
+	ListRecords(c *gin.Context)
+	ExportRecords(c *gin.Context)
+	VerifyChain(c *gin.Context)
+}
+
+// ListRecordsResponse : Page of records, latest first, the next one is fetched with before=next_before
+type ListRecordsResponse struct {
+	Records    []Record `json:"records"`
+	NextBefore uint64   `json:"next_before,omitempty"`
+}
+
+type handler struct {
+	store Store
+}
+
+// NewHandler : Handler of the records of store
+func NewHandler(store Store) Handler {
+	return &handler{store}
+}
+
+// parseFilter : Filter of the query string, an apperrors.Validation listing the invalid parameters
+func parseFilter(c *gin.Context) (Filter, error) {
+	f := Filter{
+		ActorType:  c.Query("actor_type"),
+		Action:     c.Query("action"),
+		TargetName: c.Query("target_name"),
+		Outcome:    c.Query("outcome"),
+		RequestID:  c.Query("request_id"),
+		IP:         c.Query("ip"),
+		Limit:      defaultPageSize,
+	}
+	var fields []apperrors.FieldError
+	for name, dst := range map[string]*uint64{"actor_id": &f.ActorID, "target_id": &f.TargetID, "before": &f.BeforeSeq} {
+		if v := c.Query(name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || n == 0 {
+				fields = append(fields, apperrors.FieldError{Field: name, Message: "must be a positive integer"})
+			}
+			*dst = n
+		}
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				fields = append(fields, apperrors.FieldError{Field: name, Message: "must be an RFC 3339 date"})
+			}
+			*dst = t
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			fields = append(fields, apperrors.FieldError{Field: "limit", Message: "must be a positive integer"})
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+		f.Limit = limit
+	}
+	if len(fields) > 0 {
+		return f, apperrors.Validation("Invalid query", fields...)
+	}
+	return f, nil
+}
+
+// ListRecords : Lists the audit records
+// @Summary List the audit records
+// @Description Latest first, filtered by actor_type, actor_id, action, target_id, target_name, outcome, request_id, ip and the since / until dates
+// @Tags Audit
+// @Produce  json
+// @Param actor_type query string false "user, scim or anonymous"
+// @Param actor_id query int false "ID of the acting user"
+// @Param action query string false "e.g. user.update or auth.login"
+// @Param target_id query int false "ID of the user or organization acted upon"
+// @Param target_name query string false "Username of the user acted upon, slug of the organization or email invited to it"
+// @Param outcome query string false "success or failure"
+// @Param request_id query string false "X-Request-ID of the request"
+// @Param ip query string false "Client IP"
+// @Param since query string false "RFC 3339 date, inclusive"
+// @Param until query string false "RFC 3339 date, exclusive"
+// @Param before query int false "next_before of the previous page"
+// @Param limit query int false "Records per page, 50 by default, at most 500"
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Success 200 {object} ListRecordsResponse
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /audit/records [get]
+func (h *handler) ListRecords(c *gin.Context) {
+	f, err := parseFilter(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.audit.handler.ListRecords"))
+		return
+	}
+	records, err := h.store.List(c.Request.Context(), f)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.audit.handler.ListRecords"))
+		return
+	}
+	resp := ListRecordsResponse{Records: records}
+	if resp.Records == nil {
+		resp.Records = []Record{}
+	}
+	if len(records) == f.Limit {
+		resp.NextBefore = records[len(records)-1].Seq
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ExportRecords : Exports the audit records
+// @Summary Export the audit records
+// @Description Every record matching the filters of the listing, latest first, as JSON lines or CSV
+// @Tags Audit
+// @Produce  application/x-ndjson
+// @Produce  text/csv
+// @Param format query string false "jsonl (default) or csv"
+// @Param actor_type query string false "user, scim or anonymous"
+// @Param actor_id query int false "ID of the acting user"
+// @Param action query string false "e.g. user.update or auth.login"
+// @Param target_id query int false "ID of the user or organization acted upon"
+// @Param target_name query string false "Username of the user acted upon, slug of the organization or email invited to it"
+// @Param outcome query string false "success or failure"
+// @Param since query string false "RFC 3339 date, inclusive"
+// @Param until query string false "RFC 3339 date, exclusive"
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Success 200 {string} string "The records"
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /audit/records/export [get]
+func (h *handler) ExportRecords(c *gin.Context) {
+	f, err := parseFilter(c)
+	if err == nil && c.Query("limit") != "" {
+		err = apperrors.Validation("Invalid query", apperrors.FieldError{Field: "limit", Message: "isn't supported by the export"})
+	}
+	format := c.DefaultQuery("format", "jsonl")
+	if err == nil && format != "jsonl" && format != "csv" {
+		err = apperrors.Validation("Invalid query", apperrors.FieldError{Field: "format", Message: "must be jsonl or csv"})
+	}
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.audit.handler.ExportRecords"))
+		return
+	}
+	ctx := c.Request.Context()
+	f.Limit = exportBatchSize
+	// Fetched before writing anything, so that a failing store still gets a proper error response
+	records, err := h.store.List(ctx, f)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.audit.handler.ExportRecords"))
+		return
+	}
+
+	filename := "audit-" + time.Now().UTC().Format("20060102T150405Z") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	var write func(Record) error
+	var flush func() error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		_ = w.Write(csvHeader)
+		write = func(r Record) error { return w.Write(csvRow(r)) }
+		flush = func() error { w.Flush(); return w.Error() }
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		write = func(r Record) error { return enc.Encode(r) }
+		flush = func() error { return nil }
+	}
+	c.Status(http.StatusOK)
+	for {
+		for _, r := range records {
+			if err := write(r); err != nil {
+				logging.FromContext(ctx).WithError(err).Warn("Audit export aborted")
+				return
+			}
+		}
+		if err := flush(); err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("Audit export aborted")
+			return
+		}
+		c.Writer.Flush()
+		if len(records) < f.Limit {
+			return
+		}
+		f.BeforeSeq = records[len(records)-1].Seq
+		if records, err = h.store.List(ctx, f); err != nil {
+			// Too late for an error response, the truncated export is told apart by its missing records
+			logging.FromContext(ctx).WithError(err).Error("Audit export failed")
+			return
+		}
+	}
+}
+
+// csvRow : Columns of csvHeader
+func csvRow(r Record) []string {
+	changes := ""
+	if len(r.Changes) > 0 {
+		data, _ := json.Marshal(r.Changes)
+		changes = string(data)
+	}
+	return []string{
+		strconv.FormatUint(r.Seq, 10), r.OccurredAt.UTC().Format(time.RFC3339Nano), r.ActorType,
+		strconv.FormatUint(r.ActorID, 10), r.Action, r.TargetType, strconv.FormatUint(r.TargetID, 10), r.TargetName,
+		r.Outcome, r.Reason, r.IP, r.UserAgent, r.RequestID, changes, r.PrevHash, r.Hash,
+	}
+}
+
+// VerifyChain : Verifies the hash chain
+// @Summary Verify the audit log
+// @Description Walks the whole chain and reports the first record which was altered, inserted or deleted, if any
+// @Tags Audit
+// @Produce  json
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Success 200 {object} Verification
+// @Failure 401 {object} problem.Problem
+// @Router /audit/verify [get]
+func (h *handler) VerifyChain(c *gin.Context) {
+	v, err := Verify(c.Request.Context(), h.store)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.audit.handler.VerifyChain"))
+		return
+	}
+	if !v.Valid {
+		logging.FromContext(c.Request.Context()).WithField("broken_at", v.BrokenAt).Error("Audit log chain broken : " + v.Problem)
+	}
+	c.JSON(http.StatusOK, v)
+}
@@ -0,0 +1,111 @@
+// Package avatar turns the pictures the users upload into the square images served as their avatars. The uploads are
+// told apart by their content rather than by the Content-Type the client claims, and re-encoded, which drops their
+// metadata (EXIF location ...) along with anything hidden in them.
+package avatar
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif" // Registers the format
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registers the format
+)
+
+// Sizes : Edges of the square variants stored for every avatar, in pixels, the first one is the default.
+// Smaller pictures aren't scaled up.
+var Sizes = []int{512, 128, 64}
+
+// MaxDimension : Longest edge of the pictures accepted, checked before they are decoded
+const MaxDimension = 4096
+
+// formats : Content types accepted, each registered with package image
+var formats = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true}
+
+var (
+	// ErrUnsupportedType : The upload isn't one of the image formats accepted
+	ErrUnsupportedType = apperrors.UnsupportedMediaType("Avatars must be JPEG, PNG, GIF or WebP images")
+	// ErrInvalidImage : The upload looks like an image but can't be decoded
+	ErrInvalidImage = apperrors.Validation("Invalid image", apperrors.FieldError{Field: "avatar", Message: "can't be decoded"})
+	// ErrTooManyPixels : The picture is larger than MaxDimension
+	ErrTooManyPixels = apperrors.Validation("Image too large", apperrors.FieldError{
+		Field: "avatar", Message: "must be at most " + strconv.Itoa(MaxDimension) + " pixels wide and high",
+	})
+)
+
+// Variant : Avatar at one of the Sizes
+type Variant struct {
+	Size        int
+	ContentType string
+	Data        []byte
+}
+
+// Process : Variants of the picture for every size, cropped to the centered square. They are PNGs when the picture
+// has transparent pixels, JPEGs otherwise.
+func Process(data []byte) ([]Variant, error) {
+	if !formats[http.DetectContentType(data)] {
+		return nil, ErrUnsupportedType
+	}
+	// The header is enough to tell the dimensions, a decompression bomb is rejected before it is decoded
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage.Wrap(err)
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return nil, ErrTooManyPixels
+	}
+	img, _, err := image.Decode(bytes.NewReader(data)) // The first frame of the GIFs
+	if err != nil {
+		return nil, ErrInvalidImage.Wrap(err)
+	}
+	square := centeredSquare(img.Bounds())
+	if square.Empty() {
+		return nil, ErrInvalidImage
+	}
+	variants := make([]Variant, 0, len(Sizes))
+	for _, size := range Sizes {
+		edge := size
+		if edge > square.Dx() {
+			edge = square.Dx()
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, edge, edge))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, square, draw.Src, nil)
+		v, err := encode(dst)
+		if err != nil {
+			return nil, err
+		}
+		v.Size = size
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// centeredSquare : Largest square centered in r
+func centeredSquare(r image.Rectangle) image.Rectangle {
+	side := r.Dx()
+	if r.Dy() < side {
+		side = r.Dy()
+	}
+	x := r.Min.X + (r.Dx()-side)/2
+	y := r.Min.Y + (r.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+func encode(img *image.RGBA) (Variant, error) {
+	var buf bytes.Buffer
+	if img.Opaque() {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return Variant{}, err
+		}
+		return Variant{ContentType: "image/jpeg", Data: buf.Bytes()}, nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return Variant{}, err
+	}
+	return Variant{ContentType: "image/png", Data: buf.Bytes()}, nil
+}
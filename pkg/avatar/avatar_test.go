@@ -0,0 +1,126 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// picture : w x h image, opaque or with transparent pixels
+func picture(w, h int, opaque bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255}
+			if !opaque && x < w/2 {
+				c.A = 0
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encoded(t *testing.T, encode func(*bytes.Buffer) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestProcessSniffing : The client's Content-Type isn't an input, the content alone tells the format
+func TestProcessSniffing(t *testing.T) {
+	img := picture(16, 16, true)
+	pngData := encoded(t, func(b *bytes.Buffer) error { return png.Encode(b, img) })
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"PNG", pngData, nil},
+		{"JPEG", encoded(t, func(b *bytes.Buffer) error { return jpeg.Encode(b, img, nil) }), nil},
+		{"GIF", encoded(t, func(b *bytes.Buffer) error { return gif.Encode(b, img, nil) }), nil},
+		{"SVG, sent as image/png", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), ErrUnsupportedType},
+		{"HTML, sent as image/jpeg", []byte(`<html><body><img src=x onerror=alert(1)></body></html>`), ErrUnsupportedType},
+		{"text", []byte("not a picture"), ErrUnsupportedType},
+		{"empty", nil, ErrUnsupportedType},
+		{"PNG signature, corrupted", append(append([]byte{}, pngData[:16]...), "garbage"...), ErrInvalidImage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Process(tc.data)
+			if tc.err == nil && err != nil {
+				t.Errorf("Process() = %v, want the picture accepted", err)
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("Process() = %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+// TestProcessMaxDimension : The PNGs are cut after their header, only the oversized one is told apart without
+// decoding the pixels
+func TestProcessMaxDimension(t *testing.T) {
+	// Signature, then the IHDR chunk : length, type, 13 bytes of data and CRC
+	const headerLen = 8 + 4 + 4 + 13 + 4
+	for _, tc := range []struct {
+		name string
+		w, h int
+		err  error
+	}{
+		{"wider", MaxDimension + 1, 1, ErrTooManyPixels},
+		{"higher", 1, MaxDimension + 1, ErrTooManyPixels},
+		{"at the limit", MaxDimension, 1, ErrInvalidImage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			img := image.NewGray(image.Rect(0, 0, tc.w, tc.h))
+			data := encoded(t, func(b *bytes.Buffer) error { return png.Encode(b, img) })
+			if _, err := Process(data[:headerLen]); !errors.Is(err, tc.err) {
+				t.Errorf("Process() = %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestProcessSizes(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		img         image.Image
+		edges       []int
+		contentType string
+	}{
+		{"large", picture(1200, 800, true), []int{512, 128, 64}, "image/jpeg"},
+		{"smaller than the largest size, not scaled up", picture(300, 200, true), []int{200, 128, 64}, "image/jpeg"},
+		{"transparent", picture(600, 600, false), []int{512, 128, 64}, "image/png"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			variants, err := Process(encoded(t, func(b *bytes.Buffer) error { return png.Encode(b, tc.img) }))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(variants) != len(Sizes) {
+				t.Fatalf("%d variants, want one per size", len(variants))
+			}
+			for i, v := range variants {
+				if v.Size != Sizes[i] || v.ContentType != tc.contentType {
+					t.Errorf("variant %d is a %s of size %d, want a %s of size %d", i, v.ContentType, v.Size, tc.contentType, Sizes[i])
+				}
+				cfg, format, err := image.DecodeConfig(bytes.NewReader(v.Data))
+				if err != nil || "image/"+format != v.ContentType {
+					t.Errorf("variant %d decodes as %s (%v), want %s", i, format, err, v.ContentType)
+					continue
+				}
+				if cfg.Width != tc.edges[i] || cfg.Height != tc.edges[i] {
+					t.Errorf("variant %d is %dx%d, want %dx%[4]d", i, cfg.Width, cfg.Height, tc.edges[i])
+				}
+			}
+		})
+	}
+}
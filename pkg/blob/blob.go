@@ -0,0 +1,52 @@
+// Package blob stores the files kept outside of the database, e.g. the avatars : on the local filesystem or in an S3
+// compatible object storage (AWS S3, MinIO ...). Keys are slash separated paths such as avatars/42/<id>/128.
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound : No blob is stored under the key
+var ErrNotFound = errors.New("blob not found")
+
+// Info : Metadata of a stored blob
+type Info struct {
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// Store : Storage of the blobs. Implementations return ErrNotFound for the missing keys.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error // Replaces the blob under key
+	Get(ctx context.Context, key string) (io.ReadCloser, *Info, error)                      // The caller closes the blob
+	Delete(ctx context.Context, keys ...string) error                                       // The missing keys are skipped
+	DeletePrefix(ctx context.Context, prefix string) error                                  // Deletes every blob under prefix/
+	PingContext(ctx context.Context) error                                                  // Checks the storage is reachable, see health.PingCheck
+}
+
+// Open : Store picked by the configuration
+func Open(cfg config.BlobConfig) (Store, error) {
+	switch cfg.Store {
+	case "file":
+		s, err := NewFileStore(cfg.Dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "pkg.blob.Open")
+		}
+		return s, nil
+	case "s3":
+		s, err := NewS3Store(cfg.S3)
+		if err != nil {
+			return nil, errors.Wrap(err, "pkg.blob.Open")
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("pkg.blob.Open : unknown store %q", cfg.Store)
+	}
+}
@@ -0,0 +1,61 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+)
+
+// pngHeader : Start of a PNG, which the FileStore sniffs as image/png
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// testStore : Puts, reads and deletes blobs under prefix/
+func testStore(t *testing.T, s Store, prefix string) {
+	ctx := context.Background()
+	key := func(name string) string { return prefix + "/" + name }
+	defer s.DeletePrefix(ctx, prefix)
+
+	if err := s.PingContext(ctx); err != nil {
+		t.Fatalf("PingContext() = %v", err)
+	}
+	first := append(append([]byte{}, pngHeader...), "first"...)
+	second := append(append([]byte{}, pngHeader...), "second, longer"...)
+	for _, data := range [][]byte{first, second} {
+		if err := s.Put(ctx, key("a/128"), bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+			t.Fatalf("Put() = %v", err)
+		}
+	}
+	if err := s.Put(ctx, key("b/128"), bytes.NewReader(first), int64(len(first)), "image/png"); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+
+	rc, info, err := s.Get(ctx, key("a/128"))
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, second) {
+		t.Errorf("Get() read %q (%v), want the last blob put %q", got, err, second)
+	}
+	if info.ContentType != "image/png" || info.Size != int64(len(second)) {
+		t.Errorf("Get() info = %s of %d bytes, want image/png of %d", info.ContentType, info.Size, len(second))
+	}
+	if _, _, err := s.Get(ctx, key("missing")); err != ErrNotFound {
+		t.Errorf("Get() of a missing key = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Delete(ctx, key("a/128"), key("missing")); err != nil {
+		t.Errorf("Delete() = %v, want the missing key skipped", err)
+	}
+	if _, _, err := s.Get(ctx, key("a/128")); err != ErrNotFound {
+		t.Errorf("Get() of a deleted key = %v, want ErrNotFound", err)
+	}
+	if err := s.DeletePrefix(ctx, key("b")); err != nil {
+		t.Errorf("DeletePrefix() = %v", err)
+	}
+	if _, _, err := s.Get(ctx, key("b/128")); err != ErrNotFound {
+		t.Errorf("Get() under a deleted prefix = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,120 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileStore : Keeps the blobs as files under a directory. The filesystem keeps no metadata, the content type is
+// sniffed from the content.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore : Store of the files under dir, created when missing
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("pkg.blob.NewFileStore : no directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "pkg.blob.NewFileStore")
+	}
+	return &FileStore{dir}, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return errors.Wrap(err, "pkg.blob.FileStore.Put")
+	}
+	// Written aside then renamed, so readers never see a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return errors.Wrap(err, "pkg.blob.FileStore.Put")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "pkg.blob.FileStore.Put")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "pkg.blob.FileStore.Put")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), name), "pkg.blob.FileStore.Put")
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (io.ReadCloser, *Info, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "pkg.blob.FileStore.Get")
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.Wrap(err, "pkg.blob.FileStore.Get")
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err == nil || err == io.ErrUnexpectedEOF || err == io.EOF {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.Wrap(err, "pkg.blob.FileStore.Get")
+	}
+	return f, &Info{ContentType: http.DetectContentType(head[:n]), Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		name, err := s.path(key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "pkg.blob.FileStore.Delete")
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) DeletePrefix(ctx context.Context, prefix string) error {
+	name, err := s.path(prefix)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(os.RemoveAll(name), "pkg.blob.FileStore.DeletePrefix")
+}
+
+// PingContext : Checks the directory is still there
+func (s *FileStore) PingContext(ctx context.Context) error {
+	_, err := os.Stat(s.dir)
+	return err
+}
+
+// path : File of the key, which must stay under the directory of the store
+func (s *FileStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || strings.TrimPrefix(clean, "/") != key {
+		return "", errors.Errorf("pkg.blob.FileStore : invalid key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
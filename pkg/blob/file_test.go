@@ -0,0 +1,49 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorePath(t *testing.T) {
+	s := &FileStore{dir: filepath.FromSlash("/var/blobs")}
+	for _, tc := range []struct {
+		key, want string
+	}{
+		{"avatars/42/c0ffee/128", filepath.FromSlash("/var/blobs/avatars/42/c0ffee/128")},
+		{"avatars", filepath.FromSlash("/var/blobs/avatars")},
+		{"", ""},
+		{"/", ""},
+		{"..", ""},
+		{"../x", ""},
+		{"/abs", ""},
+		{"a/../../b", ""},
+		{"a/../b", ""},
+		{"a/./b", ""},
+		{"a//b", ""},
+		{"a/", ""},
+	} {
+		got, err := s.path(tc.key)
+		if tc.want == "" && err == nil {
+			t.Errorf("path(%q) = %s, want the key rejected", tc.key, got)
+		}
+		if tc.want != "" && (err != nil || got != tc.want) {
+			t.Errorf("path(%q) = %s, %v, want %s", tc.key, got, err, tc.want)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s, "test")
+}
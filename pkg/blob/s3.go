@@ -0,0 +1,115 @@
+package blob
+
+import (
+	"context"
+	"io"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+// S3Store : Keeps the blobs as the objects of a bucket, in AWS S3 or any S3 compatible storage (MinIO, Ceph ...)
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Store : Store of the objects in cfg.Bucket, created when missing (e.g. in a fresh local MinIO)
+func NewS3Store(cfg config.S3Config) (*S3Store, error) {
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.blob.NewS3Store")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.blob.NewS3Store")
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, errors.Wrap(err, "pkg.blob.NewS3Store")
+		}
+	}
+	return &S3Store{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return errors.Wrap(err, "pkg.blob.S3Store.Put")
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, *Info, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "pkg.blob.S3Store.Get")
+	}
+	// GetObject is lazy, the first request is the Stat
+	stat, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, errors.Wrap(err, "pkg.blob.S3Store.Get")
+	}
+	return obj, &Info{ContentType: stat.ContentType, Size: stat.Size, ModTime: stat.LastModified}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, keys ...string) error {
+	objects := make(chan minio.ObjectInfo, len(keys))
+	for _, key := range keys {
+		objects <- minio.ObjectInfo{Key: key}
+	}
+	close(objects)
+	return s.remove(ctx, objects)
+}
+
+func (s *S3Store) DeletePrefix(ctx context.Context, prefix string) error {
+	listed := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true})
+	// ListObjects reports its failures in the listing, which RemoveObjects doesn't look at
+	var listErr error
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for obj := range listed {
+			if obj.Err != nil {
+				listErr = obj.Err
+				continue
+			}
+			objects <- obj
+		}
+	}()
+	if err := s.remove(ctx, objects); err != nil {
+		return err
+	}
+	return errors.Wrap(listErr, "pkg.blob.S3Store.DeletePrefix")
+}
+
+// remove : Deletes the objects in batches, S3 doesn't fail on the missing ones
+func (s *S3Store) remove(ctx context.Context, objects <-chan minio.ObjectInfo) error {
+	var err error
+	for rerr := range s.client.RemoveObjects(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if err == nil {
+			err = errors.Wrapf(rerr.Err, "pkg.blob.S3Store : removing %s", rerr.ObjectName)
+		}
+	}
+	return err
+}
+
+// PingContext : Checks the bucket is reachable
+func (s *S3Store) PingContext(ctx context.Context) error {
+	_, err := s.client.BucketExists(ctx, s.bucket)
+	return err
+}
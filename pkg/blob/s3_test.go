@@ -0,0 +1,43 @@
+package blob
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+)
+
+// s3EndpointEnv : host:port of an S3 compatible storage the tests are free to write to, e.g. the MinIO of the
+// docker compose file, the S3 tests are skipped without it. The credentials default to MinIO's.
+const s3EndpointEnv = "TEST_S3_ENDPOINT"
+
+// getenv : The variable, fallback when unset
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv(s3EndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s isn't set", s3EndpointEnv)
+	}
+	s, err := NewS3Store(config.S3Config{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    getenv("TEST_S3_BUCKET", "tnbt-test"),
+		AccessKey: getenv("TEST_S3_ACCESS_KEY", "minioadmin"),
+		SecretKey: getenv("TEST_S3_SECRET_KEY", "minioadmin"),
+		PathStyle: true,
+		Timeout:   10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bucket outlives the test, every run writes under a prefix of its own
+	testStore(t, s, fmt.Sprintf("test-%d", time.Now().UnixNano()))
+}
@@ -0,0 +1,677 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Precedence (lowest to highest) : defaults -> config file (YAML / TOML) -> environment (.env included) -> flags.
+// Values of the form "secret:<key>" are resolved through the configured SecretProvider after all the layers are merged.
+
+// Config : Typed configuration of the whole application
+type Config struct {
+	Server      ServerConfig      `yaml:"server" toml:"server" json:"server"`
+	Database    DatabaseConfig    `yaml:"database" toml:"database" json:"database"`
+	Auth        AuthConfig        `yaml:"auth" toml:"auth" json:"auth"`
+	Log         LogConfig         `yaml:"log" toml:"log" json:"log"`
+	Health      HealthConfig      `yaml:"health" toml:"health" json:"health"`
+	Tracing     TracingConfig     `yaml:"tracing" toml:"tracing" json:"tracing"`
+	Secrets     SecretsConfig     `yaml:"secrets" toml:"secrets" json:"secrets"`
+	Users       UsersConfig       `yaml:"users" toml:"users" json:"users"`
+	Idempotency IdempotencyConfig `yaml:"idempotency" toml:"idempotency" json:"idempotency"`
+	API         APIConfig         `yaml:"api" toml:"api" json:"api"`
+	SCIM        SCIMConfig        `yaml:"scim" toml:"scim" json:"scim"`
+	Admin       AdminConfig       `yaml:"admin" toml:"admin" json:"admin"`
+	Orgs        OrgsConfig        `yaml:"orgs" toml:"orgs" json:"orgs"`
+	SMTP        SMTPConfig        `yaml:"smtp" toml:"smtp" json:"smtp"`
+	Tenancy     TenancyConfig     `yaml:"tenancy" toml:"tenancy" json:"tenancy"`
+	Profiles    ProfilesConfig    `yaml:"profiles" toml:"profiles" json:"profiles"`
+	Blob        BlobConfig        `yaml:"blob" toml:"blob" json:"blob"`
+	Webhooks    WebhooksConfig    `yaml:"webhooks" toml:"webhooks" json:"webhooks"`
+	Events      EventsConfig      `yaml:"events" toml:"events" json:"events"`
+}
+
+// ServerConfig : HTTP server settings
+type ServerConfig struct {
+	Host              string        `yaml:"host" toml:"host" json:"host" env:"SERVER_HOST" flag:"host" usage:"address to listen on, empty for all interfaces"`
+	Port              string        `yaml:"port" toml:"port" json:"port" env:"SERVER_PORT" flag:"port" usage:"server port to run on"`
+	Mode              string        `yaml:"mode" toml:"mode" json:"mode" env:"SERVER_MODE" flag:"mode" usage:"server mode [debug, release, test]"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout" json:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" json:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout" json:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout" json:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" json:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"time given to in-flight requests to finish on shutdown"`
+	// DrainDelay is how long /readyz reports shutting_down before the listener stops, so load balancers can react
+	DrainDelay time.Duration `yaml:"drain_delay" toml:"drain_delay" json:"drain_delay" env:"SERVER_DRAIN_DELAY"`
+	// TrustedProxies are the addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For is believed
+	TrustedProxies []string  `yaml:"trusted_proxies" toml:"trusted_proxies" json:"trusted_proxies" env:"SERVER_TRUSTED_PROXIES"`
+	TLS            TLSConfig `yaml:"tls" toml:"tls" json:"tls"`
+}
+
+// TLSConfig : Native TLS / mutual TLS settings of the HTTP listener
+type TLSConfig struct {
+	Enabled        bool          `yaml:"enabled" toml:"enabled" json:"enabled" env:"TLS_ENABLED" flag:"tls" usage:"serve HTTPS instead of plain HTTP"`
+	CertFile       string        `yaml:"cert_file" toml:"cert_file" json:"cert_file" env:"TLS_CERT_FILE"`
+	KeyFile        string        `yaml:"key_file" toml:"key_file" json:"key_file" env:"TLS_KEY_FILE"`
+	MinVersion     string        `yaml:"min_version" toml:"min_version" json:"min_version" env:"TLS_MIN_VERSION"`
+	CipherSuites   []string      `yaml:"cipher_suites" toml:"cipher_suites" json:"cipher_suites" env:"TLS_CIPHER_SUITES"`
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval" json:"reload_interval" env:"TLS_RELOAD_INTERVAL"`
+	// ClientAuth is one of none, optional (verify if given) or require
+	ClientAuth   string `yaml:"client_auth" toml:"client_auth" json:"client_auth" env:"TLS_CLIENT_AUTH"`
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file" json:"client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	// ClientIdentity is the client certificate field mapped to a username / email : cn, email, dns or uri
+	ClientIdentity string `yaml:"client_identity" toml:"client_identity" json:"client_identity" env:"TLS_CLIENT_IDENTITY"`
+}
+
+// Addr : Address the HTTP server listens on
+func (s ServerConfig) Addr() string {
+	return net.JoinHostPort(s.Host, s.Port)
+}
+
+// DatabaseConfig : Database connection settings
+type DatabaseConfig struct {
+	Type     string `yaml:"type" toml:"type" json:"type" env:"DB_DRIVER" flag:"database" usage:"database type [redis, postgres]"`
+	Host     string `yaml:"host" toml:"host" json:"host" env:"DB_HOST"`
+	Port     string `yaml:"port" toml:"port" json:"port" env:"DB_PORT"`
+	User     string `yaml:"user" toml:"user" json:"user" env:"DB_USER"`
+	Name     string `yaml:"name" toml:"name" json:"name" env:"DB_NAME"`
+	Password string `yaml:"password" toml:"password" json:"password" env:"DB_PASSWORD" secret:"true"`
+	SSLMode  string `yaml:"sslmode" toml:"sslmode" json:"sslmode" env:"DB_SSLMODE"`
+	Seed     bool   `yaml:"seed" toml:"seed" json:"seed" env:"DB_SEED" flag:"seed" usage:"drop, migrate and seed the users table on startup"`
+	// QueryTimeout bounds every repository call, on top of the request's own deadline
+	QueryTimeout time.Duration `yaml:"query_timeout" toml:"query_timeout" json:"query_timeout" env:"DB_QUERY_TIMEOUT"`
+}
+
+// AuthConfig : JWT and password hashing settings
+type AuthConfig struct {
+	APISecret   string        `yaml:"api_secret" toml:"api_secret" json:"api_secret" env:"API_SECRET" secret:"true"`
+	TokenExpiry time.Duration `yaml:"token_expiry" toml:"token_expiry" json:"token_expiry" env:"TOKEN_EXPIRY"`
+	BcryptCost  int           `yaml:"bcrypt_cost" toml:"bcrypt_cost" json:"bcrypt_cost" env:"BCRYPT_COST"`
+}
+
+// LogConfig : Logger settings
+type LogConfig struct {
+	Level string `yaml:"level" toml:"level" json:"level" env:"LOG_LEVEL" flag:"loglevel" usage:"log level [trace, debug, info, warn, error, fatal, panic]"`
+}
+
+// HealthConfig : Readiness checks settings
+type HealthConfig struct {
+	CheckTimeout time.Duration `yaml:"check_timeout" toml:"check_timeout" json:"check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+}
+
+// UsersConfig : Account lifecycle settings
+type UsersConfig struct {
+	DeletionGracePeriod time.Duration `yaml:"deletion_grace_period" toml:"deletion_grace_period" json:"deletion_grace_period" env:"USERS_DELETION_GRACE_PERIOD"`
+	PurgeInterval       time.Duration `yaml:"purge_interval" toml:"purge_interval" json:"purge_interval" env:"USERS_PURGE_INTERVAL"`
+}
+
+// OrgsConfig : Organizations settings
+type OrgsConfig struct {
+	InvitationTTL time.Duration `yaml:"invitation_ttl" toml:"invitation_ttl" json:"invitation_ttl" env:"ORGS_INVITATION_TTL"`
+}
+
+// SMTPConfig : Server sending the emails, the invitations to join an organization. No email is sent without a host.
+type SMTPConfig struct {
+	Host     string        `yaml:"host" toml:"host" json:"host" env:"SMTP_HOST"`
+	Port     string        `yaml:"port" toml:"port" json:"port" env:"SMTP_PORT"`
+	Username string        `yaml:"username" toml:"username" json:"username" env:"SMTP_USERNAME"` // Empty to send without authenticating
+	Password string        `yaml:"password" toml:"password" json:"password" env:"SMTP_PASSWORD" secret:"true"`
+	From     string        `yaml:"from" toml:"from" json:"from" env:"SMTP_FROM"`
+	Timeout  time.Duration `yaml:"timeout" toml:"timeout" json:"timeout" env:"SMTP_TIMEOUT"`
+}
+
+// ProfilesConfig : What the users' profiles hold besides their account
+type ProfilesConfig struct {
+	// AttributesSchema is the JSON Schema file of the custom attributes, none are accepted without one
+	AttributesSchema string `yaml:"attributes_schema" toml:"attributes_schema" json:"attributes_schema" env:"PROFILES_ATTRIBUTES_SCHEMA"`
+	// AvatarMaxSize is the largest avatar upload accepted, in bytes
+	AvatarMaxSize int64 `yaml:"avatar_max_size" toml:"avatar_max_size" json:"avatar_max_size" env:"PROFILES_AVATAR_MAX_SIZE"`
+}
+
+// BlobConfig : Storage of the files kept outside of the database, e.g. the avatars
+type BlobConfig struct {
+	// Store is one of file (Dir on the local filesystem) or s3
+	Store string   `yaml:"store" toml:"store" json:"store" env:"BLOB_STORE"`
+	Dir   string   `yaml:"dir" toml:"dir" json:"dir" env:"BLOB_DIR"`
+	S3    S3Config `yaml:"s3" toml:"s3" json:"s3"`
+}
+
+// S3Config : Settings of an S3 compatible object storage, AWS S3 or e.g. a local MinIO
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" toml:"endpoint" json:"endpoint" env:"BLOB_S3_ENDPOINT"` // host[:port], without the scheme
+	Region    string `yaml:"region" toml:"region" json:"region" env:"BLOB_S3_REGION"`
+	Bucket    string `yaml:"bucket" toml:"bucket" json:"bucket" env:"BLOB_S3_BUCKET"`
+	AccessKey string `yaml:"access_key" toml:"access_key" json:"access_key" env:"BLOB_S3_ACCESS_KEY"`
+	SecretKey string `yaml:"secret_key" toml:"secret_key" json:"secret_key" env:"BLOB_S3_SECRET_KEY" secret:"true"`
+	UseSSL    bool   `yaml:"use_ssl" toml:"use_ssl" json:"use_ssl" env:"BLOB_S3_USE_SSL"`
+	// PathStyle addresses the buckets as endpoint/bucket rather than bucket.endpoint, as MinIO usually needs
+	PathStyle bool          `yaml:"path_style" toml:"path_style" json:"path_style" env:"BLOB_S3_PATH_STYLE"`
+	Timeout   time.Duration `yaml:"timeout" toml:"timeout" json:"timeout" env:"BLOB_S3_TIMEOUT"`
+}
+
+// TenancyConfig : Customers hosted side by side in one deployment, each in its own tenant
+type TenancyConfig struct {
+	Enabled bool     `yaml:"enabled" toml:"enabled" json:"enabled" env:"TENANCY_ENABLED"`
+	Tenants []string `yaml:"tenants" toml:"tenants" json:"tenants" env:"TENANCY_TENANTS"`
+	// Default is the tenant of the requests selecting none, empty to reject them
+	Default string `yaml:"default" toml:"default" json:"default" env:"TENANCY_DEFAULT"`
+	// BaseDomain is the domain whose subdomains name the tenants, e.g. acme.users.example.com for users.example.com
+	BaseDomain       string `yaml:"base_domain" toml:"base_domain" json:"base_domain" env:"TENANCY_BASE_DOMAIN"`
+	RowLevelSecurity bool   `yaml:"row_level_security" toml:"row_level_security" json:"row_level_security" env:"TENANCY_ROW_LEVEL_SECURITY"`
+}
+
+// tenantPattern, maxTenantLength : The tenant IDs are subdomains and fit the tenant_id columns
+var tenantPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const maxTenantLength = 40
+
+// IdempotencyConfig : Idempotency-Key support of the POST routes
+type IdempotencyConfig struct {
+	// Store is where the responses are kept : database (shared by every instance) or memory
+	Store         string        `yaml:"store" toml:"store" json:"store" env:"IDEMPOTENCY_STORE"`
+	KeyTTL        time.Duration `yaml:"key_ttl" toml:"key_ttl" json:"key_ttl" env:"IDEMPOTENCY_KEY_TTL"`
+	SweepInterval time.Duration `yaml:"sweep_interval" toml:"sweep_interval" json:"sweep_interval" env:"IDEMPOTENCY_SWEEP_INTERVAL"`
+	// MaxBodySize is the largest body of the requests with a key, which are read in memory to be fingerprinted
+	MaxBodySize int64 `yaml:"max_body_size" toml:"max_body_size" json:"max_body_size" env:"IDEMPOTENCY_MAX_BODY_SIZE"`
+}
+
+// APIConfig : API versions lifecycle
+type APIConfig struct {
+	// LegacyDeprecated is the RFC 3339 date the unversioned routes were deprecated, announced in their Deprecation header
+	LegacyDeprecated string `yaml:"legacy_deprecated" toml:"legacy_deprecated" json:"legacy_deprecated" env:"API_LEGACY_DEPRECATED"`
+	// LegacySunset is the RFC 3339 date the unversioned routes stop being served, announced in their Sunset header
+	LegacySunset string `yaml:"legacy_sunset" toml:"legacy_sunset" json:"legacy_sunset" env:"API_LEGACY_SUNSET"`
+}
+
+// LegacyDeprecatedAt : LegacyDeprecated, zero when it isn't set (or invalid, which Validate reports)
+func (a APIConfig) LegacyDeprecatedAt() time.Time {
+	t, _ := time.Parse(time.RFC3339, a.LegacyDeprecated)
+	return t
+}
+
+// LegacySunsetAt : LegacySunset, zero when it isn't set (or invalid, which Validate reports)
+func (a APIConfig) LegacySunsetAt() time.Time {
+	t, _ := time.Parse(time.RFC3339, a.LegacySunset)
+	return t
+}
+
+// SCIMConfig : SCIM provisioning endpoints, served under /scim/v2 when a token is set
+type SCIMConfig struct {
+	// Token is the bearer token the identity provider authenticates with
+	Token string `yaml:"token" toml:"token" json:"token" env:"SCIM_TOKEN" secret:"true"`
+}
+
+// AdminConfig : Operator access, the routes managing the service (webhooks, audit log, users admin console) are served when a token is set
+type AdminConfig struct {
+	// Token is the bearer token the operators authenticate with
+	Token string `yaml:"token" toml:"token" json:"token" env:"ADMIN_TOKEN" secret:"true"`
+}
+
+// minSCIMTokenLength, minAdminTokenLength : These tokens grant access to every user, they must not be guessable
+const (
+	minSCIMTokenLength  = 32
+	minAdminTokenLength = 32
+)
+
+// WebhooksConfig : Delivery of the user events to the webhooks, managed under /v1/webhooks when an admin token is set
+type WebhooksConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval" json:"poll_interval" env:"WEBHOOKS_POLL_INTERVAL"`
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout" json:"timeout" env:"WEBHOOKS_TIMEOUT"`
+	MaxAttempts  int           `yaml:"max_attempts" toml:"max_attempts" json:"max_attempts" env:"WEBHOOKS_MAX_ATTEMPTS"`
+	// InitialBackoff is the delay before the second attempt, doubled for every further one up to MaxBackoff
+	InitialBackoff time.Duration `yaml:"initial_backoff" toml:"initial_backoff" json:"initial_backoff" env:"WEBHOOKS_INITIAL_BACKOFF"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" toml:"max_backoff" json:"max_backoff" env:"WEBHOOKS_MAX_BACKOFF"`
+}
+
+// EventsConfig : Publication of the user events recorded in the outbox, to the webhooks and to the broker if any
+type EventsConfig struct {
+	Broker        string        `yaml:"broker" toml:"broker" json:"broker" env:"EVENTS_BROKER" flag:"events-broker" usage:"event broker [none, memory, nats, kafka]"`
+	RelayInterval time.Duration `yaml:"relay_interval" toml:"relay_interval" json:"relay_interval" env:"EVENTS_RELAY_INTERVAL"`
+	// PublishTimeout bounds the publication of a batch of events, the unpublished ones are retried after it
+	PublishTimeout time.Duration `yaml:"publish_timeout" toml:"publish_timeout" json:"publish_timeout" env:"EVENTS_PUBLISH_TIMEOUT"`
+	// Retention is how long the published events stay in the outbox
+	Retention time.Duration `yaml:"retention" toml:"retention" json:"retention" env:"EVENTS_RETENTION"`
+	NATS      NATSConfig    `yaml:"nats" toml:"nats" json:"nats"`
+	Kafka     KafkaConfig   `yaml:"kafka" toml:"kafka" json:"kafka"`
+}
+
+// NATSConfig : Settings of the NATS broker
+type NATSConfig struct {
+	URL           string `yaml:"url" toml:"url" json:"url" env:"EVENTS_NATS_URL"`
+	Token         string `yaml:"token" toml:"token" json:"token" env:"EVENTS_NATS_TOKEN" secret:"true"`
+	SubjectPrefix string `yaml:"subject_prefix" toml:"subject_prefix" json:"subject_prefix" env:"EVENTS_NATS_SUBJECT_PREFIX"`
+}
+
+// KafkaConfig : Settings of the Kafka compatible broker
+type KafkaConfig struct {
+	Brokers []string `yaml:"brokers" toml:"brokers" json:"brokers" env:"EVENTS_KAFKA_BROKERS"`
+	Topic   string   `yaml:"topic" toml:"topic" json:"topic" env:"EVENTS_KAFKA_TOPIC"`
+}
+
+// TracingConfig : OpenTelemetry tracing settings
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" json:"exporter" env:"TRACING_EXPORTER" flag:"tracing" usage:"trace exporter [none, stdout, otlp]"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" json:"endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" json:"insecure" env:"TRACING_OTLP_INSECURE"`
+	ServiceName string  `yaml:"service_name" toml:"service_name" json:"service_name" env:"OTEL_SERVICE_NAME"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" json:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// SecretsConfig : Secret provider used to resolve "secret:<key>" references
+type SecretsConfig struct {
+	Provider string      `yaml:"provider" toml:"provider" json:"provider" env:"SECRETS_PROVIDER" flag:"secrets" usage:"secret provider [none, file, vault]"`
+	Dir      string      `yaml:"dir" toml:"dir" json:"dir" env:"SECRETS_DIR"`
+	Vault    VaultConfig `yaml:"vault" toml:"vault" json:"vault"`
+}
+
+// VaultConfig : Settings for the Vault compatible (KV v2) secret provider
+type VaultConfig struct {
+	Addr      string        `yaml:"addr" toml:"addr" json:"addr" env:"VAULT_ADDR"`
+	Token     string        `yaml:"token" toml:"token" json:"token" env:"VAULT_TOKEN" secret:"true"`
+	Namespace string        `yaml:"namespace" toml:"namespace" json:"namespace" env:"VAULT_NAMESPACE"`
+	Mount     string        `yaml:"mount" toml:"mount" json:"mount" env:"VAULT_MOUNT"`
+	Path      string        `yaml:"path" toml:"path" json:"path" env:"VAULT_PATH"`
+	Timeout   time.Duration `yaml:"timeout" toml:"timeout" json:"timeout" env:"VAULT_TIMEOUT"`
+}
+
+// Default : Returns the configuration used when nothing else is specified
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:              "3000",
+			Mode:              "debug",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   20 * time.Second,
+			TLS: TLSConfig{
+				MinVersion:     "1.2",
+				ReloadInterval: 30 * time.Second,
+				ClientAuth:     "none",
+				ClientIdentity: "cn",
+			},
+		},
+		Database: DatabaseConfig{
+			Type:         "postgres",
+			Port:         "5432",
+			SSLMode:      "disable",
+			QueryTimeout: 5 * time.Second,
+		},
+		Auth: AuthConfig{
+			TokenExpiry: time.Hour,
+			BcryptCost:  bcrypt.DefaultCost,
+		},
+		Log: LogConfig{
+			Level: "debug",
+		},
+		Health: HealthConfig{
+			CheckTimeout: 2 * time.Second,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "tnbt",
+			SampleRatio: 1,
+		},
+		Users: UsersConfig{
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+			PurgeInterval:       time.Hour,
+		},
+		Orgs: OrgsConfig{
+			InvitationTTL: 7 * 24 * time.Hour,
+		},
+		SMTP: SMTPConfig{
+			Port:    "587",
+			Timeout: 10 * time.Second,
+		},
+		Tenancy: TenancyConfig{
+			Default: "default",
+		},
+		API: APIConfig{
+			// The release introducing /v1
+			LegacyDeprecated: "2026-10-19T00:00:00Z",
+		},
+		Profiles: ProfilesConfig{
+			AvatarMaxSize: 5 << 20,
+		},
+		Blob: BlobConfig{
+			Store: "file",
+			Dir:   "data/blobs",
+			S3: S3Config{
+				Endpoint:  "localhost:9000",
+				Bucket:    "tnbt",
+				PathStyle: true,
+				Timeout:   10 * time.Second,
+			},
+		},
+		Idempotency: IdempotencyConfig{
+			Store:         "database",
+			KeyTTL:        24 * time.Hour,
+			SweepInterval: 10 * time.Minute,
+			MaxBodySize:   1 << 20,
+		},
+		Webhooks: WebhooksConfig{
+			PollInterval:   5 * time.Second,
+			Timeout:        10 * time.Second,
+			MaxAttempts:    8,
+			InitialBackoff: 30 * time.Second,
+			MaxBackoff:     6 * time.Hour,
+		},
+		Events: EventsConfig{
+			Broker:         "none",
+			RelayInterval:  time.Second,
+			PublishTimeout: 10 * time.Second,
+			Retention:      7 * 24 * time.Hour,
+			NATS: NATSConfig{
+				URL:           "nats://localhost:4222",
+				SubjectPrefix: "tnbt",
+			},
+			Kafka: KafkaConfig{
+				Brokers: []string{"localhost:9092"},
+				Topic:   "tnbt.user-events",
+			},
+		},
+		Secrets: SecretsConfig{
+			Provider: "none",
+			Dir:      "/run/secrets",
+			Vault: VaultConfig{
+				Mount:   "secret",
+				Path:    "tnbt",
+				Timeout: 5 * time.Second,
+			},
+		},
+	}
+}
+
+// DSN : Connection string for the configured database
+func (d DatabaseConfig) DSN() string {
+	return fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s password=%s", d.Host, d.Port, d.User, d.Name, d.SSLMode, d.Password)
+}
+
+// Validate : Checks the configuration and reports every problem found at once
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if p, err := strconv.Atoi(c.Server.Port); err != nil || p < 1 || p > 65535 {
+		add("server.port must be a number between 1 and 65535, got %q", c.Server.Port)
+	}
+	switch c.Server.Mode {
+	case "debug", "release", "test":
+	default:
+		add("server.mode must be one of debug, release or test, got %q", c.Server.Mode)
+	}
+	for _, t := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+	} {
+		if t.value <= 0 {
+			add("%s must be positive, got %s", t.name, t.value)
+		}
+	}
+	if c.Server.DrainDelay < 0 {
+		add("server.drain_delay can't be negative, got %s", c.Server.DrainDelay)
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			add("server.trusted_proxies must hold IP addresses or CIDR ranges, got %q", proxy)
+		}
+	}
+	if c.Health.CheckTimeout <= 0 {
+		add("health.check_timeout must be positive, got %s", c.Health.CheckTimeout)
+	}
+	if c.Users.DeletionGracePeriod < 0 {
+		add("users.deletion_grace_period can't be negative, got %s", c.Users.DeletionGracePeriod)
+	}
+	if c.Users.PurgeInterval <= 0 {
+		add("users.purge_interval must be positive, got %s", c.Users.PurgeInterval)
+	}
+	if c.Orgs.InvitationTTL <= 0 {
+		add("orgs.invitation_ttl must be positive, got %s", c.Orgs.InvitationTTL)
+	}
+	if c.SMTP.Host != "" {
+		if p, err := strconv.Atoi(c.SMTP.Port); err != nil || p < 1 || p > 65535 {
+			add("smtp.port must be a number between 1 and 65535, got %q", c.SMTP.Port)
+		}
+		if c.SMTP.From == "" {
+			add("smtp.from is required to send emails")
+		}
+		if c.SMTP.Timeout <= 0 {
+			add("smtp.timeout must be positive, got %s", c.SMTP.Timeout)
+		}
+	}
+	if c.Tenancy.Enabled {
+		if len(c.Tenancy.Tenants) == 0 {
+			add("tenancy.tenants is required when tenancy is enabled")
+		}
+		known := false
+		for _, t := range c.Tenancy.Tenants {
+			if len(t) > maxTenantLength || !tenantPattern.MatchString(t) {
+				add("tenancy.tenants must be lowercase slugs of at most %d characters, got %q", maxTenantLength, t)
+			}
+			known = known || t == c.Tenancy.Default
+		}
+		if c.Tenancy.Default != "" && !known {
+			add("tenancy.default must be one of tenancy.tenants, got %q", c.Tenancy.Default)
+		}
+	}
+	if c.Profiles.AvatarMaxSize <= 0 {
+		add("profiles.avatar_max_size must be positive, got %d", c.Profiles.AvatarMaxSize)
+	}
+	switch c.Blob.Store {
+	case "file":
+		if c.Blob.Dir == "" {
+			add("blob.dir is required for the file store")
+		}
+	case "s3":
+		if c.Blob.S3.Endpoint == "" {
+			add("blob.s3.endpoint is required for the s3 store")
+		}
+		if c.Blob.S3.Bucket == "" {
+			add("blob.s3.bucket is required for the s3 store")
+		}
+		if c.Blob.S3.Timeout <= 0 {
+			add("blob.s3.timeout must be positive, got %s", c.Blob.S3.Timeout)
+		}
+	default:
+		add("blob.store must be one of file or s3, got %q", c.Blob.Store)
+	}
+	switch c.Idempotency.Store {
+	case "database", "memory":
+	default:
+		add("idempotency.store must be one of database or memory, got %q", c.Idempotency.Store)
+	}
+	if c.Idempotency.KeyTTL <= 0 {
+		add("idempotency.key_ttl must be positive, got %s", c.Idempotency.KeyTTL)
+	}
+	if c.Idempotency.SweepInterval <= 0 {
+		add("idempotency.sweep_interval must be positive, got %s", c.Idempotency.SweepInterval)
+	}
+	if c.Idempotency.MaxBodySize <= 0 {
+		add("idempotency.max_body_size must be positive, got %d", c.Idempotency.MaxBodySize)
+	}
+	for _, date := range []struct {
+		name, value string
+	}{
+		{"api.legacy_deprecated", c.API.LegacyDeprecated},
+		{"api.legacy_sunset", c.API.LegacySunset},
+	} {
+		if date.value == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, date.value); err != nil {
+			add("%s must be an RFC 3339 date, got %q", date.name, date.value)
+		}
+	}
+	if c.SCIM.Token != "" && len(c.SCIM.Token) < minSCIMTokenLength {
+		add("scim.token must be at least %d characters long", minSCIMTokenLength)
+	}
+	if c.Admin.Token != "" && len(c.Admin.Token) < minAdminTokenLength {
+		add("admin.token must be at least %d characters long", minAdminTokenLength)
+	}
+	for _, t := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"webhooks.poll_interval", c.Webhooks.PollInterval},
+		{"webhooks.timeout", c.Webhooks.Timeout},
+		{"webhooks.initial_backoff", c.Webhooks.InitialBackoff},
+		{"events.relay_interval", c.Events.RelayInterval},
+		{"events.publish_timeout", c.Events.PublishTimeout},
+		{"events.retention", c.Events.Retention},
+	} {
+		if t.value <= 0 {
+			add("%s must be positive, got %s", t.name, t.value)
+		}
+	}
+	if c.Webhooks.MaxBackoff < c.Webhooks.InitialBackoff {
+		add("webhooks.max_backoff can't be shorter than webhooks.initial_backoff, got %s", c.Webhooks.MaxBackoff)
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		add("webhooks.max_attempts must be at least 1, got %d", c.Webhooks.MaxAttempts)
+	}
+	switch c.Events.Broker {
+	case "none", "memory":
+	case "nats":
+		if c.Events.NATS.URL == "" {
+			add("events.nats.url is required for the nats broker")
+		}
+		if c.Events.NATS.SubjectPrefix == "" {
+			add("events.nats.subject_prefix is required for the nats broker")
+		}
+	case "kafka":
+		if len(c.Events.Kafka.Brokers) == 0 {
+			add("events.kafka.brokers is required for the kafka broker")
+		}
+		if c.Events.Kafka.Topic == "" {
+			add("events.kafka.topic is required for the kafka broker")
+		}
+	default:
+		add("events.broker must be one of none, memory, nats or kafka, got %q", c.Events.Broker)
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		add("tracing.exporter must be one of none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+	if c.Server.TLS.Enabled {
+		c.Server.TLS.validate(add)
+	}
+
+	switch c.Database.Type {
+	case "postgres":
+		if c.Database.Host == "" {
+			add("database.host is required")
+		}
+		if c.Database.User == "" {
+			add("database.user is required")
+		}
+		if c.Database.Name == "" {
+			add("database.name is required")
+		}
+		if p, err := strconv.Atoi(c.Database.Port); err != nil || p < 1 || p > 65535 {
+			add("database.port must be a number between 1 and 65535, got %q", c.Database.Port)
+		}
+		if c.Database.QueryTimeout < 0 {
+			add("database.query_timeout can't be negative, got %s", c.Database.QueryTimeout)
+		}
+	default:
+		add("database.type %q is not supported", c.Database.Type)
+	}
+
+	if c.Auth.APISecret == "" {
+		add("auth.api_secret is required")
+	}
+	if c.Auth.TokenExpiry <= 0 {
+		add("auth.token_expiry must be positive, got %s", c.Auth.TokenExpiry)
+	}
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		add("auth.bcrypt_cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.Auth.BcryptCost)
+	}
+
+	switch c.Log.Level {
+	case "trace", "debug", "info", "warn", "error", "fatal", "panic":
+	default:
+		add("log.level %q is not a valid level", c.Log.Level)
+	}
+
+	switch c.Secrets.Provider {
+	case "", "none":
+	case "file":
+		if c.Secrets.Dir == "" {
+			add("secrets.dir is required for the file provider")
+		}
+	case "vault":
+		if c.Secrets.Vault.Addr == "" {
+			add("secrets.vault.addr is required for the vault provider")
+		}
+		if c.Secrets.Vault.Token == "" {
+			add("secrets.vault.token is required for the vault provider")
+		}
+	default:
+		add("secrets.provider %q is not supported", c.Secrets.Provider)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration : %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (t TLSConfig) validate(add func(string, ...interface{})) {
+	if t.CertFile == "" || t.KeyFile == "" {
+		add("server.tls.cert_file and server.tls.key_file are required when TLS is enabled")
+	}
+	switch t.MinVersion {
+	case "1.0", "1.1", "1.2", "1.3":
+	default:
+		add("server.tls.min_version must be one of 1.0, 1.1, 1.2 or 1.3, got %q", t.MinVersion)
+	}
+	if t.ReloadInterval <= 0 {
+		add("server.tls.reload_interval must be positive, got %s", t.ReloadInterval)
+	}
+	switch t.ClientAuth {
+	case "none":
+	case "optional", "require":
+		if t.ClientCAFile == "" {
+			add("server.tls.client_ca_file is required when client certificates are verified")
+		}
+	default:
+		add("server.tls.client_auth must be one of none, optional or require, got %q", t.ClientAuth)
+	}
+	switch t.ClientIdentity {
+	case "cn", "email", "dns", "uri":
+	default:
+		add("server.tls.client_identity must be one of cn, email, dns or uri, got %q", t.ClientIdentity)
+	}
+}
+
+// Redacted : Copy of the configuration that is safe to print, secrets are masked
+func (c *Config) Redacted() Config {
+	redacted := *c
+	walk(&redacted, func(f field) {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString(redactedValue)
+		}
+	})
+	return redacted
+}
+
+const redactedValue = "******"
@@ -0,0 +1,249 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const secretPrefix = "secret:"
+
+// Load : Builds the effective configuration from defaults, the config file, the environment and the command line flags
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	// .env is optional, it only fills the variables that are not already set in the environment
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, errors.Wrap(err, "pkg.config.Load : reading .env")
+	}
+
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	var flags []*flagValue
+	walk(cfg, func(f field) {
+		if f.flag == "" {
+			return
+		}
+		fv := &flagValue{field: f}
+		flags = append(flags, fv)
+		fs.Var(fv, f.flag, f.usage)
+	})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := loadEnv(cfg); err != nil {
+		return nil, err
+	}
+	// Flags were only recorded while parsing, apply them last so they win over everything else
+	for _, fv := range flags {
+		if !fv.set {
+			continue
+		}
+		if err := setFromString(fv.field.value, fv.raw); err != nil {
+			return nil, errors.Wrapf(err, "pkg.config.Load : flag -%s", fv.field.flag)
+		}
+	}
+
+	if err := resolveSecrets(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "pkg.config.loadFile")
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, cfg)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), cfg)
+		if err == nil && len(md.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown keys %v", md.Undecoded())
+		}
+	default:
+		err = fmt.Errorf("unsupported config file format %q, use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	return errors.Wrapf(err, "pkg.config.loadFile : %s", path)
+}
+
+func loadEnv(cfg *Config) error {
+	var err error
+	walk(cfg, func(f field) {
+		if err != nil || f.env == "" {
+			return
+		}
+		raw, ok := os.LookupEnv(f.env)
+		if !ok {
+			return
+		}
+		if e := setFromString(f.value, raw); e != nil {
+			err = errors.Wrapf(e, "pkg.config.loadEnv : %s", f.env)
+		}
+	})
+	return err
+}
+
+// resolveSecrets : Replaces "secret:<key>" values with the value held by the secret provider.
+// The secrets section itself is skipped, the provider can't be used to configure itself.
+func resolveSecrets(cfg *Config) error {
+	var refs []field
+	walk(cfg, func(f field) {
+		if f.value.Kind() == reflect.String && strings.HasPrefix(f.value.String(), secretPrefix) && !strings.HasPrefix(f.path, "secrets.") {
+			refs = append(refs, f)
+		}
+	})
+	if len(refs) == 0 {
+		return nil
+	}
+	provider, err := NewSecretProvider(cfg.Secrets)
+	if err != nil {
+		return err
+	}
+	for _, f := range refs {
+		key := strings.TrimPrefix(f.value.String(), secretPrefix)
+		value, err := provider.Secret(key)
+		if err != nil {
+			return errors.Wrapf(err, "pkg.config.resolveSecrets : %s", f.path)
+		}
+		f.value.SetString(value)
+	}
+	return nil
+}
+
+// field : A leaf of the Config struct along with its struct tags
+type field struct {
+	path   string
+	value  reflect.Value
+	env    string
+	flag   string
+	usage  string
+	secret bool
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// walk : Calls fn for every leaf field of the struct pointed to by v
+func walk(v interface{}, fn func(field)) {
+	walkValue(reflect.ValueOf(v).Elem(), "", fn)
+}
+
+func walkValue(v reflect.Value, prefix string, fn func(field)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			walkValue(fv, prefix+name+".", fn)
+			continue
+		}
+		fn(field{
+			path:   prefix + name,
+			value:  fv,
+			env:    sf.Tag.Get("env"),
+			flag:   sf.Tag.Get("flag"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+		})
+	}
+}
+
+func setFromString(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// flagValue : flag.Value that only records what was passed on the command line
+type flagValue struct {
+	field field
+	raw   string
+	set   bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil || !f.field.value.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(f.field.value.Interface())
+}
+
+func (f *flagValue) Set(raw string) error {
+	if err := setFromString(reflect.New(f.field.value.Type()).Elem(), raw); err != nil {
+		return err
+	}
+	f.raw, f.set = raw, true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.field.value.Kind() == reflect.Bool
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SecretProvider : Source of secret values referenced as "secret:<key>" in the configuration
+type SecretProvider interface {
+	Secret(key string) (string, error)
+}
+
+// NewSecretProvider : Returns the SecretProvider selected in the configuration
+func NewSecretProvider(cfg SecretsConfig) (SecretProvider, error) {
+	switch cfg.Provider {
+	case "file":
+		return NewFileSecretProvider(cfg.Dir), nil
+	case "vault":
+		return NewVaultSecretProvider(cfg.Vault), nil
+	default:
+		return nil, fmt.Errorf("pkg.config.NewSecretProvider : secret references need a secrets provider, got %q", cfg.Provider)
+	}
+}
+
+type fileSecretProvider struct {
+	dir string
+}
+
+// NewFileSecretProvider : Reads every secret from its own file inside dir (e.g. docker / kubernetes secrets mounted at /run/secrets)
+func NewFileSecretProvider(dir string) SecretProvider {
+	return &fileSecretProvider{dir}
+}
+
+func (p *fileSecretProvider) Secret(key string) (string, error) {
+	// Keys are file names, don't let them walk out of the secrets directory
+	if key == "" || key != filepath.Base(key) {
+		return "", fmt.Errorf("pkg.config.fileSecretProvider : invalid secret key %q", key)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(p.dir, key))
+	if err != nil {
+		return "", errors.Wrap(err, "pkg.config.fileSecretProvider")
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+type vaultSecretProvider struct {
+	cfg    VaultConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]map[string]string
+}
+
+// NewVaultSecretProvider : Reads secrets from a Vault compatible KV v2 engine.
+// Keys are either "<field>", read from the configured path, or "<path>#<field>".
+func NewVaultSecretProvider(cfg VaultConfig) SecretProvider {
+	return &vaultSecretProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		cache:  make(map[string]map[string]string),
+	}
+}
+
+func (p *vaultSecretProvider) Secret(key string) (string, error) {
+	path, name := p.cfg.Path, key
+	if i := strings.Index(key, "#"); i >= 0 {
+		path, name = key[:i], key[i+1:]
+	}
+	data, err := p.read(path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[name]
+	if !ok {
+		return "", fmt.Errorf("pkg.config.vaultSecretProvider : %q not found at %s", name, path)
+	}
+	return value, nil
+}
+
+// read : Fetches (once) all the key / value pairs stored at path
+func (p *vaultSecretProvider) read(path string) (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if data, ok := p.cache[path]; ok {
+		return data, nil
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(p.cfg.Addr, "/"), strings.Trim(p.cfg.Mount, "/"), strings.Trim(path, "/"))
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.config.vaultSecretProvider")
+	}
+	req.Header.Set("X-Vault-Token", p.cfg.Token)
+	if p.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.config.vaultSecretProvider")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pkg.config.vaultSecretProvider : reading %s returned %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "pkg.config.vaultSecretProvider")
+	}
+	data := make(map[string]string, len(body.Data.Data))
+	for k, v := range body.Data.Data {
+		data[k] = fmt.Sprint(v)
+	}
+	p.cache[path] = data
+	return data, nil
+}
@@ -0,0 +1,216 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// auditRecord : Row of an audit.Record, the seq is assigned from the head of the chain rather than a sequence so that
+// it has no gaps
+type auditRecord struct {
+	Seq        uint64    `gorm:"primary_key;auto_increment:false"`
+	OccurredAt time.Time `gorm:"not null;index"`
+	ActorType  string    `gorm:"size:20;not null"`
+	ActorID    uint64    `gorm:"not null;index"`
+	Action     string    `gorm:"size:64;not null;index"`
+	TargetType string    `gorm:"size:20;not null"`
+	TargetID   uint64    `gorm:"not null;index"`
+	TargetName string    `gorm:"size:255;not null"`
+	Outcome    string    `gorm:"size:20;not null"`
+	Reason     string    `gorm:"type:text;not null"`
+	IP         string    `gorm:"size:64;not null"`
+	UserAgent  string    `gorm:"type:text;not null"`
+	RequestID  string    `gorm:"size:64;not null;index"`
+	Changes    string    `gorm:"type:text;not null"` // audit.Changes as JSON, empty for none
+	PrevHash   string    `gorm:"size:64;not null"`
+	Hash       string    `gorm:"size:64;not null"`
+}
+
+func (auditRecord) TableName() string {
+	return "audit_records"
+}
+
+// auditChain : The single row (ID 1) holding the head of the chain. Locking it serializes the appends.
+type auditChain struct {
+	ID   uint64 `gorm:"primary_key;auto_increment:false"`
+	Seq  uint64 `gorm:"not null"`
+	Hash string `gorm:"size:64;not null"`
+}
+
+func (auditChain) TableName() string {
+	return "audit_chain"
+}
+
+const auditChainID = 1
+
+type auditStore struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// MigrateAudit : Creates the tables of the audit log and the head of its chain
+func MigrateAudit(db *gorm.DB) error {
+	if err := db.AutoMigrate(&auditRecord{}, &auditChain{}).Error; err != nil {
+		return errors.Wrap(err, "pkg.database.postgres.MigrateAudit")
+	}
+	err := db.Exec(`INSERT INTO audit_chain (id, seq, hash) VALUES (?, 0, '') ON CONFLICT (id) DO NOTHING`, auditChainID).Error
+	if err != nil {
+		return errors.Wrap(err, "pkg.database.postgres.MigrateAudit")
+	}
+	return nil
+}
+
+// NewPostgresAuditStore : audit.Store shared by every instance, see MigrateAudit
+func NewPostgresAuditStore(db *gorm.DB, queryTimeout time.Duration) audit.Store {
+	return &auditStore{db: db, queryTimeout: queryTimeout}
+}
+
+func (s *auditStore) Append(ctx context.Context, r *audit.Record) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.auditStore.Append")
+	defer func() { tracing.End(span, err) }()
+	changes := ""
+	if len(r.Changes) > 0 {
+		data, err := json.Marshal(r.Changes)
+		if err != nil {
+			return err
+		}
+		changes = string(data)
+		// Hashed as it'll be read back
+		r.Changes = nil
+		if err := json.Unmarshal(data, &r.Changes); err != nil {
+			return err
+		}
+	}
+	return inTx(ctx, s.db, s.queryTimeout, false, func(tx *gorm.DB) error {
+		// Taking the row lock first, the concurrent appends wait for this one to commit and then see its hash
+		if err := tx.Exec(`UPDATE audit_chain SET seq = seq + 1 WHERE id = ?`, auditChainID).Error; err != nil {
+			return err
+		}
+		head := new(auditChain)
+		if err := tx.Where("id = ?", auditChainID).First(head).Error; err != nil {
+			return err
+		}
+		r.Normalize()
+		r.Seq, r.PrevHash = head.Seq, head.Hash
+		r.Hash = r.ComputeHash()
+		row := &auditRecord{
+			Seq:        r.Seq,
+			OccurredAt: r.OccurredAt,
+			ActorType:  r.ActorType,
+			ActorID:    r.ActorID,
+			Action:     r.Action,
+			TargetType: r.TargetType,
+			TargetID:   r.TargetID,
+			TargetName: r.TargetName,
+			Outcome:    r.Outcome,
+			Reason:     r.Reason,
+			IP:         r.IP,
+			UserAgent:  r.UserAgent,
+			RequestID:  r.RequestID,
+			Changes:    changes,
+			PrevHash:   r.PrevHash,
+			Hash:       r.Hash,
+		}
+		if err := tx.Create(row).Error; err != nil {
+			return err
+		}
+		return tx.Model(&auditChain{}).Where("id = ?", auditChainID).Update("hash", r.Hash).Error
+	})
+}
+
+func (s *auditStore) List(ctx context.Context, f audit.Filter) (_ []audit.Record, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.auditStore.List")
+	defer func() { tracing.End(span, err) }()
+	var rows []auditRecord
+	err = inTx(ctx, s.db, s.queryTimeout, true, func(tx *gorm.DB) error {
+		q := tx.Model(&auditRecord{})
+		for column, value := range map[string]string{
+			"actor_type": f.ActorType, "action": f.Action, "target_name": f.TargetName, "outcome": f.Outcome,
+			"request_id": f.RequestID, "ip": f.IP,
+		} {
+			if value != "" {
+				q = q.Where(column+" = ?", value)
+			}
+		}
+		for column, value := range map[string]uint64{"actor_id": f.ActorID, "target_id": f.TargetID} {
+			if value != 0 {
+				q = q.Where(column+" = ?", value)
+			}
+		}
+		if !f.Since.IsZero() {
+			q = q.Where("occurred_at >= ?", f.Since)
+		}
+		if !f.Until.IsZero() {
+			q = q.Where("occurred_at < ?", f.Until)
+		}
+		if f.BeforeSeq != 0 {
+			q = q.Where("seq < ?", f.BeforeSeq)
+		}
+		return q.Order("seq DESC").Limit(f.Limit).Find(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return auditRecords(rows)
+}
+
+func (s *auditStore) Range(ctx context.Context, afterSeq uint64, limit int) (_ []audit.Record, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.auditStore.Range")
+	defer func() { tracing.End(span, err) }()
+	var rows []auditRecord
+	err = inTx(ctx, s.db, s.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Where("seq > ?", afterSeq).Order("seq").Limit(limit).Find(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return auditRecords(rows)
+}
+
+func (s *auditStore) Head(ctx context.Context) (_ uint64, _ string, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.auditStore.Head")
+	defer func() { tracing.End(span, err) }()
+	head := new(auditChain)
+	err = inTx(ctx, s.db, s.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Where("id = ?", auditChainID).First(head).Error
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	return head.Seq, head.Hash, nil
+}
+
+func auditRecords(rows []auditRecord) ([]audit.Record, error) {
+	records := make([]audit.Record, len(rows))
+	for i, row := range rows {
+		records[i] = audit.Record{
+			Seq:        row.Seq,
+			OccurredAt: row.OccurredAt.UTC(),
+			ActorType:  row.ActorType,
+			ActorID:    row.ActorID,
+			Action:     row.Action,
+			TargetType: row.TargetType,
+			TargetID:   row.TargetID,
+			TargetName: row.TargetName,
+			Outcome:    row.Outcome,
+			Reason:     row.Reason,
+			IP:         row.IP,
+			UserAgent:  row.UserAgent,
+			RequestID:  row.RequestID,
+			PrevHash:   row.PrevHash,
+			Hash:       row.Hash,
+		}
+		if row.Changes != "" {
+			if err := json.Unmarshal([]byte(row.Changes), &records[i].Changes); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// postgresDSNEnv : Connection string of a Postgres database the tests are free to empty, the tests needing Postgres
+// are skipped without it. Row level security doesn't apply to superusers, its tests need another role.
+const postgresDSNEnv = "TEST_POSTGRES_DSN"
+
+// sqliteIndexes : The unique indexes of userIndexes, orgIndexes and scimIndexes, which the repositories rely on, in the subset of
+// SQL SQLite understands
+var sqliteIndexes = []string{
+	`CREATE UNIQUE INDEX idx_users_tenant_username ON users (tenant_id, username)`,
+	`CREATE UNIQUE INDEX idx_users_tenant_email ON users (tenant_id, email)`,
+	`CREATE UNIQUE INDEX idx_organizations_tenant_slug ON organizations (tenant_id, slug)`,
+	`CREATE UNIQUE INDEX idx_org_memberships_owner ON org_memberships (org_id) WHERE role = 'owner'`,
+	`CREATE UNIQUE INDEX idx_scim_groups_tenant_display_name ON scim_groups (tenant_id, display_name)`,
+}
+
+// newTestDB : In-memory SQLite database with the tables of the users, their events, the organizations and the SCIM
+// groups. Their migrations hold Postgres only statements, the tables are created by gorm and the indexes by hand.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would get a database of its own
+	db.DB().SetMaxOpenConns(1)
+	err = db.AutoMigrate(
+		&user.User{}, &userEvent{}, &organization{}, &orgMembership{}, &orgInvitation{}, &scimGroup{}, &scimGroupMember{},
+	).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range sqliteIndexes {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// newTestPostgres : Database of postgresDSNEnv, emptied and migrated, with row level security when rls is set. The
+// returned func closes it and turns row level security off again.
+func newTestPostgres(t *testing.T, rls bool) (*gorm.DB, func()) {
+	t.Helper()
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s isn't set", postgresDSNEnv)
+	}
+	db, err := gorm.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const tables = `scim_group_members, scim_groups, org_invitations, org_memberships, organizations, user_events, users`
+	if err := db.Exec(`DROP TABLE IF EXISTS ` + tables + ` CASCADE`).Error; err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	if err := MigrateOrganizations(db); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	if err := MigrateSCIM(db); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	if rls {
+		var superuser bool
+		if err := db.Raw("SELECT rolsuper FROM pg_roles WHERE rolname = current_user").Row().Scan(&superuser); err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+		if superuser {
+			db.Close()
+			t.Skip("row level security doesn't apply to superusers")
+		}
+		if err := EnableRowLevelSecurity(db); err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+	return db, func() {
+		rowLevelSecurity = false
+		db.Close()
+	}
+}
+
+// inTenant : Context of a request of the tenant
+func inTenant(tenantID string) context.Context {
+	return requestctx.WithTenant(context.Background(), tenantID)
+}
+
+// newTestUser : User to create, named after username
+func newTestUser(username string) *user.User {
+	u := &user.User{Password: "hashed password"}
+	u.Username = username
+	u.Email = username + "@example.com"
+	return u
+}
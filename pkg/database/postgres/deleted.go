@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/jinzhu/gorm"
+)
+
+// Soft deleted users are only reachable through Unscoped queries
+
+func (r *userRepository) GetDeletedUserByUsername(ctx context.Context, username string) (_ *user.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.GetDeletedUserByUsername")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u := new(user.User)
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Scopes(scope).Unscoped().Where("username = ? AND deleted_at IS NOT NULL", username).First(u).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *userRepository) RestoreUser(ctx context.Context, uid uint64, deletedAfter time.Time) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.RestoreUser")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return err
+	}
+	var restored int64
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		res := tx.Scopes(scope).Unscoped().Model(&user.User{}).
+			Where("id = ? AND deleted_at > ?", uid, deletedAfter).
+			Updates(map[string]interface{}{
+				"deleted_at": gorm.Expr("NULL"),
+				"updated_at": time.Now(),
+				"version":    gorm.Expr("version + 1"),
+			})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		restored = res.RowsAffected
+		u := new(user.User)
+		if err := tx.Scopes(scope).Where("id = ?", uid).First(u).Error; err != nil {
+			return err
+		}
+		return recordEvents(tx, user.NewEvent(user.EventRestored, u.UserInfoPayload))
+	})
+	if err != nil {
+		return err
+	}
+	if restored == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+func (r *userRepository) DeletedUserIDs(ctx context.Context, deletedBefore time.Time, limit int) (_ []uint64, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.DeletedUserIDs")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Scopes(scope).Unscoped().Model(&user.User{}).
+			Where("deleted_at < ?", deletedBefore).
+			Order("deleted_at").Limit(limit).
+			Pluck("id", &ids).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *userRepository) PurgeUsers(ctx context.Context, uids []uint64) (_ int64, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.PurgeUsers")
+	defer func() { tracing.End(span, err) }()
+	if len(uids) == 0 {
+		return 0, nil
+	}
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var purged int64
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		// The deleted_at condition keeps the users restored in the meantime
+		res := tx.Scopes(scope).Unscoped().Where("id IN (?) AND deleted_at IS NOT NULL", uids).Delete(&user.User{})
+		purged = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
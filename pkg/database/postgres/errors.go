@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/lib/pq"
+)
+
+// uniqueViolation : SQLSTATE of a unique constraint violation
+const uniqueViolation = "23505"
+
+// uniqueFields : Columns with a unique constraint, and the error returned when a value is already taken
+var uniqueFields = []struct {
+	column string
+	err    *apperrors.Error
+}{
+	{"username", user.ErrUsernameTaken},
+	{"email", user.ErrEmailTaken},
+}
+
+// translateError : Maps the driver errors the callers can act on to domain errors
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolation {
+		return err
+	}
+	// Constraints are named after the column, e.g. idx_users_tenant_username
+	for _, f := range uniqueFields {
+		if strings.Contains(pqErr.Constraint, f.column) {
+			return f.err.Wrap(err)
+		}
+	}
+	return apperrors.Conflict("User already exists").Wrap(err)
+}
@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/idempotency"
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// idempotencyKey : Row of an idempotency.Record
+type idempotencyKey struct {
+	Scope       string `gorm:"primary_key;size:100"`
+	Key         string `gorm:"column:idempotency_key;primary_key;size:255"`
+	RequestHash string `gorm:"size:64;not null"`
+	Completed   bool   `gorm:"not null;default:false"`
+	Status      int    `gorm:"not null;default:0"`
+	Header      string `gorm:"type:text;not null;default:''"` // JSON
+	Body        []byte
+	ExpiresAt   time.Time `gorm:"not null;index"`
+}
+
+func (idempotencyKey) TableName() string {
+	return "idempotency_keys"
+}
+
+// reserveAttempts : A reservation conflicting with a record released in the meantime is retried
+const reserveAttempts = 3
+
+// reserveIdempotencyKey : Inserts the record, or takes over the existing one when it expired
+const reserveIdempotencyKey = `
+INSERT INTO idempotency_keys (scope, idempotency_key, request_hash, completed, status, header, body, expires_at)
+VALUES (?, ?, ?, ?, 0, '', NULL, ?)
+ON CONFLICT (scope, idempotency_key) DO UPDATE
+SET request_hash = EXCLUDED.request_hash, completed = EXCLUDED.completed, status = 0, header = '', body = NULL,
+    expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at <= ?`
+
+type idempotencyStore struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// MigrateIdempotency : Creates the table of the idempotency keys
+func MigrateIdempotency(db *gorm.DB) error {
+	if err := db.AutoMigrate(&idempotencyKey{}).Error; err != nil {
+		return errors.Wrap(err, "pkg.database.postgres.MigrateIdempotency")
+	}
+	return nil
+}
+
+// NewPostgresIdempotencyStore : idempotency.Store shared by every instance, see MigrateIdempotency
+func NewPostgresIdempotencyStore(db *gorm.DB, queryTimeout time.Duration) idempotency.Store {
+	return &idempotencyStore{db: db, queryTimeout: queryTimeout}
+}
+
+func (s *idempotencyStore) Reserve(ctx context.Context, rec *idempotency.Record) (_ *idempotency.Record, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.idempotencyStore.Reserve")
+	defer func() { tracing.End(span, err) }()
+	for attempt := 0; attempt < reserveAttempts; attempt++ {
+		var existing *idempotency.Record
+		err = inTx(ctx, s.db, s.queryTimeout, false, func(tx *gorm.DB) error {
+			res := tx.Exec(reserveIdempotencyKey, rec.Scope, rec.Key, rec.RequestHash, false, rec.ExpiresAt, time.Now())
+			if res.Error != nil || res.RowsAffected > 0 {
+				return res.Error
+			}
+			row := new(idempotencyKey)
+			if err := tx.Where("scope = ? AND idempotency_key = ?", rec.Scope, rec.Key).First(row).Error; err != nil {
+				return err
+			}
+			existing, err = row.record()
+			return err
+		})
+		if gorm.IsRecordNotFoundError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	return nil, err
+}
+
+func (s *idempotencyStore) Complete(ctx context.Context, rec *idempotency.Record) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.idempotencyStore.Complete")
+	defer func() { tracing.End(span, err) }()
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return err
+	}
+	return inTx(ctx, s.db, s.queryTimeout, false, func(tx *gorm.DB) error {
+		return tx.Model(&idempotencyKey{}).
+			Where("scope = ? AND idempotency_key = ? AND request_hash = ?", rec.Scope, rec.Key, rec.RequestHash).
+			Updates(map[string]interface{}{
+				"completed": true,
+				"status":    rec.Status,
+				"header":    string(header),
+				"body":      rec.Body,
+			}).Error
+	})
+}
+
+func (s *idempotencyStore) Release(ctx context.Context, scope, key string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.idempotencyStore.Release")
+	defer func() { tracing.End(span, err) }()
+	return inTx(ctx, s.db, s.queryTimeout, false, func(tx *gorm.DB) error {
+		return tx.Where("scope = ? AND idempotency_key = ? AND completed = ?", scope, key, false).
+			Delete(&idempotencyKey{}).Error
+	})
+}
+
+func (s *idempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (_ int64, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.idempotencyStore.DeleteExpired")
+	defer func() { tracing.End(span, err) }()
+	var deleted int64
+	err = inTx(ctx, s.db, s.queryTimeout, false, func(tx *gorm.DB) error {
+		res := tx.Where("expires_at <= ?", now).Delete(&idempotencyKey{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+func (k *idempotencyKey) record() (*idempotency.Record, error) {
+	rec := &idempotency.Record{
+		Key:         k.Key,
+		Scope:       k.Scope,
+		RequestHash: k.RequestHash,
+		Completed:   k.Completed,
+		Status:      k.Status,
+		Body:        k.Body,
+		ExpiresAt:   k.ExpiresAt,
+	}
+	if k.Header != "" {
+		rec.Header = make(http.Header)
+		if err := json.Unmarshal([]byte(k.Header), &rec.Header); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
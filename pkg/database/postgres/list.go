@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/jinzhu/gorm"
+)
+
+// uniqueSorts : Sort columns which are unique on their own, the others need the ID as a tie breaker
+var uniqueSorts = map[string]bool{"id": true, "username": true, "email": true}
+
+// likeEscaper : Escapes the LIKE wildcards of user input, used with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (r *userRepository) ListUsers(ctx context.Context, q user.ListQuery) (_ *user.ListPage, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.ListUsers")
+	defer func() { tracing.End(span, err) }()
+
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	page := new(user.ListPage)
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		filtered := applyFilter(tx.Scopes(scope).Model(&user.User{}), q.Filter)
+		if q.WithTotal {
+			var total int64
+			if err := filtered.Count(&total).Error; err != nil {
+				return err
+			}
+			page.Total = &total
+		}
+
+		direction, cmp := "ASC", ">"
+		if q.Desc {
+			direction, cmp = "DESC", "<"
+		}
+		query := filtered
+		if q.After != nil {
+			value, err := q.After.SortValue()
+			if err != nil {
+				return err
+			}
+			switch {
+			case q.Sort == "id":
+				query = query.Where("id "+cmp+" ?", q.After.ID)
+			case uniqueSorts[q.Sort]:
+				query = query.Where(q.Sort+" "+cmp+" ?", value)
+			default:
+				query = query.Where("("+q.Sort+", id) "+cmp+" (?, ?)", value, q.After.ID)
+			}
+		}
+		query = query.Order(q.Sort + " " + direction)
+		if !uniqueSorts[q.Sort] {
+			query = query.Order("id " + direction)
+		}
+		// One extra row tells whether there's a next page
+		return query.Limit(q.Limit + 1).Find(&page.Users).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Users) > q.Limit {
+		page.Users = page.Users[:q.Limit]
+		page.Next = user.CursorOf(&page.Users[q.Limit-1], q)
+	}
+	return page, nil
+}
+
+// applyFilter : Restricts db to the users matching f
+func applyFilter(db *gorm.DB, f user.ListFilter) *gorm.DB {
+	if f.UsernamePrefix != "" {
+		db = db.Where(`username LIKE ? ESCAPE '\'`, likeEscaper.Replace(f.UsernamePrefix)+"%")
+	}
+	if f.EmailDomain != "" {
+		db = db.Where("lower(split_part(email, '@', 2)) = ?", strings.ToLower(f.EmailDomain))
+	}
+	if !f.CreatedAfter.IsZero() {
+		db = db.Where("created_at >= ?", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		db = db.Where("created_at < ?", f.CreatedBefore)
+	}
+	if f.Status != "" {
+		db = db.Where("status = ?", f.Status)
+	}
+	return db
+}
@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// userIndexes : Uniqueness of the usernames and emails within a tenant, and the indexes backing ListUsers. The
+// queries are always scoped to a tenant, so the indexes lead with it.
+var userIndexes = []string{
+	// Usernames and emails used to be unique across the tenants
+	`ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_key`,
+	`ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_username ON users (tenant_id, username)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email ON users (tenant_id, email)`,
+	`DROP INDEX IF EXISTS idx_users_created_at_id`,
+	`DROP INDEX IF EXISTS idx_users_updated_at_id`,
+	`DROP INDEX IF EXISTS idx_users_username_pattern`,
+	`DROP INDEX IF EXISTS idx_users_email_domain`,
+	`DROP INDEX IF EXISTS idx_users_status_id`,
+	// Keyset pagination on the timestamps, ties broken by the ID
+	`CREATE INDEX IF NOT EXISTS idx_users_tenant_created_at_id ON users (tenant_id, created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_users_tenant_updated_at_id ON users (tenant_id, updated_at, id)`,
+	// LIKE 'prefix%' can't use the unique index unless the database uses the C collation
+	`CREATE INDEX IF NOT EXISTS idx_users_tenant_username_pattern ON users (tenant_id, username text_pattern_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_users_tenant_email_domain ON users (tenant_id, lower(split_part(email, '@', 2)), id)`,
+	`CREATE INDEX IF NOT EXISTS idx_users_tenant_status_id ON users (tenant_id, status, id)`,
+}
+
+// Migrate : Brings the schema up to date, safe to run on every start
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&user.User{}, &userEvent{}).Error; err != nil {
+		return errors.Wrap(err, "pkg.database.postgres.Migrate")
+	}
+	for _, stmt := range append(userIndexes, outboxIndexes...) {
+		if err := db.Exec(stmt).Error; err != nil {
+			return errors.Wrap(err, "pkg.database.postgres.Migrate")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,671 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/membership"
+	"github.com/LuD1161/restructuring-tnbt/pkg/org"
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// organization : Row of an org.Organization
+type organization struct {
+	ID        uint64 `gorm:"primary_key;auto_increment"`
+	TenantID  string `gorm:"size:40;not null;default:'default'"`
+	Name      string `gorm:"size:100;not null"`
+	Slug      string `gorm:"size:40;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (organization) TableName() string {
+	return "organizations"
+}
+
+// orgMembership : Row of a membership.Membership
+type orgMembership struct {
+	OrgID     uint64 `gorm:"primary_key;auto_increment:false"`
+	UserID    uint64 `gorm:"primary_key;auto_increment:false;index"`
+	Role      string `gorm:"size:16;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (orgMembership) TableName() string {
+	return "org_memberships"
+}
+
+// orgInvitation : Row of a membership.Invitation
+type orgInvitation struct {
+	ID          uint64    `gorm:"primary_key;auto_increment"`
+	OrgID       uint64    `gorm:"not null;index"`
+	Email       string    `gorm:"size:255;not null"`
+	Role        string    `gorm:"size:16;not null"`
+	InvitedBy   uint64    `gorm:"not null"`
+	Status      string    `gorm:"size:16;not null"`
+	ExpiresAt   time.Time `gorm:"not null"`
+	RespondedAt *time.Time
+	CreatedAt   time.Time
+	TokenHash   string `gorm:"size:64;not null;unique"`
+}
+
+func (orgInvitation) TableName() string {
+	return "org_invitations"
+}
+
+// orgIndexes : Slugs unique within a tenant, one owner per organization, and one pending invitation per email and
+// organization
+var orgIndexes = []string{
+	`ALTER TABLE organizations DROP CONSTRAINT IF EXISTS organizations_slug_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_tenant_slug ON organizations (tenant_id, slug)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_org_memberships_owner ON org_memberships (org_id) WHERE role = 'owner'`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_org_invitations_pending ON org_invitations (org_id, email) WHERE status = 'pending'`,
+	`CREATE INDEX IF NOT EXISTS idx_org_invitations_email ON org_invitations (email) WHERE status = 'pending'`,
+}
+
+// MigrateOrganizations : Creates the tables of the organizations, their memberships and invitations
+func MigrateOrganizations(db *gorm.DB) error {
+	if err := db.AutoMigrate(&organization{}, &orgMembership{}, &orgInvitation{}).Error; err != nil {
+		return pkgerrors.Wrap(err, "pkg.database.postgres.MigrateOrganizations")
+	}
+	for _, stmt := range orgIndexes {
+		if err := db.Exec(stmt).Error; err != nil {
+			return pkgerrors.Wrap(err, "pkg.database.postgres.MigrateOrganizations")
+		}
+	}
+	return nil
+}
+
+type organizationRepository struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// NewPostgresOrganizationRepository : org.Repository, see MigrateOrganizations
+func NewPostgresOrganizationRepository(db *gorm.DB, queryTimeout time.Duration) org.Repository {
+	return &organizationRepository{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *organizationRepository) CreateOrganization(ctx context.Context, o *org.Organization, ownerID uint64) (_ *org.Organization, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.organizationRepository.CreateOrganization")
+	defer func() { tracing.End(span, err) }()
+	tenantID, err := creationTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := &organization{TenantID: tenantID, Name: o.Name, Slug: o.Slug}
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		if err := tx.Create(row).Error; err != nil {
+			return err
+		}
+		return tx.Create(&orgMembership{OrgID: row.ID, UserID: ownerID, Role: membership.RoleOwner}).Error
+	})
+	if err != nil {
+		return nil, translateOrgError(err)
+	}
+	return row.organization(), nil
+}
+
+func (r *organizationRepository) GetOrganization(ctx context.Context, id uint64) (_ *org.Organization, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.organizationRepository.GetOrganization")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := new(organization)
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Scopes(scope).Where("id = ?", id).First(row).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, org.ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.organization(), nil
+}
+
+func (r *organizationRepository) UpdateOrganization(ctx context.Context, o *org.Organization) (_ *org.Organization, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.organizationRepository.UpdateOrganization")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := new(organization)
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		res := tx.Scopes(scope).Model(&organization{}).Where("id = ?", o.ID).Updates(map[string]interface{}{
+			"name":       o.Name,
+			"slug":       o.Slug,
+			"updated_at": time.Now(),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Scopes(scope).Where("id = ?", o.ID).First(row).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, org.ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, translateOrgError(err)
+	}
+	return row.organization(), nil
+}
+
+func (r *organizationRepository) DeleteOrganization(ctx context.Context, id uint64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.organizationRepository.DeleteOrganization")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return err
+	}
+	var deleted int64
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		var ids []uint64
+		if err := tx.Scopes(scope).Model(&organization{}).Where("id = ?", id).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		deleted, err = deleteOrganizations(tx, ids...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return org.ErrOrganizationNotFound
+	}
+	return nil
+}
+
+// deleteOrganizations : Deletes the organizations along with their memberships and invitations
+func deleteOrganizations(tx *gorm.DB, ids ...uint64) (int64, error) {
+	if err := tx.Where("org_id IN (?)", ids).Delete(&orgMembership{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("org_id IN (?)", ids).Delete(&orgInvitation{}).Error; err != nil {
+		return 0, err
+	}
+	res := tx.Where("id IN (?)", ids).Delete(&organization{})
+	return res.RowsAffected, res.Error
+}
+
+type membershipRepository struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// NewPostgresMembershipRepository : membership.Repository, see MigrateOrganizations
+func NewPostgresMembershipRepository(db *gorm.DB, queryTimeout time.Duration) membership.Repository {
+	return &membershipRepository{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *membershipRepository) GetMembership(ctx context.Context, orgID, uid uint64) (_ *membership.Membership, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.GetMembership")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := new(orgMembership)
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Scopes(scope).Where("org_id = ? AND user_id = ?", orgID, uid).First(row).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, membership.ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.membership(), nil
+}
+
+func (r *membershipRepository) ListMembers(ctx context.Context, orgID uint64) (_ []membership.Membership, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.ListMembers")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rows []orgMembership
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Scopes(scope).Where("org_id = ?", orgID).Order("created_at, user_id").Find(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	members := make([]membership.Membership, len(rows))
+	for i := range rows {
+		members[i] = *rows[i].membership()
+	}
+	return members, nil
+}
+
+func (r *membershipRepository) ListMemberships(ctx context.Context, uid uint64) (_ []membership.Membership, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.ListMemberships")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rows []orgMembership
+	var orgs map[uint64]*org.Organization
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		if err := tx.Scopes(scope).Where("user_id = ?", uid).Order("created_at, org_id").Find(&rows).Error; err != nil {
+			return err
+		}
+		ids := make([]uint64, len(rows))
+		for i, row := range rows {
+			ids[i] = row.OrgID
+		}
+		orgs, err = organizationsByID(tx, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	memberships := make([]membership.Membership, len(rows))
+	for i := range rows {
+		memberships[i] = *rows[i].membership()
+		memberships[i].Organization = orgs[rows[i].OrgID]
+	}
+	return memberships, nil
+}
+
+func (r *membershipRepository) UpdateRole(ctx context.Context, orgID, uid uint64, role string) (_ *membership.Membership, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.UpdateRole")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := new(orgMembership)
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		res := tx.Scopes(scope).Model(&orgMembership{}).Where("org_id = ? AND user_id = ?", orgID, uid).
+			Updates(map[string]interface{}{"role": role, "updated_at": time.Now()})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Scopes(scope).Where("org_id = ? AND user_id = ?", orgID, uid).First(row).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, membership.ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.membership(), nil
+}
+
+func (r *membershipRepository) RemoveMember(ctx context.Context, orgID, uid uint64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.RemoveMember")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return err
+	}
+	var deleted int64
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		res := tx.Scopes(scope).Where("org_id = ? AND user_id = ?", orgID, uid).Delete(&orgMembership{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return membership.ErrNotMember
+	}
+	return nil
+}
+
+// TransferOwnership : The owner is demoted first, the unique index allows a single owner per organization
+func (r *membershipRepository) TransferOwnership(ctx context.Context, orgID, from, to uint64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.TransferOwnership")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return err
+	}
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Scopes(scope).Model(&orgMembership{}).Where("org_id = ? AND user_id = ? AND role = ?", orgID, from, membership.RoleOwner).
+			Updates(map[string]interface{}{"role": membership.RoleAdmin, "updated_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		res = tx.Scopes(scope).Model(&orgMembership{}).Where("org_id = ? AND user_id = ? AND role <> ?", orgID, to, membership.RoleOwner).
+			Updates(map[string]interface{}{"role": membership.RoleOwner, "updated_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return membership.ErrNotMember
+	}
+	return err
+}
+
+func (r *membershipRepository) RemoveUsers(ctx context.Context, uids []uint64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.RemoveUsers")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+	return inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		var owned []orgMembership
+		if err := tx.Scopes(scope).Where("user_id IN (?) AND role = ?", uids, membership.RoleOwner).Find(&owned).Error; err != nil {
+			return err
+		}
+		successors := make([]orgMembership, 0, len(owned))
+		var orphans []uint64
+		for _, o := range owned {
+			var successor orgMembership
+			err := tx.Scopes(scope).Where("org_id = ? AND user_id NOT IN (?)", o.OrgID, uids).
+				Order(gorm.Expr("CASE role WHEN ? THEN 0 ELSE 1 END, created_at, user_id", membership.RoleAdmin)).
+				First(&successor).Error
+			if gorm.IsRecordNotFoundError(err) {
+				orphans = append(orphans, o.OrgID)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			successors = append(successors, successor)
+		}
+		if err := tx.Scopes(scope).Where("user_id IN (?)", uids).Delete(&orgMembership{}).Error; err != nil {
+			return err
+		}
+		for _, s := range successors {
+			err := tx.Scopes(scope).Model(&orgMembership{}).Where("org_id = ? AND user_id = ?", s.OrgID, s.UserID).
+				Updates(map[string]interface{}{"role": membership.RoleOwner, "updated_at": time.Now()}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(orphans) == 0 {
+			return nil
+		}
+		_, err := deleteOrganizations(tx, orphans...)
+		return err
+	})
+}
+
+// CreateInvitation : The pending invitations past their expiry are marked expired first, so that they don't hold the
+// unique index of the pending invitations
+func (r *membershipRepository) CreateInvitation(ctx context.Context, inv *membership.Invitation) (_ *membership.Invitation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.CreateInvitation")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := &orgInvitation{
+		OrgID:     inv.OrgID,
+		Email:     inv.Email,
+		Role:      inv.Role,
+		InvitedBy: inv.InvitedBy,
+		Status:    inv.Status,
+		ExpiresAt: inv.ExpiresAt,
+		CreatedAt: inv.CreatedAt,
+		TokenHash: inv.TokenHash,
+	}
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		err := tx.Scopes(scope).Model(&orgInvitation{}).
+			Where("org_id = ? AND email = ? AND status = ? AND expires_at <= ?", inv.OrgID, inv.Email, membership.StatusPending, inv.CreatedAt).
+			Update("status", membership.StatusExpired).Error
+		if err != nil {
+			return err
+		}
+		var pending int
+		err = tx.Scopes(scope).Model(&orgInvitation{}).
+			Where("org_id = ? AND email = ? AND status = ?", inv.OrgID, inv.Email, membership.StatusPending).
+			Count(&pending).Error
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return membership.ErrAlreadyInvited
+		}
+		return tx.Create(row).Error
+	})
+	if err != nil {
+		return nil, translateOrgError(err)
+	}
+	return row.invitation(), nil
+}
+
+func (r *membershipRepository) GetInvitationByTokenHash(ctx context.Context, hash string) (_ *membership.Invitation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.GetInvitationByTokenHash")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := new(orgInvitation)
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Scopes(scope).Where("token_hash = ?", hash).First(row).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, membership.ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.invitation(), nil
+}
+
+func (r *membershipRepository) ListInvitations(ctx context.Context, orgID uint64) (_ []membership.Invitation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.ListInvitations")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rows []orgInvitation
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Scopes(scope).Where("org_id = ? AND status = ?", orgID, membership.StatusPending).
+			Order("created_at DESC, id DESC").Find(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	invitations := make([]membership.Invitation, len(rows))
+	for i := range rows {
+		invitations[i] = *rows[i].invitation()
+	}
+	return invitations, nil
+}
+
+func (r *membershipRepository) ListInvitationsFor(ctx context.Context, email string) (_ []membership.Invitation, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.ListInvitationsFor")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rows []orgInvitation
+	var orgs map[uint64]*org.Organization
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		err := tx.Scopes(scope).Where("email = ? AND status = ? AND expires_at > ?", email, membership.StatusPending, time.Now()).
+			Order("created_at DESC, id DESC").Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		ids := make([]uint64, len(rows))
+		for i, row := range rows {
+			ids[i] = row.OrgID
+		}
+		orgs, err = organizationsByID(tx, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	invitations := make([]membership.Invitation, len(rows))
+	for i := range rows {
+		invitations[i] = *rows[i].invitation()
+		invitations[i].Organization = orgs[rows[i].OrgID]
+	}
+	return invitations, nil
+}
+
+func (r *membershipRepository) AcceptInvitation(ctx context.Context, id, uid uint64, now time.Time) (_ *membership.Membership, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.AcceptInvitation")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := new(orgMembership)
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		res := tx.Scopes(scope).Model(&orgInvitation{}).
+			Where("id = ? AND status = ? AND expires_at > ?", id, membership.StatusPending, now).
+			Updates(map[string]interface{}{"status": membership.StatusAccepted, "responded_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return membership.ErrInvitationNotFound
+		}
+		inv := new(orgInvitation)
+		if err := tx.Scopes(scope).Where("id = ?", id).First(inv).Error; err != nil {
+			return err
+		}
+		var count int
+		if err := tx.Scopes(scope).Model(&orgMembership{}).Where("org_id = ? AND user_id = ?", inv.OrgID, uid).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return membership.ErrAlreadyMember
+		}
+		*row = orgMembership{OrgID: inv.OrgID, UserID: uid, Role: inv.Role}
+		return tx.Create(row).Error
+	})
+	if err != nil {
+		return nil, translateOrgError(err)
+	}
+	return row.membership(), nil
+}
+
+func (r *membershipRepository) RespondInvitation(ctx context.Context, orgID, id uint64, status string, now time.Time) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.membershipRepository.RespondInvitation")
+	defer func() { tracing.End(span, err) }()
+	scope, err := orgTenantScope(ctx)
+	if err != nil {
+		return err
+	}
+	var updated int64
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		q := tx.Scopes(scope).Model(&orgInvitation{}).Where("id = ? AND status = ?", id, membership.StatusPending)
+		if orgID != 0 {
+			q = q.Where("org_id = ?", orgID)
+		}
+		res := q.Updates(map[string]interface{}{"status": status, "responded_at": now})
+		updated = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return membership.ErrInvitationNotFound
+	}
+	return nil
+}
+
+// organizationsByID : The organizations with the given IDs
+func organizationsByID(tx *gorm.DB, ids []uint64) (map[uint64]*org.Organization, error) {
+	orgs := make(map[uint64]*org.Organization, len(ids))
+	if len(ids) == 0 {
+		return orgs, nil
+	}
+	var rows []organization
+	if err := tx.Where("id IN (?)", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		orgs[rows[i].ID] = rows[i].organization()
+	}
+	return orgs, nil
+}
+
+func (row *organization) organization() *org.Organization {
+	return &org.Organization{
+		ID:        row.ID,
+		Name:      row.Name,
+		Slug:      row.Slug,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+}
+
+func (row *orgMembership) membership() *membership.Membership {
+	return &membership.Membership{
+		OrgID:     row.OrgID,
+		UserID:    row.UserID,
+		Role:      row.Role,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+}
+
+func (row *orgInvitation) invitation() *membership.Invitation {
+	return &membership.Invitation{
+		ID:          row.ID,
+		OrgID:       row.OrgID,
+		Email:       row.Email,
+		Role:        row.Role,
+		InvitedBy:   row.InvitedBy,
+		Status:      row.Status,
+		ExpiresAt:   row.ExpiresAt,
+		RespondedAt: row.RespondedAt,
+		CreatedAt:   row.CreatedAt,
+		TokenHash:   row.TokenHash,
+	}
+}
+
+// translateOrgError : The unique constraints of the organizations, their memberships and invitations. Concurrent
+// requests can still race past the checks made beforehand.
+func translateOrgError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolation {
+		return err
+	}
+	switch {
+	case strings.Contains(pqErr.Constraint, "slug"):
+		return org.ErrSlugTaken.Wrap(err)
+	case strings.Contains(pqErr.Constraint, "org_memberships"):
+		return membership.ErrAlreadyMember.Wrap(err)
+	case strings.Contains(pqErr.Constraint, "invitations_pending"):
+		return membership.ErrAlreadyInvited.Wrap(err)
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/jinzhu/gorm"
+)
+
+// userEvent : Row of the outbox, written in the transaction of the change it records
+type userEvent struct {
+	Seq           uint64    `gorm:"primary_key;auto_increment"`
+	EventID       string    `gorm:"size:32;not null;unique"`
+	Type          string    `gorm:"size:64;not null"`
+	UserID        uint64    `gorm:"not null;index"`
+	Payload       []byte    `gorm:"not null"` // user.Event as JSON
+	OccurredAt    time.Time `gorm:"not null"`
+	NextAttemptAt time.Time `gorm:"not null"` // Claimed until then
+	Attempts      int       `gorm:"not null"`
+	LastError     string    `gorm:"type:text;not null"`
+	PublishedAt   *time.Time
+}
+
+func (userEvent) TableName() string {
+	return "user_events"
+}
+
+// outboxIndexes : The unpublished events are found in order through a partial index, which stays small
+var outboxIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_user_events_pending ON user_events (seq) WHERE published_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_user_events_published_at ON user_events (published_at)`,
+}
+
+// recordEvents : Appends the events to the outbox within tx, along with the tenant of their user's row
+func recordEvents(tx *gorm.DB, events ...user.Event) error {
+	for _, e := range events {
+		var tenants []string
+		// Unscoped, the deleted users' events are recorded along with their deletion
+		if err := tx.Unscoped().Model(&user.User{}).Where("id = ?", e.User.ID).Pluck("tenant_id", &tenants).Error; err != nil {
+			return err
+		}
+		if len(tenants) > 0 {
+			e.TenantID = tenants[0]
+		}
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		row := &userEvent{
+			EventID:       e.ID,
+			Type:          e.Type,
+			UserID:        e.User.ID,
+			Payload:       payload,
+			OccurredAt:    e.OccurredAt,
+			NextAttemptAt: e.OccurredAt,
+		}
+		if err := tx.Create(row).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *userRepository) RecordEvent(ctx context.Context, e user.Event) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.RecordEvent")
+	defer func() { tracing.End(span, err) }()
+	return inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		return recordEvents(tx, e)
+	})
+}
+
+type outbox struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// NewPostgresOutbox : user.Outbox of the events recorded by the user repository
+func NewPostgresOutbox(db *gorm.DB, queryTimeout time.Duration) user.Outbox {
+	return &outbox{db: db, queryTimeout: queryTimeout}
+}
+
+// ClaimEvents : Like webhookRepository.ClaimDeliveries, every due event is postponed by a conditional UPDATE which
+// only one of the instances racing for it gets to apply.
+func (o *outbox) ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) (_ []user.OutboxEvent, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.outbox.ClaimEvents")
+	defer func() { tracing.End(span, err) }()
+	var claimed []userEvent
+	err = inTx(ctx, o.db, o.queryTimeout, false, func(tx *gorm.DB) error {
+		var due []userEvent
+		err := tx.Where("published_at IS NULL AND next_attempt_at <= ?", now).Order("seq").Limit(limit).Find(&due).Error
+		if err != nil {
+			return err
+		}
+		leaseEnd := now.Add(lease)
+		for _, row := range due {
+			res := tx.Model(&userEvent{}).
+				Where("seq = ? AND published_at IS NULL AND next_attempt_at <= ?", row.Seq, now).
+				Update("next_attempt_at", leaseEnd)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 1 {
+				claimed = append(claimed, row)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]user.OutboxEvent, len(claimed))
+	for i, row := range claimed {
+		events[i] = user.OutboxEvent{Seq: row.Seq, Attempts: row.Attempts, LastError: row.LastError}
+		if err := json.Unmarshal(row.Payload, &events[i].Event); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (o *outbox) MarkPublished(ctx context.Context, seqs []uint64, at time.Time) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.outbox.MarkPublished")
+	defer func() { tracing.End(span, err) }()
+	return inTx(ctx, o.db, o.queryTimeout, false, func(tx *gorm.DB) error {
+		return tx.Model(&userEvent{}).Where("seq IN (?)", seqs).Update("published_at", at).Error
+	})
+}
+
+func (o *outbox) MarkFailed(ctx context.Context, seq uint64, lastError string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.outbox.MarkFailed")
+	defer func() { tracing.End(span, err) }()
+	return inTx(ctx, o.db, o.queryTimeout, false, func(tx *gorm.DB) error {
+		return tx.Model(&userEvent{}).Where("seq = ?", seq).Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"last_error": lastError,
+		}).Error
+	})
+}
+
+func (o *outbox) PurgePublished(ctx context.Context, publishedBefore time.Time) (_ int64, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.outbox.PurgePublished")
+	defer func() { tracing.End(span, err) }()
+	var purged int64
+	err = inTx(ctx, o.db, o.queryTimeout, false, func(tx *gorm.DB) error {
+		res := tx.Where("published_at < ?", publishedBefore).Delete(&userEvent{})
+		purged = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
@@ -0,0 +1,197 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/eventbus"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/jinzhu/gorm"
+)
+
+// outboxRows : Every row of the outbox, in order
+func outboxRows(t *testing.T, db *gorm.DB) []userEvent {
+	t.Helper()
+	var rows []userEvent
+	if err := db.Order("seq").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func countUsers(t *testing.T, db *gorm.DB) int {
+	t.Helper()
+	var n int
+	if err := db.Model(&user.User{}).Unscoped().Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestUserWritesRecordEvents(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewPostgresUserRepository(db, 0)
+	ctx := inTenant("acme")
+
+	created, err := repo.CreateUser(ctx, newTestUser("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Password = "new hashed password"
+	updated, err := repo.UpdateUser(ctx, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.DeleteUser(ctx, updated.ID, updated.Version); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := outboxRows(t, db)
+	want := []string{user.EventCreated, user.EventUpdated, user.EventPasswordChanged, user.EventDeleted}
+	if len(rows) != len(want) {
+		t.Fatalf("%d events recorded, want %d", len(rows), len(want))
+	}
+	for i, row := range rows {
+		if row.Type != want[i] || row.UserID != created.ID || row.PublishedAt != nil {
+			t.Errorf("event %d = %s of user %d, want an unpublished %s of user %d", i, row.Type, row.UserID, want[i], created.ID)
+		}
+		var e user.Event
+		if err := json.Unmarshal(row.Payload, &e); err != nil || e.TenantID != "acme" {
+			t.Errorf("event %d of tenant %q (%v), want acme", i, e.TenantID, err)
+		}
+	}
+}
+
+func TestFailedUserWritesRecordNoEvent(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewPostgresUserRepository(db, 0)
+	ctx := inTenant("acme")
+	created, err := repo.CreateUser(ctx, newTestUser("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.CreateUser(ctx, newTestUser("alice")); err == nil {
+		t.Error("created a duplicate username")
+	}
+	stale := *created
+	stale.Version = created.Version + 1
+	stale.Email = "alice@example.org"
+	if _, err := repo.UpdateUser(ctx, &stale); !errors.Is(err, user.ErrVersionConflict) {
+		t.Errorf("UpdateUser() with a stale version = %v, want ErrVersionConflict", err)
+	}
+	if _, err := repo.DeleteUser(inTenant("other"), created.ID, created.Version); err != nil {
+		t.Errorf("DeleteUser() of another tenant's user = %v", err)
+	}
+
+	if rows := outboxRows(t, db); len(rows) != 1 || rows[0].Type != user.EventCreated {
+		t.Errorf("%d events recorded, want the user.created one only", len(rows))
+	}
+}
+
+func TestFailedEventRecordRollsTheUserBack(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewPostgresUserRepository(db, 0)
+	ctx := inTenant("acme")
+	created, err := repo.CreateUser(ctx, newTestUser("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording the events now fails, after the user is written
+	if err := db.DropTable(&userEvent{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateUser(ctx, newTestUser("bob")); err == nil {
+		t.Fatal("CreateUser() succeeded without recording its event")
+	}
+	if n := countUsers(t, db); n != 1 {
+		t.Errorf("%d users, want the user without an event rolled back", n)
+	}
+	created.Email = "alice@example.org"
+	if _, err := repo.UpdateUser(ctx, created); err == nil {
+		t.Fatal("UpdateUser() succeeded without recording its event")
+	}
+	current, err := repo.GetUserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Email != "alice@example.com" || current.Version != created.Version {
+		t.Errorf("user = %s at version %d, want the update without an event rolled back", current.Email, current.Version)
+	}
+}
+
+func TestRelayPublishesTheOutbox(t *testing.T) {
+	db := newTestDB(t)
+	repo := NewPostgresUserRepository(db, 0)
+	ctx := inTenant("acme")
+	for _, username := range []string{"alice", "bob", "carol"} {
+		if _, err := repo.CreateUser(ctx, newTestUser(username)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	broker := eventbus.NewMemoryBroker()
+	var received []eventbus.Message
+	failing := true
+	broker.Subscribe(func(_ context.Context, m eventbus.Message) error {
+		// bob's event fails once
+		if m.Key == "2" && failing {
+			failing = false
+			return errors.New("consumer down")
+		}
+		received = append(received, m)
+		return nil
+	}, user.EventCreated)
+	outbox := NewPostgresOutbox(db, 0)
+	relay := user.NewRelay(outbox, eventbus.NewPublisher(broker), time.Second, time.Hour)
+
+	// The batch stops at the failure, the failed event waits for its lease
+	published, err := relay.Publish(context.Background())
+	if err == nil || published != 1 {
+		t.Fatalf("Publish() = %d, %v, want 1 published and the failure", published, err)
+	}
+	rows := outboxRows(t, db)
+	if rows[0].PublishedAt == nil {
+		t.Error("published event not marked as published")
+	}
+	if rows[1].PublishedAt != nil || rows[1].Attempts != 1 || rows[1].LastError == "" {
+		t.Errorf("failed event published at %v after %d attempts (%q), want unpublished after 1 with the error",
+			rows[1].PublishedAt, rows[1].Attempts, rows[1].LastError)
+	}
+	if rows[1].NextAttemptAt.Before(time.Now()) {
+		t.Error("failed event not leased")
+	}
+
+	// Once the leases are over, the rest is published in order
+	if err := db.Model(&userEvent{}).Where("published_at IS NULL").Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if published, err = relay.Publish(context.Background()); err != nil || published != 2 {
+		t.Fatalf("Publish() = %d, %v, want the 2 remaining events published", published, err)
+	}
+	for _, row := range outboxRows(t, db) {
+		if row.PublishedAt == nil {
+			t.Errorf("event %s not marked as published", row.EventID)
+		}
+	}
+	if len(received) != 3 {
+		t.Fatalf("broker got %d messages, want 3", len(received))
+	}
+	for i, key := range []string{"1", "2", "3"} {
+		if received[i].Key != key || received[i].Type != user.EventCreated || received[i].ID != rows[i].EventID {
+			t.Errorf("message %d = %s of user %s, want the user.created of user %s", i, received[i].ID, received[i].Key, key)
+		}
+	}
+
+	// Nothing left to publish, and the published events are purged after the retention
+	if published, err = relay.Publish(context.Background()); err != nil || published != 0 {
+		t.Errorf("Publish() = %d, %v, want nothing left", published, err)
+	}
+	if purged, err := outbox.PurgePublished(context.Background(), time.Now().Add(time.Second)); err != nil || purged != 3 {
+		t.Errorf("PurgePublished() = %d, %v, want the 3 events", purged, err)
+	}
+}
@@ -0,0 +1,296 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/scim"
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// scimGroup : Row of a scim.Group, the members are rows of scim_group_members
+type scimGroup struct {
+	ID          uint64 `gorm:"primary_key;auto_increment"`
+	TenantID    string `gorm:"size:40;not null;default:'default'"`
+	DisplayName string `gorm:"size:255;not null"`
+	Version     uint64 `gorm:"not null;default:1"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (scimGroup) TableName() string {
+	return "scim_groups"
+}
+
+type scimGroupMember struct {
+	GroupID uint64 `gorm:"primary_key;auto_increment:false"`
+	UserID  uint64 `gorm:"primary_key;auto_increment:false;index"`
+}
+
+func (scimGroupMember) TableName() string {
+	return "scim_group_members"
+}
+
+// scimIndexes : Display names unique within a tenant
+var scimIndexes = []string{
+	// They used to be unique across the tenants
+	`ALTER TABLE scim_groups DROP CONSTRAINT IF EXISTS scim_groups_display_name_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_scim_groups_tenant_display_name ON scim_groups (tenant_id, display_name)`,
+}
+
+// memberBatchSize : Members inserted per statement, well below the limit of 65535 parameters
+const memberBatchSize = 1000
+
+type groupRepository struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// MigrateSCIM : Creates the tables of the SCIM groups
+func MigrateSCIM(db *gorm.DB) error {
+	if err := db.AutoMigrate(&scimGroup{}, &scimGroupMember{}).Error; err != nil {
+		return pkgerrors.Wrap(err, "pkg.database.postgres.MigrateSCIM")
+	}
+	for _, stmt := range scimIndexes {
+		if err := db.Exec(stmt).Error; err != nil {
+			return pkgerrors.Wrap(err, "pkg.database.postgres.MigrateSCIM")
+		}
+	}
+	return nil
+}
+
+// NewPostgresGroupRepository : scim.GroupRepository, see MigrateSCIM. The groups belong to a tenant, their members
+// are scoped through them.
+func NewPostgresGroupRepository(db *gorm.DB, queryTimeout time.Duration) scim.GroupRepository {
+	return &groupRepository{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *groupRepository) CreateGroup(ctx context.Context, g *scim.Group) (_ *scim.Group, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.groupRepository.CreateGroup")
+	defer func() { tracing.End(span, err) }()
+	tenantID, err := creationTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := &scimGroup{TenantID: tenantID, DisplayName: g.DisplayName, Version: 1}
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		if err := tx.Create(row).Error; err != nil {
+			return err
+		}
+		return insertMembers(tx, row.ID, g.Members)
+	})
+	if err != nil {
+		return nil, translateGroupError(err)
+	}
+	return row.group(g.Members), nil
+}
+
+func (r *groupRepository) GetGroup(ctx context.Context, gid uint64) (_ *scim.Group, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.groupRepository.GetGroup")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := new(scimGroup)
+	var members map[uint64][]uint64
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		if err := tx.Scopes(scope).Where("id = ?", gid).First(row).Error; err != nil {
+			return err
+		}
+		members, err = groupMembers(tx, gid)
+		return err
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, scim.ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.group(members[gid]), nil
+}
+
+func (r *groupRepository) ListGroups(ctx context.Context, afterID uint64, limit int) (_ []scim.Group, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.groupRepository.ListGroups")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rows []scimGroup
+	var members map[uint64][]uint64
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		if err := tx.Scopes(scope).Where("id > ?", afterID).Order("id").Limit(limit).Find(&rows).Error; err != nil {
+			return err
+		}
+		gids := make([]uint64, len(rows))
+		for i, row := range rows {
+			gids[i] = row.ID
+		}
+		members, err = groupMembers(tx, gids...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]scim.Group, len(rows))
+	for i := range rows {
+		groups[i] = *rows[i].group(members[rows[i].ID])
+	}
+	return groups, nil
+}
+
+func (r *groupRepository) UpdateGroup(ctx context.Context, g *scim.Group) (_ *scim.Group, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.groupRepository.UpdateGroup")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := new(scimGroup)
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		res := compareAndSwap(tx.Scopes(scope).Model(&scimGroup{}), g.ID, g.Version).Updates(map[string]interface{}{
+			"display_name": g.DisplayName,
+			"updated_at":   time.Now(),
+			"version":      gorm.Expr("version + 1"),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			var count int
+			if err := tx.Scopes(scope).Model(&scimGroup{}).Where("id = ?", g.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return scim.ErrGroupVersionConflict
+		}
+		if err := tx.Where("group_id = ?", g.ID).Delete(&scimGroupMember{}).Error; err != nil {
+			return err
+		}
+		if err := insertMembers(tx, g.ID, g.Members); err != nil {
+			return err
+		}
+		return tx.Scopes(scope).Where("id = ?", g.ID).First(row).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, scim.ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, translateGroupError(err)
+	}
+	return row.group(g.Members), nil
+}
+
+func (r *groupRepository) DeleteGroup(ctx context.Context, gid uint64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.groupRepository.DeleteGroup")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return err
+	}
+	var deleted int64
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		// The members go along with a group of the tenant only
+		res := tx.Scopes(scope).Where("id = ?", gid).Delete(&scimGroup{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		deleted = res.RowsAffected
+		return tx.Where("group_id = ?", gid).Delete(&scimGroupMember{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return scim.ErrGroupNotFound
+	}
+	return nil
+}
+
+// RemoveMembers : The groups losing members get a new version, so their ETags change
+func (r *groupRepository) RemoveMembers(ctx context.Context, uids []uint64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.groupRepository.RemoveMembers")
+	defer func() { tracing.End(span, err) }()
+	if len(uids) == 0 {
+		return nil
+	}
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return err
+	}
+	return inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		err := tx.Scopes(scope).Model(&scimGroup{}).
+			Where("id IN (SELECT group_id FROM scim_group_members WHERE user_id IN (?))", uids).
+			Updates(map[string]interface{}{"updated_at": time.Now(), "version": gorm.Expr("version + 1")}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id IN (?)", uids).Delete(&scimGroupMember{}).Error
+	})
+}
+
+// insertMembers : Adds the users to group gid, a multi-row INSERT per batch since gorm v1 creates one row at a time
+func insertMembers(tx *gorm.DB, gid uint64, uids []uint64) error {
+	for start := 0; start < len(uids); start += memberBatchSize {
+		end := start + memberBatchSize
+		if end > len(uids) {
+			end = len(uids)
+		}
+		batch := uids[start:end]
+		values := make([]string, len(batch))
+		args := make([]interface{}, 0, 2*len(batch))
+		for i, uid := range batch {
+			values[i] = "(?, ?)"
+			args = append(args, gid, uid)
+		}
+		stmt := "INSERT INTO scim_group_members (group_id, user_id) VALUES " + strings.Join(values, ", ")
+		if err := tx.Exec(stmt, args...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupMembers : Members of the groups by group ID, sorted by user ID
+func groupMembers(tx *gorm.DB, gids ...uint64) (map[uint64][]uint64, error) {
+	members := make(map[uint64][]uint64, len(gids))
+	if len(gids) == 0 {
+		return members, nil
+	}
+	var rows []scimGroupMember
+	if err := tx.Where("group_id IN (?)", gids).Order("group_id, user_id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		members[row.GroupID] = append(members[row.GroupID], row.UserID)
+	}
+	return members, nil
+}
+
+func (row *scimGroup) group(members []uint64) *scim.Group {
+	return &scim.Group{
+		ID:          row.ID,
+		DisplayName: row.DisplayName,
+		Members:     members,
+		Version:     row.Version,
+		CreatedAt:   row.CreatedAt,
+		UpdatedAt:   row.UpdatedAt,
+	}
+}
+
+// translateGroupError : The only unique constraint of the groups is on their display name, within a tenant
+func translateGroupError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && strings.Contains(pqErr.Constraint, "display_name") {
+		return scim.ErrGroupNameTaken.Wrap(err)
+	}
+	return err
+}
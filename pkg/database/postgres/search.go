@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// searchIndexes : pg_trgm GIN indexes, serving both the prefix (LIKE 'text%') and the fuzzy (%) matches
+var searchIndexes = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING gin (lower(username) gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING gin (lower(email) gin_trgm_ops)`,
+}
+
+// searchQuery : Scored like user.Score, the % operator uses pg_trgm.similarity_threshold (0.3 by default)
+const searchQuery = `
+SELECT id, username, email, status, created_at, updated_at,
+	CASE WHEN lower(username) LIKE ? ESCAPE '\' THEN 2 WHEN lower(email) LIKE ? ESCAPE '\' THEN 1 ELSE 0 END
+	+ GREATEST(similarity(lower(username), ?), similarity(lower(email), ?)) AS score
+FROM users
+WHERE status = ? AND deleted_at IS NULL AND (tenant_id = ? OR ? = '*')
+	AND (lower(username) LIKE ? ESCAPE '\' OR lower(email) LIKE ? ESCAPE '\' OR lower(username) % ? OR lower(email) % ?)
+ORDER BY score DESC, username
+LIMIT ?`
+
+// usernameSearchQuery : searchQuery of user.SearchQuery.UsernamesOnly, without the emails
+const usernameSearchQuery = `
+SELECT id, username, status, created_at, updated_at,
+	CASE WHEN lower(username) LIKE ? ESCAPE '\' THEN 2 ELSE 0 END + similarity(lower(username), ?) AS score
+FROM users
+WHERE status = ? AND deleted_at IS NULL AND (tenant_id = ? OR ? = '*')
+	AND (lower(username) LIKE ? ESCAPE '\' OR lower(username) % ?)
+ORDER BY score DESC, username
+LIMIT ?`
+
+// MigrateSearch : Installs pg_trgm and the search indexes. Creating the extension needs enough privileges,
+// callers can fall back to user.NewMemorySearcher when it fails.
+func MigrateSearch(db *gorm.DB) error {
+	for _, stmt := range searchIndexes {
+		if err := db.Exec(stmt).Error; err != nil {
+			return errors.Wrap(err, "pkg.database.postgres.MigrateSearch")
+		}
+	}
+	return nil
+}
+
+type userSearcher struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// NewPostgresUserSearcher : user.Searcher backed by pg_trgm, see MigrateSearch
+func NewPostgresUserSearcher(db *gorm.DB, queryTimeout time.Duration) user.Searcher {
+	return &userSearcher{
+		db,
+		queryTimeout,
+	}
+}
+
+func (s *userSearcher) SearchUsers(ctx context.Context, q user.SearchQuery) (_ []user.SearchResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userSearcher.SearchUsers")
+	defer func() { tracing.End(span, err) }()
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := likeEscaper.Replace(q.Text) + "%"
+	results := []user.SearchResult{}
+	err = inTx(ctx, s.db, s.queryTimeout, true, func(tx *gorm.DB) error {
+		if q.UsernamesOnly {
+			return tx.Raw(usernameSearchQuery,
+				prefix, q.Text,
+				user.StatusActive, tenantID, tenantID,
+				prefix, q.Text,
+				q.Limit,
+			).Scan(&results).Error
+		}
+		return tx.Raw(searchQuery,
+			prefix, prefix, q.Text, q.Text,
+			user.StatusActive, tenantID, tenantID,
+			prefix, prefix, q.Text, q.Text,
+			q.Limit,
+		).Scan(&results).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/jinzhu/gorm"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// The users, the organizations and the SCIM groups belong to a tenant, their tenant_id column. The repositories scope
+// every query on them to the tenant of the context (see requestctx.TenantID), and refuse to run without one rather
+// than reading across the tenants by accident. The memberships and invitations are scoped through their organization,
+// the members of the SCIM groups through their group.
+//
+// The other tables are deployment-wide and ignore the tenant : the outbox (user_events), the webhook subscriptions and
+// deliveries, and the audit records. Every webhook gets the events of every tenant and the audit API serves the
+// records of every tenant, both to the operators only.
+
+// errNoTenant : A repository call scoped by tenant was made with a context carrying none, a programming error
+var errNoTenant = errors.New("no tenant in the context, see requestctx.WithTenant")
+
+// errWriteAllTenants : Rows are created in a single tenant, requestctx.AllTenants only reads and deletes
+var errWriteAllTenants = errors.New("rows can't be created in every tenant at once")
+
+// rowLevelSecurity : Whether the transactions set app.tenant_id for the policies, see EnableRowLevelSecurity
+var rowLevelSecurity bool
+
+// tenantTables : Tables with a tenant_id column
+var tenantTables = []string{"users", "organizations", "scim_groups"}
+
+// EnableRowLevelSecurity : Lets Postgres enforce the isolation of the tenants on top of the repositories : the rows of
+// tenantTables are only visible to, and only written by, the transactions whose app.tenant_id setting is their
+// tenant, or *. From then on every transaction sets it. Superusers and roles with BYPASSRLS aren't subject to it.
+func EnableRowLevelSecurity(db *gorm.DB) error {
+	for _, table := range tenantTables {
+		for _, stmt := range []string{
+			`ALTER TABLE ` + table + ` ENABLE ROW LEVEL SECURITY`,
+			// The service usually owns its tables, the owner is only subject to the policies when forced
+			`ALTER TABLE ` + table + ` FORCE ROW LEVEL SECURITY`,
+			`DROP POLICY IF EXISTS tenant_isolation ON ` + table,
+			`CREATE POLICY tenant_isolation ON ` + table + `
+				USING (current_setting('app.tenant_id', true) IN (tenant_id, '*'))
+				WITH CHECK (current_setting('app.tenant_id', true) IN (tenant_id, '*'))`,
+		} {
+			if err := db.Exec(stmt).Error; err != nil {
+				return pkgerrors.Wrap(err, "pkg.database.postgres.EnableRowLevelSecurity")
+			}
+		}
+	}
+	rowLevelSecurity = true
+	return nil
+}
+
+// setTenant : Sets app.tenant_id for the rest of the transaction, empty when ctx carries no tenant so that the
+// policies hide every row
+func setTenant(ctx context.Context, tx *gorm.DB) error {
+	tenantID, _ := requestctx.TenantID(ctx)
+	return tx.Exec("SELECT set_config('app.tenant_id', ?, true)", tenantID).Error
+}
+
+// tenantOf : Tenant the repository call is scoped to, requestctx.AllTenants for every tenant
+func tenantOf(ctx context.Context) (string, error) {
+	tenantID, ok := requestctx.TenantID(ctx)
+	if !ok || tenantID == "" {
+		return "", errNoTenant
+	}
+	return tenantID, nil
+}
+
+// tenantScope : Restricts the queries on a table with a tenant_id column to the tenant of ctx
+func tenantScope(ctx context.Context) (func(*gorm.DB) *gorm.DB, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		if tenantID == requestctx.AllTenants {
+			return db
+		}
+		return db.Where("tenant_id = ?", tenantID)
+	}, nil
+}
+
+// orgTenantScope : Restricts the queries on a table referring to the organizations by org_id to the organizations of
+// the tenant of ctx
+func orgTenantScope(ctx context.Context) (func(*gorm.DB) *gorm.DB, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		if tenantID == requestctx.AllTenants {
+			return db
+		}
+		return db.Where("org_id IN (SELECT id FROM organizations WHERE tenant_id = ?)", tenantID)
+	}, nil
+}
+
+// creationTenant : Tenant the rows created by the call belong to
+func creationTenant(ctx context.Context) (string, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return "", err
+	}
+	if tenantID == requestctx.AllTenants {
+		return "", errWriteAllTenants
+	}
+	return tenantID, nil
+}
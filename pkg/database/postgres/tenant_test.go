@@ -0,0 +1,271 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/membership"
+	"github.com/LuD1161/restructuring-tnbt/pkg/org"
+	"github.com/LuD1161/restructuring-tnbt/pkg/scim"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/jinzhu/gorm"
+)
+
+// tenantBackends : Databases the isolation of the tenants is checked on : the repositories scoping the queries on
+// their own, on SQLite and Postgres, and along with the row level security of Postgres
+var tenantBackends = []struct {
+	name string
+	rls  bool
+	open func(*testing.T) (*gorm.DB, func())
+}{
+	{"sqlite", false, func(t *testing.T) (*gorm.DB, func()) {
+		db := newTestDB(t)
+		return db, func() { db.Close() }
+	}},
+	{"postgres", false, func(t *testing.T) (*gorm.DB, func()) { return newTestPostgres(t, false) }},
+	{"postgres with row level security", true, func(t *testing.T) (*gorm.DB, func()) { return newTestPostgres(t, true) }},
+}
+
+// testSearcher : pg_trgm searcher when the database has it, the fallback of the deployments without it otherwise
+func testSearcher(db *gorm.DB, repo user.Repository) user.Searcher {
+	if db.Dialect().GetName() == "postgres" && MigrateSearch(db) == nil {
+		return NewPostgresUserSearcher(db, 0)
+	}
+	return user.NewMemorySearcher(repo, time.Minute)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	for _, backend := range tenantBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			db, closeDB := backend.open(t)
+			defer closeDB()
+			testTenantIsolation(t, db, backend.rls)
+		})
+	}
+}
+
+// testTenantIsolation : acme and globex both have an alice, globex has bob. Each tenant has an organization with the
+// same slug, owned by its alice, and a SCIM group with the same display name. acme invited bob@example.com.
+func testTenantIsolation(t *testing.T, db *gorm.DB, rls bool) {
+	users := NewPostgresUserRepository(db, 0)
+	orgs := NewPostgresOrganizationRepository(db, 0)
+	memberships := NewPostgresMembershipRepository(db, 0)
+	groups := NewPostgresGroupRepository(db, 0)
+	searcher := testSearcher(db, users)
+	acme, globex := inTenant("acme"), inTenant("globex")
+
+	acmeAlice, err := users.CreateUser(acme, newTestUser("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	globexAlice, err := users.CreateUser(globex, newTestUser("alice"))
+	if err != nil {
+		t.Fatalf("the same username and email in another tenant : %v", err)
+	}
+	bob, err := users.CreateUser(globex, newTestUser("bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acmeOrg, err := orgs.CreateOrganization(acme, &org.Organization{Name: "Team", Slug: "team"}, acmeAlice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globexOrg, err := orgs.CreateOrganization(globex, &org.Organization{Name: "Team", Slug: "team"}, globexAlice.ID)
+	if err != nil {
+		t.Fatalf("the same slug in another tenant : %v", err)
+	}
+	acmeGroup, err := groups.CreateGroup(acme, &scim.Group{DisplayName: "Engineering", Members: []uint64{acmeAlice.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	globexGroup, err := groups.CreateGroup(globex, &scim.Group{DisplayName: "Engineering", Members: []uint64{bob.ID}})
+	if err != nil {
+		t.Fatalf("the same group display name in another tenant : %v", err)
+	}
+	now := time.Now()
+	_, err = memberships.CreateInvitation(acme, &membership.Invitation{
+		OrgID: acmeOrg.ID, Email: "bob@example.com", Role: membership.RoleMember, InvitedBy: acmeAlice.ID,
+		Status: membership.StatusPending, ExpiresAt: now.Add(time.Hour), CreatedAt: now, TokenHash: "acme-invitation",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("users by ID", func(t *testing.T) {
+		if _, err := users.GetUserByID(acme, bob.ID); err != user.ErrUserNotFound {
+			t.Errorf("GetUserByID() of another tenant's user = %v, want ErrUserNotFound", err)
+		}
+		if u, err := users.GetUserByID(globex, bob.ID); err != nil || u.ID != bob.ID {
+			t.Errorf("GetUserByID() of the tenant's user = %v, %v", u, err)
+		}
+		found, err := users.GetUsersByIDs(acme, []uint64{acmeAlice.ID, globexAlice.ID, bob.ID})
+		if err != nil || len(found) != 1 || found[0].ID != acmeAlice.ID {
+			t.Errorf("GetUsersByIDs() = %d users, %v, want acme's alice only", len(found), err)
+		}
+	})
+
+	t.Run("users by username and email", func(t *testing.T) {
+		if _, err := users.GetUserByUsername(acme, "bob"); err != user.ErrUserNotFound {
+			t.Errorf("GetUserByUsername() of another tenant's user = %v, want ErrUserNotFound", err)
+		}
+		if _, err := users.GetUserByEmail(acme, "bob@example.com"); err != user.ErrUserNotFound {
+			t.Errorf("GetUserByEmail() of another tenant's user = %v, want ErrUserNotFound", err)
+		}
+		for name, tc := range map[string]struct {
+			find func() (*user.User, error)
+			want uint64
+		}{
+			"acme's alice by username":   {func() (*user.User, error) { return users.GetUserByUsername(acme, "alice") }, acmeAlice.ID},
+			"globex's alice by username": {func() (*user.User, error) { return users.GetUserByUsername(globex, "alice") }, globexAlice.ID},
+			"acme's alice by email":      {func() (*user.User, error) { return users.GetUserByEmail(acme, "alice@example.com") }, acmeAlice.ID},
+			"globex's alice by email":    {func() (*user.User, error) { return users.GetUserByEmail(globex, "alice@example.com") }, globexAlice.ID},
+		} {
+			if u, err := tc.find(); err != nil || u.ID != tc.want {
+				t.Errorf("%s = %v, %v, want user %d", name, u, err, tc.want)
+			}
+		}
+	})
+
+	t.Run("writes", func(t *testing.T) {
+		other := *bob
+		other.Email = "bob@example.org"
+		if _, err := users.UpdateUser(acme, &other); err != user.ErrUserNotFound {
+			t.Errorf("UpdateUser() of another tenant's user = %v, want ErrUserNotFound", err)
+		}
+		if deleted, err := users.DeleteUser(acme, bob.ID, 0); err != nil || deleted != 0 {
+			t.Errorf("DeleteUser() of another tenant's user = %d, %v, want none deleted", deleted, err)
+		}
+		if u, err := users.GetUserByID(globex, bob.ID); err != nil || u.Email != "bob@example.com" {
+			t.Errorf("another tenant's user changed : %v, %v", u, err)
+		}
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		for tenant, want := range map[string][]uint64{"acme": {acmeAlice.ID}, "globex": {globexAlice.ID, bob.ID}} {
+			page, err := users.ListUsers(inTenant(tenant), user.ListQuery{Sort: "id", Limit: 10, WithTotal: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Users) != len(want) || *page.Total != int64(len(want)) {
+				t.Errorf("%s lists %d users out of %d, want %d", tenant, len(page.Users), *page.Total, len(want))
+				continue
+			}
+			for i := range want {
+				if page.Users[i].ID != want[i] {
+					t.Errorf("%s lists user %d, want %d", tenant, page.Users[i].ID, want[i])
+				}
+			}
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		results, err := searcher.SearchUsers(acme, user.SearchQuery{Text: "bob", Limit: 10})
+		if err != nil || len(results) != 0 {
+			t.Errorf("acme finds %d users for bob (%v), want none", len(results), err)
+		}
+		results, err = searcher.SearchUsers(globex, user.SearchQuery{Text: "bob", Limit: 10})
+		if err != nil || len(results) != 1 || results[0].ID != bob.ID {
+			t.Errorf("globex finds %d users for bob (%v), want bob", len(results), err)
+		}
+		results, err = searcher.SearchUsers(acme, user.SearchQuery{Text: "alice", Limit: 10})
+		if err != nil || len(results) != 1 || results[0].ID != acmeAlice.ID {
+			t.Errorf("acme finds %d users for alice (%v), want its alice only", len(results), err)
+		}
+		results, err = searcher.SearchUsers(globex, user.SearchQuery{Text: "example.com", UsernamesOnly: true, Limit: 10})
+		if err != nil || len(results) != 0 {
+			t.Errorf("globex finds %d usernames for example.com (%v), want the emails left out", len(results), err)
+		}
+	})
+
+	t.Run("organizations", func(t *testing.T) {
+		if _, err := orgs.GetOrganization(globex, acmeOrg.ID); err != org.ErrOrganizationNotFound {
+			t.Errorf("GetOrganization() of another tenant's organization = %v, want ErrOrganizationNotFound", err)
+		}
+		if o, err := orgs.GetOrganization(globex, globexOrg.ID); err != nil || o.ID != globexOrg.ID {
+			t.Errorf("GetOrganization() of the tenant's organization = %v, %v", o, err)
+		}
+	})
+
+	t.Run("memberships", func(t *testing.T) {
+		if _, err := memberships.GetMembership(globex, acmeOrg.ID, acmeAlice.ID); err != membership.ErrNotMember {
+			t.Errorf("GetMembership() in another tenant's organization = %v, want ErrNotMember", err)
+		}
+		if members, err := memberships.ListMembers(globex, acmeOrg.ID); err != nil || len(members) != 0 {
+			t.Errorf("ListMembers() of another tenant's organization = %d members, %v, want none", len(members), err)
+		}
+		if list, err := memberships.ListMemberships(globex, acmeAlice.ID); err != nil || len(list) != 0 {
+			t.Errorf("ListMemberships() of another tenant's user = %d, %v, want none", len(list), err)
+		}
+		list, err := memberships.ListMemberships(acme, acmeAlice.ID)
+		if err != nil || len(list) != 1 || list[0].OrgID != acmeOrg.ID || list[0].Organization == nil {
+			t.Errorf("ListMemberships() of the tenant's user = %d, %v, want the acme organization", len(list), err)
+		}
+	})
+
+	t.Run("invitations", func(t *testing.T) {
+		if list, err := memberships.ListInvitations(globex, acmeOrg.ID); err != nil || len(list) != 0 {
+			t.Errorf("ListInvitations() of another tenant's organization = %d, %v, want none", len(list), err)
+		}
+		if list, err := memberships.ListInvitationsFor(globex, "bob@example.com"); err != nil || len(list) != 0 {
+			t.Errorf("globex's bob sees %d invitations (%v), want none of acme's", len(list), err)
+		}
+		if _, err := memberships.GetInvitationByTokenHash(globex, "acme-invitation"); err != membership.ErrInvitationNotFound {
+			t.Errorf("GetInvitationByTokenHash() of another tenant's invitation = %v, want ErrInvitationNotFound", err)
+		}
+		if list, err := memberships.ListInvitationsFor(acme, "bob@example.com"); err != nil || len(list) != 1 {
+			t.Errorf("ListInvitationsFor() in the tenant = %d, %v, want the invitation", len(list), err)
+		}
+	})
+
+	t.Run("scim groups", func(t *testing.T) {
+		if _, err := groups.GetGroup(globex, acmeGroup.ID); err != scim.ErrGroupNotFound {
+			t.Errorf("GetGroup() of another tenant's group = %v, want ErrGroupNotFound", err)
+		}
+		if g, err := groups.GetGroup(globex, globexGroup.ID); err != nil || len(g.Members) != 1 || g.Members[0] != bob.ID {
+			t.Errorf("GetGroup() of the tenant's group = %v, %v, want bob as member", g, err)
+		}
+		if list, err := groups.ListGroups(globex, 0, 10); err != nil || len(list) != 1 || list[0].ID != globexGroup.ID {
+			t.Errorf("ListGroups() = %d groups, %v, want globex's only", len(list), err)
+		}
+		stolen := scim.Group{ID: acmeGroup.ID, DisplayName: "Stolen", Members: []uint64{bob.ID}}
+		if _, err := groups.UpdateGroup(globex, &stolen); err != scim.ErrGroupNotFound {
+			t.Errorf("UpdateGroup() of another tenant's group = %v, want ErrGroupNotFound", err)
+		}
+		if err := groups.DeleteGroup(globex, acmeGroup.ID); err != scim.ErrGroupNotFound {
+			t.Errorf("DeleteGroup() of another tenant's group = %v, want ErrGroupNotFound", err)
+		}
+		g, err := groups.GetGroup(acme, acmeGroup.ID)
+		if err != nil || g.DisplayName != "Engineering" || len(g.Members) != 1 || g.Members[0] != acmeAlice.ID {
+			t.Errorf("another tenant's group changed : %v, %v", g, err)
+		}
+	})
+
+	if rls {
+		t.Run("row level security without the repositories' scope", func(t *testing.T) {
+			var rows []user.User
+			err := inTx(acme, db, 0, true, func(tx *gorm.DB) error {
+				return tx.Find(&rows).Error
+			})
+			if err != nil || len(rows) != 1 || rows[0].ID != acmeAlice.ID {
+				t.Errorf("acme's transaction sees %d users (%v), want its alice only", len(rows), err)
+			}
+			var count int
+			err = inTx(globex, db, 0, false, func(tx *gorm.DB) error {
+				res := tx.Model(&user.User{}).Where("id = ?", acmeAlice.ID).Update("email", "stolen@example.com")
+				count = int(res.RowsAffected)
+				return res.Error
+			})
+			if err != nil || count != 0 {
+				t.Errorf("globex's transaction updated %d of acme's users (%v), want none", count, err)
+			}
+			var orgRows []organization
+			if err := inTx(globex, db, 0, true, func(tx *gorm.DB) error { return tx.Find(&orgRows).Error }); err != nil || len(orgRows) != 1 {
+				t.Errorf("globex's transaction sees %d organizations (%v), want its own only", len(orgRows), err)
+			}
+			var groupRows []scimGroup
+			if err := inTx(globex, db, 0, true, func(tx *gorm.DB) error { return tx.Find(&groupRows).Error }); err != nil || len(groupRows) != 1 {
+				t.Errorf("globex's transaction sees %d SCIM groups (%v), want its own only", len(groupRows), err)
+			}
+		})
+	}
+}
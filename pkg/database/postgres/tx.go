@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/jinzhu/gorm"
+)
+
+// gorm v1 has no context aware API, so every repository call runs in a transaction started with
+// BeginTx : database/sql rolls it back and lib/pq cancels the running query as soon as ctx is done.
+
+// withTimeout : Bounds ctx by the repository's query timeout, if any
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// inTx : Runs fn in a transaction bound to ctx (and to timeout), committing it when fn succeeds
+func inTx(ctx context.Context, db *gorm.DB, timeout time.Duration, readOnly bool, fn func(tx *gorm.DB) error) error {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	tx := tracing.WithContext(db, ctx).BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if rowLevelSecurity {
+		if err := setTenant(ctx, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -1,88 +1,234 @@
 package postgres
 
 import (
-	"errors"
+	"context"
 	"time"
 
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
 	"github.com/LuD1161/restructuring-tnbt/pkg/user"
 	"github.com/jinzhu/gorm"
-	"github.com/sirupsen/logrus"
 )
 
+// errUserNotFound : The methods below shadow the user package with their local variables
+var errUserNotFound = user.ErrUserNotFound
+
 type userRepository struct {
-	db *gorm.DB
+	db           *gorm.DB
+	queryTimeout time.Duration
 }
 
-// NewPostgresUserRepository : To create new postgres repository connection
-func NewPostgresUserRepository(db *gorm.DB) user.Repository {
+// NewPostgresUserRepository : To create new postgres repository connection.
+// Every call is bounded by queryTimeout on top of the caller's context deadline (0 for no limit).
+func NewPostgresUserRepository(db *gorm.DB, queryTimeout time.Duration) user.Repository {
 	return &userRepository{
 		db,
+		queryTimeout,
 	}
 }
 
-func (r *userRepository) CreateUser(user *user.User) (*user.User, error) {
-	err := r.db.Create(&user).Error
-	if err != nil {
+func (r *userRepository) CreateUser(ctx context.Context, u *user.User) (_ *user.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.CreateUser")
+	defer func() { tracing.End(span, err) }()
+	if u.TenantID, err = creationTenant(ctx); err != nil {
 		return nil, err
 	}
-	return user, nil
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		if err := tx.Create(&u).Error; err != nil {
+			return err
+		}
+		return recordEvents(tx, user.NewEvent(user.EventCreated, u.UserInfoPayload))
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return u, nil
 }
 
-func (r *userRepository) UpdateUser(u *user.User) (*user.User, error) {
-	var err error
-	user := new(user.User)
-	logrus.Info("Inside UpdateUser (in repo) : ", u.ID)
-	db := r.db.Model(user).Where("id = ?", u.ID).Updates(
-		map[string]interface{}{
-			"password":   u.Password,
-			"updated_at": time.Now(),
-		},
-	)
-	if db.Error != nil {
-		return user, db.Error
-	}
-	err = r.db.Where("id = ?", u.ID).First(&u).Error
+func (r *userRepository) UpdateUser(ctx context.Context, u *user.User) (_ *user.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.UpdateUser")
+	defer func() { tracing.End(span, err) }()
+	return r.update(ctx, u.ID, u.Version, updatedColumns(u), u.Password != "")
+}
+
+func (r *userRepository) SetAvatar(ctx context.Context, uid, version uint64, avatarID string) (_ *user.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.SetAvatar")
+	defer func() { tracing.End(span, err) }()
+	return r.update(ctx, uid, version, map[string]interface{}{
+		"avatar_id":  avatarID,
+		"updated_at": time.Now(),
+		"version":    gorm.Expr("version + 1"),
+	}, false)
+}
+
+// update : Writes the columns of user uid, compare-and-swap on version unless it is 0, and records the events
+func (r *userRepository) update(ctx context.Context, uid, version uint64, columns map[string]interface{}, passwordChanged bool) (*user.User, error) {
+	scope, err := tenantScope(ctx)
 	if err != nil {
-		return user, err
+		return nil, err
 	}
-	return u, nil
+	updated := new(user.User)
+	logging.FromContext(ctx).WithFields(map[string]interface{}{"user_id": uid, "version": version}).Debug("Updating user")
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		res := compareAndSwap(tx.Scopes(scope).Model(&user.User{}), uid, version).Updates(columns)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return missingOrStale(tx.Scopes(scope), uid)
+		}
+		if err := tx.Scopes(scope).Where("id = ?", uid).First(updated).Error; err != nil {
+			return err
+		}
+		events := []user.Event{user.NewEvent(user.EventUpdated, updated.UserInfoPayload)}
+		if passwordChanged {
+			events = append(events, user.NewEvent(user.EventPasswordChanged, updated.UserInfoPayload))
+		}
+		return recordEvents(tx, events...)
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return updated, nil
 }
 
-func (r *userRepository) DeleteUser(uid uint64) (int64, error) {
-	var err error
-	user := new(user.User)
-	err = r.db.Model(user).Where("id = ?", uid).Delete(&user).Error
+func (r *userRepository) DeleteUser(ctx context.Context, uid, version uint64) (_ int64, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.DeleteUser")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		// Soft delete, written by hand rather than through gorm's Delete to bump the version as well
+		res := compareAndSwap(tx.Scopes(scope).Model(&user.User{}), uid, version).Updates(map[string]interface{}{
+			"deleted_at": time.Now(),
+			"version":    gorm.Expr("version + 1"),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		deleted = res.RowsAffected
+		if deleted == 0 && version != 0 {
+			return missingOrStale(tx.Scopes(scope), uid)
+		}
+		if deleted == 0 {
+			return nil
+		}
+		u := new(user.User)
+		if err := tx.Scopes(scope).Unscoped().Where("id = ?", uid).First(u).Error; err != nil {
+			return err
+		}
+		return recordEvents(tx, user.NewEvent(user.EventDeleted, u.UserInfoPayload))
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return 0, nil
+	}
 	if err != nil {
 		return 0, err
 	}
-	return r.db.RowsAffected, nil
+	return deleted, nil
 }
 
-func (r *userRepository) GetUserByID(uid uint64) (*user.User, error) {
-	var err error
-	user := new(user.User)
-	err = r.db.Model(user).Where("id = ?", uid).First(&user).Error
-	// Handle the specific case first
-	if gorm.IsRecordNotFoundError(err) {
-		return user, errors.New("User Not Found")
+func (r *userRepository) GetUserByID(ctx context.Context, uid uint64) (_ *user.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.GetUserByID")
+	defer func() { tracing.End(span, err) }()
+	return r.first(ctx, "id = ?", uid)
+}
+
+func (r *userRepository) GetUsersByIDs(ctx context.Context, uids []uint64) (_ []user.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.GetUsersByIDs")
+	defer func() { tracing.End(span, err) }()
+	scope, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
 	}
+	var users []user.User
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Scopes(scope).Where("id IN (?)", uids).Find(&users).Error
+	})
 	if err != nil {
-		return user, err
+		return nil, err
 	}
-	return user, err
+	return users, nil
+}
+
+func (r *userRepository) GetUserByUsername(ctx context.Context, username string) (_ *user.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.GetUserByUsername")
+	defer func() { tracing.End(span, err) }()
+	return r.first(ctx, "username = ?", username)
 }
 
-func (r *userRepository) GetUserByUsername(username string) (*user.User, error) {
-	var err error // so that in the end when returning, err == nil ( so the signature doesn't change for any function)
+func (r *userRepository) GetUserByEmail(ctx context.Context, email string) (_ *user.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.userRepository.GetUserByEmail")
+	defer func() { tracing.End(span, err) }()
+	return r.first(ctx, "email = ?", email)
+}
+
+// first : First user of the tenant matching the condition, errUserNotFound if there's none
+func (r *userRepository) first(ctx context.Context, query string, args ...interface{}) (*user.User, error) {
 	user := new(user.User)
-	logrus.Info("username ", username)
-	err = r.db.Model(user).Where("username = ?", username).First(&user).Error
-	// Handle the specific case first
-	if gorm.IsRecordNotFoundError(err) {
-		return user, errors.New("User Not Found")
-	}
+	scope, err := tenantScope(ctx)
 	if err != nil {
 		return user, err
 	}
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Scopes(scope).Model(user).Where(query, args...).First(&user).Error
+	})
+	// Handle the specific case first
+	if gorm.IsRecordNotFoundError(err) {
+		return user, errUserNotFound
+	}
 	return user, err
 }
+
+// updatedColumns : Columns written by UpdateUser, the empty fields of u are left untouched but the profile's
+func updatedColumns(u *user.User) map[string]interface{} {
+	columns := map[string]interface{}{
+		"updated_at": time.Now(),
+		"version":    gorm.Expr("version + 1"),
+	}
+	if u.ProfileChanged {
+		columns["display_name"] = u.DisplayName
+		columns["locale"] = u.Locale
+		columns["timezone"] = u.Timezone
+		columns["bio"] = u.Bio
+		columns["attributes"] = u.Attributes
+	}
+	for column, value := range map[string]string{
+		"username": u.Username,
+		"email":    u.Email,
+		"password": u.Password,
+		"status":   u.Status,
+	} {
+		if value != "" {
+			columns[column] = value
+		}
+	}
+	return columns
+}
+
+// compareAndSwap : Restricts the write to user uid, and to its given version unless it is 0
+func compareAndSwap(db *gorm.DB, uid, version uint64) *gorm.DB {
+	db = db.Where("id = ?", uid)
+	if version != 0 {
+		db = db.Where("version = ?", version)
+	}
+	return db
+}
+
+// missingOrStale : Why a compare-and-swap of user uid matched no row
+func missingOrStale(tx *gorm.DB, uid uint64) error {
+	var count int
+	if err := tx.Model(&user.User{}).Where("id = ?", uid).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return user.ErrVersionConflict
+}
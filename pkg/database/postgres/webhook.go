@@ -0,0 +1,311 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/LuD1161/restructuring-tnbt/pkg/webhook"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// webhookSubscription : Row of a webhook.Subscription
+type webhookSubscription struct {
+	ID        uint64 `gorm:"primary_key;auto_increment"`
+	URL       string `gorm:"size:2048;not null"`
+	Events    string `gorm:"size:255;not null"` // Comma separated
+	Secret    string `gorm:"size:255;not null"`
+	Active    bool   `gorm:"not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (webhookSubscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// webhookDelivery : Row of a webhook.Delivery, the due ones are found through idx_webhook_deliveries_due
+type webhookDelivery struct {
+	ID             uint64     `gorm:"primary_key;auto_increment"`
+	SubscriptionID uint64     `gorm:"not null;index"`
+	EventID        string     `gorm:"size:64;not null"`
+	EventType      string     `gorm:"size:64;not null"`
+	Payload        []byte     `gorm:"not null"`
+	Status         string     `gorm:"size:20;not null;index:idx_webhook_deliveries_due"`
+	Attempts       int        `gorm:"not null"`
+	NextAttemptAt  *time.Time `gorm:"index:idx_webhook_deliveries_due"`
+	LastAttemptAt  *time.Time
+	LastStatusCode int    `gorm:"not null"`
+	LastError      string `gorm:"type:text;not null"`
+	CreatedAt      time.Time
+}
+
+func (webhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+type webhookRepository struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// MigrateWebhooks : Creates the tables of the webhook subscriptions and deliveries
+func MigrateWebhooks(db *gorm.DB) error {
+	if err := db.AutoMigrate(&webhookSubscription{}, &webhookDelivery{}).Error; err != nil {
+		return errors.Wrap(err, "pkg.database.postgres.MigrateWebhooks")
+	}
+	return nil
+}
+
+// NewPostgresWebhookRepository : webhook.Repository, see MigrateWebhooks
+func NewPostgresWebhookRepository(db *gorm.DB, queryTimeout time.Duration) webhook.Repository {
+	return &webhookRepository{db: db, queryTimeout: queryTimeout}
+}
+
+func (r *webhookRepository) CreateSubscription(ctx context.Context, s *webhook.Subscription) (_ *webhook.Subscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.CreateSubscription")
+	defer func() { tracing.End(span, err) }()
+	row := &webhookSubscription{URL: s.URL, Events: strings.Join(s.Events, ","), Secret: s.Secret, Active: s.Active}
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		return tx.Create(row).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return row.subscription(), nil
+}
+
+func (r *webhookRepository) GetSubscription(ctx context.Context, id uint64) (_ *webhook.Subscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.GetSubscription")
+	defer func() { tracing.End(span, err) }()
+	row := new(webhookSubscription)
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Where("id = ?", id).First(row).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, webhook.ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.subscription(), nil
+}
+
+func (r *webhookRepository) ListSubscriptions(ctx context.Context) (_ []webhook.Subscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.ListSubscriptions")
+	defer func() { tracing.End(span, err) }()
+	var rows []webhookSubscription
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Order("id").Find(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]webhook.Subscription, len(rows))
+	for i := range rows {
+		subs[i] = *rows[i].subscription()
+	}
+	return subs, nil
+}
+
+func (r *webhookRepository) UpdateSubscription(ctx context.Context, s *webhook.Subscription) (_ *webhook.Subscription, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.UpdateSubscription")
+	defer func() { tracing.End(span, err) }()
+	row := new(webhookSubscription)
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		res := tx.Model(&webhookSubscription{}).Where("id = ?", s.ID).Updates(map[string]interface{}{
+			"url":        s.URL,
+			"events":     strings.Join(s.Events, ","),
+			"secret":     s.Secret,
+			"active":     s.Active,
+			"updated_at": time.Now(),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("id = ?", s.ID).First(row).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, webhook.ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.subscription(), nil
+}
+
+func (r *webhookRepository) DeleteSubscription(ctx context.Context, id uint64) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.DeleteSubscription")
+	defer func() { tracing.End(span, err) }()
+	var deleted int64
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		if err := tx.Where("subscription_id = ?", id).Delete(&webhookDelivery{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("id = ?", id).Delete(&webhookSubscription{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return webhook.ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+func (r *webhookRepository) CreateDeliveries(ctx context.Context, deliveries []webhook.Delivery) (_ []webhook.Delivery, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.CreateDeliveries")
+	defer func() { tracing.End(span, err) }()
+	rows := make([]webhookDelivery, len(deliveries))
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		for i, d := range deliveries {
+			rows[i] = webhookDelivery{
+				SubscriptionID: d.SubscriptionID,
+				EventID:        d.EventID,
+				EventType:      d.EventType,
+				Payload:        d.Payload,
+				Status:         d.Status,
+				NextAttemptAt:  d.NextAttemptAt,
+			}
+			if err := tx.Create(&rows[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveriesOf(rows), nil
+}
+
+func (r *webhookRepository) GetDelivery(ctx context.Context, subscriptionID, id uint64) (_ *webhook.Delivery, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.GetDelivery")
+	defer func() { tracing.End(span, err) }()
+	row := new(webhookDelivery)
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		return tx.Where("subscription_id = ? AND id = ?", subscriptionID, id).First(row).Error
+	})
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, webhook.ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := row.delivery()
+	return &d, nil
+}
+
+func (r *webhookRepository) ListDeliveries(ctx context.Context, subscriptionID, beforeID uint64, limit int) (_ []webhook.Delivery, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.ListDeliveries")
+	defer func() { tracing.End(span, err) }()
+	var rows []webhookDelivery
+	err = inTx(ctx, r.db, r.queryTimeout, true, func(tx *gorm.DB) error {
+		q := tx.Where("subscription_id = ?", subscriptionID)
+		if beforeID != 0 {
+			q = q.Where("id < ?", beforeID)
+		}
+		return q.Order("id DESC").Limit(limit).Find(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveriesOf(rows), nil
+}
+
+// ClaimDeliveries : Every due delivery is postponed by a conditional UPDATE, which only one of the instances racing
+// for it gets to apply : the others wait for its row lock, then find it no longer due.
+func (r *webhookRepository) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) (_ []webhook.Delivery, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.ClaimDeliveries")
+	defer func() { tracing.End(span, err) }()
+	var claimed []webhookDelivery
+	err = inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		var due []webhookDelivery
+		err := tx.Where("status = ? AND next_attempt_at <= ?", webhook.StatusPending, now).
+			Order("next_attempt_at, id").Limit(limit).Find(&due).Error
+		if err != nil {
+			return err
+		}
+		leaseEnd := now.Add(lease)
+		for _, row := range due {
+			res := tx.Model(&webhookDelivery{}).
+				Where("id = ? AND status = ? AND next_attempt_at <= ?", row.ID, webhook.StatusPending, now).
+				Update("next_attempt_at", leaseEnd)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 1 {
+				row.NextAttemptAt = &leaseEnd
+				claimed = append(claimed, row)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveriesOf(claimed), nil
+}
+
+func (r *webhookRepository) UpdateDelivery(ctx context.Context, d *webhook.Delivery) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "postgres.webhookRepository.UpdateDelivery")
+	defer func() { tracing.End(span, err) }()
+	return inTx(ctx, r.db, r.queryTimeout, false, func(tx *gorm.DB) error {
+		return tx.Model(&webhookDelivery{}).Where("id = ?", d.ID).Updates(map[string]interface{}{
+			"status":           d.Status,
+			"attempts":         d.Attempts,
+			"next_attempt_at":  d.NextAttemptAt,
+			"last_attempt_at":  d.LastAttemptAt,
+			"last_status_code": d.LastStatusCode,
+			"last_error":       d.LastError,
+		}).Error
+	})
+}
+
+func (row *webhookSubscription) subscription() *webhook.Subscription {
+	var events []string
+	if row.Events != "" {
+		events = strings.Split(row.Events, ",")
+	}
+	return &webhook.Subscription{
+		ID:        row.ID,
+		URL:       row.URL,
+		Events:    events,
+		Secret:    row.Secret,
+		Active:    row.Active,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+}
+
+func (row *webhookDelivery) delivery() webhook.Delivery {
+	return webhook.Delivery{
+		ID:             row.ID,
+		SubscriptionID: row.SubscriptionID,
+		EventID:        row.EventID,
+		EventType:      row.EventType,
+		Payload:        row.Payload,
+		Status:         row.Status,
+		Attempts:       row.Attempts,
+		NextAttemptAt:  row.NextAttemptAt,
+		LastAttemptAt:  row.LastAttemptAt,
+		LastStatusCode: row.LastStatusCode,
+		LastError:      row.LastError,
+		CreatedAt:      row.CreatedAt,
+	}
+}
+
+func deliveriesOf(rows []webhookDelivery) []webhook.Delivery {
+	deliveries := make([]webhook.Delivery, len(rows))
+	for i := range rows {
+		deliveries[i] = rows[i].delivery()
+	}
+	return deliveries
+}
@@ -0,0 +1,140 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// Environment variables pointing the tests to the brokers of the docker compose file (profile events), the tests
+// of a broker are skipped without its variable
+const (
+	natsURLEnv      = "TEST_NATS_URL"      // e.g. nats://localhost:4222
+	kafkaBrokersEnv = "TEST_KAFKA_BROKERS" // Comma separated, e.g. localhost:19092
+)
+
+func TestNATSBroker(t *testing.T) {
+	url := os.Getenv(natsURLEnv)
+	if url == "" {
+		t.Skipf("%s isn't set", natsURLEnv)
+	}
+	prefix := fmt.Sprintf("test%d", time.Now().UnixNano())
+	b, err := NewNATSBroker(config.NATSConfig{URL: url, SubjectPrefix: prefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.PingContext(ctx); err != nil {
+		t.Fatalf("PingContext() = %v", err)
+	}
+
+	// A subscriber of every user event, on a connection of its own
+	conn, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msgs := make(chan *nats.Msg, 1)
+	if _, err := conn.ChanSubscribe(prefix+".user.>", msgs); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.FlushWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	e := testEvent(user.EventCreated)
+	if err := NewPublisher(b).Publish(ctx, e); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	select {
+	case msg := <-msgs:
+		if want := prefix + "." + user.EventCreated; msg.Subject != want {
+			t.Errorf("subject = %q, want %q", msg.Subject, want)
+		}
+		checkMessage(t, Message{
+			ID:      msg.Header.Get(nats.MsgIdHdr),
+			Type:    strings.TrimPrefix(msg.Subject, prefix+"."),
+			Key:     msg.Header.Get("User-Id"),
+			Payload: msg.Data,
+		}, e)
+	case <-ctx.Done():
+		t.Fatal("the message wasn't received")
+	}
+}
+
+func TestNATSBrokerUnreachable(t *testing.T) {
+	// Nothing listens on port 1, the connection is refused right away
+	if _, err := NewNATSBroker(config.NATSConfig{URL: "nats://127.0.0.1:1", SubjectPrefix: "tnbt"}); err == nil {
+		t.Error("NewNATSBroker() succeeded without a server")
+	}
+}
+
+// createKafkaTopic : Creates a topic of a single partition through the controller of the cluster
+func createKafkaTopic(t *testing.T, addr, topic string) {
+	conn, err := kafka.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	controller, err := conn.Controller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := kafka.Dial("tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	if err := cc.CreateTopics(kafka.TopicConfig{Topic: topic, NumPartitions: 1, ReplicationFactor: 1}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKafkaBroker(t *testing.T) {
+	brokers := os.Getenv(kafkaBrokersEnv)
+	if brokers == "" {
+		t.Skipf("%s isn't set", kafkaBrokersEnv)
+	}
+	addrs := strings.Split(brokers, ",")
+	// Every run publishes to a topic of its own
+	topic := fmt.Sprintf("tnbt.test-%d", time.Now().UnixNano())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	b := NewKafkaBroker(config.KafkaConfig{Brokers: addrs, Topic: topic})
+	defer b.Close()
+	if err := b.PingContext(ctx); err == nil {
+		t.Errorf("PingContext() succeeded before the topic %s exists", topic)
+	}
+	createKafkaTopic(t, addrs[0], topic)
+	if err := b.PingContext(ctx); err != nil {
+		t.Fatalf("PingContext() = %v", err)
+	}
+
+	e := testEvent(user.EventDeleted)
+	if err := NewPublisher(b).Publish(ctx, e); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	r := kafka.NewReader(kafka.ReaderConfig{Brokers: addrs, Topic: topic, Partition: 0})
+	defer r.Close()
+	msg, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("ReadMessage() = %v", err)
+	}
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	checkMessage(t, Message{ID: headers["event-id"], Type: headers["event-type"], Key: string(msg.Key), Payload: msg.Value}, e)
+}
@@ -0,0 +1,70 @@
+// Package eventbus publishes the user events relayed from the outbox to a message broker : in process, NATS or a
+// Kafka compatible one (Kafka, Redpanda ...).
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/pkg/errors"
+)
+
+// Message : Event as sent to the broker
+type Message struct {
+	ID      string // Event ID, consumers can deduplicate on it
+	Type    string // Event type
+	Key     string // User ID, the events of a user share it so that partitioned brokers keep them in order
+	Payload []byte // user.Event as JSON
+}
+
+// Broker : Message broker the events are published to
+type Broker interface {
+	Publish(context.Context, Message) error // Returns once the broker has the message
+	PingContext(context.Context) error      // Checks the broker is reachable, for the readiness probe
+	Close() error
+}
+
+// Open : Broker picked by cfg.Broker, nil for none
+func Open(cfg config.EventsConfig) (Broker, error) {
+	switch cfg.Broker {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		return NewMemoryBroker(), nil
+	case "nats":
+		b, err := NewNATSBroker(cfg.NATS)
+		if err != nil {
+			return nil, errors.Wrap(err, "pkg.eventbus.Open")
+		}
+		return b, nil
+	case "kafka":
+		return NewKafkaBroker(cfg.Kafka), nil
+	default:
+		return nil, fmt.Errorf("pkg.eventbus.Open : unknown broker %q", cfg.Broker)
+	}
+}
+
+type publisher struct {
+	broker Broker
+}
+
+// NewPublisher : user.Publisher sending the events to broker
+func NewPublisher(broker Broker) user.Publisher {
+	return &publisher{broker}
+}
+
+func (p *publisher) Publish(ctx context.Context, e user.Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "pkg.eventbus.publisher.Publish")
+	}
+	err = p.broker.Publish(ctx, Message{ID: e.ID, Type: e.Type, Key: strconv.FormatUint(e.User.ID, 10), Payload: payload})
+	if err != nil {
+		return errors.Wrap(err, "pkg.eventbus.publisher.Publish")
+	}
+	return nil
+}
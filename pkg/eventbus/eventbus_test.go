@@ -0,0 +1,128 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+)
+
+// testEvent : Event of user 42
+func testEvent(eventType string) user.Event {
+	return user.NewEvent(eventType, user.UserInfoPayload{ID: 42, Username: "jdoe", Email: "jdoe@example.com", Version: 3})
+}
+
+// checkMessage : m is the message of e
+func checkMessage(t *testing.T, m Message, e user.Event) {
+	t.Helper()
+	if m.ID != e.ID || m.Type != e.Type || m.Key != "42" {
+		t.Errorf("message ID, Type, Key = %q, %q, %q, want %q, %q, \"42\"", m.ID, m.Type, m.Key, e.ID, e.Type)
+	}
+	var got user.Event
+	if err := json.Unmarshal(m.Payload, &got); err != nil {
+		t.Fatalf("payload %s : %v", m.Payload, err)
+	}
+	if got.ID != e.ID || got.Type != e.Type || !got.OccurredAt.Equal(e.OccurredAt) ||
+		got.User.ID != e.User.ID || got.User.Username != e.User.Username || got.User.Version != e.User.Version {
+		t.Errorf("payload = %+v, want %+v", got, e)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for _, broker := range []string{"", "none"} {
+		if b, err := Open(config.EventsConfig{Broker: broker}); b != nil || err != nil {
+			t.Errorf("Open(%q) = %v, %v, want no broker", broker, b, err)
+		}
+	}
+	if b, err := Open(config.EventsConfig{Broker: "memory"}); err != nil {
+		t.Errorf("Open(memory) = %v", err)
+	} else if _, ok := b.(*MemoryBroker); !ok {
+		t.Errorf("Open(memory) = %T, want a *MemoryBroker", b)
+	}
+	if _, err := Open(config.EventsConfig{Broker: "rabbitmq"}); err == nil {
+		t.Error("Open(rabbitmq) succeeded, want an unknown broker error")
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	b := NewMemoryBroker()
+	var got []Message
+	b.Subscribe(func(_ context.Context, m Message) error {
+		got = append(got, m)
+		return nil
+	})
+	e := testEvent(user.EventUpdated)
+	if err := NewPublisher(b).Publish(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("%d messages published, want 1", len(got))
+	}
+	checkMessage(t, got[0], e)
+
+	failure := errors.New("broker down")
+	b.Subscribe(func(context.Context, Message) error { return failure })
+	if err := NewPublisher(b).Publish(context.Background(), e); !errors.Is(err, failure) {
+		t.Errorf("Publish() = %v, want %v", err, failure)
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBroker()
+	if err := b.Publish(ctx, Message{ID: "1", Type: user.EventCreated}); err != nil {
+		t.Errorf("Publish() without subscribers = %v", err)
+	}
+
+	var calls []string
+	handler := func(name string, err error) Handler {
+		return func(_ context.Context, m Message) error {
+			calls = append(calls, name+" "+m.ID)
+			return err
+		}
+	}
+	b.Subscribe(handler("all", nil))
+	b.Subscribe(handler("created", nil), user.EventCreated)
+	b.Subscribe(handler("deleted or restored", nil), user.EventDeleted, user.EventRestored)
+
+	for _, tc := range []struct {
+		m     Message
+		calls []string
+	}{
+		{Message{ID: "2", Type: user.EventCreated}, []string{"all 2", "created 2"}},
+		{Message{ID: "3", Type: user.EventRestored}, []string{"all 3", "deleted or restored 3"}},
+		{Message{ID: "4", Type: user.EventLoggedIn}, []string{"all 4"}},
+	} {
+		calls = nil
+		if err := b.Publish(ctx, tc.m); err != nil {
+			t.Fatalf("Publish(%s) = %v", tc.m.Type, err)
+		}
+		if !reflect.DeepEqual(calls, tc.calls) {
+			t.Errorf("Publish(%s) called %v, want %v", tc.m.Type, calls, tc.calls)
+		}
+	}
+
+	// The first failing handler fails the publication, the next ones aren't called
+	failure := errors.New("handler failed")
+	b.Subscribe(handler("failing", failure), user.EventCreated)
+	b.Subscribe(handler("last", nil), user.EventCreated)
+	calls = nil
+	if err := b.Publish(ctx, Message{ID: "5", Type: user.EventCreated}); err != failure {
+		t.Errorf("Publish() = %v, want %v", err, failure)
+	}
+	if want := []string{"all 5", "created 5", "failing 5"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Publish() called %v, want %v", calls, want)
+	}
+}
+
+func TestKafkaBrokerPingWithoutBrokers(t *testing.T) {
+	b := NewKafkaBroker(config.KafkaConfig{Topic: "tnbt.user-events"})
+	defer b.Close()
+	if err := b.PingContext(context.Background()); err == nil {
+		t.Error("PingContext() without brokers succeeded")
+	}
+}
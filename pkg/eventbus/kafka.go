@@ -0,0 +1,66 @@
+package eventbus
+
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaBatchTimeout : The Relay publishes the events one at a time, there's no batch worth waiting for
+const kafkaBatchTimeout = 10 * time.Millisecond
+
+// KafkaBroker : Publishes every event to a single topic, keyed by user so that the events of a user land on the same
+// partition, in order. The event type and ID are sent as headers.
+type KafkaBroker struct {
+	writer  *kafka.Writer
+	brokers []string
+	topic   string
+}
+
+// NewKafkaBroker : Broker of the Kafka compatible cluster, connected on the first Publish
+func NewKafkaBroker(cfg config.KafkaConfig) *KafkaBroker {
+	return &KafkaBroker{writer: &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: kafkaBatchTimeout,
+	}, brokers: cfg.Brokers, topic: cfg.Topic}
+}
+
+// Publish : Sends m and waits for every in-sync replica to acknowledge it
+func (b *KafkaBroker) Publish(ctx context.Context, m Message) error {
+	return b.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(m.Key),
+		Value: m.Payload,
+		Headers: []kafka.Header{
+			{Key: "event-id", Value: []byte(m.ID)},
+			{Key: "event-type", Value: []byte(m.Type)},
+		},
+	})
+}
+
+// PingContext : Looks the partitions of the topic up from the first broker answering, failing when none does or when
+// the topic is missing
+func (b *KafkaBroker) PingContext(ctx context.Context) error {
+	var dialer kafka.Dialer
+	err := errors.New("no kafka broker configured")
+	for _, addr := range b.brokers {
+		var partitions []kafka.Partition
+		if partitions, err = dialer.LookupPartitions(ctx, "tcp", addr, b.topic); err == nil {
+			if len(partitions) == 0 {
+				return errors.Errorf("kafka topic %q not found", b.topic)
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// Close : Flushes the pending messages and closes the connections
+func (b *KafkaBroker) Close() error {
+	return b.writer.Close()
+}
@@ -0,0 +1,57 @@
+package eventbus
+
+import (
+	"context"
+	"sync"
+)
+
+// Handler : Consumes a message of the MemoryBroker
+type Handler func(context.Context, Message) error
+
+// MemoryBroker : In process Broker, delivering the messages to the handlers subscribed in the same process before
+// Publish returns. It is the stand-in for the other brokers when running locally.
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler // By event type, "" for every type
+}
+
+// NewMemoryBroker : MemoryBroker without subscribers, it drops the messages until some subscribe
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{handlers: make(map[string][]Handler)}
+}
+
+// Subscribe : Calls h with the messages of the given event types, or with every message when none is given
+func (b *MemoryBroker) Subscribe(h Handler, eventTypes ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(eventTypes) == 0 {
+		eventTypes = []string{""}
+	}
+	for _, t := range eventTypes {
+		b.handlers[t] = append(b.handlers[t], h)
+	}
+}
+
+// Publish : Delivers m to its handlers in the order they subscribed, failing with the first error so that the event
+// is published again : handlers must be idempotent.
+func (b *MemoryBroker) Publish(ctx context.Context, m Message) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler(nil), b.handlers[""]...), b.handlers[m.Type]...)
+	b.mu.RUnlock()
+	for _, h := range handlers {
+		if err := h(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PingContext : Always reachable
+func (b *MemoryBroker) PingContext(context.Context) error {
+	return nil
+}
+
+// Close : Nothing to release
+func (b *MemoryBroker) Close() error {
+	return nil
+}
@@ -0,0 +1,56 @@
+package eventbus
+
+import (
+	"context"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// NATSBroker : Publishes every event on the subject <prefix>.<event type>, e.g. tnbt.user.created, which
+// subscribers can match with tnbt.user.>. The event ID is sent as Nats-Msg-Id, which JetStream deduplicates on.
+type NATSBroker struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+// NewNATSBroker : Connects to the NATS server, reconnecting for as long as the broker is open
+func NewNATSBroker(cfg config.NATSConfig) (*NATSBroker, error) {
+	opts := []nats.Option{nats.Name("tnbt"), nats.MaxReconnects(-1)}
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(cfg.Token))
+	}
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSBroker{conn: conn, prefix: cfg.SubjectPrefix}, nil
+}
+
+// Publish : Sends m and waits for the server to acknowledge it got it
+func (b *NATSBroker) Publish(ctx context.Context, m Message) error {
+	msg := nats.NewMsg(b.prefix + "." + m.Type)
+	msg.Data = m.Payload
+	msg.Header.Set(nats.MsgIdHdr, m.ID)
+	msg.Header.Set("User-Id", m.Key)
+	if err := b.conn.PublishMsg(msg); err != nil {
+		return err
+	}
+	// A core NATS publish is buffered, the flush round trip makes sure it left
+	return b.conn.FlushWithContext(ctx)
+}
+
+// PingContext : Fails while the connection is down, otherwise waits for a round trip to the server
+func (b *NATSBroker) PingContext(ctx context.Context) error {
+	if !b.conn.IsConnected() {
+		return errors.Errorf("nats connection %s", strings.ToLower(b.conn.Status().String()))
+	}
+	return b.conn.FlushWithContext(ctx)
+}
+
+// Close : Sends the buffered messages and closes the connection
+func (b *NATSBroker) Close() error {
+	return b.conn.Drain()
+}
@@ -0,0 +1,134 @@
+package graphqlserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/pkg/errors"
+)
+
+// MaxDepth : Deepest selection a query may make, bounding the work a single request can ask for
+const MaxDepth = 10
+
+var (
+	errMalformedRequest = apperrors.Validation("Malformed GraphQL request")
+	errUnsupportedType  = apperrors.UnsupportedMediaType("GraphQL requests must be application/json")
+)
+
+// New : Executable schema whose panics are logged and returned as opaque internal errors
+func New(schema string, resolver interface{}) (*graphql.Schema, error) {
+	return graphql.ParseSchema(schema, resolver,
+		graphql.UseStringDescriptions(),
+		graphql.MaxDepth(MaxDepth),
+		graphql.Logger(panicLogger{}),
+		graphql.PanicHandler(panicHandler{}),
+	)
+}
+
+// request : Body of a GraphQL POST request
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Handler : Serves the GraphQL requests POSTed as JSON. The request's context is the resolvers' one, with the user
+// authenticated by the auth middlewares. Malformed requests get a problem+json response, the errors met while
+// executing them are part of the GraphQL response (see Error).
+func Handler(schema *graphql.Schema) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.ContentType() != "application/json" {
+			problem.Abort(c, errors.Wrap(errUnsupportedType, "pkg.graphqlserver.Handler"))
+			return
+		}
+		var req request
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+			problem.Abort(c, errors.Wrap(errMalformedRequest.Wrap(err), "pkg.graphqlserver.Handler"))
+			return
+		}
+		ctx := c.Request.Context()
+		resp := schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+		for i, qe := range resp.Errors {
+			if qe.ResolverError == nil {
+				continue
+			}
+			// Logged as is by the access log, returned without the details
+			_ = c.Error(qe.ResolverError)
+			resp.Errors[i] = Error(qe)
+			if e, ok := apperrors.As(qe.ResolverError); !ok || e.Kind == apperrors.KindInternal {
+				logging.FromContext(ctx).WithError(qe.ResolverError).WithField("path", qe.Path).Error("GraphQL resolver failed")
+			}
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// Error : GraphQL counterpart of problem.New. Domain errors keep their message and get their kind as the "code"
+// extension along with the invalid fields, anything else is an opaque internal error.
+func Error(qe *gqlerrors.QueryError) *gqlerrors.QueryError {
+	mapped := &gqlerrors.QueryError{
+		Message:   "Internal error",
+		Locations: qe.Locations,
+		Path:      qe.Path,
+		Extensions: map[string]interface{}{
+			"code": code(apperrors.KindInternal),
+		},
+	}
+	e, ok := apperrors.As(qe.ResolverError)
+	if !ok || e.Kind == apperrors.KindInternal {
+		return mapped
+	}
+	mapped.Message = e.Message
+	mapped.Extensions["code"] = code(e.Kind)
+	if len(e.Fields) > 0 {
+		mapped.Extensions["fields"] = e.Fields
+	}
+	return mapped
+}
+
+// code : Error codes in the usual GraphQL spelling
+func code(kind apperrors.Kind) string {
+	switch kind {
+	case apperrors.KindNotFound:
+		return "NOT_FOUND"
+	case apperrors.KindConflict:
+		return "CONFLICT"
+	case apperrors.KindUnauthorized:
+		return "UNAUTHENTICATED"
+	case apperrors.KindForbidden:
+		return "FORBIDDEN"
+	case apperrors.KindValidation, apperrors.KindUnsupportedMediaType, apperrors.KindNotAcceptable, apperrors.KindPayloadTooLarge:
+		return "BAD_USER_INPUT"
+	case apperrors.KindPreconditionFailed:
+		return "PRECONDITION_FAILED"
+	default:
+		return "INTERNAL_SERVER_ERROR"
+	}
+}
+
+// panicLogger : Logs the panics of the resolvers with the request's logger
+type panicLogger struct{}
+
+func (panicLogger) LogPanic(ctx context.Context, value interface{}) {
+	logging.FromContext(ctx).WithField("stack", string(debug.Stack())).Errorf("Panic resolving GraphQL : %v", value)
+}
+
+// panicHandler : The panic's value isn't disclosed, panicLogger logged it
+type panicHandler struct{}
+
+func (panicHandler) MakePanicError(ctx context.Context, value interface{}) *gqlerrors.QueryError {
+	return &gqlerrors.QueryError{
+		Err:        fmt.Errorf("panic : %v", value),
+		Message:    "Internal error",
+		Extensions: map[string]interface{}{"code": code(apperrors.KindInternal)},
+	}
+}
@@ -0,0 +1,113 @@
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC is served by the HTTP server itself (see Handler) rather than on a listener of its own : requests keep going
+// through the same TLS setup, client certificates included, and one port serves both protocols.
+
+// New : gRPC server whose calls are traced, logged and recovered from panics, with the domain errors turned into
+// gRPC statuses (see Status). interceptors run after these, closest to the handlers.
+func New(log *logrus.Logger, interceptors ...grpc.UnaryServerInterceptor) *grpc.Server {
+	chain := append([]grpc.UnaryServerInterceptor{accessLog(log), recovery}, interceptors...)
+	return grpc.NewServer(grpc.ChainUnaryInterceptor(chain...))
+}
+
+// Handler : Serves the gRPC requests with grpcServer and the others with next. HTTP/2 without TLS (h2c) is accepted,
+// so that gRPC clients work against a plain HTTP listener as well.
+func Handler(grpcServer *grpc.Server, next http.Handler) http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The request's context becomes the calls' one
+		id := requestctx.ValidOrNew(r.Header.Get(requestctx.RequestIDHeader))
+		ctx := requestctx.WithRequestID(r.Context(), id)
+		ctx = requestctx.WithClient(ctx, requestctx.Client{IP: requestctx.ClientIP(r), UserAgent: r.UserAgent()})
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+		w.Header().Set(requestctx.RequestIDHeader, id)
+		grpcServer.ServeHTTP(w, r.WithContext(ctx))
+	}), &http2.Server{})
+}
+
+// accessLog : Server span and access log entry of every call, also stores the logger tagged with the request ID
+// in the call's context like logging.Middleware. The errors are logged as is and returned as gRPC statuses.
+func accessLog(log *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		id := requestctx.RequestID(ctx)
+		ctx, span := tracing.Tracer().Start(ctx, info.FullMethod,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("rpc.system", "grpc"), attribute.String("rpc.method", info.FullMethod)),
+		)
+		entry := log.WithFields(logrus.Fields{"request_id": id, "method": info.FullMethod})
+		ctx = logging.WithLogger(ctx, entry)
+
+		resp, err := handler(ctx, req)
+
+		var st *status.Status
+		if err != nil {
+			st = Status(err)
+		}
+		code := st.Code()
+		span.SetAttributes(attribute.String("rpc.grpc.status_code", code.String()))
+		tracing.End(span, serverError(code, err))
+		access := entry.WithFields(logrus.Fields{
+			"code":       code.String(),
+			"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+		})
+		if err != nil {
+			access = access.WithField("errors", err.Error())
+		}
+		switch code {
+		case codes.OK:
+			access.Info("Call handled")
+		case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+			access.Error("Call handled")
+		default:
+			access.Warn("Call handled")
+		}
+		return resp, st.Err()
+	}
+}
+
+// serverError : Only the server side failures mark the span as failed, like the HTTP 5xx
+func serverError(code codes.Code, err error) error {
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		return err
+	}
+	return nil
+}
+
+// recovery : Turns a panicking call into an internal error, logging the stack trace
+func recovery(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.FromContext(ctx).WithField("stack", string(debug.Stack())).Errorf("Panic serving %s : %v", info.FullMethod, r)
+			err = fmt.Errorf("panic : %v", r)
+		}
+	}()
+	return handler(ctx, req)
+}
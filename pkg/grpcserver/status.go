@@ -0,0 +1,51 @@
+package grpcserver
+
+import (
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Status : gRPC counterpart of problem.New. Errors which already are statuses are kept, domain errors get the code
+// matching their kind and their message, anything else is an opaque Internal error.
+func Status(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+	e, ok := apperrors.As(err)
+	if !ok || e.Kind == apperrors.KindInternal {
+		return status.New(codes.Internal, "Internal error")
+	}
+	st := status.New(code(e.Kind), e.Message)
+	if len(e.Fields) == 0 {
+		return st
+	}
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: f.Field, Description: f.Message})
+	}
+	if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+		return detailed
+	}
+	return st
+}
+
+func code(kind apperrors.Kind) codes.Code {
+	switch kind {
+	case apperrors.KindNotFound:
+		return codes.NotFound
+	case apperrors.KindConflict:
+		return codes.AlreadyExists
+	case apperrors.KindUnauthorized:
+		return codes.Unauthenticated
+	case apperrors.KindForbidden:
+		return codes.PermissionDenied
+	case apperrors.KindValidation, apperrors.KindUnsupportedMediaType, apperrors.KindNotAcceptable, apperrors.KindPayloadTooLarge:
+		return codes.InvalidArgument
+	case apperrors.KindPreconditionFailed:
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
+	}
+}
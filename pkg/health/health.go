@@ -0,0 +1,147 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Check : Reports whether a dependency is usable, it must give up once ctx is done
+type Check func(ctx context.Context) error
+
+// Checker : Liveness and readiness endpoints backed by the registered dependency checks
+type Checker interface {
+	// Register : Adds a dependency checked by the readiness endpoint, each run is bounded by timeout
+	Register(name string, timeout time.Duration, check Check)
+	// SetShuttingDown : Makes the readiness endpoint fail so no new traffic is routed here
+	SetShuttingDown()
+	Liveness(c *gin.Context)
+	Readiness(c *gin.Context)
+}
+
+// Report : Body of the health endpoints
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult : Outcome of a single dependency check
+type CheckResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+const (
+	statusOK           = "ok"
+	statusFail         = "fail"
+	statusShuttingDown = "shutting_down"
+)
+
+type registeredCheck struct {
+	name    string
+	timeout time.Duration
+	check   Check
+}
+
+type checker struct {
+	mu           sync.RWMutex
+	checks       []registeredCheck
+	shuttingDown int32
+}
+
+// NewChecker : Returns a Checker without any dependency registered
+func NewChecker() Checker {
+	return &checker{}
+}
+
+func (h *checker) Register(name string, timeout time.Duration, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, registeredCheck{name, timeout, check})
+}
+
+func (h *checker) SetShuttingDown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+// Liveness : Liveness probe, succeeds as long as the process is able to serve requests.
+// Probes aren't part of the versioned API, so they're left out of its Swagger docs.
+func (h *checker) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, Report{Status: statusOK})
+}
+
+// Readiness : Readiness probe, checks every registered dependency and fails while the server is shutting down
+func (h *checker) Readiness(c *gin.Context) {
+	if atomic.LoadInt32(&h.shuttingDown) == 1 {
+		c.JSON(http.StatusServiceUnavailable, Report{Status: statusShuttingDown})
+		return
+	}
+
+	h.mu.RLock()
+	checks := h.checks
+	h.mu.RUnlock()
+
+	report := Report{Status: statusOK, Checks: make([]CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, rc := range checks {
+		wg.Add(1)
+		go func(i int, rc registeredCheck) {
+			defer wg.Done()
+			report.Checks[i] = run(c.Request.Context(), rc)
+		}(i, rc)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, result := range report.Checks {
+		if result.Status != statusOK {
+			report.Status = statusFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(status, report)
+}
+
+func run(parent context.Context, rc registeredCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(parent, rc.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	// A check ignoring ctx must not hold the probe past its timeout
+	go func() { errs <- rc.check(ctx) }()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Name:       rc.name,
+		Status:     statusOK,
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		result.Status = statusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Pinger : Anything that can be pinged, e.g. *sql.DB
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// PingCheck : Check pinging p, used for the database connection
+func PingCheck(p Pinger) Check {
+	return p.PingContext
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GormLogger : gorm logger writing through logrus. Only the parametrized SQL is logged, never the bound values
+// (password hashes, emails ...)
+type GormLogger struct {
+	Log *logrus.Logger
+}
+
+// Print : Implements gorm.logger
+func (l GormLogger) Print(values ...interface{}) {
+	if len(values) < 2 {
+		return
+	}
+	entry := l.Log.WithField("source", values[1])
+	switch values[0] {
+	case "sql":
+		if len(values) < 6 {
+			return
+		}
+		fields := logrus.Fields{"sql": values[3], "rows": values[5]}
+		if d, ok := values[2].(time.Duration); ok {
+			fields["duration_ms"] = float64(d) / float64(time.Millisecond)
+		}
+		entry.WithFields(fields).Debug("gorm query")
+	case "error":
+		entry.Error(values[2:]...)
+	default:
+		entry.Debug(values[2:]...)
+	}
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"context"
+	"os"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	runtime "github.com/banzaicloud/logrus-runtime-formatter"
+	"github.com/sirupsen/logrus"
+)
+
+type contextKey struct{}
+
+var base = logrus.StandardLogger()
+
+// New : Logger shared by the whole application, secrets are redacted from every entry
+func New(logLevel string) *logrus.Logger {
+	var level logrus.Level
+	switch logLevel {
+	case "trace":
+		level = logrus.TraceLevel
+	case "info":
+		level = logrus.InfoLevel
+	case "warn":
+		level = logrus.WarnLevel
+	case "error":
+		level = logrus.ErrorLevel
+	case "fatal":
+		level = logrus.FatalLevel
+	case "panic":
+		level = logrus.PanicLevel
+	default:
+		level = logrus.DebugLevel
+	}
+	log := &logrus.Logger{
+		Out: os.Stderr,
+		Formatter: &runtime.Formatter{
+			ChildFormatter: &logrus.JSONFormatter{},
+			Package:        true,
+			Line:           true,
+			File:           true,
+		},
+		Hooks:    make(logrus.LevelHooks),
+		Level:    level,
+		ExitFunc: os.Exit,
+	}
+	log.AddHook(RedactHook{})
+	return log
+}
+
+// SetDefault : Logger used when a context doesn't carry one, and by the logrus package level functions
+func SetDefault(log *logrus.Logger) {
+	base = log
+	std := logrus.StandardLogger()
+	std.SetOutput(log.Out)
+	std.SetFormatter(log.Formatter)
+	std.SetLevel(log.Level)
+	std.ReplaceHooks(log.Hooks)
+}
+
+// Default : Logger set with SetDefault
+func Default() *logrus.Logger {
+	return base
+}
+
+// WithLogger : Returns a copy of ctx carrying the given entry
+func WithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, entry)
+}
+
+// FromContext : Request scoped logger carried by ctx, falls back to the default logger tagged with the request ID if any
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(contextKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	entry := logrus.NewEntry(base)
+	if id := requestctx.RequestID(ctx); id != "" {
+		entry = entry.WithField("request_id", id)
+	}
+	return entry
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Middleware : Access log replacing gin's default logger. It also stores a logger tagged with the request ID
+// and the route in the request's context, see FromContext. Must run after requestctx.Middleware.
+func Middleware(log *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		entry := log.WithFields(logrus.Fields{
+			"request_id": requestctx.RequestID(c.Request.Context()),
+			"method":     c.Request.Method,
+			"route":      c.FullPath(),
+		})
+		c.Request = c.Request.WithContext(WithLogger(c.Request.Context(), entry))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		fields := logrus.Fields{
+			"path":       RedactURL(c.Request.URL),
+			"status":     status,
+			"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			"client_ip":  requestctx.ClientIP(c.Request),
+			"user_agent": c.Request.UserAgent(),
+			"bytes":      c.Writer.Size(),
+		}
+		if uid, ok := requestctx.UserID(c.Request.Context()); ok {
+			fields["user_id"] = uid
+		}
+		if len(c.Errors) > 0 {
+			fields["errors"] = c.Errors.String()
+		}
+		access := entry.WithFields(fields)
+		switch {
+		case status >= http.StatusInternalServerError:
+			access.Error("Request handled")
+		case status >= http.StatusBadRequest:
+			access.Warn("Request handled")
+		default:
+			access.Info("Request handled")
+		}
+	}
+}
+
+// Recovery : gin's panic recovery, writing the stack traces through the logger
+func Recovery(log *logrus.Logger) gin.HandlerFunc {
+	return gin.RecoveryWithWriter(log.WriterLevel(logrus.ErrorLevel))
+}
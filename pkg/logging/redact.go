@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys : Fields (and query parameters) whose values never reach the logs
+var sensitiveKeys = []string{"password", "token", "secret", "authorization", "cookie"}
+
+// bearerPattern : Credentials embedded in free text, e.g. an error message quoting a header
+var bearerPattern = regexp.MustCompile(`(?i)(bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`)
+
+// RedactHook : logrus hook masking secrets in the fields and the message of every entry
+type RedactHook struct{}
+
+// Levels : All of them
+func (RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire : Masks the entry in place, before it gets formatted
+func (RedactHook) Fire(entry *logrus.Entry) error {
+	for k, v := range entry.Data {
+		if IsSensitive(k) {
+			entry.Data[k] = redacted
+			continue
+		}
+		if s, ok := v.(string); ok {
+			entry.Data[k] = RedactString(s)
+		}
+	}
+	entry.Message = RedactString(entry.Message)
+	return nil
+}
+
+// IsSensitive : Whether values stored under key must be masked
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactString : Masks bearer / basic credentials found in s
+func RedactString(s string) string {
+	return bearerPattern.ReplaceAllString(s, "$1 "+redacted)
+}
+
+// RedactURL : Request URI with the values of the sensitive query parameters (e.g. ?token=) masked
+func RedactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	query := u.Query()
+	for k := range query {
+		if IsSensitive(k) {
+			query[k] = []string{redacted}
+		}
+	}
+	return u.Path + "?" + query.Encode()
+}
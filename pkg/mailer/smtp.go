@@ -0,0 +1,102 @@
+// Package mailer sends the emails of the service, e.g. the invitations to join an organization, through an SMTP
+// server.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/pkg/errors"
+)
+
+// SMTPSender : Sends plain text emails through an SMTP server, over STARTTLS whenever the server offers it
+type SMTPSender struct {
+	cfg config.SMTPConfig
+}
+
+// NewSMTPSender : Sender through the server of cfg, connected on every Send
+func NewSMTPSender(cfg config.SMTPConfig) *SMTPSender {
+	return &SMTPSender{cfg}
+}
+
+// Send : Sends the email to the address to, within the configured timeout
+func (s *SMTPSender) Send(ctx context.Context, to, subject, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	if err := s.send(ctx, to, message(s.cfg.From, to, subject, body)); err != nil {
+		return errors.Wrap(err, "pkg.mailer.SMTPSender.Send")
+	}
+	return nil
+}
+
+func (s *SMTPSender) send(ctx context.Context, to string, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.cfg.Host, s.cfg.Port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// net/smtp isn't context aware, the deadline bounds the whole conversation instead
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection, but to localhost
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message : RFC 5322 message, the subject and body encoded so that they can hold any UTF-8 text
+func message(from, to, subject, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(&buf)
+	_, _ = w.Write([]byte(body))
+	_ = w.Close()
+	return buf.Bytes()
+}
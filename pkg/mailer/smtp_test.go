@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	body := "Bienvenue chez Acme, l'invitation expire bientôt.\n" + strings.Repeat("a long line ", 10) + "\n"
+	msg, err := mail.ReadMessage(strings.NewReader(string(message("tnbt@example.com", "bob@example.com", "Invitation à Acme", body))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec mime.WordDecoder
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Invitation à Acme" {
+		t.Errorf("subject = %q (%v), want the UTF-8 subject", subject, err)
+	}
+	if from, to := msg.Header.Get("From"), msg.Header.Get("To"); from != "tnbt@example.com" || to != "bob@example.com" {
+		t.Errorf("from %s to %s, want from tnbt@example.com to bob@example.com", from, to)
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("date : %v", err)
+	}
+	decoded, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Replace(string(decoded), "\r\n", "\n", -1); got != body {
+		t.Errorf("body = %q, want %q", got, body)
+	}
+}
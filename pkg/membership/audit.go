@@ -0,0 +1,108 @@
+package membership
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+)
+
+type auditedService struct {
+	Service
+	recorder audit.Recorder
+}
+
+// NewAuditedService : Wraps the Service so every change of a membership or an invitation is recorded in the audit
+// log whether it succeeds or not, with the organization as target. The reads, and the declined invitations which
+// change no membership, go straight to next.
+func NewAuditedService(next Service, recorder audit.Recorder) Service {
+	return &auditedService{next, recorder}
+}
+
+// record : Appends the record of action in organization orgID. The changes are the ones attempted when err isn't
+// nil, the record is then failed.
+func (a *auditedService) record(ctx context.Context, action string, orgID uint64, email string, changes audit.Changes, err error) {
+	r := audit.Record{Action: action, TargetType: audit.TargetOrganization, TargetID: orgID, TargetName: email, Changes: changes}
+	if err != nil {
+		r.Outcome, r.Reason = audit.OutcomeFailure, audit.Reason(err)
+	}
+	a.recorder.Record(ctx, r)
+}
+
+// roleKey, invitationKey : Fields of the changes, e.g. members.42.role or invitations.7.status
+func roleKey(uid uint64) string {
+	return "members." + strconv.FormatUint(uid, 10) + ".role"
+}
+
+func invitationKey(id uint64, field string) string {
+	return "invitations." + strconv.FormatUint(id, 10) + "." + field
+}
+
+// role : Role of member uid ahead of a change, nil when it can't be read (the change is then likely to fail too)
+func (a *auditedService) role(ctx context.Context, orgID, uid uint64) interface{} {
+	m, err := a.Service.GetMembership(ctx, orgID, uid)
+	if err != nil {
+		return nil
+	}
+	return m.Role
+}
+
+func (a *auditedService) ChangeRole(ctx context.Context, orgID, actorID, uid uint64, role string) (*Membership, error) {
+	before := a.role(ctx, orgID, uid)
+	updated, err := a.Service.ChangeRole(ctx, orgID, actorID, uid, role)
+	// Unless it succeeded without changing anything
+	if err != nil || before != updated.Role {
+		a.record(ctx, audit.ActionMemberChangeRole, orgID, "", audit.Changes{roleKey(uid): {Before: before, After: role}}, err)
+	}
+	return updated, err
+}
+
+func (a *auditedService) RemoveMember(ctx context.Context, orgID, actorID, uid uint64) error {
+	before := a.role(ctx, orgID, uid)
+	err := a.Service.RemoveMember(ctx, orgID, actorID, uid)
+	a.record(ctx, audit.ActionMemberRemove, orgID, "", audit.Changes{roleKey(uid): {Before: before}}, err)
+	return err
+}
+
+func (a *auditedService) TransferOwnership(ctx context.Context, orgID, actorID, to uint64) error {
+	before := a.role(ctx, orgID, to)
+	err := a.Service.TransferOwnership(ctx, orgID, actorID, to)
+	a.record(ctx, audit.ActionMemberTransferOwnership, orgID, "", audit.Changes{
+		roleKey(actorID): {Before: RoleOwner, After: RoleAdmin},
+		roleKey(to):      {Before: before, After: RoleOwner},
+	}, err)
+	return err
+}
+
+func (a *auditedService) Invite(ctx context.Context, orgID, actorID uint64, p InvitePayload) (*Invitation, string, error) {
+	inv, token, err := a.Service.Invite(ctx, orgID, actorID, p)
+	if err != nil {
+		a.record(ctx, audit.ActionMemberInvite, orgID, strings.ToLower(strings.TrimSpace(p.Email)), nil, err)
+		return nil, "", err
+	}
+	a.record(ctx, audit.ActionMemberInvite, orgID, inv.Email, audit.Changes{
+		invitationKey(inv.ID, "role"):   {After: inv.Role},
+		invitationKey(inv.ID, "status"): {After: inv.Status},
+	}, nil)
+	return inv, token, nil
+}
+
+func (a *auditedService) RevokeInvitation(ctx context.Context, orgID, actorID, id uint64) error {
+	err := a.Service.RevokeInvitation(ctx, orgID, actorID, id)
+	a.record(ctx, audit.ActionMemberRevokeInvitation, orgID, "", audit.Changes{
+		invitationKey(id, "status"): {Before: StatusPending, After: StatusRevoked},
+	}, err)
+	return err
+}
+
+func (a *auditedService) AcceptInvitation(ctx context.Context, uid uint64, token string) (*Membership, error) {
+	m, err := a.Service.AcceptInvitation(ctx, uid, token)
+	if err != nil {
+		// The organization isn't known, the token can't be told
+		a.record(ctx, audit.ActionMemberAcceptInvitation, 0, "", nil, err)
+		return nil, err
+	}
+	a.record(ctx, audit.ActionMemberAcceptInvitation, m.OrgID, "", audit.Changes{roleKey(uid): {After: m.Role}}, nil)
+	return m, nil
+}
@@ -0,0 +1,48 @@
+package membership
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+)
+
+// recordingRecorder : audit.Recorder keeping the records
+type recordingRecorder struct {
+	records []audit.Record
+}
+
+func (r *recordingRecorder) Record(_ context.Context, record audit.Record) {
+	r.records = append(r.records, record)
+}
+
+func TestAuditedInvite(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		orgID uint64
+		want  audit.Record
+	}{
+		{"invited", 1, audit.Record{
+			Action: audit.ActionMemberInvite, TargetType: audit.TargetOrganization, TargetID: 1, TargetName: "bob@example.com",
+			Changes: audit.Changes{
+				"invitations.1.role":   {After: RoleMember},
+				"invitations.1.status": {After: StatusPending},
+			},
+		}},
+		{"not a member", 2, audit.Record{
+			Action: audit.ActionMemberInvite, TargetType: audit.TargetOrganization, TargetID: 2, TargetName: "bob@example.com",
+			Outcome: audit.OutcomeFailure, Reason: ErrNotMember.Message,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := &recordingRecorder{}
+			s := NewAuditedService(NewService(&invitationRepository{}, nil, time.Hour, nil), recorder)
+			_, _, _ = s.Invite(context.Background(), tc.orgID, 1, InvitePayload{Email: " Bob@Example.com"})
+			if len(recorder.records) != 1 || !reflect.DeepEqual(recorder.records[0], tc.want) {
+				t.Errorf("records = %+v, want %+v", recorder.records, tc.want)
+			}
+		})
+	}
+}
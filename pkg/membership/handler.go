@@ -0,0 +1,518 @@
+package membership
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/LuD1161/restructuring-tnbt/pkg/org"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Handler : Memberships and invitations. The routes under /orgs/:id are expected behind RequireRole, the others
+// behind the authentication and ActiveOrganization. Errors are rendered by problem.Middleware
+type Handler interface {
+	ListMemberships(c *gin.Context)
+	ActiveMembership(c *gin.Context)
+	IssueToken(c *gin.Context)
+	ListMembers(c *gin.Context)
+	ChangeRole(c *gin.Context)
+	RemoveMember(c *gin.Context)
+	TransferOwnership(c *gin.Context)
+	Invite(c *gin.Context)
+	ListInvitations(c *gin.Context)
+	RevokeInvitation(c *gin.Context)
+	ListOwnInvitations(c *gin.Context)
+	AcceptInvitation(c *gin.Context)
+	DeclineInvitation(c *gin.Context)
+}
+
+var (
+	errMalformedBody       = apperrors.Validation("Malformed JSON body")
+	errInvalidUserID       = apperrors.Validation("Invalid user ID", apperrors.FieldError{Field: "user_id", Message: "must be a positive integer"})
+	errInvalidInvitationID = apperrors.Validation("Invalid invitation ID", apperrors.FieldError{Field: "invitation_id", Message: "must be a positive integer"})
+	errNoActiveOrg         = apperrors.NotFound("No organization selected")
+)
+
+// ChangeRolePayload : New role of a member, admin or member
+type ChangeRolePayload struct {
+	Role string `json:"role"`
+}
+
+// TransferPayload : Member to make the owner
+type TransferPayload struct {
+	UserID uint64 `json:"user_id"`
+}
+
+// InvitationTokenPayload : Token an invitation was sent with
+type InvitationTokenPayload struct {
+	Token string `json:"token"`
+}
+
+// InvitationCreated : The invitation and, unless it was emailed, its token to send to the invitee. The token isn't
+// shown again.
+type InvitationCreated struct {
+	Invitation
+	Token string `json:"token,omitempty"`
+}
+
+type handler struct {
+	service Service
+	orgs    org.Service
+}
+
+// NewHandler : Handler of the memberships of service, in the organizations of orgs
+func NewHandler(service Service, orgs org.Service) Handler {
+	return &handler{service: service, orgs: orgs}
+}
+
+func decode(c *gin.Context, v interface{}) error {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return errMalformedBody.Wrap(err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return errMalformedBody.Wrap(err)
+	}
+	return nil
+}
+
+// parseParam : Positive integer path parameter, invalid otherwise
+func parseParam(c *gin.Context, name string, invalid error) (uint64, error) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || id == 0 {
+		return 0, invalid
+	}
+	return id, nil
+}
+
+// actor : Organization of the :id path parameter and the authenticated user acting in it
+func actor(c *gin.Context) (uint64, uint64, error) {
+	orgID, err := org.ParseID(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, err := auth.UserID(c)
+	if err != nil {
+		return 0, 0, auth.ErrUnauthenticated.Wrap(err)
+	}
+	return orgID, uid, nil
+}
+
+// ListMemberships godoc
+// @Summary List my organizations
+// @Description Memberships of the authenticated user along with their organization, oldest first
+// @Tags Membership
+// @Produce  json
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 200 {array} Membership
+// @Failure 401 {object} problem.Problem
+// @Router /memberships [get]
+// ListMemberships : Organizations of the authenticated user
+func (h *handler) ListMemberships(c *gin.Context) {
+	uid, err := auth.UserID(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(auth.ErrUnauthenticated.Wrap(err), "pkg.membership.handler.ListMemberships"))
+		return
+	}
+	memberships, err := h.service.ListMemberships(c.Request.Context(), uid)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ListMemberships"))
+		return
+	}
+	c.JSON(http.StatusOK, memberships)
+}
+
+// ActiveMembership godoc
+// @Summary Get the active organization
+// @Description Membership of the authenticated user in the organization selected by the X-Org-ID header, else by the token
+// @Tags Membership
+// @Produce  json
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param X-Org-ID header int false "Organization to act in, overrides the one of the token"
+// @Success 200 {object} Membership
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /memberships/active [get]
+// ActiveMembership : Organization the request acts in
+func (h *handler) ActiveMembership(c *gin.Context) {
+	active, ok := requestctx.OrgOf(c.Request.Context())
+	if !ok {
+		problem.Abort(c, errors.Wrap(errNoActiveOrg, "pkg.membership.handler.ActiveMembership"))
+		return
+	}
+	uid, err := auth.UserID(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(auth.ErrUnauthenticated.Wrap(err), "pkg.membership.handler.ActiveMembership"))
+		return
+	}
+	m, err := h.service.GetMembership(c.Request.Context(), active.ID, uid)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ActiveMembership"))
+		return
+	}
+	if m.Organization, err = h.orgs.GetOrganization(c.Request.Context(), active.ID); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ActiveMembership"))
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// IssueToken godoc
+// @Summary Switch to an organization
+// @Description Issues a token whose requests act in the organization, without the X-Org-ID header. Members only.
+// @Tags Membership
+// @Produce  json
+// @Param id path int true "Organization ID"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 200 {string} string "JWToken here"
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /orgs/{id}/token [post]
+// IssueToken : Token of the authenticated user acting in the organization
+func (h *handler) IssueToken(c *gin.Context) {
+	orgID, uid, err := actor(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.IssueToken"))
+		return
+	}
+	token, err := auth.CreateOrganizationToken(c.Request.Context(), uid, orgID)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.IssueToken"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token": token,
+	})
+}
+
+// ListMembers godoc
+// @Summary List the members
+// @Description Members of the organization with their role, oldest first. Members only.
+// @Tags Membership
+// @Produce  json
+// @Param id path int true "Organization ID"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 200 {array} Membership
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /orgs/{id}/members [get]
+// ListMembers : Members of the organization
+func (h *handler) ListMembers(c *gin.Context) {
+	orgID, err := org.ParseID(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ListMembers"))
+		return
+	}
+	members, err := h.service.ListMembers(c.Request.Context(), orgID)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ListMembers"))
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// ChangeRole godoc
+// @Summary Change the role of a member
+// @Description Makes a member an admin or a member, by someone ranked above them. The ownership is transferred instead.
+// @Tags Membership
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Organization ID"
+// @Param user_id path int true "User ID of the member"
+// @Param json body ChangeRolePayload true "role"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 200 {object} Membership
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /orgs/{id}/members/{user_id} [patch]
+// ChangeRole : Changes the role of a member
+func (h *handler) ChangeRole(c *gin.Context) {
+	orgID, actorID, err := actor(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ChangeRole"))
+		return
+	}
+	uid, err := parseParam(c, "user_id", errInvalidUserID)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ChangeRole"))
+		return
+	}
+	var p ChangeRolePayload
+	if err := decode(c, &p); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ChangeRole"))
+		return
+	}
+	m, err := h.service.ChangeRole(c.Request.Context(), orgID, actorID, uid, p.Role)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ChangeRole"))
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// RemoveMember godoc
+// @Summary Remove a member
+// @Description Removes a member, by an admin or the owner ranked above them. Members leave by removing themselves, but the owner.
+// @Tags Membership
+// @Param id path int true "Organization ID"
+// @Param user_id path int true "User ID of the member"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /orgs/{id}/members/{user_id} [delete]
+// RemoveMember : Removes a member from the organization
+func (h *handler) RemoveMember(c *gin.Context) {
+	orgID, actorID, err := actor(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.RemoveMember"))
+		return
+	}
+	uid, err := parseParam(c, "user_id", errInvalidUserID)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.RemoveMember"))
+		return
+	}
+	if err := h.service.RemoveMember(c.Request.Context(), orgID, actorID, uid); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.RemoveMember"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// TransferOwnership godoc
+// @Summary Transfer the ownership
+// @Description Makes another member the owner, the current owner becomes an admin. The owner only.
+// @Tags Membership
+// @Accept  json
+// @Param id path int true "Organization ID"
+// @Param json body TransferPayload true "user_id of the new owner"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param Idempotency-Key header string false "Unique key of the request, retries with the same key get the first response replayed"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /orgs/{id}/transfer [post]
+// TransferOwnership : Hands the organization over to another member
+func (h *handler) TransferOwnership(c *gin.Context) {
+	orgID, actorID, err := actor(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.TransferOwnership"))
+		return
+	}
+	var p TransferPayload
+	if err := decode(c, &p); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.TransferOwnership"))
+		return
+	}
+	if p.UserID == 0 {
+		problem.Abort(c, errors.Wrap(errInvalidUserID, "pkg.membership.handler.TransferOwnership"))
+		return
+	}
+	if err := h.service.TransferOwnership(c.Request.Context(), orgID, actorID, p.UserID); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.TransferOwnership"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Invite godoc
+// @Summary Invite to the organization
+// @Description Invites an email to join the organization, by an admin or the owner. The token answering the invitation is emailed to the invitee when SMTP is configured, else it is only returned here, to be sent to the invitee.
+// @Tags Membership
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Organization ID"
+// @Param json body InvitePayload true "email and role, member by default"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param Idempotency-Key header string false "Unique key of the request, retries with the same key get the first response replayed"
+// @Success 201 {object} InvitationCreated
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /orgs/{id}/invitations [post]
+// Invite : Invites an email to the organization
+func (h *handler) Invite(c *gin.Context) {
+	orgID, actorID, err := actor(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.Invite"))
+		return
+	}
+	var p InvitePayload
+	if err := decode(c, &p); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.Invite"))
+		return
+	}
+	inv, token, err := h.service.Invite(c.Request.Context(), orgID, actorID, p)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.Invite"))
+		return
+	}
+	c.JSON(http.StatusCreated, InvitationCreated{Invitation: *inv, Token: token})
+}
+
+// ListInvitations godoc
+// @Summary List the invitations
+// @Description Pending invitations of the organization, latest first, the expired ones flagged as such. Admins and the owner only.
+// @Tags Membership
+// @Produce  json
+// @Param id path int true "Organization ID"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 200 {array} Invitation
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /orgs/{id}/invitations [get]
+// ListInvitations : Pending invitations of the organization
+func (h *handler) ListInvitations(c *gin.Context) {
+	orgID, err := org.ParseID(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ListInvitations"))
+		return
+	}
+	invitations, err := h.service.ListInvitations(c.Request.Context(), orgID)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ListInvitations"))
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+// RevokeInvitation godoc
+// @Summary Revoke an invitation
+// @Description Withdraws a pending invitation, by an admin or the owner
+// @Tags Membership
+// @Param id path int true "Organization ID"
+// @Param invitation_id path int true "Invitation ID"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /orgs/{id}/invitations/{invitation_id} [delete]
+// RevokeInvitation : Withdraws an invitation
+func (h *handler) RevokeInvitation(c *gin.Context) {
+	orgID, actorID, err := actor(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.RevokeInvitation"))
+		return
+	}
+	id, err := parseParam(c, "invitation_id", errInvalidInvitationID)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.RevokeInvitation"))
+		return
+	}
+	if err := h.service.RevokeInvitation(c.Request.Context(), orgID, actorID, id); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.RevokeInvitation"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListOwnInvitations godoc
+// @Summary List my invitations
+// @Description Pending invitations sent to the email of the authenticated user, with their organization
+// @Tags Membership
+// @Produce  json
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 200 {array} Invitation
+// @Failure 401 {object} problem.Problem
+// @Router /invitations [get]
+// ListOwnInvitations : Invitations of the authenticated user
+func (h *handler) ListOwnInvitations(c *gin.Context) {
+	uid, err := auth.UserID(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(auth.ErrUnauthenticated.Wrap(err), "pkg.membership.handler.ListOwnInvitations"))
+		return
+	}
+	invitations, err := h.service.ListInvitationsFor(c.Request.Context(), uid)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.ListOwnInvitations"))
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+// AcceptInvitation godoc
+// @Summary Accept an invitation
+// @Description Joins the organization the token invites the authenticated user to, the invitation must have been sent to their email
+// @Tags Membership
+// @Accept  json
+// @Produce  json
+// @Param json body InvitationTokenPayload true "token of the invitation"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param Idempotency-Key header string false "Unique key of the request, retries with the same key get the first response replayed"
+// @Success 200 {object} Membership
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Router /invitations/accept [post]
+// AcceptInvitation : Joins an organization
+func (h *handler) AcceptInvitation(c *gin.Context) {
+	uid, token, err := invitee(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.AcceptInvitation"))
+		return
+	}
+	m, err := h.service.AcceptInvitation(c.Request.Context(), uid, token)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.AcceptInvitation"))
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// DeclineInvitation godoc
+// @Summary Decline an invitation
+// @Description Turns down the invitation of the token, which must have been sent to the email of the authenticated user
+// @Tags Membership
+// @Accept  json
+// @Param json body InvitationTokenPayload true "token of the invitation"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /invitations/decline [post]
+// DeclineInvitation : Turns down an invitation
+func (h *handler) DeclineInvitation(c *gin.Context) {
+	uid, token, err := invitee(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.DeclineInvitation"))
+		return
+	}
+	if err := h.service.DeclineInvitation(c.Request.Context(), uid, token); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.membership.handler.DeclineInvitation"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// invitee : Authenticated user answering an invitation, and the token of the body
+func invitee(c *gin.Context) (uint64, string, error) {
+	uid, err := auth.UserID(c)
+	if err != nil {
+		return 0, "", auth.ErrUnauthenticated.Wrap(err)
+	}
+	var p InvitationTokenPayload
+	if err := decode(c, &p); err != nil {
+		return 0, "", err
+	}
+	return uid, p.Token, nil
+}
@@ -0,0 +1,54 @@
+package membership
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/org"
+	"github.com/pkg/errors"
+)
+
+// Mailer : Delivers the invitations to the invitees, along with the token answering them
+type Mailer interface {
+	SendInvitation(ctx context.Context, inv *Invitation, token string) error
+}
+
+// Sender : Sends an email, e.g. a mailer.SMTPSender
+type Sender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// Organizations : Lookup of the organizations the invitations are to, e.g. an org.Service
+type Organizations interface {
+	GetOrganization(context.Context, uint64) (*org.Organization, error)
+}
+
+type emailMailer struct {
+	sender Sender
+	orgs   Organizations
+}
+
+// NewEmailMailer : Mailer emailing the invitations through sender
+func NewEmailMailer(sender Sender, orgs Organizations) Mailer {
+	return &emailMailer{sender: sender, orgs: orgs}
+}
+
+func (m *emailMailer) SendInvitation(ctx context.Context, inv *Invitation, token string) error {
+	o, err := m.orgs.GetOrganization(ctx, inv.OrgID)
+	if err != nil {
+		return errors.Wrap(err, "pkg.membership.emailMailer.SendInvitation")
+	}
+	subject := fmt.Sprintf("Invitation to join %s", o.Name)
+	body := fmt.Sprintf(`You're invited to join %s as %s.
+
+Log in as %s and accept the invitation with POST /v1/invitations/accept, or decline it with
+POST /v1/invitations/decline, sending {"token": "%s"}.
+
+The invitation expires on %s.
+`, o.Name, inv.Role, inv.Email, token, inv.ExpiresAt.UTC().Format(time.RFC1123))
+	if err := m.sender.Send(ctx, inv.Email, subject, body); err != nil {
+		return errors.Wrap(err, "pkg.membership.emailMailer.SendInvitation")
+	}
+	return nil
+}
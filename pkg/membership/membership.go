@@ -0,0 +1,115 @@
+// Package membership manages who belongs to which organization of pkg/org, with which role, the invitations to join
+// them, and the organization a request acts in.
+package membership
+
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/org"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+)
+
+// Roles, from the most to the least privileged
+const (
+	RoleOwner  = "owner"  // Exactly one per organization, transfers the ownership and deletes the organization
+	RoleAdmin  = "admin"  // Manages the organization, invites and removes the members
+	RoleMember = "member" // Acts in the organization
+)
+
+// rank : Privilege of the roles, a role includes the ones ranked below it
+var rank = map[string]int{RoleOwner: 3, RoleAdmin: 2, RoleMember: 1}
+
+// Includes : Whether role grants at least the privileges of other
+func Includes(role, other string) bool {
+	return rank[role] >= rank[other] && rank[other] > 0
+}
+
+// Statuses of an invitation
+const (
+	StatusPending  = "pending"
+	StatusAccepted = "accepted"
+	StatusDeclined = "declined"
+	StatusRevoked  = "revoked"
+	StatusExpired  = "expired" // Pending past its expiry
+)
+
+var (
+	// ErrNotMember : Returned by the Repository when the user isn't a member of the organization
+	ErrNotMember = apperrors.NotFound("Membership Not Found")
+	// ErrAlreadyMember : The user is already a member of the organization
+	ErrAlreadyMember = apperrors.Conflict("Already a member of the organization")
+	// ErrInvitationNotFound : Returned by the Repository when no invitation matches the lookup, or it was answered
+	// or revoked meanwhile
+	ErrInvitationNotFound = apperrors.NotFound("Invitation Not Found")
+	// ErrAlreadyInvited : The email has a pending invitation to the organization
+	ErrAlreadyInvited = apperrors.Conflict("Already invited", apperrors.FieldError{Field: "email", Message: "has a pending invitation"})
+	// ErrInsufficientRole : The caller's role in the organization doesn't allow the action
+	ErrInsufficientRole = apperrors.Forbidden("Your role in the organization doesn't allow this")
+)
+
+// Membership : Role of a user in an organization
+type Membership struct {
+	OrgID     uint64    `json:"org_id"`
+	UserID    uint64    `json:"user_id"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"` // When the user joined
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Filled by the listings
+	User         *user.UserInfoPayload `json:"user,omitempty"`
+	Organization *org.Organization     `json:"organization,omitempty"`
+}
+
+// Invitation : Invitation of an email to join an organization with a role. It's answered with its token, which
+// only the invitee is sent.
+type Invitation struct {
+	ID          uint64     `json:"id"`
+	OrgID       uint64     `json:"org_id"`
+	Email       string     `json:"email"` // Lowercase
+	Role        string     `json:"role"`
+	InvitedBy   uint64     `json:"invited_by"`
+	Status      string     `json:"status"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"` // When it was accepted, declined or revoked
+	CreatedAt   time.Time  `json:"created_at"`
+	TokenHash   string     `json:"-"` // SHA-256 of the token, which isn't stored
+
+	Organization *org.Organization `json:"organization,omitempty"` // Filled for the invitee
+}
+
+// Repository : Storage of the memberships and the invitations. The organizations are created along with their
+// owner's membership by org.Repository.
+type Repository interface {
+	GetMembership(ctx context.Context, orgID, uid uint64) (*Membership, error) // ErrNotMember
+	ListMembers(ctx context.Context, orgID uint64) ([]Membership, error)       // Oldest first
+	ListMemberships(ctx context.Context, uid uint64) ([]Membership, error)     // With their Organization, oldest first
+	UpdateRole(ctx context.Context, orgID, uid uint64, role string) (*Membership, error)
+	RemoveMember(ctx context.Context, orgID, uid uint64) error
+	// TransferOwnership : Makes to, a member, the owner and from, the owner, an admin. ErrNotMember unless both hold
+	// these roles.
+	TransferOwnership(ctx context.Context, orgID, from, to uint64) error
+	// RemoveUsers : Drops the users from every organization, a user.PurgeHook. The organizations they owned pass to
+	// their longest standing admin, else member, and are deleted when no member remains.
+	RemoveUsers(ctx context.Context, uids []uint64) error
+
+	// CreateInvitation : ErrAlreadyInvited when the email has a pending invitation to the organization, the expired
+	// ones are marked as such first
+	CreateInvitation(context.Context, *Invitation) (*Invitation, error)
+	GetInvitationByTokenHash(context.Context, string) (*Invitation, error)
+	ListInvitations(ctx context.Context, orgID uint64) ([]Invitation, error)    // Pending, latest first
+	ListInvitationsFor(ctx context.Context, email string) ([]Invitation, error) // Pending and unexpired, with their Organization
+	// AcceptInvitation : Marks the pending invitation accepted and adds uid to the organization with its role.
+	// ErrInvitationNotFound when it's no longer pending or expired at now, ErrAlreadyMember.
+	AcceptInvitation(ctx context.Context, id, uid uint64, now time.Time) (*Membership, error)
+	// RespondInvitation : Marks the pending invitation declined or revoked, ErrInvitationNotFound when it's no longer
+	// pending. Revocations are limited to the organization orgID unless it's 0.
+	RespondInvitation(ctx context.Context, orgID, id uint64, status string, now time.Time) error
+}
+
+// Users : Lookup of the users the memberships are about, e.g. a user.Service
+type Users interface {
+	GetUserByID(context.Context, uint64) (*user.User, error)
+	GetUsersByIDs(context.Context, []uint64) ([]user.User, error)
+}
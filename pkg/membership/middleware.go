@@ -0,0 +1,97 @@
+package membership
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/LuD1161/restructuring-tnbt/pkg/org"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// OrgHeader : Header selecting the organization a request acts in, it takes precedence over the orgID claim of the
+// token issued by auth.CreateOrganizationToken
+const OrgHeader = "X-Org-ID"
+
+var (
+	errInvalidOrgHeader = apperrors.Validation("Invalid organization", apperrors.FieldError{Field: OrgHeader, Message: "must be a positive integer"})
+	errNotMemberOfOrg   = apperrors.Forbidden("Not a member of the selected organization")
+)
+
+// RequireRole : Requires the authenticated user to hold at least role in the organization of the :id path parameter,
+// which the request then acts in. Organizations the user isn't a member of are reported as not found.
+func RequireRole(svc Service, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, err := org.ParseID(c)
+		if err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.membership.middleware.RequireRole"))
+			return
+		}
+		uid, err := auth.UserID(c)
+		if err != nil {
+			problem.Abort(c, errors.Wrap(auth.ErrUnauthenticated.Wrap(err), "pkg.membership.middleware.RequireRole"))
+			return
+		}
+		m, err := svc.GetMembership(c.Request.Context(), orgID, uid)
+		if errors.Is(err, ErrNotMember) {
+			err = org.ErrOrganizationNotFound
+		}
+		if err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.membership.middleware.RequireRole"))
+			return
+		}
+		if !Includes(m.Role, role) {
+			problem.Abort(c, errors.Wrap(ErrInsufficientRole, "pkg.membership.middleware.RequireRole"))
+			return
+		}
+		c.Request = c.Request.WithContext(withOrg(c.Request.Context(), m))
+		c.Next()
+	}
+}
+
+// ActiveOrganization : Selects the organization the request acts in from the OrgHeader header, else from the token's
+// claims, once checked that the authenticated user is still a member of it. Requests selecting none go through
+// without an organization, see requestctx.OrgOf.
+func ActiveOrganization(svc Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID := auth.TokenOrgID(c.Request)
+		if header := c.GetHeader(OrgHeader); header != "" {
+			id, err := strconv.ParseUint(header, 10, 64)
+			if err != nil || id == 0 {
+				problem.Abort(c, errors.Wrap(errInvalidOrgHeader, "pkg.membership.middleware.ActiveOrganization"))
+				return
+			}
+			orgID = id
+		}
+		if orgID == 0 {
+			c.Next()
+			return
+		}
+		uid, err := auth.UserID(c)
+		if err != nil {
+			problem.Abort(c, errors.Wrap(auth.ErrUnauthenticated.Wrap(err), "pkg.membership.middleware.ActiveOrganization"))
+			return
+		}
+		m, err := svc.GetMembership(c.Request.Context(), orgID, uid)
+		if errors.Is(err, ErrNotMember) {
+			err = errNotMemberOfOrg
+		}
+		if err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.membership.middleware.ActiveOrganization"))
+			return
+		}
+		c.Request = c.Request.WithContext(withOrg(c.Request.Context(), m))
+		c.Next()
+	}
+}
+
+// withOrg : ctx of a request acting in the organization of m, carrying it to the logs
+func withOrg(ctx context.Context, m *Membership) context.Context {
+	ctx = requestctx.WithOrg(ctx, requestctx.Org{ID: m.OrgID, Role: m.Role})
+	return logging.WithLogger(ctx, logging.FromContext(ctx).WithField("org_id", m.OrgID))
+}
@@ -0,0 +1,336 @@
+package membership
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+)
+
+var (
+	errOwnerRole = apperrors.Validation("Invalid role", apperrors.FieldError{
+		Field: "role", Message: "must be admin or member, the ownership is transferred instead",
+	})
+	errInvalidEmail      = apperrors.Validation("Invalid invitation", apperrors.FieldError{Field: "email", Message: "must be an email address"})
+	errOwnerCantLeave    = apperrors.Forbidden("The owner can't leave the organization, transfer the ownership first")
+	errTransferToSelf    = apperrors.Validation("Invalid transfer", apperrors.FieldError{Field: "user_id", Message: "must be another member"})
+	errInvitationExpired = apperrors.NotFound("The invitation expired")
+	errOtherInvitee      = apperrors.Forbidden("The invitation was sent to another email")
+)
+
+// InvitePayload : The role is member by default
+type InvitePayload struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// Service : Memberships and invitations. The actions on behalf of a member (actorID) check that its role allows them :
+// roles are changed and members removed by someone ranked above them, the owner only hands over the ownership.
+type Service interface {
+	GetMembership(ctx context.Context, orgID, uid uint64) (*Membership, error)
+	ListMembers(ctx context.Context, orgID uint64) ([]Membership, error) // With their User
+	ListMemberships(ctx context.Context, uid uint64) ([]Membership, error)
+	ChangeRole(ctx context.Context, orgID, actorID, uid uint64, role string) (*Membership, error)
+	RemoveMember(ctx context.Context, orgID, actorID, uid uint64) error // Members can leave by removing themselves
+	TransferOwnership(ctx context.Context, orgID, actorID, to uint64) error
+
+	// Invite : The invitation, and its token which isn't stored. The token is only returned when there's no Mailer
+	// to deliver it, the inviter then forwards it to the invitee.
+	Invite(ctx context.Context, orgID, actorID uint64, p InvitePayload) (*Invitation, string, error)
+	ListInvitations(ctx context.Context, orgID uint64) ([]Invitation, error)
+	RevokeInvitation(ctx context.Context, orgID, actorID, id uint64) error
+	ListInvitationsFor(ctx context.Context, uid uint64) ([]Invitation, error) // Those sent to the user's email
+	AcceptInvitation(ctx context.Context, uid uint64, token string) (*Membership, error)
+	DeclineInvitation(ctx context.Context, uid uint64, token string) error
+}
+
+type service struct {
+	repo          Repository
+	users         Users
+	invitationTTL time.Duration
+	mailer        Mailer
+}
+
+// NewService : Service of the memberships stored in repo, whose invitations expire after invitationTTL and are
+// delivered by mailer, nil to leave it to the inviters
+func NewService(repo Repository, users Users, invitationTTL time.Duration, mailer Mailer) Service {
+	return &service{repo: repo, users: users, invitationTTL: invitationTTL, mailer: mailer}
+}
+
+// GetMembership : Role of user uid in the organization, ErrNotMember
+func (s *service) GetMembership(ctx context.Context, orgID, uid uint64) (*Membership, error) {
+	return s.repo.GetMembership(ctx, orgID, uid)
+}
+
+// ListMembers : Members of the organization, oldest first
+func (s *service) ListMembers(ctx context.Context, orgID uint64) ([]Membership, error) {
+	members, err := s.repo.ListMembers(ctx, orgID)
+	if err != nil || len(members) == 0 {
+		return members, err
+	}
+	uids := make([]uint64, len(members))
+	for i, m := range members {
+		uids[i] = m.UserID
+	}
+	users, err := s.users.GetUsersByIDs(ctx, uids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint64]*user.UserInfoPayload, len(users))
+	for i := range users {
+		byID[users[i].ID] = &users[i].UserInfoPayload
+	}
+	for i := range members {
+		members[i].User = byID[members[i].UserID]
+	}
+	return members, nil
+}
+
+// ListMemberships : Organizations of user uid, oldest first
+func (s *service) ListMemberships(ctx context.Context, uid uint64) ([]Membership, error) {
+	return s.repo.ListMemberships(ctx, uid)
+}
+
+// ChangeRole : Makes member uid an admin or a member
+func (s *service) ChangeRole(ctx context.Context, orgID, actorID, uid uint64, role string) (*Membership, error) {
+	if role != RoleAdmin && role != RoleMember {
+		return nil, errOwnerRole
+	}
+	actor, target, err := s.actorAndTarget(ctx, orgID, actorID, uid)
+	if err != nil {
+		return nil, err
+	}
+	if rank[actor.Role] <= rank[target.Role] || !Includes(actor.Role, role) {
+		return nil, ErrInsufficientRole
+	}
+	if target.Role == role {
+		return target, nil
+	}
+	updated, err := s.repo.UpdateRole(ctx, orgID, uid, role)
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).WithFields(map[string]interface{}{"org_id": orgID, "member_id": uid, "role": role}).Info("Member role changed")
+	return updated, nil
+}
+
+// RemoveMember : Removes member uid from the organization
+func (s *service) RemoveMember(ctx context.Context, orgID, actorID, uid uint64) error {
+	actor, target, err := s.actorAndTarget(ctx, orgID, actorID, uid)
+	if err != nil {
+		return err
+	}
+	switch {
+	case uid == actorID && actor.Role == RoleOwner:
+		return errOwnerCantLeave
+	case uid != actorID && (rank[actor.Role] <= rank[target.Role] || !Includes(actor.Role, RoleAdmin)):
+		return ErrInsufficientRole
+	}
+	if err := s.repo.RemoveMember(ctx, orgID, uid); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).WithFields(map[string]interface{}{"org_id": orgID, "member_id": uid}).Info("Member removed")
+	return nil
+}
+
+// TransferOwnership : Makes member to the owner, the current owner becomes an admin
+func (s *service) TransferOwnership(ctx context.Context, orgID, actorID, to uint64) error {
+	if to == actorID {
+		return errTransferToSelf
+	}
+	actor, _, err := s.actorAndTarget(ctx, orgID, actorID, to)
+	if err != nil {
+		return err
+	}
+	if actor.Role != RoleOwner {
+		return ErrInsufficientRole
+	}
+	if err := s.repo.TransferOwnership(ctx, orgID, actorID, to); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).WithFields(map[string]interface{}{"org_id": orgID, "owner_id": to}).Info("Organization ownership transferred")
+	return nil
+}
+
+// actorAndTarget : Memberships of the acting user and of the one acted upon
+func (s *service) actorAndTarget(ctx context.Context, orgID, actorID, uid uint64) (*Membership, *Membership, error) {
+	actor, err := s.repo.GetMembership(ctx, orgID, actorID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uid == actorID {
+		return actor, actor, nil
+	}
+	target, err := s.repo.GetMembership(ctx, orgID, uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return actor, target, nil
+}
+
+// Invite : Invites the email to join the organization, by an admin or the owner
+func (s *service) Invite(ctx context.Context, orgID, actorID uint64, p InvitePayload) (*Invitation, string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(p.Email))
+	if err != nil || addr.Name != "" {
+		return nil, "", errInvalidEmail
+	}
+	role := p.Role
+	if role == "" {
+		role = RoleMember
+	}
+	if role != RoleAdmin && role != RoleMember {
+		return nil, "", errOwnerRole
+	}
+	actor, err := s.repo.GetMembership(ctx, orgID, actorID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !Includes(actor.Role, RoleAdmin) || !Includes(actor.Role, role) {
+		return nil, "", ErrInsufficientRole
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	inv, err := s.repo.CreateInvitation(ctx, &Invitation{
+		OrgID:     orgID,
+		Email:     strings.ToLower(addr.Address),
+		Role:      role,
+		InvitedBy: actorID,
+		Status:    StatusPending,
+		ExpiresAt: now.Add(s.invitationTTL),
+		CreatedAt: now,
+		TokenHash: hashToken(token),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	log := logging.FromContext(ctx).WithFields(map[string]interface{}{"org_id": orgID, "invitation_id": inv.ID})
+	if s.mailer == nil {
+		log.Info("Invitation created, the inviter forwards its token")
+		return inv, token, nil
+	}
+	if err := s.mailer.SendInvitation(ctx, inv, token); err != nil {
+		// Revoked, so that the email can be invited again
+		if rerr := s.repo.RespondInvitation(ctx, orgID, inv.ID, StatusRevoked, time.Now()); rerr != nil {
+			log.WithError(rerr).Error("Revoking an undelivered invitation failed")
+		}
+		return nil, "", err
+	}
+	log.Info("Invitation sent")
+	return inv, "", nil
+}
+
+// ListInvitations : Pending invitations of the organization, latest first
+func (s *service) ListInvitations(ctx context.Context, orgID uint64) ([]Invitation, error) {
+	invitations, err := s.repo.ListInvitations(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range invitations {
+		invitations[i].refresh(now)
+	}
+	return invitations, nil
+}
+
+// RevokeInvitation : Withdraws a pending invitation, by an admin or the owner
+func (s *service) RevokeInvitation(ctx context.Context, orgID, actorID, id uint64) error {
+	actor, err := s.repo.GetMembership(ctx, orgID, actorID)
+	if err != nil {
+		return err
+	}
+	if !Includes(actor.Role, RoleAdmin) {
+		return ErrInsufficientRole
+	}
+	return s.repo.RespondInvitation(ctx, orgID, id, StatusRevoked, time.Now())
+}
+
+// ListInvitationsFor : Pending invitations sent to the email of user uid
+func (s *service) ListInvitationsFor(ctx context.Context, uid uint64) ([]Invitation, error) {
+	u, err := s.users.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.ListInvitationsFor(ctx, strings.ToLower(u.Email))
+}
+
+// AcceptInvitation : Joins the organization the token invites user uid to
+func (s *service) AcceptInvitation(ctx context.Context, uid uint64, token string) (*Membership, error) {
+	inv, err := s.invitation(ctx, uid, token)
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.repo.AcceptInvitation(ctx, inv.ID, uid, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).WithFields(map[string]interface{}{"org_id": m.OrgID, "invitation_id": inv.ID}).Info("Invitation accepted")
+	return m, nil
+}
+
+// DeclineInvitation : Turns down the invitation of the token
+func (s *service) DeclineInvitation(ctx context.Context, uid uint64, token string) error {
+	inv, err := s.invitation(ctx, uid, token)
+	if err != nil {
+		return err
+	}
+	return s.repo.RespondInvitation(ctx, 0, inv.ID, StatusDeclined, time.Now())
+}
+
+// invitation : Pending invitation of the token, sent to the email of user uid
+func (s *service) invitation(ctx context.Context, uid uint64, token string) (*Invitation, error) {
+	if token == "" {
+		return nil, ErrInvitationNotFound
+	}
+	inv, err := s.repo.GetInvitationByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.users.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Email, inv.Email) {
+		return nil, errOtherInvitee
+	}
+	inv.refresh(time.Now())
+	switch inv.Status {
+	case StatusPending:
+		return inv, nil
+	case StatusExpired:
+		return nil, errInvitationExpired
+	default:
+		return nil, ErrInvitationNotFound
+	}
+}
+
+// refresh : Shows the pending invitation past its expiry as expired
+func (i *Invitation) refresh(now time.Time) {
+	if i.Status == StatusPending && !now.Before(i.ExpiresAt) {
+		i.Status = StatusExpired
+	}
+}
+
+// newToken : Random 256 bit token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken : What is stored of a token, enough to find its invitation and useless to answer it
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
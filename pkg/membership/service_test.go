@@ -0,0 +1,134 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/org"
+)
+
+// invitationRepository : Repository holding the invitations of organization 1, whose admin is user 1
+type invitationRepository struct {
+	Repository  // Not used by Invite
+	invitations []Invitation
+}
+
+func (r *invitationRepository) GetMembership(_ context.Context, orgID, uid uint64) (*Membership, error) {
+	if orgID != 1 || uid != 1 {
+		return nil, ErrNotMember
+	}
+	return &Membership{OrgID: 1, UserID: 1, Role: RoleAdmin}, nil
+}
+
+func (r *invitationRepository) CreateInvitation(_ context.Context, inv *Invitation) (*Invitation, error) {
+	inv.ID = uint64(len(r.invitations) + 1)
+	r.invitations = append(r.invitations, *inv)
+	return inv, nil
+}
+
+func (r *invitationRepository) RespondInvitation(_ context.Context, orgID, id uint64, status string, now time.Time) error {
+	r.invitations[id-1].Status = status
+	r.invitations[id-1].RespondedAt = &now
+	return nil
+}
+
+// recordingMailer : Mailer keeping the tokens it's given, failing with err
+type recordingMailer struct {
+	tokens []string
+	err    error
+}
+
+func (m *recordingMailer) SendInvitation(_ context.Context, _ *Invitation, token string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func TestInvite(t *testing.T) {
+	payload := InvitePayload{Email: "Bob@Example.com", Role: RoleMember}
+
+	t.Run("without mailer, the token goes to the inviter", func(t *testing.T) {
+		repo := &invitationRepository{}
+		inv, token, err := NewService(repo, nil, time.Hour, nil).Invite(context.Background(), 1, 1, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token == "" || hashToken(token) != repo.invitations[0].TokenHash {
+			t.Errorf("token %q doesn't answer the invitation", token)
+		}
+		if inv.Email != "bob@example.com" || inv.Status != StatusPending {
+			t.Errorf("invitation of %s %s, want a pending one of bob@example.com", inv.Status, inv.Email)
+		}
+	})
+
+	t.Run("with a mailer, the token only goes to the invitee", func(t *testing.T) {
+		repo := &invitationRepository{}
+		mailer := &recordingMailer{}
+		_, token, err := NewService(repo, nil, time.Hour, mailer).Invite(context.Background(), 1, 1, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			t.Errorf("token %q returned to the inviter", token)
+		}
+		if len(mailer.tokens) != 1 || hashToken(mailer.tokens[0]) != repo.invitations[0].TokenHash {
+			t.Errorf("mailer got %q, want the token answering the invitation", mailer.tokens)
+		}
+	})
+
+	t.Run("an undelivered invitation is revoked", func(t *testing.T) {
+		repo := &invitationRepository{}
+		mailer := &recordingMailer{err: errors.New("smtp down")}
+		if _, _, err := NewService(repo, nil, time.Hour, mailer).Invite(context.Background(), 1, 1, payload); err != mailer.err {
+			t.Fatalf("Invite() = %v, want the mailer's error", err)
+		}
+		if repo.invitations[0].Status != StatusRevoked {
+			t.Errorf("undelivered invitation %s, want revoked", repo.invitations[0].Status)
+		}
+	})
+}
+
+type recordingSender struct {
+	to, subject, body string
+}
+
+func (s *recordingSender) Send(_ context.Context, to, subject, body string) error {
+	s.to, s.subject, s.body = to, subject, body
+	return nil
+}
+
+type organizations map[uint64]*org.Organization
+
+func (o organizations) GetOrganization(_ context.Context, id uint64) (*org.Organization, error) {
+	if found, ok := o[id]; ok {
+		return found, nil
+	}
+	return nil, org.ErrOrganizationNotFound
+}
+
+func TestEmailMailer(t *testing.T) {
+	sender := &recordingSender{}
+	m := NewEmailMailer(sender, organizations{1: {ID: 1, Name: "Acme"}})
+	inv := &Invitation{OrgID: 1, Email: "bob@example.com", Role: RoleAdmin, ExpiresAt: time.Date(2026, time.October, 26, 0, 0, 0, 0, time.UTC)}
+	if err := m.SendInvitation(context.Background(), inv, "c0ffee"); err != nil {
+		t.Fatal(err)
+	}
+	if sender.to != "bob@example.com" || sender.subject != "Invitation to join Acme" {
+		t.Errorf("sent %q to %s, want the invitation to Acme to bob@example.com", sender.subject, sender.to)
+	}
+	for _, want := range []string{"join Acme as admin", `{"token": "c0ffee"}`, "Mon, 26 Oct 2026"} {
+		if !strings.Contains(sender.body, want) {
+			t.Errorf("body doesn't mention %q :\n%s", want, sender.body)
+		}
+	}
+
+	inv.OrgID = 2
+	if err := m.SendInvitation(context.Background(), inv, "c0ffee"); !errors.Is(err, org.ErrOrganizationNotFound) {
+		t.Errorf("SendInvitation() to an unknown organization = %v, want ErrOrganizationNotFound", err)
+	}
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	dbQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "queries_total",
+		Help:      "Queries run through gorm, by operation, table and status.",
+	}, []string{"operation", "table", "status"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Latency of the queries run through gorm, by operation and table.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation", "table"})
+)
+
+const startKey = "metrics:start"
+
+// InstrumentGorm : Records query counts and latencies through gorm callbacks, and exports the
+// connection pool statistics of db under the given name
+func InstrumentGorm(db *gorm.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db.DB(), name))
+
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("metrics:before_create", before)
+	cb.Create().After("gorm:create").Register("metrics:after_create", after("create"))
+	cb.Query().Before("gorm:query").Register("metrics:before_query", before)
+	cb.Query().After("gorm:query").Register("metrics:after_query", after("query"))
+	cb.Update().Before("gorm:update").Register("metrics:before_update", before)
+	cb.Update().After("gorm:update").Register("metrics:after_update", after("update"))
+	cb.Delete().Before("gorm:delete").Register("metrics:before_delete", before)
+	cb.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete"))
+	cb.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", before)
+	cb.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", after("row_query"))
+}
+
+func before(scope *gorm.Scope) {
+	scope.InstanceSet(startKey, time.Now())
+}
+
+func after(operation string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		table := scope.TableName()
+		status := "ok"
+		// A missing row is an expected outcome, not a failing query
+		if scope.HasError() && !gorm.IsRecordNotFoundError(scope.DB().Error) {
+			status = "error"
+		}
+		dbQueries.WithLabelValues(operation, table, status).Inc()
+		dbQueryDuration.WithLabelValues(operation, table).Observe(time.Since(v.(time.Time)).Seconds())
+	}
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tnbt"
+
+// HTTP (RED) metrics, labelled with the gin route template instead of the raw path to keep the cardinality bounded
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+)
+
+// Auth metrics
+var (
+	loginAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "logins_total",
+		Help:      "Login attempts, by result (succeeded / failed) and failure reason.",
+	}, []string{"result", "reason"})
+
+	tokensIssued = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "tokens_issued_total",
+		Help:      "JWTs issued.",
+	})
+
+	tokenValidationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "token_validation_failures_total",
+		Help:      "JWTs rejected, by reason.",
+	}, []string{"reason"})
+
+	passwordHashDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "password_hash_duration_seconds",
+		Help:      "Time spent in bcrypt, by operation (hash / verify).",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+)
+
+// Webhook metrics
+var webhookAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "webhooks",
+	Name:      "delivery_attempts_total",
+	Help:      "Webhook delivery attempts, by outcome (delivered / retried / dead).",
+}, []string{"outcome"})
+
+// Webhook delivery attempt outcomes
+const (
+	WebhookDelivered = "delivered"
+	WebhookRetried   = "retried"
+	WebhookDead      = "dead"
+)
+
+// Event outbox metrics
+var eventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "events",
+	Name:      "publications_total",
+	Help:      "User events published from the outbox, by outcome (published / failed).",
+}, []string{"outcome"})
+
+// Event publication outcomes
+const (
+	EventOutcomePublished = "published"
+	EventOutcomeFailed    = "failed"
+)
+
+// Login failure reasons
+const (
+	LoginReasonUnknownUser     = "unknown_user"
+	LoginReasonInvalidPassword = "invalid_password"
+	LoginReasonDisabled        = "disabled"
+	LoginReasonError           = "error"
+)
+
+// Token validation failure reasons
+const (
+	TokenReasonMissing   = "missing"
+	TokenReasonMalformed = "malformed"
+	TokenReasonExpired   = "expired"
+	TokenReasonSignature = "invalid_signature"
+	TokenReasonInvalid   = "invalid"
+)
+
+// Handler : Serves the metrics in the Prometheus exposition format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// LoginSucceeded : Counts a successful login
+func LoginSucceeded() {
+	loginAttempts.WithLabelValues("succeeded", "").Inc()
+}
+
+// LoginFailed : Counts a failed login, reason is one of the LoginReason constants
+func LoginFailed(reason string) {
+	loginAttempts.WithLabelValues("failed", reason).Inc()
+}
+
+// TokenIssued : Counts a JWT handed out
+func TokenIssued() {
+	tokensIssued.Inc()
+}
+
+// TokenValidationFailed : Counts a rejected JWT, reason is one of the TokenReason constants
+func TokenValidationFailed(reason string) {
+	tokenValidationFailures.WithLabelValues(reason).Inc()
+}
+
+// ObservePasswordHash : Records the time spent in bcrypt since start, operation is hash or verify
+func ObservePasswordHash(operation string, start time.Time) {
+	passwordHashDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// WebhookAttempted : Counts a webhook delivery attempt, outcome is one of the Webhook constants
+func WebhookAttempted(outcome string) {
+	webhookAttempts.WithLabelValues(outcome).Inc()
+}
+
+// EventPublished : Counts an attempt to publish an event of the outbox, outcome is one of the EventOutcome constants
+func EventPublished(outcome string) {
+	eventsPublished.WithLabelValues(outcome).Inc()
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+)
+
+var auditRecorder audit.Recorder
+
+// ConfigureAudit : Records the rejected credentials in the audit log. Requests without any credentials aren't
+// recorded, they're routine for a client discovering it must sign in.
+func ConfigureAudit(recorder audit.Recorder) {
+	auditRecorder = recorder
+}
+
+// recordRejection : Records that the credentials of the request were rejected, and why
+func recordRejection(ctx context.Context, reason string) {
+	if auditRecorder == nil {
+		return
+	}
+	auditRecorder.Record(ctx, audit.Record{
+		ActorType: audit.ActorAnonymous,
+		Action:    audit.ActionAuthReject,
+		Outcome:   audit.OutcomeFailure,
+		Reason:    reason,
+	})
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// IdentityResolver : Maps the identity found in a client certificate (username or email) to a user ID
+type IdentityResolver func(ctx context.Context, identity string) (uint64, error)
+
+var (
+	certIdentityField string
+	resolveIdentity   IdentityResolver
+)
+
+// ConfigureClientCertAuth : Lets SetMiddleWareAuthentication accept verified client certificates alongside JWTs.
+// field is the certificate field holding the identity : cn, email, dns or uri.
+func ConfigureClientCertAuth(field string, resolver IdentityResolver) {
+	certIdentityField = field
+	resolveIdentity = resolver
+}
+
+// ClientCertIdentity : Identity held by the given field of the certificate
+func ClientCertIdentity(cert *x509.Certificate, field string) (string, bool) {
+	switch field {
+	case "cn":
+		return cert.Subject.CommonName, cert.Subject.CommonName != ""
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0], true
+		}
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0], true
+		}
+	case "uri":
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String(), true
+		}
+	}
+	return "", false
+}
+
+// clientCertUserID : User ID of the verified client certificate presented on the connection
+func clientCertUserID(ctx context.Context, state *tls.ConnectionState) (uint64, error) {
+	if !hasClientCert(state) {
+		return 0, errNoClientCert
+	}
+	identity, ok := ClientCertIdentity(state.VerifiedChains[0][0], certIdentityField)
+	if !ok {
+		return 0, errors.New("client certificate has no " + certIdentityField + " identity")
+	}
+	return resolveIdentity(ctx, identity)
+}
+
+// hasClientCert : Whether the connection presented a verified client certificate, which is accepted as credentials
+func hasClientCert(state *tls.ConnectionState) bool {
+	return resolveIdentity != nil && state != nil && len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0
+}
+
+var errNoClientCert = errors.New("no verified client certificate")
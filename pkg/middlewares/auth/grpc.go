@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor : gRPC counterpart of SetMiddleWareAuthentication, reading the JWT from the "authorization"
+// metadata. The methods listed in public (full names, e.g. /tnbt.user.v1.UserService/Login) skip authentication.
+func UnaryServerInterceptor(public ...string) grpc.UnaryServerInterceptor {
+	skip := make(map[string]bool, len(public))
+	for _, method := range public {
+		skip[method] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if skip[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		uid, err := authenticate(ctx, MetadataToken(ctx), peerTLS(ctx))
+		if err != nil {
+			return nil, errors.Wrap(err, "pkg.middlewares.auth.UnaryServerInterceptor")
+		}
+		return handler(withUser(ctx, uid), req)
+	}
+}
+
+// StaticTokenInterceptor : gRPC counterpart of StaticToken, the listed methods require the given bearer token rather
+// than a user's JWT, so they must be public to UnaryServerInterceptor. With an empty token they aren't served, like
+// the HTTP routes which are only registered once the token is set.
+func StaticTokenInterceptor(token string, methods ...string) grpc.UnaryServerInterceptor {
+	guarded := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		guarded[method] = true
+	}
+	expected := []byte(token)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !guarded[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		if token == "" {
+			return nil, status.Errorf(codes.Unimplemented, "%s isn't served without an admin token", info.FullMethod)
+		}
+		given := MetadataToken(ctx)
+		if given == "" {
+			return nil, errors.Wrap(ErrUnauthenticated, "pkg.middlewares.auth.StaticTokenInterceptor")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), expected) != 1 {
+			logging.FromContext(ctx).WithField("method", info.FullMethod).Warn("Rejected static token")
+			recordRejection(ctx, "invalid static token for "+info.FullMethod)
+			return nil, errors.Wrap(ErrInvalidCredentials, "pkg.middlewares.auth.StaticTokenInterceptor")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// MetadataToken : Token of the "authorization: Bearer <token>" metadata, the gRPC counterpart of ExtractToken
+func MetadataToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if parts := strings.Fields(value); len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+// peerTLS : TLS state of the connection, where the verified client certificates are
+func peerTLS(ctx context.Context) *tls.ConnectionState {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		return &info.State
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestStaticTokenInterceptor(t *testing.T) {
+	const adminToken = "admin-token-of-at-least-32-characters"
+	const guarded, other = "/tnbt.user.v1.UserService/ListUsers", "/tnbt.user.v1.UserService/GetUser"
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "served", nil }
+	withToken := func(token string) context.Context {
+		if token == "" {
+			return context.Background()
+		}
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	for _, tc := range []struct {
+		name, configured, token, method string
+		err                             error
+		code                            codes.Code
+	}{
+		{"admin token", adminToken, adminToken, guarded, nil, codes.OK},
+		{"other methods untouched", adminToken, "", other, nil, codes.OK},
+		{"no token", adminToken, "", guarded, ErrUnauthenticated, codes.OK},
+		{"a user's JWT", adminToken, "eyJhbGciOiJIUzI1NiJ9.e30.sig", guarded, ErrInvalidCredentials, codes.OK},
+		{"not configured", "", adminToken, guarded, nil, codes.Unimplemented},
+		{"not configured, without token either", "", "", guarded, nil, codes.Unimplemented},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			interceptor := StaticTokenInterceptor(tc.configured, guarded)
+			resp, err := interceptor(withToken(tc.token), nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+			switch {
+			case tc.code != codes.OK:
+				if status.Code(err) != tc.code {
+					t.Errorf("interceptor() = %v, want %s", err, tc.code)
+				}
+			case tc.err != nil:
+				if errors.Cause(err) != tc.err {
+					t.Errorf("interceptor() = %v, want %v", err, tc.err)
+				}
+			case err != nil || resp != "served":
+				t.Errorf("interceptor() = %v, %v, want the call served", resp, err)
+			}
+		})
+	}
+}
@@ -1,50 +1,121 @@
 package auth
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/metrics"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
 	"github.com/pkg/errors"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// CreateToken : Create JWT Token
-func CreateToken(userID uint64) (string, error) {
-	claims := jwt.MapClaims{}
+var (
+	apiSecret   []byte
+	tokenExpiry = time.Hour
+)
+
+var (
+	// ErrUnauthenticated : The request carries neither a token nor a client certificate
+	ErrUnauthenticated = apperrors.Unauthorized("Authentication required")
+	// ErrInvalidCredentials : The token or client certificate was rejected
+	ErrInvalidCredentials = apperrors.Unauthorized("Invalid or expired credentials")
+)
+
+// Configure : Sets the secret used to sign the JWTs and how long they stay valid
+func Configure(secret string, expiry time.Duration) {
+	apiSecret = []byte(secret)
+	tokenExpiry = expiry
+}
+
+// CreateToken : Create JWT Token, valid in the tenant of ctx only
+func CreateToken(ctx context.Context, userID uint64) (string, error) {
+	return createToken(ctx, jwt.MapClaims{"userID": userID})
+}
+
+// CreateOrganizationToken : CreateToken whose claims select the organization orgID as the active one, see TokenOrgID
+func CreateOrganizationToken(ctx context.Context, userID, orgID uint64) (string, error) {
+	return createToken(ctx, jwt.MapClaims{"userID": userID, "orgID": orgID})
+}
+
+func createToken(ctx context.Context, claims jwt.MapClaims) (string, error) {
+	if tenantID, ok := requestctx.TenantID(ctx); ok && tenantID != requestctx.DefaultTenant {
+		claims["tenant"] = tenantID
+	}
 	claims["authorized"] = true
-	claims["userID"] = userID
-	// Use Global Config package to set expiry
-	claims["exp"] = time.Now().Add(time.Hour * 1).Unix() // Token expires after 1 hour
+	claims["exp"] = time.Now().Add(tokenExpiry).Unix()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+	signed, err := token.SignedString(apiSecret)
+	if err != nil {
+		return "", err
+	}
+	metrics.TokenIssued()
+	return signed, nil
 }
 
 // TokenValid : Check Token's Validity
 func TokenValid(r *http.Request) error {
-	tokenString := ExtractToken(r)
-	fmt.Println("tokenString : ", tokenString)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+	return validateToken(r.Context(), ExtractToken(r))
+}
+
+// validateToken : Checks the signature and the expiry of tokenString, recording why it was rejected
+func validateToken(ctx context.Context, tokenString string) error {
+	if tokenString == "" {
+		metrics.TokenValidationFailed(metrics.TokenReasonMissing)
+	}
+	token, err := parseToken(tokenString)
+	if err != nil {
+		if tokenString != "" {
+			reason := tokenFailureReason(err)
+			metrics.TokenValidationFailed(reason)
+			// Never log the token itself, the reason is enough to tell an expired session from a forged one
+			logging.FromContext(ctx).WithField("reason", reason).Info("Rejected token")
+		}
+		return err
+	}
+	if !token.Valid {
+		return errors.New("Invalid token")
+	}
+	return nil
+}
+
+func parseToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(os.Getenv("API_SECRET")), nil
+		return apiSecret, nil
 	})
-	if err != nil {
-		fmt.Println(err)
-		return err
+}
+
+func tokenFailureReason(err error) string {
+	verr, ok := err.(*jwt.ValidationError)
+	if !ok {
+		return metrics.TokenReasonInvalid
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		Pretty(claims)
+	switch {
+	case verr.Errors&jwt.ValidationErrorMalformed != 0:
+		return metrics.TokenReasonMalformed
+	case verr.Errors&jwt.ValidationErrorExpired != 0:
+		return metrics.TokenReasonExpired
+	case verr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+		return metrics.TokenReasonSignature
+	default:
+		return metrics.TokenReasonInvalid
 	}
-	return nil
 }
 
 // ExtractToken : To extract jwt from "Authorization" header from the client
@@ -63,13 +134,16 @@ func ExtractToken(r *http.Request) string {
 
 // ExtractTokenID : Extract userID
 func ExtractTokenID(r *http.Request) (uint64, error) {
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method : %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+	return tokenUserID(ExtractToken(r))
+}
+
+// TokenUserID : ID of the user the token was issued to, e.g. by Login
+func TokenUserID(tokenString string) (uint64, error) {
+	return tokenUserID(tokenString)
+}
+
+func tokenUserID(tokenString string) (uint64, error) {
+	token, err := parseToken(tokenString)
 	if err != nil {
 		return 0, err
 	}
@@ -84,24 +158,144 @@ func ExtractTokenID(r *http.Request) (uint64, error) {
 	return 0, nil
 }
 
-// Pretty display the claims nicely in the terminal
-func Pretty(data interface{}) {
-	b, err := json.MarshalIndent(data, "", " ")
-	if err != nil {
-		log.Println(err)
-		return
+// TokenOrgID : Organization selected by the claims of the request's token, 0 when it selects none. The membership
+// isn't checked, the user may have left the organization since the token was issued.
+func TokenOrgID(r *http.Request) uint64 {
+	token, err := parseToken(ExtractToken(r))
+	if err != nil || !token.Valid {
+		return 0
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0
+	}
+	orgID, ok := claims["orgID"].(float64)
+	if !ok || orgID < 1 {
+		return 0
+	}
+	return uint64(orgID)
+}
+
+// TokenTenant : Tenant the token was issued in, requestctx.DefaultTenant for the tokens without a tenant claim and
+// empty when the token is invalid
+func TokenTenant(tokenString string) string {
+	token, err := parseToken(tokenString)
+	if err != nil || !token.Valid {
+		return ""
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	if tenantID, ok := claims["tenant"].(string); ok {
+		return tenantID
 	}
-	fmt.Println(string(b))
+	return requestctx.DefaultTenant
 }
 
-// SetMiddleWareAuthentication : Check for authenticated users
+// userIDKey : gin context key holding the authenticated user's ID
+const userIDKey = "userID"
+
+// SetMiddleWareAuthentication : Check for authenticated users, either through a JWT or a verified client certificate
 func SetMiddleWareAuthentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		err := TokenValid(c.Request)
+		uid, err := authenticate(c.Request.Context(), ExtractToken(c.Request), c.Request.TLS)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-				"error": errors.Wrap(err, "pkg.user.middleware.SetMiddleWare").Error(),
-			})
+			problem.Abort(c, errors.Wrap(err, "pkg.user.middleware.SetMiddleWare"))
+			return
+		}
+		c.Set(userIDKey, uid)
+		c.Request = c.Request.WithContext(withUser(c.Request.Context(), uid))
+		c.Next()
+	}
+}
+
+// OptionalAuthentication : SetMiddleWareAuthentication for the routes serving anonymous requests as well, such as
+// /graphql whose resolvers check authentication themselves : requests without credentials go through anonymously,
+// the ones with invalid credentials are still rejected
+func OptionalAuthentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := ExtractToken(c.Request)
+		if tokenString == "" && !hasClientCert(c.Request.TLS) {
+			c.Next()
+			return
+		}
+		uid, err := authenticate(c.Request.Context(), tokenString, c.Request.TLS)
+		if err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.middlewares.auth.OptionalAuthentication"))
+			return
+		}
+		c.Set(userIDKey, uid)
+		c.Request = c.Request.WithContext(withUser(c.Request.Context(), uid))
+		c.Next()
+	}
+}
+
+// authenticate : User ID of the token, or of the verified client certificate of the connection when there's no token.
+// ErrUnauthenticated or ErrInvalidCredentials otherwise.
+func authenticate(ctx context.Context, tokenString string, state *tls.ConnectionState) (uint64, error) {
+	if tokenString == "" {
+		uid, err := clientCertUserID(ctx, state)
+		if err == errNoClientCert {
+			metrics.TokenValidationFailed(metrics.TokenReasonMissing)
+			return 0, ErrUnauthenticated
+		}
+		if err != nil {
+			recordRejection(ctx, "invalid client certificate")
+			return 0, ErrInvalidCredentials.Wrap(err)
+		}
+		return uid, nil
+	}
+	if err := validateToken(ctx, tokenString); err != nil {
+		recordRejection(ctx, "invalid token : "+tokenFailureReason(err))
+		return 0, ErrInvalidCredentials.Wrap(err)
+	}
+	uid, err := tokenUserID(tokenString)
+	if err != nil {
+		recordRejection(ctx, "invalid token : "+metrics.TokenReasonInvalid)
+		return 0, ErrInvalidCredentials.Wrap(err)
+	}
+	// User IDs are unique across the tenants, but a token only opens the tenant it was issued in
+	if tenantID, ok := requestctx.TenantID(ctx); ok && TokenTenant(tokenString) != tenantID {
+		recordRejection(ctx, "invalid token : issued in another tenant")
+		return 0, ErrInvalidCredentials
+	}
+	return uid, nil
+}
+
+// withUser : ctx of a request authenticated as uid, carrying it to the logs and the trace
+func withUser(ctx context.Context, uid uint64) context.Context {
+	ctx = requestctx.WithUserID(ctx, uid)
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).WithField("user_id", uid))
+	trace.SpanFromContext(ctx).SetAttributes(semconv.EnduserID(strconv.FormatUint(uid, 10)))
+	return ctx
+}
+
+// UserID : ID of the user authenticated by SetMiddleWareAuthentication
+func UserID(c *gin.Context) (uint64, error) {
+	if uid, ok := c.Get(userIDKey); ok {
+		return uid.(uint64), nil
+	}
+	if uid, ok := requestctx.UserID(c.Request.Context()); ok {
+		return uid, nil
+	}
+	return ExtractTokenID(c.Request)
+}
+
+// StaticToken : Requires the given bearer token, for the routes operators rather than users call (e.g. the webhooks
+// management). The token is only accepted in the Authorization header, the users' JWTs aren't accepted.
+func StaticToken(token string) gin.HandlerFunc {
+	expected := []byte(token)
+	return func(c *gin.Context) {
+		parts := strings.Fields(c.GetHeader("Authorization"))
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+			problem.Abort(c, errors.Wrap(ErrUnauthenticated, "pkg.middlewares.auth.StaticToken"))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(parts[1]), expected) != 1 {
+			logging.FromContext(c.Request.Context()).WithField("path", c.FullPath()).Warn("Rejected static token")
+			recordRejection(c.Request.Context(), "invalid static token for "+c.FullPath())
+			problem.Abort(c, errors.Wrap(ErrInvalidCredentials, "pkg.middlewares.auth.StaticToken"))
 			return
 		}
 		c.Next()
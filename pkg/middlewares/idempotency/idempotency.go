@@ -0,0 +1,197 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Retried POST requests carrying the same Idempotency-Key get the response of the first one replayed instead of
+// running again, see https://datatracker.ietf.org/doc/draft-ietf-httpapi-idempotency-key-header/
+
+// Header : Request header carrying the idempotency key
+const Header = "Idempotency-Key"
+
+// ReplayedHeader : Response header set on the replayed responses
+const ReplayedHeader = "Idempotent-Replayed"
+
+// MaxKeyLength : Longest idempotency key accepted, UUIDs are the expected ones
+const MaxKeyLength = 255
+
+// storeTimeout : Bounds the store calls made once the handler ran, which don't use the request's context
+// so that a client hanging up doesn't leave its key reserved
+const storeTimeout = 5 * time.Second
+
+var (
+	errInvalidKey = apperrors.Validation("Invalid "+Header, apperrors.FieldError{Field: Header, Message: "must be at most 255 characters long"})
+	errKeyReused  = apperrors.Validation(Header + " was already used for a different request")
+	errInFlight   = apperrors.Conflict("A request with the same " + Header + " is still being processed")
+)
+
+// errBodyTooLarge : The body of a request with a key is larger than the middleware accepts
+func errBodyTooLarge(maxBodySize int64) error {
+	return apperrors.PayloadTooLarge(fmt.Sprintf("Requests with an %s must have a body of at most %d bytes", Header, maxBodySize))
+}
+
+// unreplayedHeaders : Response headers describing the first request only, never replayed
+var unreplayedHeaders = []string{requestctx.RequestIDHeader, "Set-Cookie", "Date"}
+
+// Middleware : Stores the responses of the POST requests carrying an Idempotency-Key for ttl, and replays them
+// to the retries of these requests. Keys are scoped to the authenticated user, or to the client IP on the public
+// routes, and are bound to the request they were first used with. Server errors aren't stored, so that they can
+// be retried. The bodies of these requests are read in memory, up to maxBodySize bytes.
+func Middleware(store Store, ttl time.Duration, maxBodySize int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" || c.Request.Method != http.MethodPost {
+			c.Next()
+			return
+		}
+		if len(key) > MaxKeyLength {
+			problem.Abort(c, errInvalidKey)
+			return
+		}
+		// One byte more than allowed tells a body at the limit from a larger one
+		body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize+1))
+		if int64(len(body)) > maxBodySize {
+			problem.Abort(c, errBodyTooLarge(maxBodySize))
+			return
+		}
+		if err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.middlewares.idempotency.Middleware"))
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		rec := &Record{
+			Key:         key,
+			Scope:       scope(c),
+			RequestHash: requestHash(c.Request, body),
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+		existing, err := store.Reserve(c.Request.Context(), rec)
+		if err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.middlewares.idempotency.Middleware"))
+			return
+		}
+		if existing != nil {
+			replay(c, existing, rec.RequestHash)
+			return
+		}
+
+		w := &recorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		completed := false
+		defer func() {
+			// Also runs when the handler panics, the key must not stay reserved until it expires
+			if !completed {
+				release(c, store, rec)
+			}
+		}()
+		c.Next()
+		// Errors are rendered by problem.Middleware once the chain returned, too late to be recorded
+		if !c.Writer.Written() && len(c.Errors) > 0 {
+			problem.Render(c, c.Errors.Last().Err)
+		}
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+		rec.Status = c.Writer.Status()
+		rec.Header = replayableHeader(c.Writer.Header())
+		rec.Body = w.body.Bytes()
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := store.Complete(ctx, rec); err != nil {
+			logging.FromContext(c.Request.Context()).WithError(err).Error("Storing the idempotent response failed")
+			return
+		}
+		completed = true
+	}
+}
+
+// replay : Answers a retry with the stored response, or explains why it can't
+func replay(c *gin.Context, rec *Record, requestHash string) {
+	switch {
+	case rec.RequestHash != requestHash:
+		problem.Abort(c, errKeyReused)
+	case !rec.Completed:
+		problem.Abort(c, errInFlight)
+	default:
+		for name, values := range rec.Header {
+			c.Writer.Header()[name] = values
+		}
+		c.Header(ReplayedHeader, "true")
+		c.Status(rec.Status)
+		_, _ = c.Writer.Write(rec.Body)
+		c.Abort()
+	}
+}
+
+// release : Drops the reservation of a request which didn't complete
+func release(c *gin.Context, store Store, rec *Record) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := store.Release(ctx, rec.Scope, rec.Key); err != nil {
+		logging.FromContext(c.Request.Context()).WithError(err).Error("Releasing the idempotency key failed")
+	}
+}
+
+// scope : Authenticated user, or client IP within the tenant for the anonymous requests. The user IDs are unique
+// across the tenants already.
+func scope(c *gin.Context) string {
+	if uid, ok := requestctx.UserID(c.Request.Context()); ok {
+		return "user:" + strconv.FormatUint(uid, 10)
+	}
+	if tenantID, ok := requestctx.TenantID(c.Request.Context()); ok && tenantID != requestctx.DefaultTenant {
+		return "tenant:" + tenantID + ":ip:" + requestctx.ClientIP(c.Request)
+	}
+	return "ip:" + requestctx.ClientIP(c.Request)
+}
+
+// requestHash : Fingerprint of the request, a key reused on another route or with another body doesn't match
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replayableHeader(header http.Header) http.Header {
+	replayable := make(http.Header, len(header))
+	for name, values := range header {
+		replayable[name] = append([]string(nil), values...)
+	}
+	for _, name := range unreplayedHeaders {
+		replayable.Del(name)
+	}
+	return replayable
+}
+
+// recorder : Keeps a copy of the response body
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
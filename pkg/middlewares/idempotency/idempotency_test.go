@@ -0,0 +1,49 @@
+package idempotency
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	runs := 0
+	r := gin.New()
+	r.Use(problem.Middleware(), Middleware(NewMemoryStore(), time.Hour, 16))
+	r.POST("/", func(c *gin.Context) {
+		runs++
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusCreated, string(body))
+	})
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(Header, key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	atLimit := strings.Repeat("a", 16)
+	if w := post("k1", atLimit); w.Code != http.StatusCreated || w.Body.String() != atLimit {
+		t.Fatalf("body at the limit : %d %q, want 201 echoing it", w.Code, w.Body.String())
+	}
+	if w := post("k1", atLimit); w.Code != http.StatusCreated || w.Header().Get(ReplayedHeader) != "true" || runs != 1 {
+		t.Errorf("retry : %d, replayed %q, %d runs, want the first response replayed", w.Code, w.Header().Get(ReplayedHeader), runs)
+	}
+	if w := post("k1", "another body"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused with another body : %d, want 422", w.Code)
+	}
+	if w := post("k2", atLimit+"a"); w.Code != http.StatusRequestEntityTooLarge || runs != 1 {
+		t.Errorf("body over the limit : %d, %d runs, want 413 without running the handler", w.Code, runs)
+	}
+	if w := post("k3", strings.Repeat("a", 1<<20)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body far over the limit : %d, want 413", w.Code)
+	}
+}
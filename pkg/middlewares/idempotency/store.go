@@ -0,0 +1,86 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Record : Request stored under an idempotency key, with its response once it completed
+type Record struct {
+	Key         string
+	Scope       string // Who sent the request, keys of different users or clients never collide
+	RequestHash string
+	Completed   bool // False while the first request is in flight
+	Status      int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// Store : Keeps the records of the idempotency keys. Implementations must make Reserve atomic,
+// it's what keeps two concurrent requests with the same key from both running.
+type Store interface {
+	// Reserve : Stores rec unless a live record exists for its scope and key. It returns that record in this case,
+	// nil when rec was stored. Expired records are replaced.
+	Reserve(ctx context.Context, rec *Record) (*Record, error)
+	// Complete : Saves the response of a reserved record
+	Complete(ctx context.Context, rec *Record) error
+	// Release : Drops a reserved record, so that the key can be retried
+	Release(ctx context.Context, scope, key string) error
+	// DeleteExpired : Drops the records expired before now, returns how many were dropped
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[[2]string]*Record
+}
+
+// NewMemoryStore : Store keeping the records in memory, for a single instance deployment
+func NewMemoryStore() Store {
+	return &memoryStore{records: make(map[[2]string]*Record)}
+}
+
+func (m *memoryStore) Reserve(ctx context.Context, rec *Record) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := [2]string{rec.Scope, rec.Key}
+	if existing, ok := m.records[id]; ok && time.Now().Before(existing.ExpiresAt) {
+		copied := *existing
+		return &copied, nil
+	}
+	copied := *rec
+	m.records[id] = &copied
+	return nil, nil
+}
+
+func (m *memoryStore) Complete(ctx context.Context, rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied := *rec
+	copied.Completed = true
+	m.records[[2]string{rec.Scope, rec.Key}] = &copied
+	return nil
+}
+
+func (m *memoryStore) Release(ctx context.Context, scope, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, [2]string{scope, key})
+	return nil
+}
+
+func (m *memoryStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for id, rec := range m.records {
+		if !now.Before(rec.ExpiresAt) {
+			delete(m.records, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,49 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sweeper : Deletes the expired records of a store. Expired keys are already ignored by Reserve,
+// sweeping only keeps the store from growing.
+type Sweeper struct {
+	store Store
+
+	// Cancelled by Stop, which also aborts a running sweep
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewSweeper : Sweeper of the given store
+func NewSweeper(store Store) *Sweeper {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Sweeper{store: store, ctx: ctx, cancel: cancel}
+}
+
+// Run : Sweeps every interval until Stop is called
+func (s *Sweeper) Run(interval time.Duration, log logrus.FieldLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.store.DeleteExpired(s.ctx, time.Now())
+			if err != nil && s.ctx.Err() == nil {
+				log.Errorf("Deleting the expired idempotency keys failed, retrying on the next run : %v", err)
+			}
+			if deleted > 0 {
+				log.WithField("deleted", deleted).Debug("Deleted expired idempotency keys")
+			}
+		}
+	}
+}
+
+// Stop : Stops Run
+func (s *Sweeper) Stop() {
+	s.cancel()
+}
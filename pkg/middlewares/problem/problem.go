@@ -0,0 +1,70 @@
+package problem
+
+import (
+	"net/http"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType : Media type of the error responses, see RFC 7807
+const ContentType = "application/problem+json"
+
+// Problem : RFC 7807 error response
+type Problem struct {
+	Type      string                 `json:"type" example:"/problems/not-found"`
+	Title     string                 `json:"title" example:"Not Found"`
+	Status    int                    `json:"status" example:"404"`
+	Detail    string                 `json:"detail,omitempty" example:"User Not Found"`
+	Instance  string                 `json:"instance,omitempty" example:"/user/42"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Errors    []apperrors.FieldError `json:"errors,omitempty"`
+}
+
+// New : Problem describing err. Only domain errors get their message exposed, anything else is an opaque 500.
+func New(r *http.Request, err error) Problem {
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(http.StatusInternalServerError),
+		Status:    http.StatusInternalServerError,
+		Instance:  r.URL.Path,
+		RequestID: requestctx.RequestID(r.Context()),
+	}
+	if e, ok := apperrors.As(err); ok && e.Kind != apperrors.KindInternal {
+		p.Type = "/problems/" + e.Kind.String()
+		p.Status = e.Kind.Status()
+		p.Title = http.StatusText(p.Status)
+		p.Detail = e.Message
+		p.Errors = e.Fields
+	}
+	return p
+}
+
+// Abort : Records err for Middleware to render, and stops the handler chain
+func Abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// Render : Writes err as a problem+json response
+func Render(c *gin.Context, err error) {
+	p := New(c.Request, err)
+	if p.Status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Bearer realm="tnbt"`)
+	}
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// Middleware : Renders the last error recorded on the context (see Abort) when the handlers didn't write a response.
+// Must be the innermost global middleware so the outer ones (metrics, tracing, access log) see the final status.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		Render(c, c.Errors.Last().Err)
+	}
+}
@@ -0,0 +1,144 @@
+package tenancy
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Every request acts in one tenant, resolved from (first match wins) the Header header, the subdomain of the
+// configured base domain, the tenant claim of the token, and finally the default tenant. The repositories only see
+// the data of that tenant, see requestctx.TenantID.
+
+// Header : Request header selecting the tenant
+const Header = "X-Tenant-ID"
+
+// metadataKey : gRPC counterpart of Header
+const metadataKey = "x-tenant-id"
+
+var (
+	// ErrUnknownTenant : The request selects a tenant the deployment doesn't host
+	ErrUnknownTenant = apperrors.NotFound("Unknown tenant")
+	// ErrNoTenant : The request selects no tenant and there's no default one
+	ErrNoTenant = apperrors.Validation("Tenant required", apperrors.FieldError{Field: Header, Message: "is required"})
+)
+
+// Resolver : Resolves the tenant of the requests
+type Resolver interface {
+	// Resolve : Tenant selected by the header, the host or the token of a request, any of them may be empty
+	Resolve(header, host, token string) (string, error)
+}
+
+type resolver struct {
+	cfg     config.TenancyConfig
+	tenants map[string]bool
+	suffix  string
+}
+
+// NewResolver : Resolver of the configured tenants, every request acts in requestctx.DefaultTenant when the tenancy
+// is disabled
+func NewResolver(cfg config.TenancyConfig) Resolver {
+	tenants := make(map[string]bool, len(cfg.Tenants))
+	for _, t := range cfg.Tenants {
+		tenants[t] = true
+	}
+	var suffix string
+	if cfg.BaseDomain != "" {
+		suffix = "." + strings.ToLower(strings.Trim(cfg.BaseDomain, "."))
+	}
+	return &resolver{cfg: cfg, tenants: tenants, suffix: suffix}
+}
+
+func (r *resolver) Resolve(header, host, token string) (string, error) {
+	if !r.cfg.Enabled {
+		return requestctx.DefaultTenant, nil
+	}
+	tenantID := header
+	if tenantID == "" {
+		tenantID = r.subdomain(host)
+	}
+	if tenantID == "" && token != "" {
+		tenantID = auth.TokenTenant(token)
+	}
+	if tenantID == "" {
+		tenantID = r.cfg.Default
+	}
+	if tenantID == "" {
+		return "", ErrNoTenant
+	}
+	if !r.tenants[tenantID] {
+		return "", ErrUnknownTenant
+	}
+	return tenantID, nil
+}
+
+// subdomain : Label of host right under the base domain, e.g. acme for acme.users.example.com:8080, empty for the
+// other hosts
+func (r *resolver) subdomain(host string) string {
+	if r.suffix == "" || host == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if !strings.HasSuffix(host, r.suffix) {
+		return ""
+	}
+	label := strings.TrimSuffix(host, r.suffix)
+	if strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// Middleware : Scopes the request to its tenant, see Resolver
+func Middleware(r Resolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, err := r.Resolve(c.GetHeader(Header), c.Request.Host, auth.ExtractToken(c.Request))
+		if err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.middlewares.tenancy.Middleware"))
+			return
+		}
+		c.Request = c.Request.WithContext(withTenant(c.Request.Context(), tenantID))
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor : gRPC counterpart of Middleware, reading the "x-tenant-id" metadata, the :authority and the
+// token of the "authorization" metadata. It goes before auth.UnaryServerInterceptor, which checks the token against
+// the tenant.
+func UnaryServerInterceptor(r Resolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		tenantID, err := r.Resolve(first(md, metadataKey), first(md, ":authority"), auth.MetadataToken(ctx))
+		if err != nil {
+			return nil, errors.Wrap(err, "pkg.middlewares.tenancy.UnaryServerInterceptor")
+		}
+		return handler(withTenant(ctx, tenantID), req)
+	}
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// withTenant : ctx of a request acting in the tenant, carrying it to the logs
+func withTenant(ctx context.Context, tenantID string) context.Context {
+	ctx = requestctx.WithTenant(ctx, tenantID)
+	return logging.WithLogger(ctx, logging.FromContext(ctx).WithField("tenant_id", tenantID))
+}
@@ -0,0 +1,103 @@
+package tenancy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/config"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/gin-gonic/gin"
+)
+
+var testConfig = config.TenancyConfig{
+	Enabled:    true,
+	Tenants:    []string{"acme", "globex", "initech"},
+	Default:    "initech",
+	BaseDomain: "users.example.com",
+}
+
+// tokenIn : JWT issued in the tenant
+func tokenIn(t *testing.T, tenantID string) string {
+	t.Helper()
+	token, err := auth.CreateToken(requestctx.WithTenant(context.Background(), tenantID), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestResolve(t *testing.T) {
+	auth.Configure("tenancy test secret", time.Hour)
+	globexToken := tokenIn(t, "globex")
+	unknownToken := tokenIn(t, "umbrella")
+	noTenantToken := tokenIn(t, requestctx.DefaultTenant)
+	noDefault := testConfig
+	noDefault.Default = ""
+
+	for _, tc := range []struct {
+		name                string
+		cfg                 config.TenancyConfig
+		header, host, token string
+		want                string
+		err                 error
+	}{
+		{"header first", testConfig, "acme", "globex.users.example.com", globexToken, "acme", nil},
+		{"subdomain before the token", testConfig, "", "acme.users.example.com:8080", globexToken, "acme", nil},
+		{"subdomain, any case", testConfig, "", "ACME.Users.Example.com.", "", "acme", nil},
+		{"token", testConfig, "", "localhost:3000", globexToken, "globex", nil},
+		{"nested subdomain ignored", testConfig, "", "www.acme.users.example.com", globexToken, "globex", nil},
+		{"base domain itself ignored", testConfig, "", "users.example.com", "", "initech", nil},
+		{"invalid token ignored", testConfig, "", "", "not a jwt", "initech", nil},
+		{"default", testConfig, "", "", "", "initech", nil},
+		{"unknown header, no fallback", testConfig, "umbrella", "acme.users.example.com", "", "", ErrUnknownTenant},
+		{"unknown subdomain, no fallback", testConfig, "", "umbrella.users.example.com", globexToken, "", ErrUnknownTenant},
+		{"unknown token tenant", testConfig, "", "", unknownToken, "", ErrUnknownTenant},
+		{"token without tenant claim, from the default tenant", testConfig, "", "", noTenantToken, "", ErrUnknownTenant},
+		{"no default", noDefault, "", "", "", "", ErrNoTenant},
+		{"disabled", config.TenancyConfig{}, "acme", "globex.users.example.com", globexToken, requestctx.DefaultTenant, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NewResolver(tc.cfg).Resolve(tc.header, tc.host, tc.token)
+			if err != tc.err || got != tc.want {
+				t.Errorf("Resolve() = %q, %v, want %q, %v", got, err, tc.want, tc.err)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(problem.Middleware(), Middleware(NewResolver(testConfig)))
+	r.GET("/", func(c *gin.Context) {
+		tenantID, _ := requestctx.TenantID(c.Request.Context())
+		c.String(http.StatusOK, tenantID)
+	})
+
+	for _, tc := range []struct {
+		name, header string
+		status       int
+		body         string
+	}{
+		{"scoped to the tenant", "acme", http.StatusOK, "acme"},
+		{"unknown tenant", "umbrella", http.StatusNotFound, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(Header, tc.header)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d", w.Code, tc.status)
+			}
+			if tc.body != "" && w.Body.String() != tc.body {
+				t.Errorf("tenant = %q, want %q", w.Body.String(), tc.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package versioning
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// Every API version is a route group named after it (/v1, /v2 ...). Clients either put the version in the path,
+// or keep the unversioned paths and ask for it in the Accept header : application/vnd.tnbt.v1+json.
+
+// Header : Response header telling which API version served the request
+const Header = "API-Version"
+
+// vendorPrefix : Media types naming a version are application/vnd.tnbt.<version>+json
+const vendorPrefix = "application/vnd.tnbt."
+
+// Version : Tags the responses of a version's route group
+func Version(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(Header, name)
+		c.Next()
+	}
+}
+
+// Deprecation : When a version was deprecated, when it goes away and what replaces it
+type Deprecation struct {
+	// Since is when the version was deprecated, the Deprecation header is left out when it's zero
+	Since time.Time
+	// Sunset is when the version stops being served, zero when it isn't scheduled yet
+	Sunset time.Time
+	// Successor is the path prefix of the replacing version, e.g. /v1
+	Successor string
+}
+
+// Deprecated : Announces the deprecation of a version on each of its responses, with the Deprecation (RFC 9745),
+// Sunset (RFC 8594) and Link headers
+func Deprecated(d Deprecation) gin.HandlerFunc {
+	var deprecation, sunset string
+	if !d.Since.IsZero() {
+		deprecation = "@" + strconv.FormatInt(d.Since.Unix(), 10)
+	}
+	if !d.Sunset.IsZero() {
+		sunset = d.Sunset.UTC().Format(http.TimeFormat)
+	}
+	return func(c *gin.Context) {
+		if deprecation != "" {
+			c.Header("Deprecation", deprecation)
+		}
+		if sunset != "" {
+			c.Header("Sunset", sunset)
+		}
+		if d.Successor != "" {
+			c.Writer.Header().Add("Link", `<`+d.Successor+c.Request.URL.Path+`>; rel="successor-version"`)
+		}
+		c.Next()
+	}
+}
+
+// Negotiate : Serves the requests on unversioned paths whose Accept header names a version (see vendorPrefix)
+// as if the version was in the path. Unknown versions get a 406, explicit paths win over the Accept header.
+// The paths under unversioned (e.g. /healthz, /metrics) aren't part of the API and are served as is.
+func Negotiate(next http.Handler, versions []string, unversioned ...string) http.Handler {
+	known := make(map[string]bool, len(versions))
+	for _, v := range versions {
+		known[v] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, ok := requestedVersion(r.Header.Get("Accept"))
+		if !ok || versioned(r.URL.Path, known) || under(r.URL.Path, unversioned) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		if !known[version] {
+			notAcceptable(w, r, versions)
+			return
+		}
+		r.URL.Path = "/" + version + r.URL.Path
+		if r.URL.RawPath != "" {
+			r.URL.RawPath = "/" + version + r.URL.RawPath
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestedVersion : Version named by the first vendor media type of the Accept header
+func requestedVersion(accept string) (string, bool) {
+	if !strings.Contains(accept, vendorPrefix) {
+		return "", false
+	}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil || !strings.HasPrefix(mediaType, vendorPrefix) {
+			continue
+		}
+		version := strings.TrimSuffix(strings.TrimPrefix(mediaType, vendorPrefix), "+json")
+		if version != "" {
+			return version, true
+		}
+	}
+	return "", false
+}
+
+// versioned : Whether the path already starts with a known version
+func versioned(path string, known map[string]bool) bool {
+	segment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	return known[segment]
+}
+
+// under : Whether the path is one of the prefixes or below one of them
+func under(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notAcceptable : Written here rather than through problem.Middleware, the request never reaches the router
+func notAcceptable(w http.ResponseWriter, r *http.Request, versions []string) {
+	p := problem.New(r, apperrors.NotAcceptable("Unknown API version, supported versions : "+strings.Join(versions, ", ")))
+	w.Header().Set("Content-Type", problem.ContentType)
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,72 @@
+package versioning
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNegotiate(t *testing.T) {
+	var served string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.URL.Path
+	})
+	h := Negotiate(next, []string{"v1"}, "/healthz", "/metrics", "/swagger/")
+
+	for _, tc := range []struct {
+		name, path, accept, want string
+		status                   int
+	}{
+		{"no vendor type", "/user/1", "application/json", "/user/1", http.StatusOK},
+		{"rewritten", "/user/1", "application/vnd.tnbt.v1+json", "/v1/user/1", http.StatusOK},
+		{"explicit path wins", "/v1/user/1", "application/vnd.tnbt.v2+json", "/v1/user/1", http.StatusOK},
+		{"unknown version", "/user/1", "application/vnd.tnbt.v9+json", "", http.StatusNotAcceptable},
+		{"health check", "/healthz", "application/vnd.tnbt.v1+json", "/healthz", http.StatusOK},
+		{"metrics", "/metrics", "application/vnd.tnbt.v9+json", "/metrics", http.StatusOK},
+		{"below an unversioned prefix", "/swagger/index.html", "application/vnd.tnbt.v1+json", "/swagger/index.html", http.StatusOK},
+		{"prefix on a segment boundary only", "/healthzz", "application/vnd.tnbt.v1+json", "/v1/healthzz", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			served = ""
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			r.Header.Set("Accept", tc.accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d", w.Code, tc.status)
+			}
+			if served != tc.want {
+				t.Errorf("served %q, want %q", served, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	since := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name        string
+		d           Deprecation
+		deprecation string
+	}{
+		{"configured", Deprecation{Since: since, Successor: "/v1"}, "@1792368000"},
+		{"not configured", Deprecation{Successor: "/v1"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/user/:id", Deprecated(tc.d), func(c *gin.Context) { c.Status(http.StatusOK) })
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/user/1", nil))
+			if got := w.Header().Get("Deprecation"); got != tc.deprecation {
+				t.Errorf("Deprecation = %q, want %q", got, tc.deprecation)
+			}
+			if got := w.Header().Get("Link"); got != `</v1/user/1>; rel="successor-version"` {
+				t.Errorf("Link = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package org
+
+import (
+	"context"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+)
+
+type auditedService struct {
+	Service
+	recorder audit.Recorder
+}
+
+// NewAuditedService : Wraps the Service so every change of an organization is recorded in the audit log whether it
+// succeeds or not. The reads go straight to next.
+func NewAuditedService(next Service, recorder audit.Recorder) Service {
+	return &auditedService{next, recorder}
+}
+
+// record : Appends the record of action on organization id, failed when err isn't nil
+func (a *auditedService) record(ctx context.Context, action string, id uint64, slug string, changes audit.Changes, err error) {
+	r := audit.Record{Action: action, TargetType: audit.TargetOrganization, TargetID: id, TargetName: slug, Changes: changes}
+	if err != nil {
+		r.Outcome, r.Reason, r.Changes = audit.OutcomeFailure, audit.Reason(err), nil
+	}
+	a.recorder.Record(ctx, r)
+}
+
+// before : State of the organization ahead of a change, nil when it can't be read (the change is then likely to fail
+// too)
+func (a *auditedService) before(ctx context.Context, id uint64) *Organization {
+	o, err := a.Service.GetOrganization(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return o
+}
+
+func (a *auditedService) CreateOrganization(ctx context.Context, ownerID uint64, p CreateOrganizationPayload) (*Organization, error) {
+	created, err := a.Service.CreateOrganization(ctx, ownerID, p)
+	if err != nil {
+		a.record(ctx, audit.ActionOrgCreate, 0, p.Slug, nil, err)
+		return nil, err
+	}
+	a.record(ctx, audit.ActionOrgCreate, created.ID, created.Slug, audit.Diff(nil, created), nil)
+	return created, nil
+}
+
+func (a *auditedService) UpdateOrganization(ctx context.Context, id uint64, p UpdateOrganizationPayload) (*Organization, error) {
+	before := a.before(ctx, id)
+	updated, err := a.Service.UpdateOrganization(ctx, id, p)
+	switch {
+	case err != nil:
+		slug := ""
+		if before != nil {
+			slug = before.Slug
+		}
+		a.record(ctx, audit.ActionOrgUpdate, id, slug, nil, err)
+	default:
+		// Unless it succeeded without changing anything
+		if changes := audit.Diff(before, updated); changes != nil {
+			a.record(ctx, audit.ActionOrgUpdate, id, updated.Slug, changes, nil)
+		}
+	}
+	return updated, err
+}
+
+func (a *auditedService) DeleteOrganization(ctx context.Context, id uint64) error {
+	before := a.before(ctx, id)
+	err := a.Service.DeleteOrganization(ctx, id)
+	slug := ""
+	if before != nil {
+		slug = before.Slug
+	}
+	a.record(ctx, audit.ActionOrgDelete, id, slug, audit.Diff(before, nil), err)
+	return err
+}
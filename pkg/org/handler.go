@@ -0,0 +1,179 @@
+package org
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Handler : Organizations of the authenticated user. The routes of an organization are expected behind
+// membership.RequireRole, which checks the caller's role in it. Errors are rendered by problem.Middleware
+type Handler interface {
+	CreateOrganization(c *gin.Context)
+	GetOrganization(c *gin.Context)
+	UpdateOrganization(c *gin.Context)
+	DeleteOrganization(c *gin.Context)
+}
+
+var (
+	errMalformedBody = apperrors.Validation("Malformed JSON body")
+	errInvalidID     = apperrors.Validation("Invalid organization ID", apperrors.FieldError{Field: "id", Message: "must be a positive integer"})
+)
+
+type handler struct {
+	service Service
+}
+
+// NewHandler : Handler of the organizations of service
+func NewHandler(service Service) Handler {
+	return &handler{service}
+}
+
+func decode(c *gin.Context, v interface{}) error {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return errMalformedBody.Wrap(err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return errMalformedBody.Wrap(err)
+	}
+	return nil
+}
+
+// ParseID : ID of the organization in the :id path parameter
+func ParseID(c *gin.Context) (uint64, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, errInvalidID
+	}
+	return id, nil
+}
+
+// CreateOrganization godoc
+// @Summary Create an organization
+// @Description Creates an organization owned by the authenticated user. The slug is derived from the name when missing.
+// @Tags Organization
+// @Accept  json
+// @Produce  json
+// @Param json body CreateOrganizationPayload true "name and slug"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param Idempotency-Key header string false "Unique key of the request, retries with the same key get the first response replayed"
+// @Success 201 {object} Organization
+// @Failure 401 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /orgs [post]
+// CreateOrganization : Creates an organization
+func (h *handler) CreateOrganization(c *gin.Context) {
+	uid, err := auth.UserID(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(auth.ErrUnauthenticated.Wrap(err), "pkg.org.handler.CreateOrganization"))
+		return
+	}
+	var p CreateOrganizationPayload
+	if err := decode(c, &p); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.org.handler.CreateOrganization"))
+		return
+	}
+	created, err := h.service.CreateOrganization(c.Request.Context(), uid, p)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.org.handler.CreateOrganization"))
+		return
+	}
+	c.Header("Location", c.Request.URL.Path+"/"+strconv.FormatUint(created.ID, 10))
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetOrganization godoc
+// @Summary Get an organization
+// @Description Members only
+// @Tags Organization
+// @Produce  json
+// @Param id path int true "Organization ID"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 200 {object} Organization
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /orgs/{id} [get]
+// GetOrganization : Finds an organization
+func (h *handler) GetOrganization(c *gin.Context) {
+	id, err := ParseID(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.org.handler.GetOrganization"))
+		return
+	}
+	o, err := h.service.GetOrganization(c.Request.Context(), id)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.org.handler.GetOrganization"))
+		return
+	}
+	c.JSON(http.StatusOK, o)
+}
+
+// UpdateOrganization godoc
+// @Summary Update an organization
+// @Description Admins and the owner only, the missing fields are kept
+// @Tags Organization
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Organization ID"
+// @Param json body UpdateOrganizationPayload true "name and slug"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 200 {object} Organization
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /orgs/{id} [patch]
+// UpdateOrganization : Changes the name or the slug of an organization
+func (h *handler) UpdateOrganization(c *gin.Context) {
+	id, err := ParseID(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.org.handler.UpdateOrganization"))
+		return
+	}
+	var p UpdateOrganizationPayload
+	if err := decode(c, &p); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.org.handler.UpdateOrganization"))
+		return
+	}
+	updated, err := h.service.UpdateOrganization(c.Request.Context(), id, p)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.org.handler.UpdateOrganization"))
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteOrganization godoc
+// @Summary Delete an organization
+// @Description The owner only, the memberships and the invitations are deleted as well
+// @Tags Organization
+// @Param id path int true "Organization ID"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /orgs/{id} [delete]
+// DeleteOrganization : Deletes an organization
+func (h *handler) DeleteOrganization(c *gin.Context) {
+	id, err := ParseID(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.org.handler.DeleteOrganization"))
+		return
+	}
+	if err := h.service.DeleteOrganization(c.Request.Context(), id); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.org.handler.DeleteOrganization"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
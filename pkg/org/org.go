@@ -0,0 +1,48 @@
+// Package org manages the organizations the users belong to, the customers of the product. Who belongs to which
+// organization, and with which role, is up to pkg/membership.
+package org
+
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+)
+
+var (
+	// ErrOrganizationNotFound : Returned by the Repository when no organization matches the lookup
+	ErrOrganizationNotFound = apperrors.NotFound("Organization Not Found")
+	// ErrSlugTaken : Another organization has the slug
+	ErrSlugTaken = apperrors.Conflict("Slug already taken", apperrors.FieldError{Field: "slug", Message: "is already taken"})
+)
+
+// Organization : Customer account the users are members of
+type Organization struct {
+	ID        uint64    `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"` // Unique within the tenant, URL friendly
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateOrganizationPayload : The slug is derived from the name when missing
+type CreateOrganizationPayload struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// UpdateOrganizationPayload : The fields to change, the missing ones are kept
+type UpdateOrganizationPayload struct {
+	Name *string `json:"name"`
+	Slug *string `json:"slug"`
+}
+
+// Repository : Storage of the organizations. Implementations return ErrOrganizationNotFound for missing
+// organizations and ErrSlugTaken when the slug is taken.
+type Repository interface {
+	// CreateOrganization : Creates the organization along with the membership of its owner, see membership.RoleOwner
+	CreateOrganization(ctx context.Context, o *Organization, ownerID uint64) (*Organization, error)
+	GetOrganization(context.Context, uint64) (*Organization, error)
+	UpdateOrganization(context.Context, *Organization) (*Organization, error) // Writes the name and the slug
+	DeleteOrganization(context.Context, uint64) error                         // Deletes its memberships and invitations as well
+}
@@ -0,0 +1,117 @@
+package org
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+)
+
+const (
+	maxNameLength = 100
+	minSlugLength = 3
+	maxSlugLength = 40
+)
+
+var (
+	// slugPattern : Lowercase words separated by single dashes
+	slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	// notSlug : Runs of characters a slug can't hold, replaced by a dash when deriving one
+	notSlug = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Service : Organizations. Who may act on them is checked by the callers, see membership.RequireRole.
+type Service interface {
+	CreateOrganization(ctx context.Context, ownerID uint64, p CreateOrganizationPayload) (*Organization, error)
+	GetOrganization(context.Context, uint64) (*Organization, error)
+	UpdateOrganization(ctx context.Context, id uint64, p UpdateOrganizationPayload) (*Organization, error)
+	DeleteOrganization(context.Context, uint64) error
+}
+
+type service struct {
+	repo Repository
+}
+
+// NewService : Service of the organizations stored in repo
+func NewService(repo Repository) Service {
+	return &service{repo}
+}
+
+// CreateOrganization : Creates the organization, owned by ownerID
+func (s *service) CreateOrganization(ctx context.Context, ownerID uint64, p CreateOrganizationPayload) (*Organization, error) {
+	o := &Organization{Name: strings.TrimSpace(p.Name), Slug: strings.TrimSpace(p.Slug)}
+	if o.Slug == "" {
+		o.Slug = slugOf(o.Name)
+	}
+	if err := validate(o); err != nil {
+		return nil, err
+	}
+	created, err := s.repo.CreateOrganization(ctx, o, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).WithField("org_id", created.ID).Info("Organization created")
+	return created, nil
+}
+
+// GetOrganization : Finds an organization by ID
+func (s *service) GetOrganization(ctx context.Context, id uint64) (*Organization, error) {
+	return s.repo.GetOrganization(ctx, id)
+}
+
+// UpdateOrganization : Changes the name or the slug of the organization
+func (s *service) UpdateOrganization(ctx context.Context, id uint64, p UpdateOrganizationPayload) (*Organization, error) {
+	o, err := s.repo.GetOrganization(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Name != nil {
+		o.Name = strings.TrimSpace(*p.Name)
+	}
+	if p.Slug != nil {
+		o.Slug = strings.TrimSpace(*p.Slug)
+	}
+	if err := validate(o); err != nil {
+		return nil, err
+	}
+	return s.repo.UpdateOrganization(ctx, o)
+}
+
+// DeleteOrganization : Deletes the organization, its members lose access to it
+func (s *service) DeleteOrganization(ctx context.Context, id uint64) error {
+	if err := s.repo.DeleteOrganization(ctx, id); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).WithField("org_id", id).Info("Organization deleted")
+	return nil
+}
+
+// validate : apperrors.Validation listing the invalid fields of o
+func validate(o *Organization) error {
+	var fields []apperrors.FieldError
+	if n := utf8.RuneCountInString(o.Name); n == 0 || n > maxNameLength {
+		fields = append(fields, apperrors.FieldError{Field: "name", Message: "must be between 1 and 100 characters long"})
+	}
+	if len(o.Slug) < minSlugLength || len(o.Slug) > maxSlugLength || !slugPattern.MatchString(o.Slug) {
+		fields = append(fields, apperrors.FieldError{
+			Field: "slug", Message: "must be 3 to 40 lowercase letters and digits, words separated by dashes",
+		})
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation("Invalid organization", fields...)
+	}
+	return nil
+}
+
+// slugOf : Slug derived from the name, e.g. "Acme Corp." becomes acme-corp. Names without any ASCII letter or
+// digit make invalid slugs, which have to be given.
+func slugOf(name string) string {
+	slug := strings.Trim(notSlug.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	return slug
+}
@@ -0,0 +1,228 @@
+package requestctx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Request scoped values carried by the request's context.Context down to the service and repository layers
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userIDKey
+	clientKey
+	orgKey
+	tenantKey
+)
+
+// RequestIDHeader : Header used to propagate the request ID between services
+const RequestIDHeader = "X-Request-ID"
+
+// WithRequestID : Returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID : Request ID carried by ctx, empty if none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithUserID : Returns a copy of ctx carrying the authenticated user's ID
+func WithUserID(ctx context.Context, uid uint64) context.Context {
+	return context.WithValue(ctx, userIDKey, uid)
+}
+
+// UserID : Authenticated user's ID carried by ctx
+func UserID(ctx context.Context) (uint64, bool) {
+	uid, ok := ctx.Value(userIDKey).(uint64)
+	return uid, ok
+}
+
+// Tenants a context carries besides the configured ones
+const (
+	DefaultTenant = "default" // Tenant of the deployments hosting a single one, and of the data predating multi-tenancy
+	AllTenants    = "*"       // Background jobs working across the tenants, e.g. the purge of the deleted users
+)
+
+// WithTenant : Returns a copy of ctx scoped to the tenant, see TenantID
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+// TenantID : Tenant the request acts in, the repositories scope their queries to it and refuse to run without one
+func TenantID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantKey).(string)
+	return id, ok
+}
+
+// Org : Organization a request acts in, and the role of the authenticated user in it
+type Org struct {
+	ID   uint64
+	Role string
+}
+
+// WithOrg : Returns a copy of ctx acting in the organization
+func WithOrg(ctx context.Context, org Org) context.Context {
+	return context.WithValue(ctx, orgKey, org)
+}
+
+// OrgOf : Organization carried by ctx, false if the request doesn't act in one
+func OrgOf(ctx context.Context) (Org, bool) {
+	org, ok := ctx.Value(orgKey).(Org)
+	return org, ok
+}
+
+// Client : Where a request comes from
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// WithClient : Returns a copy of ctx carrying the request's client
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey, client)
+}
+
+// ClientOf : Client carried by ctx, empty if none
+func ClientOf(ctx context.Context) Client {
+	client, _ := ctx.Value(clientKey).(Client)
+	return client
+}
+
+// trustedProxies : Networks of the reverse proxies whose forwarding headers are believed, see SetTrustedProxies
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies : Addresses or CIDR ranges of the reverse proxies in front of the service, none by default.
+// ClientIP only honours X-Forwarded-For and X-Real-Ip on the requests coming from them, anyone can forge the headers.
+func SetTrustedProxies(proxies []string) error {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		network, err := ParseNetwork(proxy)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, network)
+	}
+	trustedProxies = networks
+	return nil
+}
+
+// ParseNetwork : Network of a CIDR range, or of a single address
+func ParseNetwork(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: s}
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP : Address of the client. Behind trusted proxies (see SetTrustedProxies) it's the last X-Forwarded-For
+// address not belonging to one, as the ones before it may have been made up by the client, else X-Real-Ip.
+// Otherwise it's the peer's address, whatever the headers say.
+func ClientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return ""
+	}
+	if !trusted(peer) {
+		return peer
+	}
+	var first string
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			continue
+		}
+		if !trusted(ip) {
+			return ip
+		}
+		first = ip
+	}
+	if first != "" {
+		return first
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-Ip")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return peer
+}
+
+const maxRequestIDLength = 128
+
+// Middleware : Propagates the caller's X-Request-ID (or generates one) into the request's context and the response,
+// along with the client
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := ValidOrNew(c.GetHeader(RequestIDHeader))
+		ctx := WithRequestID(c.Request.Context(), id)
+		ctx = WithClient(ctx, Client{IP: ClientIP(c.Request), UserAgent: c.Request.UserAgent()})
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// ValidOrNew : The caller's request ID when it is acceptable, a new one otherwise
+func ValidOrNew(id string) string {
+	if !validRequestID(id) {
+		return NewRequestID()
+	}
+	return id
+}
+
+// validRequestID : Incoming IDs end up in every log line, only accept short tokens made of safe characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestID : Random 128 bit request ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,51 @@
+package requestctx
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTrustedProxies(nil)
+
+	for _, tc := range []struct {
+		name, remote, forwarded, realIP, want string
+	}{
+		{"direct", "203.0.113.7:1234", "", "", "203.0.113.7"},
+		{"forged by an untrusted peer", "203.0.113.7:1234", "1.2.3.4", "5.6.7.8", "203.0.113.7"},
+		{"behind a trusted proxy", "10.1.2.3:1234", "198.51.100.9", "", "198.51.100.9"},
+		{"client prepending a forged address", "10.1.2.3:1234", "1.2.3.4, 198.51.100.9", "", "198.51.100.9"},
+		{"chain of trusted proxies", "10.1.2.3:1234", "198.51.100.9, 192.168.1.1, 10.9.9.9", "", "198.51.100.9"},
+		{"garbage skipped", "10.1.2.3:1234", "198.51.100.9, not-an-ip", "", "198.51.100.9"},
+		{"X-Real-Ip from a trusted proxy", "192.168.1.1:1234", "", "198.51.100.9", "198.51.100.9"},
+		{"trusted proxy without headers", "10.1.2.3:1234", "", "", "10.1.2.3"},
+		{"IPv6 peer", "[2001:db8::1]:1234", "1.2.3.4", "", "2001:db8::1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remote
+			if tc.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-Ip", tc.realIP)
+			}
+			if got := ClientIP(r); got != tc.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxiesRejectsInvalid(t *testing.T) {
+	defer SetTrustedProxies(nil)
+	if err := SetTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	if err := SetTrustedProxies([]string{"proxy.local"}); err == nil {
+		t.Error("expected an error for a host name")
+	}
+}
@@ -0,0 +1,42 @@
+package scim
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errUnauthenticated = apperrors.Unauthorized("Authentication required, expected the SCIM bearer token")
+	errInvalidToken    = apperrors.Unauthorized("Invalid SCIM token")
+)
+
+// Authentication : Requires the SCIM token of the service as a bearer token. The identity provider acts on behalf of
+// every user, the token is unrelated to the users' JWTs and only accepted in the Authorization header. The rejected
+// tokens are recorded by recorder.
+func Authentication(token string, recorder audit.Recorder) gin.HandlerFunc {
+	expected := []byte(token)
+	return func(c *gin.Context) {
+		parts := strings.Fields(c.GetHeader("Authorization"))
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+			abort(c, errUnauthenticated)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(parts[1]), expected) != 1 {
+			logging.FromContext(c.Request.Context()).Warn("Rejected SCIM token")
+			recorder.Record(c.Request.Context(), audit.Record{
+				ActorType: audit.ActorAnonymous,
+				Action:    audit.ActionAuthReject,
+				Outcome:   audit.OutcomeFailure,
+				Reason:    "invalid SCIM token",
+			})
+			abort(c, errInvalidToken)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,89 @@
+package scim
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/gin-gonic/gin"
+)
+
+// scimType values of the error responses, RFC 7644 3.12
+const (
+	scimTypeInvalidFilter = "invalidFilter"
+	scimTypeInvalidSyntax = "invalidSyntax"
+	scimTypeInvalidPath   = "invalidPath"
+	scimTypeNoTarget      = "noTarget"
+	scimTypeInvalidValue  = "invalidValue"
+	scimTypeMutability    = "mutability"
+	scimTypeUniqueness    = "uniqueness"
+)
+
+// typedError : Domain error along with the scimType describing it
+type typedError struct {
+	scimType string
+	err      *apperrors.Error
+}
+
+func (e *typedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *typedError) Unwrap() error {
+	return e.err
+}
+
+// withType : err reported with the given scimType
+func withType(scimType string, err *apperrors.Error) error {
+	return &typedError{scimType: scimType, err: err}
+}
+
+// errorResponse : SCIM error response body, RFC 7644 3.12
+type errorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// abort : SCIM counterpart of problem.Abort, rendering err right away in the SCIM error format. Like problem.New only
+// the domain errors disclose their message, the invalid values are reported with a 400 rather than a 422.
+func abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	resp := errorResponse{Schemas: []string{schemaError}, Detail: "Internal error"}
+	status := http.StatusInternalServerError
+	if e, ok := apperrors.As(err); ok && e.Kind != apperrors.KindInternal {
+		status = e.Kind.Status()
+		resp.Detail = e.Message
+		switch e.Kind {
+		case apperrors.KindValidation:
+			status = http.StatusBadRequest
+			resp.ScimType = scimTypeInvalidValue
+			// SCIM errors have no room for the fields, they are spelled out in the detail
+			var fields []string
+			for _, f := range e.Fields {
+				fields = append(fields, f.Field+" "+f.Message)
+			}
+			if len(fields) > 0 {
+				resp.Detail += " : " + strings.Join(fields, ", ")
+			}
+		case apperrors.KindConflict:
+			// Concurrent modifications have no scimType, only the taken values
+			if len(e.Fields) > 0 {
+				resp.ScimType = scimTypeUniqueness
+			}
+		}
+	}
+	var typed *typedError
+	if errors.As(err, &typed) {
+		resp.ScimType = typed.scimType
+	}
+	resp.Status = strconv.Itoa(status)
+	if status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+	}
+	render(c, status, resp)
+	c.Abort()
+}
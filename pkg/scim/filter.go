@@ -0,0 +1,471 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+)
+
+// Filters (RFC 7644 3.4.2.2) are parsed into an expression tree evaluated against the JSON representation of the
+// resources, decoded into a map : the same evaluation serves the Users and the Groups, and the value filters of the
+// PATCH paths.
+
+// Filter : Parsed filter expression, see ParseFilter
+type Filter struct {
+	expr expr
+}
+
+// Condition : Comparison of an attribute with a value, e.g. userName eq "bjensen"
+type Condition struct {
+	Attr  string // Lower cased, without the schema URN
+	Sub   string // Lower cased sub-attribute, e.g. value for emails.value
+	Op    string // Lower cased comparison operator
+	Value interface{}
+}
+
+// ParseFilter : Parses a filter, an invalidFilter error when it is malformed
+func ParseFilter(s string) (Filter, error) {
+	p := new(parser)
+	if err := p.lex(s); err != nil {
+		return Filter{}, err
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return Filter{}, err
+	}
+	if !p.done() {
+		return Filter{}, invalidFilter("unexpected " + p.peek().text)
+	}
+	return Filter{e}, nil
+}
+
+// Matches : Whether the resource, as decoded from its JSON representation, matches the filter
+func (f Filter) Matches(resource map[string]interface{}) bool {
+	return f.expr == nil || f.expr.eval(resource)
+}
+
+// Conditions : Comparisons every matching resource satisfies, those joined by "and" at the top of the filter.
+// They let the filter narrow down the resources fetched before Matches is applied.
+func (f Filter) Conditions() []Condition {
+	var conditions []Condition
+	var walk func(e expr)
+	walk = func(e expr) {
+		switch e := e.(type) {
+		case *logical:
+			if e.op == "and" {
+				walk(e.left)
+				walk(e.right)
+			}
+		case *comparison:
+			conditions = append(conditions, Condition{Attr: e.path.attr, Sub: e.path.sub, Op: e.op, Value: e.value})
+		}
+	}
+	walk(f.expr)
+	return conditions
+}
+
+func invalidFilter(detail string) error {
+	return withType(scimTypeInvalidFilter, apperrors.Validation("Invalid filter : "+detail))
+}
+
+type expr interface {
+	eval(resource map[string]interface{}) bool
+}
+
+// logical : "and" / "or" of two expressions
+type logical struct {
+	op          string
+	left, right expr
+}
+
+func (e *logical) eval(r map[string]interface{}) bool {
+	if e.op == "and" {
+		return e.left.eval(r) && e.right.eval(r)
+	}
+	return e.left.eval(r) || e.right.eval(r)
+}
+
+type negation struct {
+	expr expr
+}
+
+func (e *negation) eval(r map[string]interface{}) bool {
+	return !e.expr.eval(r)
+}
+
+// path : Attribute path, e.g. emails.value
+type path struct {
+	attr string
+	sub  string
+}
+
+// values : Values found at the path, the elements of the multi-valued attributes included. Without a sub-attribute
+// the complex values are compared by their "value".
+func (p path) values(r map[string]interface{}) []interface{} {
+	v, ok := lookup(r, p.attr)
+	if !ok || v == nil {
+		return nil
+	}
+	elems, multi := v.([]interface{})
+	if !multi {
+		elems = []interface{}{v}
+	}
+	sub := p.sub
+	var values []interface{}
+	for _, elem := range elems {
+		complexValue, isComplex := elem.(map[string]interface{})
+		switch {
+		case isComplex && sub == "":
+			if value, ok := lookup(complexValue, "value"); ok {
+				values = append(values, value)
+			}
+		case isComplex:
+			if value, ok := lookup(complexValue, sub); ok {
+				values = append(values, value)
+			}
+		case sub == "":
+			values = append(values, elem)
+		}
+	}
+	return values
+}
+
+// lookup : Attribute of the resource, names are case insensitive
+func lookup(r map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := r[name]; ok {
+		return v, true
+	}
+	for k, v := range r {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// comparison : attrPath op value, or attrPath pr
+type comparison struct {
+	path  path
+	op    string
+	value interface{}
+}
+
+func (e *comparison) eval(r map[string]interface{}) bool {
+	values := e.path.values(r)
+	present := false
+	for _, v := range values {
+		if v != nil && v != "" {
+			present = true
+		}
+	}
+	switch {
+	case e.op == "pr":
+		return present
+	case e.value == nil && e.op == "eq":
+		return !present
+	case e.value == nil && e.op == "ne":
+		return present
+	}
+	for _, v := range values {
+		if compare(v, e.op, e.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare : v op literal. Strings compare case insensitively, as timestamps when both are, and values of different
+// types never match.
+func compare(v interface{}, op string, literal interface{}) bool {
+	switch l := literal.(type) {
+	case bool:
+		b, ok := v.(bool)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return b == l
+		case "ne":
+			return b != l
+		}
+		return false
+	case float64:
+		n, ok := v.(float64)
+		if !ok {
+			return false
+		}
+		return ordered(op, n-l)
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		if t1, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			if t2, err := time.Parse(time.RFC3339Nano, l); err == nil {
+				return ordered(op, float64(t1.Sub(t2)))
+			}
+		}
+		s, l = strings.ToLower(s), strings.ToLower(l)
+		switch op {
+		case "co":
+			return strings.Contains(s, l)
+		case "sw":
+			return strings.HasPrefix(s, l)
+		case "ew":
+			return strings.HasSuffix(s, l)
+		}
+		return ordered(op, float64(strings.Compare(s, l)))
+	}
+	return false
+}
+
+// ordered : Outcome of the comparison operator given the sign of the difference of its operands
+func ordered(op string, diff float64) bool {
+	switch op {
+	case "eq":
+		return diff == 0
+	case "ne":
+		return diff != 0
+	case "gt":
+		return diff > 0
+	case "ge":
+		return diff >= 0
+	case "lt":
+		return diff < 0
+	case "le":
+		return diff <= 0
+	}
+	return false
+}
+
+// valuePath : attr[filter], matching when an element of the multi-valued attribute matches the filter
+type valuePath struct {
+	attr   string
+	filter expr
+}
+
+func (e *valuePath) eval(r map[string]interface{}) bool {
+	v, _ := lookup(r, e.attr)
+	elems, multi := v.([]interface{})
+	if !multi {
+		elems = []interface{}{v}
+	}
+	for _, elem := range elems {
+		if m, ok := elem.(map[string]interface{}); ok && e.filter.eval(m) {
+			return true
+		}
+	}
+	return false
+}
+
+var compareOps = map[string]bool{"eq": true, "ne": true, "co": true, "sw": true, "ew": true, "gt": true, "ge": true, "lt": true, "le": true}
+
+type token struct {
+	text   string
+	quoted bool // JSON string literal, text is its value
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// lex : Splits the filter into words, parentheses, brackets and JSON strings
+func (p *parser) lex(s string) error {
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			p.tokens = append(p.tokens, token{text: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return invalidFilter("unterminated string")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(s[i:end+1]), &value); err != nil {
+				return invalidFilter("invalid string " + s[i:end+1])
+			}
+			p.tokens = append(p.tokens, token{text: value, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && strings.IndexByte(" \t()[]\"", s[end]) < 0 {
+				end++
+			}
+			p.tokens = append(p.tokens, token{text: s[i:end]})
+			i = end
+		}
+	}
+	return nil
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword : Whether the next token is the given unquoted keyword, case insensitively
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	return !t.quoted && strings.EqualFold(t.text, word)
+}
+
+func (p *parser) expect(text string) error {
+	if t := p.next(); t.quoted || t.text != text {
+		if t.text == "" {
+			return invalidFilter("expected " + text + " at the end")
+		}
+		return invalidFilter("expected " + text + ", got " + t.text)
+	}
+	return nil
+}
+
+// parseOr : Lowest precedence, "or"
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	switch {
+	case p.keyword("not"):
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return &negation{e}, p.expect(")")
+	case p.peek().text == "(" && !p.peek().quoted:
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	return p.parseAttrExp()
+}
+
+// parseAttrExp : attrPath pr, attrPath op value, or attrPath[filter]
+func (p *parser) parseAttrExp() (expr, error) {
+	t := p.next()
+	if t.quoted || t.text == "" {
+		return nil, invalidFilter("expected an attribute")
+	}
+	attr, err := parsePath(t.text)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().text == "[" && !p.peek().quoted {
+		if attr.sub != "" {
+			return nil, invalidFilter("unexpected [ after " + t.text)
+		}
+		p.next()
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return &valuePath{attr: attr.attr, filter: filter}, p.expect("]")
+	}
+	opToken := p.next()
+	op := strings.ToLower(opToken.text)
+	if op == "pr" && !opToken.quoted {
+		return &comparison{path: attr, op: op}, nil
+	}
+	if opToken.quoted || !compareOps[op] {
+		return nil, invalidFilter("unknown operator " + opToken.text)
+	}
+	value, err := parseValue(p.next())
+	if err != nil {
+		return nil, err
+	}
+	return &comparison{path: attr, op: op, value: value}, nil
+}
+
+// parseValue : JSON string, number, true, false or null
+func parseValue(t token) (interface{}, error) {
+	if t.quoted {
+		return t.text, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(t.text), &value); err != nil || t.text == "" {
+		return nil, invalidFilter("invalid value " + t.text)
+	}
+	switch value.(type) {
+	case bool, float64, nil:
+		return value, nil
+	}
+	return nil, invalidFilter("invalid value " + t.text)
+}
+
+// parsePath : Attribute path, without the URN of the schema if any (e.g. urn:...:User:userName)
+func parsePath(s string) (path, error) {
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		if !strings.HasPrefix(strings.ToLower(s), "urn:") {
+			return path{}, invalidFilter("invalid attribute " + s)
+		}
+		s = s[i+1:]
+	}
+	parts := strings.Split(strings.ToLower(s), ".")
+	if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+		return path{}, invalidFilter("invalid attribute " + s)
+	}
+	p := path{attr: parts[0]}
+	if len(parts) == 2 {
+		p.sub = parts[1]
+	}
+	return p, nil
+}
@@ -0,0 +1,153 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// scimTypeOf : scimType the error is reported with, empty when it has none
+func scimTypeOf(err error) string {
+	var typed *typedError
+	if errors.As(err, &typed) {
+		return typed.scimType
+	}
+	return ""
+}
+
+const testUserResource = `{
+	"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+	"id": "42",
+	"userName": "bjensen",
+	"active": true,
+	"emails": [
+		{"value": "bjensen@example.com", "type": "work", "primary": true},
+		{"value": "babs@jensen.org", "type": "home"}
+	],
+	"meta": {"resourceType": "User", "created": "2020-01-23T04:56:22Z", "lastModified": "2020-03-01T10:00:00Z"}
+}`
+
+const testGroupResource = `{
+	"id": "7",
+	"displayName": "Tour Guides",
+	"members": [{"value": "42", "type": "User"}, {"value": "43", "type": "User"}]
+}`
+
+func decodeResource(t *testing.T, s string) map[string]interface{} {
+	var r map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestFilterMatches(t *testing.T) {
+	u := decodeResource(t, testUserResource)
+	g := decodeResource(t, testGroupResource)
+	for _, tc := range []struct {
+		name     string
+		filter   string
+		resource map[string]interface{}
+		match    bool
+	}{
+		{"eq", `userName eq "bjensen"`, u, true},
+		{"eq is case insensitive", `UserName Eq "BJensen"`, u, true},
+		{"eq no match", `userName eq "jsmith"`, u, false},
+		{"ne", `userName ne "jsmith"`, u, true},
+		{"co", `userName co "jen"`, u, true},
+		{"co no match", `userName co "smith"`, u, false},
+		{"sw", `userName sw "bj"`, u, true},
+		{"sw no match", `userName sw "jen"`, u, false},
+		{"ew", `userName ew "sen"`, u, true},
+		{"ew no match", `userName ew "bj"`, u, false},
+		{"ew on a multi-valued attribute", `emails.value ew "@jensen.org"`, u, true},
+		{"gt timestamps", `meta.lastModified gt "2020-02-01T00:00:00Z"`, u, true},
+		{"lt timestamps", `meta.created lt "2020-01-01T00:00:00Z"`, u, false},
+		{"boolean", `active eq true`, u, true},
+		{"boolean no match", `active eq false`, u, false},
+		{"pr", `userName pr`, u, true},
+		{"pr missing", `nickName pr`, u, false},
+		{"eq null", `nickName eq null`, u, true},
+		{"urn prefix", `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, u, true},
+		{"complex attribute compared by value", `emails eq "babs@jensen.org"`, u, true},
+		{"and", `userName eq "bjensen" and active eq true`, u, true},
+		{"and no match", `userName eq "bjensen" and active eq false`, u, false},
+		{"or", `userName eq "jsmith" or emails.type eq "home"`, u, true},
+		{"or no match", `userName eq "jsmith" or emails.type eq "other"`, u, false},
+		{"and binds tighter than or", `userName eq "jsmith" and active eq true or userName sw "b"`, u, true},
+		{"parentheses", `userName eq "jsmith" and (active eq true or userName sw "b")`, u, false},
+		{"not", `not (userName eq "jsmith")`, u, true},
+		{"not no match", `not (userName eq "bjensen" or active eq false)`, u, false},
+		{"value path", `members[value eq "42"]`, g, true},
+		{"value path no match", `members[value eq "44"]`, g, false},
+		{"value path with and", `emails[type eq "work" and value ew "@example.com"]`, u, true},
+		{"value path elements match alone", `emails[type eq "home" and value ew "@example.com"]`, u, false},
+		{"value path combined", `displayName sw "tour" and members[value eq "43"]`, g, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ParseFilter(tc.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q) = %v", tc.filter, err)
+			}
+			if match := f.Matches(tc.resource); match != tc.match {
+				t.Errorf("Matches() = %v, want %v", match, tc.match)
+			}
+		})
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName is "bjensen"`,
+		`userName eq bjensen`,
+		`userName eq "bjensen`,
+		`(userName eq "bjensen"`,
+		`userName eq "bjensen")`,
+		`not userName eq "bjensen"`,
+		`userName eq "bjensen" and`,
+		`members[value eq "42"`,
+		`emails.value[type eq "work"]`,
+		`name.givenName.x eq "Barbara"`,
+		`schemas:userName eq "bjensen"`,
+		`"userName" eq "bjensen"`,
+	} {
+		t.Run(filter, func(t *testing.T) {
+			if _, err := ParseFilter(filter); scimTypeOf(err) != scimTypeInvalidFilter {
+				t.Errorf("ParseFilter(%q) = %v, want an %s error", filter, err, scimTypeInvalidFilter)
+			}
+		})
+	}
+}
+
+func TestFilterConditions(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		filter string
+		want   []Condition
+	}{
+		{"comparison", `userName eq "bjensen"`, []Condition{{Attr: "username", Op: "eq", Value: "bjensen"}}},
+		{"and", `emails.value ew "@example.com" and active eq true`, []Condition{
+			{Attr: "emails", Sub: "value", Op: "ew", Value: "@example.com"},
+			{Attr: "active", Op: "eq", Value: true},
+		}},
+		{"or isn't narrowed down", `userName eq "bjensen" or userName eq "jsmith"`, nil},
+		{"not isn't narrowed down", `not (userName eq "bjensen")`, nil},
+		{"and of an or", `active eq true and (userName eq "bjensen" or userName eq "jsmith")`, []Condition{
+			{Attr: "active", Op: "eq", Value: true},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ParseFilter(tc.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q) = %v", tc.filter, err)
+			}
+			if got := f.Conditions(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Conditions() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package scim
+
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+)
+
+var (
+	// ErrGroupNotFound : Returned by the GroupRepository when no group matches the lookup
+	ErrGroupNotFound = apperrors.NotFound("Group Not Found")
+	// ErrGroupNameTaken : Another group has the display name
+	ErrGroupNameTaken = apperrors.Conflict("Group name already taken", apperrors.FieldError{Field: "displayName", Message: "is already taken"})
+	// ErrGroupVersionConflict : The group changed since the version the write was based on
+	ErrGroupVersionConflict = apperrors.Conflict("The group was modified concurrently, fetch it again and retry")
+)
+
+// Group : Group of users provisioned by an identity provider. Groups only exist for SCIM, the rest of the API
+// doesn't use them.
+type Group struct {
+	ID          uint64
+	DisplayName string
+	Members     []uint64 // IDs of the member users
+	Version     uint64   // Bumped by every write
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// GroupRepository : Storage of the groups. Writes bump the group's version, given a non-zero version they
+// compare-and-swap and return ErrGroupVersionConflict when the stored version differs.
+type GroupRepository interface {
+	CreateGroup(context.Context, *Group) (*Group, error) // ErrGroupNameTaken when the display name is taken
+	GetGroup(context.Context, uint64) (*Group, error)
+	ListGroups(ctx context.Context, afterID uint64, limit int) ([]Group, error) // Sorted by ID
+	UpdateGroup(context.Context, *Group) (*Group, error)                        // Replaces the display name and the members
+	DeleteGroup(context.Context, uint64) error
+	RemoveMembers(ctx context.Context, uids []uint64) error // Drops the users from every group, a user.PurgeHook
+}
@@ -0,0 +1,866 @@
+package scim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	hashing "github.com/LuD1161/restructuring-tnbt/pkg/utils"
+	"github.com/gin-gonic/gin"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// maxResults : Most resources returned by a listing, advertised in the ServiceProviderConfig
+const maxResults = user.MaxPageSize
+
+// patchAttempts : A PATCH without If-Match is applied again when another write wins the race
+const patchAttempts = 3
+
+// Handler : SCIM 2.0 service provider (RFC 7643 / 7644) provisioning the users of a user.Repository, and groups of
+// them. Errors are rendered in the SCIM format rather than by problem.Middleware.
+type Handler interface {
+	ServiceProviderConfig(c *gin.Context)
+	ResourceTypes(c *gin.Context)
+	Schemas(c *gin.Context)
+	Schema(c *gin.Context)
+
+	ListUsers(c *gin.Context)
+	GetUser(c *gin.Context)
+	CreateUser(c *gin.Context)
+	ReplaceUser(c *gin.Context)
+	PatchUser(c *gin.Context)
+	DeleteUser(c *gin.Context)
+
+	ListGroups(c *gin.Context)
+	GetGroup(c *gin.Context)
+	CreateGroup(c *gin.Context)
+	ReplaceGroup(c *gin.Context)
+	PatchGroup(c *gin.Context)
+	DeleteGroup(c *gin.Context)
+}
+
+type handler struct {
+	users    user.Repository
+	groups   GroupRepository
+	basePath string
+	recorder audit.Recorder
+}
+
+// NewHandler : Handler serving the SCIM endpoints under basePath (e.g. /scim/v2), which the resources' locations
+// are built with. The writes to the users are recorded by recorder, on behalf of the identity provider.
+func NewHandler(users user.Repository, groups GroupRepository, basePath string, recorder audit.Recorder) Handler {
+	return &handler{users: users, groups: groups, basePath: strings.TrimSuffix(basePath, "/"), recorder: recorder}
+}
+
+// record : Appends the audit record of a write to a user by the identity provider, failed when err isn't nil
+func (h *handler) record(ctx context.Context, action string, uid uint64, username string, changes audit.Changes, err error) {
+	r := audit.Record{
+		ActorType:  audit.ActorSCIM,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   uid,
+		TargetName: username,
+		Changes:    changes,
+	}
+	if err != nil {
+		r.Outcome, r.Reason = audit.OutcomeFailure, audit.Reason(err)
+	}
+	h.recorder.Record(ctx, r)
+}
+
+var (
+	errEmailRequired    = apperrors.Validation("An email is required")
+	errUserNameRequired = apperrors.Validation("userName is required")
+	errNameRequired     = apperrors.Validation("displayName is required")
+	errSchemaNotFound   = apperrors.NotFound("Schema Not Found")
+	errPrecondition     = apperrors.PreconditionFailed("The resource changed since it was fetched, If-Match doesn't match its version")
+)
+
+// page : startIndex (1-based) and count of a listing, RFC 7644 3.4.2.4
+func page(c *gin.Context) (int, int, error) {
+	startIndex, count := 1, maxResults
+	var fields []apperrors.FieldError
+	if v := c.Query("startIndex"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			fields = append(fields, apperrors.FieldError{Field: "startIndex", Message: "must be an integer"})
+		} else if n > 1 {
+			startIndex = n
+		}
+	}
+	if v := c.Query("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		switch {
+		case err != nil:
+			fields = append(fields, apperrors.FieldError{Field: "count", Message: "must be an integer"})
+		case n < 0:
+			count = 0
+		case n < maxResults:
+			count = n
+		}
+	}
+	if len(fields) > 0 {
+		return 0, 0, apperrors.Validation("Invalid pagination", fields...)
+	}
+	return startIndex, count, nil
+}
+
+// collector : Gathers the page of the matching resources while counting them all
+type collector struct {
+	startIndex, count int
+	total             int
+	resources         []interface{}
+}
+
+func (p *collector) add(resource interface{}) {
+	p.total++
+	if p.total >= p.startIndex && len(p.resources) < p.count {
+		p.resources = append(p.resources, resource)
+	}
+}
+
+func (p *collector) render(c *gin.Context) {
+	resources := p.resources
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	render(c, http.StatusOK, listResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: p.total,
+		StartIndex:   p.startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// versionError : A conditional write losing the race to another one gets a 412 like a stale If-Match
+func versionError(c *gin.Context, err error) error {
+	if c.GetHeader("If-Match") != "" && (errors.Is(err, user.ErrVersionConflict) || errors.Is(err, ErrGroupVersionConflict)) {
+		return errPrecondition.Wrap(err)
+	}
+	return err
+}
+
+// ListUsers : GET /Users, the filter is narrowed down to the repository's lookups and listing filters when it can be
+func (h *handler) ListUsers(c *gin.Context) {
+	startIndex, count, err := page(c)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ListUsers"))
+		return
+	}
+	filter, err := ParseFilter(c.Query("filter"))
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ListUsers"))
+		return
+	}
+	p := &collector{startIndex: startIndex, count: count}
+	match := func(u *user.User) {
+		resource := h.userResource(c, u)
+		if filter.Matches(asMap(resource)) {
+			p.add(resource)
+		}
+	}
+	ctx := c.Request.Context()
+
+	if u, ok, err := h.lookupUser(ctx, filter); ok {
+		if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+			abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ListUsers"))
+			return
+		}
+		if err == nil {
+			match(u)
+		}
+		p.render(c)
+		return
+	}
+
+	q := user.ListQuery{Filter: listFilter(filter), Sort: "id", Limit: user.MaxPageSize}
+	for {
+		page, err := h.users.ListUsers(ctx, q)
+		if err != nil {
+			abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ListUsers"))
+			return
+		}
+		for i := range page.Users {
+			match(&page.Users[i])
+		}
+		if page.Next == nil {
+			break
+		}
+		q.After = page.Next
+	}
+	p.render(c)
+}
+
+// lookupUser : The only user a filter can match when it requires an equality on the id, the userName or the email,
+// fetched directly. The lookups are case sensitive, like the unique constraints.
+func (h *handler) lookupUser(ctx context.Context, filter Filter) (*user.User, bool, error) {
+	for _, cond := range filter.Conditions() {
+		value, isString := cond.Value.(string)
+		if cond.Op != "eq" || !isString {
+			continue
+		}
+		switch {
+		case cond.Attr == "id" && cond.Sub == "":
+			uid, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, true, user.ErrUserNotFound
+			}
+			u, err := h.users.GetUserByID(ctx, uid)
+			return u, true, err
+		case cond.Attr == "username" && cond.Sub == "":
+			u, err := h.users.GetUserByUsername(ctx, value)
+			return u, true, err
+		case cond.Attr == "emails" && (cond.Sub == "" || cond.Sub == "value"):
+			u, err := h.users.GetUserByEmail(ctx, value)
+			return u, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// listFilter : Listing filter every user matching the filter satisfies, the filter itself is still applied after
+func listFilter(filter Filter) user.ListFilter {
+	var f user.ListFilter
+	for _, cond := range filter.Conditions() {
+		switch value := cond.Value.(type) {
+		case bool:
+			if cond.Attr == "active" && cond.Op == "eq" {
+				f.Status = user.StatusActive
+				if !value {
+					f.Status = user.StatusDisabled
+				}
+			}
+		case string:
+			switch {
+			case cond.Attr == "emails" && (cond.Sub == "" || cond.Sub == "value") && cond.Op == "ew" &&
+				strings.Count(value, "@") == 1 && strings.HasPrefix(value, "@"):
+				f.EmailDomain = strings.TrimPrefix(value, "@")
+			case cond.Attr == "meta" && cond.Sub == "created":
+				t, err := time.Parse(time.RFC3339Nano, value)
+				if err != nil {
+					continue
+				}
+				switch cond.Op {
+				case "gt", "ge":
+					f.CreatedAfter = t
+				case "lt":
+					f.CreatedBefore = t
+				}
+			}
+		}
+	}
+	return f
+}
+
+// GetUser : GET /Users/:id
+func (h *handler) GetUser(c *gin.Context) {
+	uid, err := parseID(c, user.ErrUserNotFound)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.GetUser"))
+		return
+	}
+	u, err := h.users.GetUserByID(c.Request.Context(), uid)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.GetUser"))
+		return
+	}
+	h.renderUser(c, http.StatusOK, u)
+}
+
+func (h *handler) renderUser(c *gin.Context, status int, u *user.User) {
+	resource := h.userResource(c, u)
+	c.Header("ETag", resource.Meta.Version)
+	if status == http.StatusCreated {
+		c.Header("Location", resource.Meta.Location)
+	}
+	render(c, status, resource)
+}
+
+// userState : Attributes of a user which SCIM writes
+type userState struct {
+	UserName string
+	Email    string
+	Active   bool
+	Password *string
+}
+
+func stateOf(u *user.User) userState {
+	return userState{UserName: u.Username, Email: u.Email, Active: u.Status != user.StatusDisabled}
+}
+
+// apply : Overwrites the state with the attributes of the resource, those it lacks are kept
+func (s *userState) apply(r UserResource) {
+	s.UserName = strings.TrimSpace(r.UserName)
+	if email, ok := primaryEmail(r.Emails); ok {
+		s.Email = email
+	}
+	if r.Active != nil {
+		s.Active = *r.Active
+	}
+	if r.Password != "" {
+		s.Password = &r.Password
+	}
+}
+
+// primaryEmail : The primary email, or the first one when none is
+func primaryEmail(emails []Email) (string, bool) {
+	for _, e := range emails {
+		if e.Primary {
+			return strings.TrimSpace(e.Value), true
+		}
+	}
+	if len(emails) > 0 {
+		return strings.TrimSpace(emails[0].Value), true
+	}
+	return "", false
+}
+
+func (s userState) validate() error {
+	if s.UserName == "" {
+		return errUserNameRequired
+	}
+	if s.Email == "" {
+		return errEmailRequired
+	}
+	return user.ProfileUpdate{Username: s.UserName, Email: s.Email, Password: s.Password}.Validate()
+}
+
+func (s userState) status() string {
+	if s.Active {
+		return user.StatusActive
+	}
+	return user.StatusDisabled
+}
+
+// CreateUser : POST /Users. Users provisioned without a password get a random one, they sign in through other means
+// (e.g. client certificates) until they set one.
+func (h *handler) CreateUser(c *gin.Context) {
+	var resource UserResource
+	if err := decode(c, &resource); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.CreateUser"))
+		return
+	}
+	state := userState{Active: true}
+	state.apply(resource)
+	if err := state.validate(); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.CreateUser"))
+		return
+	}
+	ctx := c.Request.Context()
+	if err := h.checkAvailable(ctx, 0, state.UserName, state.Email); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.CreateUser"))
+		return
+	}
+	password := state.Password
+	if password == nil {
+		random, err := randomPassword()
+		if err != nil {
+			abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.CreateUser"))
+			return
+		}
+		password = &random
+	}
+	hash, err := hashing.Hash(*password)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.CreateUser"))
+		return
+	}
+	u := &user.User{Password: string(hash)}
+	u.Username, u.Email, u.Status, u.Version = state.UserName, state.Email, state.status(), 1
+	created, err := h.users.CreateUser(ctx, u)
+	if err != nil {
+		h.record(ctx, audit.ActionUserCreate, 0, u.Username, nil, err)
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.CreateUser"))
+		return
+	}
+	h.record(ctx, audit.ActionUserCreate, created.ID, created.Username, audit.Diff(nil, created), nil)
+	logging.FromContext(ctx).WithField("user_id", created.ID).Info("User provisioned through SCIM")
+	h.renderUser(c, http.StatusCreated, created)
+}
+
+// ReplaceUser : PUT /Users/:id, active and emails are kept when the resource lacks them
+func (h *handler) ReplaceUser(c *gin.Context) {
+	uid, err := parseID(c, user.ErrUserNotFound)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ReplaceUser"))
+		return
+	}
+	var resource UserResource
+	if err := decode(c, &resource); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ReplaceUser"))
+		return
+	}
+	current, err := h.currentUser(c, uid)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ReplaceUser"))
+		return
+	}
+	state := stateOf(current)
+	state.apply(resource)
+	updated, err := h.updateUser(c.Request.Context(), current, state)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(versionError(c, err), "pkg.scim.handler.ReplaceUser"))
+		return
+	}
+	h.renderUser(c, http.StatusOK, updated)
+}
+
+// PatchUser : PATCH /Users/:id
+func (h *handler) PatchUser(c *gin.Context) {
+	uid, err := parseID(c, user.ErrUserNotFound)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchUser"))
+		return
+	}
+	var req patchRequest
+	if err := decode(c, &req); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchUser"))
+		return
+	}
+	if err := req.check(); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchUser"))
+		return
+	}
+	var updated *user.User
+	for attempt := 1; ; attempt++ {
+		current, err := h.currentUser(c, uid)
+		if err != nil {
+			abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchUser"))
+			return
+		}
+		state := stateOf(current)
+		if err := req.applyUser(&state); err != nil {
+			abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchUser"))
+			return
+		}
+		updated, err = h.updateUser(c.Request.Context(), current, state)
+		if errors.Is(err, user.ErrVersionConflict) && c.GetHeader("If-Match") == "" && attempt < patchAttempts {
+			continue
+		}
+		if err != nil {
+			abort(c, pkgerrors.Wrap(versionError(c, err), "pkg.scim.handler.PatchUser"))
+			return
+		}
+		break
+	}
+	h.renderUser(c, http.StatusOK, updated)
+}
+
+// currentUser : User uid, errPrecondition when it doesn't have the version required by If-Match
+func (h *handler) currentUser(c *gin.Context, uid uint64) (*user.User, error) {
+	required, err := ifMatch(c)
+	if err != nil {
+		return nil, err
+	}
+	u, err := h.users.GetUserByID(c.Request.Context(), uid)
+	if err != nil {
+		return nil, err
+	}
+	if required != 0 && required != u.Version {
+		return nil, errPrecondition
+	}
+	return u, nil
+}
+
+// updateUser : Writes the attributes of the state which differ from current, based on current's version
+func (h *handler) updateUser(ctx context.Context, current *user.User, state userState) (*user.User, error) {
+	if err := state.validate(); err != nil {
+		return nil, err
+	}
+	u := new(user.User)
+	u.ID, u.Version = current.ID, current.Version
+	if state.UserName != current.Username {
+		u.Username = state.UserName
+	}
+	if state.Email != current.Email {
+		u.Email = state.Email
+	}
+	if state.status() != current.Status {
+		u.Status = state.status()
+	}
+	if u.Username == "" && u.Email == "" && u.Status == "" && state.Password == nil {
+		return current, nil
+	}
+	if err := h.checkAvailable(ctx, current.ID, u.Username, u.Email); err != nil {
+		return nil, err
+	}
+	if state.Password != nil {
+		hash, err := hashing.Hash(*state.Password)
+		if err != nil {
+			return nil, err
+		}
+		u.Password = string(hash)
+	}
+	updated, err := h.users.UpdateUser(ctx, u)
+	if err != nil {
+		h.record(ctx, audit.ActionUserUpdate, current.ID, current.Username, nil, err)
+		return nil, err
+	}
+	h.record(ctx, audit.ActionUserUpdate, updated.ID, updated.Username, audit.Diff(current, updated), nil)
+	if u.Status != "" {
+		logging.FromContext(ctx).WithFields(map[string]interface{}{"user_id": u.ID, "status": u.Status}).Info("User status changed through SCIM")
+	}
+	return updated, nil
+}
+
+// checkAvailable : user.ErrUsernameTaken or user.ErrEmailTaken when another user than uid has the username or email.
+// Friendlier than waiting for the unique constraints, which still settle the races.
+func (h *handler) checkAvailable(ctx context.Context, uid uint64, username, email string) error {
+	for _, check := range []struct {
+		value  string
+		lookup func(context.Context, string) (*user.User, error)
+		taken  error
+	}{
+		{username, h.users.GetUserByUsername, user.ErrUsernameTaken},
+		{email, h.users.GetUserByEmail, user.ErrEmailTaken},
+	} {
+		if check.value == "" {
+			continue
+		}
+		other, err := check.lookup(ctx, check.value)
+		if errors.Is(err, user.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if other.ID != uid {
+			return check.taken
+		}
+	}
+	return nil
+}
+
+// randomPassword : Password nobody knows, for the users provisioned without one
+func randomPassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// DeleteUser : DELETE /Users/:id, a soft delete like DELETE /user/:id : the account can still be restored until it is
+// purged
+func (h *handler) DeleteUser(c *gin.Context) {
+	uid, err := parseID(c, user.ErrUserNotFound)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.DeleteUser"))
+		return
+	}
+	required, err := ifMatch(c)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.DeleteUser"))
+		return
+	}
+	ctx := c.Request.Context()
+	// Only for the record, the delete itself settles whether the user exists
+	before, _ := h.users.GetUserByID(ctx, uid)
+	deleted, err := h.users.DeleteUser(ctx, uid, required)
+	if err == nil && deleted == 0 {
+		err = user.ErrUserNotFound
+	}
+	username, changes := "", audit.Changes(nil)
+	if before != nil {
+		username = before.Username
+		if err == nil {
+			changes = audit.Diff(before, nil)
+		}
+	}
+	h.record(ctx, audit.ActionUserDelete, uid, username, changes, err)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(versionError(c, err), "pkg.scim.handler.DeleteUser"))
+		return
+	}
+	logging.FromContext(ctx).WithField("user_id", uid).Info("User deprovisioned through SCIM")
+	c.Status(http.StatusNoContent)
+}
+
+// ListGroups : GET /Groups, the filter is applied to every group
+func (h *handler) ListGroups(c *gin.Context) {
+	startIndex, count, err := page(c)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ListGroups"))
+		return
+	}
+	filter, err := ParseFilter(c.Query("filter"))
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ListGroups"))
+		return
+	}
+	ctx := c.Request.Context()
+	p := &collector{startIndex: startIndex, count: count}
+	var after uint64
+	for {
+		groups, err := h.groups.ListGroups(ctx, after, maxResults)
+		if err != nil {
+			abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ListGroups"))
+			return
+		}
+		members, err := h.members(ctx, groups...)
+		if err != nil {
+			abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ListGroups"))
+			return
+		}
+		for i := range groups {
+			resource := h.groupResource(c, &groups[i], members)
+			if filter.Matches(asMap(resource)) {
+				p.add(resource)
+			}
+		}
+		if len(groups) < maxResults {
+			break
+		}
+		after = groups[len(groups)-1].ID
+	}
+	p.render(c)
+}
+
+// members : Users of the groups, fetched at once
+func (h *handler) members(ctx context.Context, groups ...Group) (map[uint64]*user.User, error) {
+	var uids []uint64
+	for _, g := range groups {
+		uids = append(uids, g.Members...)
+	}
+	users := make(map[uint64]*user.User, len(uids))
+	if len(uids) == 0 {
+		return users, nil
+	}
+	found, err := h.users.GetUsersByIDs(ctx, uids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range found {
+		users[found[i].ID] = &found[i]
+	}
+	return users, nil
+}
+
+// GetGroup : GET /Groups/:id
+func (h *handler) GetGroup(c *gin.Context) {
+	gid, err := parseID(c, ErrGroupNotFound)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.GetGroup"))
+		return
+	}
+	g, err := h.groups.GetGroup(c.Request.Context(), gid)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.GetGroup"))
+		return
+	}
+	h.renderGroup(c, http.StatusOK, g)
+}
+
+func (h *handler) renderGroup(c *gin.Context, status int, g *Group) {
+	members, err := h.members(c.Request.Context(), *g)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.renderGroup"))
+		return
+	}
+	resource := h.groupResource(c, g, members)
+	c.Header("ETag", resource.Meta.Version)
+	if status == http.StatusCreated {
+		c.Header("Location", resource.Meta.Location)
+	}
+	render(c, status, resource)
+}
+
+// groupState : Attributes of a group which SCIM writes
+type groupState struct {
+	DisplayName string
+	Members     []uint64
+}
+
+func (h *handler) groupState(r GroupResource) (groupState, error) {
+	s := groupState{DisplayName: strings.TrimSpace(r.DisplayName)}
+	var err error
+	s.Members, err = memberIDs(r.Members)
+	return s, err
+}
+
+// memberIDs : IDs of the members, without duplicates
+func memberIDs(members []Member) ([]uint64, error) {
+	seen := make(map[uint64]bool, len(members))
+	uids := make([]uint64, 0, len(members))
+	for _, m := range members {
+		uid, err := strconv.ParseUint(m.Value, 10, 64)
+		if err != nil || (m.Type != "" && m.Type != "User") {
+			return nil, withType(scimTypeInvalidValue, apperrors.Validation("Unknown member "+m.Value+", members are users"))
+		}
+		if !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+	}
+	return uids, nil
+}
+
+// validate : The display name is required and the members must exist
+func (h *handler) validateGroup(ctx context.Context, s groupState) error {
+	if s.DisplayName == "" {
+		return errNameRequired
+	}
+	if len(s.Members) == 0 {
+		return nil
+	}
+	found, err := h.users.GetUsersByIDs(ctx, s.Members)
+	if err != nil {
+		return err
+	}
+	known := make(map[uint64]bool, len(found))
+	for _, u := range found {
+		known[u.ID] = true
+	}
+	for _, uid := range s.Members {
+		if !known[uid] {
+			return withType(scimTypeInvalidValue, apperrors.Validation("Unknown member "+strconv.FormatUint(uid, 10)))
+		}
+	}
+	return nil
+}
+
+// CreateGroup : POST /Groups
+func (h *handler) CreateGroup(c *gin.Context) {
+	var resource GroupResource
+	if err := decode(c, &resource); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.CreateGroup"))
+		return
+	}
+	state, err := h.groupState(resource)
+	if err == nil {
+		err = h.validateGroup(c.Request.Context(), state)
+	}
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.CreateGroup"))
+		return
+	}
+	created, err := h.groups.CreateGroup(c.Request.Context(), &Group{DisplayName: state.DisplayName, Members: state.Members})
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.CreateGroup"))
+		return
+	}
+	h.renderGroup(c, http.StatusCreated, created)
+}
+
+// ReplaceGroup : PUT /Groups/:id
+func (h *handler) ReplaceGroup(c *gin.Context) {
+	gid, err := parseID(c, ErrGroupNotFound)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ReplaceGroup"))
+		return
+	}
+	var resource GroupResource
+	if err := decode(c, &resource); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ReplaceGroup"))
+		return
+	}
+	state, err := h.groupState(resource)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ReplaceGroup"))
+		return
+	}
+	current, err := h.currentGroup(c, gid)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.ReplaceGroup"))
+		return
+	}
+	updated, err := h.updateGroup(c.Request.Context(), current, state)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(versionError(c, err), "pkg.scim.handler.ReplaceGroup"))
+		return
+	}
+	h.renderGroup(c, http.StatusOK, updated)
+}
+
+// PatchGroup : PATCH /Groups/:id, typically adding or removing members
+func (h *handler) PatchGroup(c *gin.Context) {
+	gid, err := parseID(c, ErrGroupNotFound)
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchGroup"))
+		return
+	}
+	var req patchRequest
+	if err := decode(c, &req); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchGroup"))
+		return
+	}
+	if err := req.check(); err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchGroup"))
+		return
+	}
+	var updated *Group
+	for attempt := 1; ; attempt++ {
+		current, err := h.currentGroup(c, gid)
+		if err != nil {
+			abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchGroup"))
+			return
+		}
+		state := groupState{DisplayName: current.DisplayName, Members: current.Members}
+		if err := req.applyGroup(&state); err != nil {
+			abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.PatchGroup"))
+			return
+		}
+		updated, err = h.updateGroup(c.Request.Context(), current, state)
+		if errors.Is(err, ErrGroupVersionConflict) && c.GetHeader("If-Match") == "" && attempt < patchAttempts {
+			continue
+		}
+		if err != nil {
+			abort(c, pkgerrors.Wrap(versionError(c, err), "pkg.scim.handler.PatchGroup"))
+			return
+		}
+		break
+	}
+	h.renderGroup(c, http.StatusOK, updated)
+}
+
+// currentGroup : Group gid, errPrecondition when it doesn't have the version required by If-Match
+func (h *handler) currentGroup(c *gin.Context, gid uint64) (*Group, error) {
+	required, err := ifMatch(c)
+	if err != nil {
+		return nil, err
+	}
+	g, err := h.groups.GetGroup(c.Request.Context(), gid)
+	if err != nil {
+		return nil, err
+	}
+	if required != 0 && required != g.Version {
+		return nil, errPrecondition
+	}
+	return g, nil
+}
+
+// updateGroup : Writes the state, based on current's version
+func (h *handler) updateGroup(ctx context.Context, current *Group, state groupState) (*Group, error) {
+	if err := h.validateGroup(ctx, state); err != nil {
+		return nil, err
+	}
+	return h.groups.UpdateGroup(ctx, &Group{
+		ID:          current.ID,
+		DisplayName: state.DisplayName,
+		Members:     state.Members,
+		Version:     current.Version,
+	})
+}
+
+// DeleteGroup : DELETE /Groups/:id, the members are left untouched
+func (h *handler) DeleteGroup(c *gin.Context) {
+	gid, err := parseID(c, ErrGroupNotFound)
+	if err == nil {
+		err = h.groups.DeleteGroup(c.Request.Context(), gid)
+	}
+	if err != nil {
+		abort(c, pkgerrors.Wrap(err, "pkg.scim.handler.DeleteGroup"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,297 @@
+package scim
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+)
+
+// patchRequest : PATCH body, RFC 7644 3.5.2. The operations are applied in order to the state of the resource, which
+// is written at once : a failing operation leaves the resource untouched.
+type patchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []patchOperation `json:"Operations"`
+}
+
+// patchOperation : add, replace or remove. The op is case insensitive, some identity providers send "Replace".
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchPath : attr, attr.sub, attr[filter] or attr[filter].sub, lower cased and without the schema URN
+type patchPath struct {
+	attr   string
+	sub    string
+	filter *Filter
+}
+
+func invalidPath(p string) error {
+	return withType(scimTypeInvalidPath, apperrors.Validation("Invalid path "+p))
+}
+
+func invalidValue(detail string) error {
+	return withType(scimTypeInvalidValue, apperrors.Validation(detail))
+}
+
+func (r patchRequest) check() error {
+	if len(r.Operations) == 0 {
+		return invalidValue("Operations are required")
+	}
+	for _, op := range r.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace", "remove":
+		default:
+			return invalidValue("Unknown op " + op.Op + ", expected add, replace or remove")
+		}
+	}
+	return nil
+}
+
+func parsePatchPath(s string) (patchPath, error) {
+	var p patchPath
+	attrPath := s
+	if open := strings.IndexByte(s, '['); open >= 0 {
+		end := strings.LastIndexByte(s, ']')
+		if end < open {
+			return p, invalidPath(s)
+		}
+		filter, err := ParseFilter(s[open+1 : end])
+		if err != nil {
+			return p, invalidPath(s)
+		}
+		p.filter = &filter
+		attrPath = s[:open]
+		rest := s[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") {
+				return p, invalidPath(s)
+			}
+			attrPath += rest
+		}
+	}
+	parsed, err := parsePath(attrPath)
+	if err != nil {
+		return p, invalidPath(s)
+	}
+	p.attr, p.sub = parsed.attr, parsed.sub
+	return p, nil
+}
+
+// operations : Operations spelled out with a path, those without one set every attribute of their value
+func (r patchRequest) operations() ([]patchOperation, error) {
+	var ops []patchOperation
+	for _, op := range r.Operations {
+		op.Op = strings.ToLower(op.Op)
+		if op.Path != "" {
+			ops = append(ops, op)
+			continue
+		}
+		var values map[string]json.RawMessage
+		if op.Op == "remove" || json.Unmarshal(op.Value, &values) != nil {
+			return nil, withType(scimTypeNoTarget, apperrors.Validation("An operation without a path needs an object value"))
+		}
+		for attr, value := range values {
+			ops = append(ops, patchOperation{Op: op.Op, Path: attr, Value: value})
+		}
+	}
+	return ops, nil
+}
+
+// applyUser : Applies the operations to the state of a user, the attributes which aren't stored are ignored
+func (r patchRequest) applyUser(s *userState) error {
+	ops, err := r.operations()
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		p, err := parsePatchPath(op.Path)
+		if err != nil {
+			return err
+		}
+		switch p.attr {
+		case "username":
+			if op.Op == "remove" {
+				return withType(scimTypeMutability, apperrors.Validation("userName is required"))
+			}
+			if s.UserName, err = stringValue(op.Value); err != nil {
+				return err
+			}
+		case "active":
+			if op.Op == "remove" {
+				return withType(scimTypeMutability, apperrors.Validation("active can't be removed"))
+			}
+			if s.Active, err = boolValue(op.Value); err != nil {
+				return err
+			}
+		case "password":
+			if op.Op == "remove" {
+				return withType(scimTypeMutability, apperrors.Validation("password can't be removed"))
+			}
+			password, err := stringValue(op.Value)
+			if err != nil {
+				return err
+			}
+			s.Password = &password
+		case "emails":
+			if err := s.patchEmail(op, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// patchEmail : Users have a single email, the operations on emails target it
+func (s *userState) patchEmail(op patchOperation, p patchPath) error {
+	if op.Op == "remove" {
+		return withType(scimTypeMutability, apperrors.Validation("An email is required"))
+	}
+	if p.filter != nil && !p.filter.Matches(map[string]interface{}{"value": s.Email, "type": "work", "primary": true}) {
+		return withType(scimTypeNoTarget, apperrors.Validation("No email matches "+op.Path))
+	}
+	switch {
+	case p.sub == "value":
+		email, err := stringValue(op.Value)
+		if err != nil {
+			return err
+		}
+		s.Email = strings.TrimSpace(email)
+	case p.sub != "":
+		// type and primary are fixed
+	case p.filter != nil:
+		var email Email
+		if err := json.Unmarshal(op.Value, &email); err != nil {
+			return invalidValue("Expected an email object")
+		}
+		s.Email = strings.TrimSpace(email.Value)
+	default:
+		var emails []Email
+		if err := json.Unmarshal(op.Value, &emails); err != nil {
+			return invalidValue("Expected an array of emails")
+		}
+		if email, ok := primaryEmail(emails); ok {
+			s.Email = email
+		}
+	}
+	return nil
+}
+
+// applyGroup : Applies the operations to the state of a group, the attributes which aren't stored are ignored
+func (r patchRequest) applyGroup(s *groupState) error {
+	ops, err := r.operations()
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		p, err := parsePatchPath(op.Path)
+		if err != nil {
+			return err
+		}
+		switch p.attr {
+		case "displayname":
+			if op.Op == "remove" {
+				return withType(scimTypeMutability, apperrors.Validation("displayName is required"))
+			}
+			if s.DisplayName, err = stringValue(op.Value); err != nil {
+				return err
+			}
+			s.DisplayName = strings.TrimSpace(s.DisplayName)
+		case "members":
+			if err := s.patchMembers(op, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// patchMembers : members are added, replaced, removed by filter (members[value eq "2"]), or removed by value as
+// Azure AD does
+func (s *groupState) patchMembers(op patchOperation, p patchPath) error {
+	if p.sub != "" {
+		return invalidPath(op.Path)
+	}
+	if op.Op == "remove" && p.filter != nil {
+		kept := s.Members[:0:0]
+		for _, uid := range s.Members {
+			if !p.filter.Matches(map[string]interface{}{"value": strconv.FormatUint(uid, 10), "type": "User"}) {
+				kept = append(kept, uid)
+			}
+		}
+		s.Members = kept
+		return nil
+	}
+	if p.filter != nil {
+		return invalidPath(op.Path)
+	}
+	var members []Member
+	if op.Op != "remove" || len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &members); err != nil {
+			var member Member
+			if json.Unmarshal(op.Value, &member) != nil {
+				return invalidValue("Expected an array of members")
+			}
+			members = []Member{member}
+		}
+	}
+	uids, err := memberIDs(members)
+	if err != nil {
+		return err
+	}
+	switch {
+	case op.Op == "replace":
+		s.Members = uids
+	case op.Op == "add":
+		s.Members, _ = memberIDs(append(toMembers(s.Members), toMembers(uids)...))
+	case len(op.Value) == 0:
+		s.Members = nil
+	default:
+		removed := make(map[uint64]bool, len(uids))
+		for _, uid := range uids {
+			removed[uid] = true
+		}
+		kept := s.Members[:0:0]
+		for _, uid := range s.Members {
+			if !removed[uid] {
+				kept = append(kept, uid)
+			}
+		}
+		s.Members = kept
+	}
+	return nil
+}
+
+func toMembers(uids []uint64) []Member {
+	members := make([]Member, len(uids))
+	for i, uid := range uids {
+		members[i] = Member{Value: strconv.FormatUint(uid, 10)}
+	}
+	return members
+}
+
+func stringValue(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", invalidValue("Expected a string")
+	}
+	return s, nil
+}
+
+// boolValue : A boolean, or its string some identity providers send ("True")
+func boolValue(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	s, err := stringValue(raw)
+	if err == nil {
+		if b, err = strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return b, nil
+		}
+	}
+	return false, invalidValue("Expected a boolean")
+}
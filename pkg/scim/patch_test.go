@@ -0,0 +1,289 @@
+package scim
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodePatch(t *testing.T, s string) patchRequest {
+	var r patchRequest
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.check(); err != nil {
+		t.Fatalf("check() = %v", err)
+	}
+	return r
+}
+
+func TestPatchCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"ops", `{"Operations":[{"op":"add"},{"op":"Replace"},{"op":"remove"}]}`, true},
+		{"no operations", `{"Operations":[]}`, false},
+		{"unknown op", `{"Operations":[{"op":"move"}]}`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var r patchRequest
+			if err := json.Unmarshal([]byte(tc.body), &r); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.check(); (err == nil) != tc.ok {
+				t.Errorf("check() = %v, want ok %v", err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestPatchApplyUser(t *testing.T) {
+	password := "n3w-Passw0rd"
+	initial := userState{UserName: "bjensen", Email: "bjensen@example.com", Active: true}
+	for _, tc := range []struct {
+		name     string
+		body     string
+		want     userState
+		scimType string // Of the error, empty when the operations apply
+	}{
+		{
+			"replace userName",
+			`{"Operations":[{"op":"replace","path":"userName","value":"babs"}]}`,
+			userState{UserName: "babs", Email: "bjensen@example.com", Active: true}, "",
+		},
+		{
+			"urn prefixed path",
+			`{"Operations":[{"op":"replace","path":"urn:ietf:params:scim:schemas:core:2.0:User:userName","value":"babs"}]}`,
+			userState{UserName: "babs", Email: "bjensen@example.com", Active: true}, "",
+		},
+		{
+			"deactivate",
+			`{"Operations":[{"op":"Replace","path":"active","value":false}]}`,
+			userState{UserName: "bjensen", Email: "bjensen@example.com"}, "",
+		},
+		{
+			"deactivate with a string",
+			`{"Operations":[{"op":"replace","path":"active","value":"False"}]}`,
+			userState{UserName: "bjensen", Email: "bjensen@example.com"}, "",
+		},
+		{
+			"password",
+			`{"Operations":[{"op":"replace","path":"password","value":"n3w-Passw0rd"}]}`,
+			userState{UserName: "bjensen", Email: "bjensen@example.com", Active: true, Password: &password}, "",
+		},
+		{
+			"without a path",
+			`{"Operations":[{"op":"replace","value":{"userName":"babs","active":false,"displayName":"Babs"}}]}`,
+			userState{UserName: "babs", Email: "bjensen@example.com"}, "",
+		},
+		{
+			"operations in order",
+			`{"Operations":[{"op":"replace","path":"userName","value":"babs"},{"op":"replace","path":"userName","value":"barbara"}]}`,
+			userState{UserName: "barbara", Email: "bjensen@example.com", Active: true}, "",
+		},
+		{
+			"attributes which aren't stored",
+			`{"Operations":[{"op":"replace","path":"name.givenName","value":"Barbara"}]}`,
+			initial, "",
+		},
+		{
+			"emails",
+			`{"Operations":[{"op":"replace","path":"emails","value":[{"value":"babs@jensen.org","type":"home"},{"value":"barbara@example.com","primary":true}]}]}`,
+			userState{UserName: "bjensen", Email: "barbara@example.com", Active: true}, "",
+		},
+		{
+			"emails without a primary",
+			`{"Operations":[{"op":"add","path":"emails","value":[{"value":" babs@jensen.org "}]}]}`,
+			userState{UserName: "bjensen", Email: "babs@jensen.org", Active: true}, "",
+		},
+		{
+			"email value",
+			`{"Operations":[{"op":"replace","path":"emails.value","value":"barbara@example.com"}]}`,
+			userState{UserName: "bjensen", Email: "barbara@example.com", Active: true}, "",
+		},
+		{
+			"email value by filter",
+			`{"Operations":[{"op":"replace","path":"emails[type eq \"work\"].value","value":"barbara@example.com"}]}`,
+			userState{UserName: "bjensen", Email: "barbara@example.com", Active: true}, "",
+		},
+		{
+			"email by filter",
+			`{"Operations":[{"op":"replace","path":"emails[primary eq true]","value":{"value":"barbara@example.com"}}]}`,
+			userState{UserName: "bjensen", Email: "barbara@example.com", Active: true}, "",
+		},
+		{
+			"email type is fixed",
+			`{"Operations":[{"op":"replace","path":"emails.type","value":"home"}]}`,
+			initial, "",
+		},
+		{
+			"email filter without a match",
+			`{"Operations":[{"op":"replace","path":"emails[type eq \"home\"].value","value":"barbara@example.com"}]}`,
+			initial, scimTypeNoTarget,
+		},
+		{
+			"remove userName",
+			`{"Operations":[{"op":"remove","path":"userName"}]}`,
+			initial, scimTypeMutability,
+		},
+		{
+			"remove active",
+			`{"Operations":[{"op":"remove","path":"active"}]}`,
+			initial, scimTypeMutability,
+		},
+		{
+			"remove password",
+			`{"Operations":[{"op":"remove","path":"password"}]}`,
+			initial, scimTypeMutability,
+		},
+		{
+			"remove emails",
+			`{"Operations":[{"op":"remove","path":"emails[type eq \"work\"]"}]}`,
+			initial, scimTypeMutability,
+		},
+		{
+			"remove without a path",
+			`{"Operations":[{"op":"remove"}]}`,
+			initial, scimTypeNoTarget,
+		},
+		{
+			"without a path nor an object",
+			`{"Operations":[{"op":"replace","value":"babs"}]}`,
+			initial, scimTypeNoTarget,
+		},
+		{
+			"userName not a string",
+			`{"Operations":[{"op":"replace","path":"userName","value":42}]}`,
+			initial, scimTypeInvalidValue,
+		},
+		{
+			"active not a boolean",
+			`{"Operations":[{"op":"replace","path":"active","value":"yes please"}]}`,
+			initial, scimTypeInvalidValue,
+		},
+		{
+			"emails not an array",
+			`{"Operations":[{"op":"replace","path":"emails","value":"barbara@example.com"}]}`,
+			initial, scimTypeInvalidValue,
+		},
+		{
+			"invalid path",
+			`{"Operations":[{"op":"replace","path":"emails[type eq]","value":"barbara@example.com"}]}`,
+			initial, scimTypeInvalidPath,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := initial
+			err := decodePatch(t, tc.body).applyUser(&s)
+			if scimTypeOf(err) != tc.scimType || (err != nil && tc.scimType == "") {
+				t.Fatalf("applyUser() = %v, want scimType %q", err, tc.scimType)
+			}
+			if err == nil && !reflect.DeepEqual(s, tc.want) {
+				t.Errorf("applyUser() state = %+v, want %+v", s, tc.want)
+			}
+		})
+	}
+}
+
+func TestPatchApplyGroup(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		body     string
+		want     groupState
+		scimType string // Of the error, empty when the operations apply
+	}{
+		{
+			"replace displayName",
+			`{"Operations":[{"op":"replace","path":"displayName","value":" Guides "}]}`,
+			groupState{DisplayName: "Guides", Members: []uint64{1, 2, 3}}, "",
+		},
+		{
+			"without a path",
+			`{"Operations":[{"op":"replace","value":{"displayName":"Guides","members":[{"value":"4"}]}}]}`,
+			groupState{DisplayName: "Guides", Members: []uint64{4}}, "",
+		},
+		{
+			"add members",
+			`{"Operations":[{"op":"add","path":"members","value":[{"value":"3"},{"value":"4","type":"User"},{"value":"4"}]}]}`,
+			groupState{DisplayName: "Tour Guides", Members: []uint64{1, 2, 3, 4}}, "",
+		},
+		{
+			"add a single member",
+			`{"Operations":[{"op":"add","path":"members","value":{"value":"5"}}]}`,
+			groupState{DisplayName: "Tour Guides", Members: []uint64{1, 2, 3, 5}}, "",
+		},
+		{
+			"replace members",
+			`{"Operations":[{"op":"replace","path":"members","value":[{"value":"5"},{"value":"1"}]}]}`,
+			groupState{DisplayName: "Tour Guides", Members: []uint64{5, 1}}, "",
+		},
+		{
+			"remove by filter",
+			`{"Operations":[{"op":"remove","path":"members[value eq \"2\"]"}]}`,
+			groupState{DisplayName: "Tour Guides", Members: []uint64{1, 3}}, "",
+		},
+		{
+			"remove by filter with or",
+			`{"Operations":[{"op":"remove","path":"members[value eq \"1\" or value eq \"3\"]"}]}`,
+			groupState{DisplayName: "Tour Guides", Members: []uint64{2}}, "",
+		},
+		{
+			"remove by value",
+			`{"Operations":[{"op":"remove","path":"members","value":[{"value":"1"},{"value":"9"}]}]}`,
+			groupState{DisplayName: "Tour Guides", Members: []uint64{2, 3}}, "",
+		},
+		{
+			"remove all",
+			`{"Operations":[{"op":"remove","path":"members"}]}`,
+			groupState{DisplayName: "Tour Guides"}, "",
+		},
+		{
+			"attributes which aren't stored",
+			`{"Operations":[{"op":"replace","path":"externalId","value":"guides"}]}`,
+			groupState{DisplayName: "Tour Guides", Members: []uint64{1, 2, 3}}, "",
+		},
+		{
+			"remove displayName",
+			`{"Operations":[{"op":"remove","path":"displayName"}]}`,
+			groupState{}, scimTypeMutability,
+		},
+		{
+			"member which isn't a user",
+			`{"Operations":[{"op":"add","path":"members","value":[{"value":"7","type":"Group"}]}]}`,
+			groupState{}, scimTypeInvalidValue,
+		},
+		{
+			"member which isn't an id",
+			`{"Operations":[{"op":"add","path":"members","value":[{"value":"bjensen"}]}]}`,
+			groupState{}, scimTypeInvalidValue,
+		},
+		{
+			"members not members",
+			`{"Operations":[{"op":"add","path":"members","value":"1"}]}`,
+			groupState{}, scimTypeInvalidValue,
+		},
+		{
+			"members sub-attribute",
+			`{"Operations":[{"op":"replace","path":"members.value","value":"1"}]}`,
+			groupState{}, scimTypeInvalidPath,
+		},
+		{
+			"add by filter",
+			`{"Operations":[{"op":"add","path":"members[value eq \"2\"]","value":[{"value":"4"}]}]}`,
+			groupState{}, scimTypeInvalidPath,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := groupState{DisplayName: "Tour Guides", Members: []uint64{1, 2, 3}}
+			err := decodePatch(t, tc.body).applyGroup(&s)
+			if scimTypeOf(err) != tc.scimType || (err != nil && tc.scimType == "") {
+				t.Fatalf("applyGroup() = %v, want scimType %q", err, tc.scimType)
+			}
+			if err == nil && !reflect.DeepEqual(s, tc.want) {
+				t.Errorf("applyGroup() state = %+v, want %+v", s, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package scim
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType : Media type of the SCIM requests and responses, RFC 7644 3.1
+const ContentType = "application/scim+json"
+
+// Schema URNs, RFC 7643 and 7644
+const (
+	schemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	schemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	schemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	schemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	schemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	schemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	schemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Meta : Metadata of a resource
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Version      string     `json:"version,omitempty"`
+}
+
+// Email : Email address of a User, users have a single one which is their primary
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// UserResource : SCIM User, mapped to a user.User. active false disables the account.
+// The attributes which aren't stored (name, externalId ...) are accepted and ignored.
+type UserResource struct {
+	Schemas  []string `json:"schemas"`
+	ID       string   `json:"id,omitempty"`
+	UserName string   `json:"userName"`
+	Active   *bool    `json:"active,omitempty"`
+	Emails   []Email  `json:"emails,omitempty"`
+	Password string   `json:"password,omitempty"` // Write only
+	Meta     *Meta    `json:"meta,omitempty"`
+}
+
+// Member : Member of a Group, always a User
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+// GroupResource : SCIM Group, mapped to a Group
+type GroupResource struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// listResponse : Page of resources, RFC 7644 3.4.2
+type listResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// version : Weak entity tag of a version, SCIM ETags are weak (RFC 7644 3.14)
+func version(v uint64) string {
+	return `W/"` + strconv.FormatUint(v, 10) + `"`
+}
+
+// ifMatch : Version required by the If-Match header, 0 when there's none or it is *. Entity tags compare weakly.
+func ifMatch(c *gin.Context) (uint64, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	v, err := strconv.ParseUint(tag, 10, 64)
+	if err != nil || v == 0 {
+		return 0, apperrors.Validation("Invalid If-Match header, expected the meta.version of the resource")
+	}
+	return v, nil
+}
+
+func (h *handler) userResource(c *gin.Context, u *user.User) UserResource {
+	active := u.Status != user.StatusDisabled
+	created, modified := u.CreatedAt, u.UpdatedAt
+	return UserResource{
+		Schemas:  []string{schemaUser},
+		ID:       strconv.FormatUint(u.ID, 10),
+		UserName: u.Username,
+		Active:   &active,
+		Emails:   []Email{{Value: u.Email, Type: "work", Primary: true}},
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      &created,
+			LastModified: &modified,
+			Location:     h.location(c, "Users", u.ID),
+			Version:      version(u.Version),
+		},
+	}
+}
+
+// groupResource : The members are displayed with their username, those deleted in the meantime are left out
+func (h *handler) groupResource(c *gin.Context, g *Group, users map[uint64]*user.User) GroupResource {
+	created, modified := g.CreatedAt, g.UpdatedAt
+	members := make([]Member, 0, len(g.Members))
+	for _, uid := range g.Members {
+		u, ok := users[uid]
+		if !ok {
+			continue
+		}
+		members = append(members, Member{
+			Value:   strconv.FormatUint(uid, 10),
+			Display: u.Username,
+			Ref:     h.location(c, "Users", uid),
+			Type:    "User",
+		})
+	}
+	return GroupResource{
+		Schemas:     []string{schemaGroup},
+		ID:          strconv.FormatUint(g.ID, 10),
+		DisplayName: g.DisplayName,
+		Members:     members,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      &created,
+			LastModified: &modified,
+			Location:     h.location(c, "Groups", g.ID),
+			Version:      version(g.Version),
+		},
+	}
+}
+
+// location : URL of a resource
+func (h *handler) location(c *gin.Context, resourceType string, id uint64) string {
+	return h.url(c, "/"+resourceType+"/"+strconv.FormatUint(id, 10))
+}
+
+// url : Absolute URL of a path under the base path
+func (h *handler) url(c *gin.Context, path string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + h.basePath + path
+}
+
+// asMap : JSON representation of a resource, which the filters are evaluated against
+func asMap(resource interface{}) map[string]interface{} {
+	b, _ := json.Marshal(resource)
+	m := make(map[string]interface{})
+	_ = json.Unmarshal(b, &m)
+	return m
+}
+
+var errMalformedBody = withType(scimTypeInvalidSyntax, apperrors.Validation("Malformed JSON body"))
+
+// decode : Reads the JSON body of the request into v
+func decode(c *gin.Context, v interface{}) error {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return errMalformedBody
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return withType(scimTypeInvalidSyntax, apperrors.Validation("Malformed JSON body").Wrap(err))
+	}
+	return nil
+}
+
+// render : Writes body as application/scim+json
+func render(c *gin.Context, status int, body interface{}) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, ContentType, b)
+}
+
+// parseID : Resource ID of the path, which is unknown (404) rather than invalid when it isn't a number
+func parseID(c *gin.Context, notFound error) (uint64, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, notFound
+	}
+	return id, nil
+}
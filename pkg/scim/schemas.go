@@ -0,0 +1,175 @@
+package scim
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Discovery endpoints, RFC 7644 4. They describe what this service provider supports, which the identity providers
+// read to decide how to sync.
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type bulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+type serviceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulkSupport            `json:"bulk"`
+	Filter                filterSupport          `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+	Meta                  Meta                   `json:"meta"`
+}
+
+type resourceType struct {
+	Schemas  []string `json:"schemas"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint"`
+	Schema   string   `json:"schema"`
+	Meta     Meta     `json:"meta"`
+}
+
+// attribute : Attribute definition of a schema, RFC 7643 7
+type attribute struct {
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	MultiValued   bool        `json:"multiValued"`
+	Required      bool        `json:"required"`
+	CaseExact     bool        `json:"caseExact"`
+	Mutability    string      `json:"mutability"`
+	Returned      string      `json:"returned"`
+	Uniqueness    string      `json:"uniqueness"`
+	SubAttributes []attribute `json:"subAttributes,omitempty"`
+}
+
+type schema struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Attributes  []attribute `json:"attributes"`
+	Meta        Meta        `json:"meta"`
+}
+
+func attr(name, typ string, required bool, mutability, returned, uniqueness string, subs ...attribute) attribute {
+	return attribute{
+		Name: name, Type: typ, Required: required, Mutability: mutability, Returned: returned, Uniqueness: uniqueness,
+		MultiValued: len(subs) > 0, SubAttributes: subs,
+	}
+}
+
+// schemas : Attributes this service provider stores, the others are ignored
+var schemas = []schema{
+	{
+		ID:          schemaUser,
+		Name:        "User",
+		Description: "User Account",
+		Attributes: []attribute{
+			attr("userName", "string", true, "readWrite", "default", "server"),
+			attr("active", "boolean", false, "readWrite", "default", "none"),
+			attr("password", "string", false, "writeOnly", "never", "none"),
+			attr("emails", "complex", true, "readWrite", "default", "server",
+				attr("value", "string", true, "readWrite", "default", "server"),
+				attr("type", "string", false, "readOnly", "default", "none"),
+				attr("primary", "boolean", false, "readOnly", "default", "none"),
+			),
+		},
+	},
+	{
+		ID:          schemaGroup,
+		Name:        "Group",
+		Description: "Group",
+		Attributes: []attribute{
+			attr("displayName", "string", true, "readWrite", "default", "server"),
+			attr("members", "complex", false, "readWrite", "default", "none",
+				attr("value", "string", false, "immutable", "default", "none"),
+				attr("display", "string", false, "readOnly", "default", "none"),
+				attr("$ref", "reference", false, "immutable", "default", "none"),
+				attr("type", "string", false, "immutable", "default", "none"),
+			),
+		},
+	},
+}
+
+// ServiceProviderConfig : GET /ServiceProviderConfig
+func (h *handler) ServiceProviderConfig(c *gin.Context) {
+	render(c, http.StatusOK, serviceProviderConfig{
+		Schemas:        []string{schemaServiceProviderConfig},
+		Patch:          supported{true},
+		Filter:         filterSupport{Supported: true, MaxResults: maxResults},
+		ChangePassword: supported{true},
+		ETag:           supported{true},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer Token",
+			Description: "The SCIM token of the service, in the Authorization header",
+			Primary:     true,
+		}},
+		Meta: Meta{ResourceType: "ServiceProviderConfig", Location: h.url(c, "/ServiceProviderConfig")},
+	})
+}
+
+// ResourceTypes : GET /ResourceTypes
+func (h *handler) ResourceTypes(c *gin.Context) {
+	var resources []interface{}
+	for _, s := range schemas {
+		resources = append(resources, resourceType{
+			Schemas:  []string{schemaResourceType},
+			ID:       s.Name,
+			Name:     s.Name,
+			Endpoint: "/" + s.Name + "s",
+			Schema:   s.ID,
+			Meta:     Meta{ResourceType: "ResourceType", Location: h.url(c, "/ResourceTypes/"+s.Name)},
+		})
+	}
+	(&collector{startIndex: 1, count: len(resources), total: len(resources), resources: resources}).render(c)
+}
+
+// Schemas : GET /Schemas
+func (h *handler) Schemas(c *gin.Context) {
+	var resources []interface{}
+	for _, s := range schemas {
+		resources = append(resources, h.schema(c, s))
+	}
+	(&collector{startIndex: 1, count: len(resources), total: len(resources), resources: resources}).render(c)
+}
+
+// Schema : GET /Schemas/:id, id being the URN of the schema
+func (h *handler) Schema(c *gin.Context) {
+	for _, s := range schemas {
+		if s.ID == c.Param("id") {
+			render(c, http.StatusOK, h.schema(c, s))
+			return
+		}
+	}
+	abort(c, errSchemaNotFound)
+}
+
+func (h *handler) schema(c *gin.Context, s schema) schema {
+	s.Schemas = []string{schemaSchema}
+	s.Meta = Meta{ResourceType: "Schema", Location: h.url(c, "/Schemas/"+s.ID)}
+	return s
+}
@@ -0,0 +1,132 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Reloader : Keeps the server certificate and the client CA pool in sync with the files on disk
+type Reloader struct {
+	certFile, keyFile, caFile string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes map[string]time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewReloader : Loads the key pair (and the client CA bundle when caFile isn't empty)
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		stop:     make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload : Reads the files again, the previous certificates stay in use if anything fails
+func (r *Reloader) Reload() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "pkg.tlsconfig.Reloader.Reload")
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return errors.Wrap(err, "pkg.tlsconfig.Reloader.Reload")
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("pkg.tlsconfig.Reloader.Reload : no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.pool, r.modTimes = &cert, pool, modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// Watch : Polls the files every interval and reloads them when they change, until Stop is called
+func (r *Reloader) Watch(interval time.Duration, log logrus.FieldLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Errorf("Reloading TLS certificates failed, keeping the previous ones : %v", err)
+				continue
+			}
+			log.Info("Reloaded TLS certificates")
+		}
+	}
+}
+
+// Stop : Stops Watch
+func (r *Reloader) Stop() {
+	r.once.Do(func() { close(r.stop) })
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+func (r *Reloader) changed() bool {
+	modTimes, err := r.stat()
+	if err != nil {
+		// Files being replaced (e.g. kubernetes secret updates), try again on the next tick
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for file, t := range modTimes {
+		if !t.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) stat() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "pkg.tlsconfig.Reloader")
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
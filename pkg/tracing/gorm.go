@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	contextKey = "tracing:context"
+	spanKey    = "tracing:span"
+)
+
+// WithContext : gorm handle whose queries are traced as children of the span held by ctx
+func WithContext(db *gorm.DB, ctx context.Context) *gorm.DB {
+	return db.Set(contextKey, ctx)
+}
+
+// InstrumentGorm : Starts a client span around every query run through a handle returned by WithContext
+func InstrumentGorm(db *gorm.DB) {
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("tracing:before_create", before("create"))
+	cb.Create().After("gorm:create").Register("tracing:after_create", after)
+	cb.Query().Before("gorm:query").Register("tracing:before_query", before("query"))
+	cb.Query().After("gorm:query").Register("tracing:after_query", after)
+	cb.Update().Before("gorm:update").Register("tracing:before_update", before("update"))
+	cb.Update().After("gorm:update").Register("tracing:after_update", after)
+	cb.Delete().Before("gorm:delete").Register("tracing:before_delete", before("delete"))
+	cb.Delete().After("gorm:delete").Register("tracing:after_delete", after)
+	cb.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", before("row_query"))
+	cb.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", after)
+}
+
+func before(operation string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.Get(contextKey)
+		if !ok {
+			return
+		}
+		table := scope.TableName()
+		_, span := Tracer().Start(v.(context.Context), "gorm."+operation+" "+table,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemPostgreSQL,
+				semconv.DBOperation(operation),
+				semconv.DBSQLTable(table),
+			),
+		)
+		scope.InstanceSet(spanKey, span)
+	}
+}
+
+func after(scope *gorm.Scope) {
+	v, ok := scope.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	// Only the parametrized statement is recorded, the bound values never leave the process
+	span.SetAttributes(semconv.DBStatement(scope.SQL))
+	var err error
+	if scope.HasError() && !gorm.IsRecordNotFoundError(scope.DB().Error) {
+		err = scope.DB().Error
+	}
+	End(span, err)
+}
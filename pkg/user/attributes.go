@@ -0,0 +1,126 @@
+package user
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// MaxAttributesSize : Largest custom attributes accepted, as JSON
+const MaxAttributesSize = 16 << 10
+
+var (
+	errAttributesTooLarge = apperrors.Validation("Invalid attributes", apperrors.FieldError{
+		Field: "attributes", Message: "must be at most 16 KiB as JSON",
+	})
+	errNoAttributes = apperrors.Validation("Invalid attributes", apperrors.FieldError{
+		Field: "attributes", Message: "aren't defined by this deployment",
+	})
+)
+
+// Attributes : Custom fields of a profile, whose names and values the deployment defines with an AttributesSchema
+type Attributes map[string]interface{}
+
+// MarshalJSON : Profiles without attributes have an empty object rather than null
+func (a Attributes) MarshalJSON() ([]byte, error) {
+	if a == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]interface{}(a))
+}
+
+// Value : Stored as JSON
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(map[string]interface{}(a))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan : Reads the JSON stored by Value
+func (a *Attributes) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("pkg.user.Attributes.Scan : unsupported type %T", src)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return errors.Wrap(err, "pkg.user.Attributes.Scan")
+	}
+	*a = m
+	return nil
+}
+
+// AttributesSchema : JSON Schema the custom attributes of the profiles must match, e.g.
+// {"type": "object", "properties": {"department": {"type": "string"}}, "additionalProperties": false}
+type AttributesSchema struct {
+	schema *jsonschema.Schema
+}
+
+// LoadAttributesSchema : Compiles the JSON Schema in the file at path. Its $refs may point to other local files, the
+// remote ones aren't fetched.
+func LoadAttributesSchema(path string) (*AttributesSchema, error) {
+	schema, err := jsonschema.Compile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.LoadAttributesSchema")
+	}
+	return &AttributesSchema{schema}, nil
+}
+
+// Validate : apperrors.Validation listing where attrs doesn't match the schema, by field (e.g. attributes.department).
+// A nil schema only accepts empty attributes.
+func (s *AttributesSchema) Validate(attrs Attributes) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+	if s == nil {
+		return errNoAttributes
+	}
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return errors.Wrap(err, "pkg.user.AttributesSchema.Validate")
+	}
+	if len(data) > MaxAttributesSize {
+		return errAttributesTooLarge
+	}
+	err = s.schema.Validate(map[string]interface{}(attrs))
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return err
+	}
+	var fields []apperrors.FieldError
+	for _, leaf := range leaves(verr) {
+		field := "attributes" + strings.Replace(leaf.InstanceLocation, "/", ".", -1)
+		fields = append(fields, apperrors.FieldError{Field: field, Message: leaf.Message})
+	}
+	return apperrors.Validation("Invalid attributes", fields...).Wrap(err)
+}
+
+// leaves : The errors of e which aren't caused by others, the ones telling what is wrong
+func leaves(e *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(e.Causes) == 0 {
+		return []*jsonschema.ValidationError{e}
+	}
+	var found []*jsonschema.ValidationError
+	for _, cause := range e.Causes {
+		found = append(found, leaves(cause)...)
+	}
+	return found
+}
@@ -0,0 +1,143 @@
+package user
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/audit"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+)
+
+type auditedService struct {
+	Service
+	recorder audit.Recorder
+}
+
+// NewAuditedService : Wraps the Service so every change of a user, and every login, is recorded in the audit log
+// whether it succeeds or not. The reads go straight to next.
+func NewAuditedService(next Service, recorder audit.Recorder) Service {
+	return &auditedService{next, recorder}
+}
+
+// record : Appends the record of action on the user, failed when err isn't nil
+func (a *auditedService) record(ctx context.Context, action string, target *User, username string, changes audit.Changes, err error) {
+	r := audit.Record{Action: action, TargetType: audit.TargetUser, TargetName: username, Changes: changes}
+	if target != nil {
+		r.TargetID, r.TargetName = target.ID, target.Username
+	}
+	if err != nil {
+		r.Outcome, r.Reason = audit.OutcomeFailure, audit.Reason(err)
+	}
+	a.recorder.Record(ctx, r)
+}
+
+// before : State of the user ahead of a change, nil when it can't be read (the change is then likely to fail too)
+func (a *auditedService) before(ctx context.Context, uid uint64) *User {
+	u, err := a.Service.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+func (a *auditedService) Login(ctx context.Context, username, password string) (string, error) {
+	token, err := a.Service.Login(ctx, username, password)
+	r := audit.Record{Action: audit.ActionLogin, TargetType: audit.TargetUser, TargetName: username}
+	if err != nil {
+		r.Outcome, r.Reason = audit.OutcomeFailure, audit.Reason(err)
+	} else if uid, err := auth.TokenUserID(token); err == nil {
+		// Done on behalf of the user who just proved who they are
+		r.ActorType, r.ActorID, r.TargetID = audit.ActorUser, uid, uid
+	}
+	a.recorder.Record(ctx, r)
+	return token, err
+}
+
+func (a *auditedService) CreateUser(ctx context.Context, u *User) (*User, error) {
+	username := u.Username
+	created, err := a.Service.CreateUser(ctx, u)
+	if err != nil {
+		a.record(ctx, audit.ActionUserCreate, nil, username, nil, err)
+		return nil, err
+	}
+	a.record(ctx, audit.ActionUserCreate, created, "", audit.Diff(nil, created), nil)
+	return created, nil
+}
+
+func (a *auditedService) UpdateUser(ctx context.Context, u *User) (*User, error) {
+	before := a.before(ctx, u.ID)
+	updated, err := a.Service.UpdateUser(ctx, u)
+	a.recordUpdate(ctx, u.ID, before, updated, err)
+	return updated, err
+}
+
+func (a *auditedService) UpdateProfile(ctx context.Context, uid, version uint64, p ProfileUpdate) (*User, error) {
+	before := a.before(ctx, uid)
+	updated, err := a.Service.UpdateProfile(ctx, uid, version, p)
+	a.recordUpdate(ctx, uid, before, updated, err)
+	return updated, err
+}
+
+func (a *auditedService) SetAvatar(ctx context.Context, uid, version uint64, r io.Reader) (*User, error) {
+	before := a.before(ctx, uid)
+	updated, err := a.Service.SetAvatar(ctx, uid, version, r)
+	a.recordUpdate(ctx, uid, before, updated, err)
+	return updated, err
+}
+
+func (a *auditedService) DeleteAvatar(ctx context.Context, uid, version uint64) (*User, error) {
+	before := a.before(ctx, uid)
+	updated, err := a.Service.DeleteAvatar(ctx, uid, version)
+	a.recordUpdate(ctx, uid, before, updated, err)
+	return updated, err
+}
+
+// recordUpdate : Records the update of user uid, unless it succeeded without changing anything
+func (a *auditedService) recordUpdate(ctx context.Context, uid uint64, before, updated *User, err error) {
+	target := before
+	if target == nil {
+		target = &User{UserInfoPayload: UserInfoPayload{ID: uid}}
+	}
+	if err != nil {
+		a.record(ctx, audit.ActionUserUpdate, target, "", nil, err)
+		return
+	}
+	if changes := audit.Diff(before, updated); changes != nil {
+		a.record(ctx, audit.ActionUserUpdate, updated, "", changes, nil)
+	}
+}
+
+func (a *auditedService) DeleteUser(ctx context.Context, uid, version uint64) (time.Time, error) {
+	before := a.before(ctx, uid)
+	restorableUntil, err := a.Service.DeleteUser(ctx, uid, version)
+	target := before
+	if target == nil {
+		target = &User{UserInfoPayload: UserInfoPayload{ID: uid}}
+	}
+	var changes audit.Changes
+	if err == nil {
+		changes = audit.Diff(before, nil)
+	}
+	a.record(ctx, audit.ActionUserDelete, target, "", changes, err)
+	return restorableUntil, err
+}
+
+func (a *auditedService) RestoreUser(ctx context.Context, username, password string) (*User, error) {
+	restored, err := a.Service.RestoreUser(ctx, username, password)
+	if err != nil {
+		a.record(ctx, audit.ActionUserRestore, nil, username, nil, err)
+		return nil, err
+	}
+	// Like a login, the credentials prove who is acting
+	a.recorder.Record(ctx, audit.Record{
+		ActorType:  audit.ActorUser,
+		ActorID:    restored.ID,
+		Action:     audit.ActionUserRestore,
+		TargetType: audit.TargetUser,
+		TargetID:   restored.ID,
+		TargetName: restored.Username,
+		Changes:    audit.Diff(nil, restored),
+	})
+	return restored, nil
+}
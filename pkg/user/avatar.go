@@ -0,0 +1,193 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/avatar"
+	"github.com/LuD1161/restructuring-tnbt/pkg/blob"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+)
+
+var (
+	errAvatarsDisabled = apperrors.NotFound("Avatars aren't enabled")
+	errNoAvatar        = apperrors.NotFound("The user has no avatar")
+
+	errInvalidAvatarSize = apperrors.Validation("Invalid avatar size", apperrors.FieldError{
+		Field: "size", Message: "must be one of " + strings.Replace(strings.Trim(fmt.Sprint(avatar.Sizes), "[]"), " ", ", ", -1),
+	})
+)
+
+// Avatar : One of the variants of a user's avatar, to be closed once read
+type Avatar struct {
+	io.ReadCloser
+	blob.Info
+	ETag string // Changes with every upload
+}
+
+// avatarsPrefix : Blob keys of the avatars of user uid, every upload getting its own avatarsPrefix/<avatar ID>/<size>
+func avatarsPrefix(uid uint64) string {
+	return "avatars/" + strconv.FormatUint(uid, 10)
+}
+
+// avatarKeys : Blob keys of the variants of an avatar, one for each of avatar.Sizes
+func avatarKeys(uid uint64, avatarID string) []string {
+	keys := make([]string, len(avatar.Sizes))
+	for i, size := range avatar.Sizes {
+		keys[i] = avatarKey(uid, avatarID, size)
+	}
+	return keys
+}
+
+func avatarKey(uid uint64, avatarID string, size int) string {
+	return fmt.Sprintf("%s/%s/%d", avatarsPrefix(uid), avatarID, size)
+}
+
+// newAvatarID : Random 64 bit avatar ID
+func newAvatarID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SetAvatar : Replaces the avatar of user uid with the picture read from r, stored in every one of avatar.Sizes.
+// The update is based on the given version (the current one when 0), see UpdateProfile.
+func (s *service) SetAvatar(ctx context.Context, uid, version uint64, r io.Reader) (*User, error) {
+	if s.profiles.Avatars == nil {
+		return nil, errAvatarsDisabled
+	}
+	// One byte more than allowed tells a picture at the limit from a larger one
+	data, err := ioutil.ReadAll(io.LimitReader(r, s.profiles.AvatarMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.profiles.AvatarMaxSize {
+		return nil, apperrors.PayloadTooLarge(fmt.Sprintf("Avatars must be at most %d bytes", s.profiles.AvatarMaxSize))
+	}
+	variants, err := avatar.Process(data)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && version != current.Version {
+		return nil, ErrVersionConflict
+	}
+
+	avatarID, err := newAvatarID()
+	if err != nil {
+		return nil, err
+	}
+	// Stored before the user points to them, so the avatar of a user can always be read
+	keys := avatarKeys(uid, avatarID)
+	for _, v := range variants {
+		key := avatarKey(uid, avatarID, v.Size)
+		if err := s.profiles.Avatars.Put(ctx, key, bytes.NewReader(v.Data), int64(len(v.Data)), v.ContentType); err != nil {
+			s.removeAvatar(ctx, keys)
+			return nil, err
+		}
+	}
+	updated, err := s.repo.SetAvatar(ctx, uid, current.Version, avatarID)
+	if err != nil {
+		s.removeAvatar(ctx, keys)
+		return nil, err
+	}
+	if current.AvatarID != "" {
+		s.removeAvatar(ctx, avatarKeys(uid, current.AvatarID))
+	}
+	return updated, nil
+}
+
+// DeleteAvatar : Removes the avatar of user uid, if any. The update is based on the given version
+// (the current one when 0), see UpdateProfile.
+func (s *service) DeleteAvatar(ctx context.Context, uid, version uint64) (*User, error) {
+	if s.profiles.Avatars == nil {
+		return nil, errAvatarsDisabled
+	}
+	current, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && version != current.Version {
+		return nil, ErrVersionConflict
+	}
+	if current.AvatarID == "" {
+		return current, nil
+	}
+	updated, err := s.repo.SetAvatar(ctx, uid, current.Version, "")
+	if err != nil {
+		return nil, err
+	}
+	s.removeAvatar(ctx, avatarKeys(uid, current.AvatarID))
+	return updated, nil
+}
+
+// GetAvatar : Variant of the avatar of user uid at size, one of avatar.Sizes
+func (s *service) GetAvatar(ctx context.Context, uid uint64, size int) (*Avatar, error) {
+	if s.profiles.Avatars == nil {
+		return nil, errAvatarsDisabled
+	}
+	if size == 0 {
+		size = avatar.Sizes[0]
+	}
+	if !validAvatarSize(size) {
+		return nil, errInvalidAvatarSize
+	}
+	u, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if u.AvatarID == "" {
+		return nil, errNoAvatar
+	}
+	r, info, err := s.profiles.Avatars.Get(ctx, avatarKey(uid, u.AvatarID, size))
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, errNoAvatar.Wrap(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Avatar{ReadCloser: r, Info: *info, ETag: `"` + u.AvatarID + "-" + strconv.Itoa(size) + `"`}, nil
+}
+
+// removeAvatar : Deletes the variants of an avatar no user points to. Failing only leaves unused blobs behind,
+// purged along with the user.
+func (s *service) removeAvatar(ctx context.Context, keys []string) {
+	if err := s.profiles.Avatars.Delete(ctx, keys...); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Deleting an unused avatar failed")
+	}
+}
+
+func validAvatarSize(size int) bool {
+	for _, s := range avatar.Sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveAvatars : PurgeHook deleting the avatars of the purged users from store
+func RemoveAvatars(store blob.Store) PurgeHook {
+	return func(ctx context.Context, uids []uint64) error {
+		for _, uid := range uids {
+			if err := store.DeletePrefix(ctx, avatarsPrefix(uid)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/gin-gonic/gin"
+)
+
+var errPreconditionFailed = apperrors.PreconditionFailed("The user changed since it was fetched, If-Match doesn't match its ETag")
+
+// ETag : Strong entity tag of the user, derived from its version
+func ETag(u *User) string {
+	return `"` + strconv.FormatUint(u.Version, 10) + `"`
+}
+
+// etagMatches : Whether the If-Match / If-None-Match header lists etag. If-None-Match compares weakly, ignoring W/.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified : Answers 304 when If-None-Match lists the user's ETag
+func notModified(c *gin.Context, u *User) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" || !etagMatches(header, ETag(u), true) {
+		return false
+	}
+	c.Header("ETag", ETag(u))
+	c.Status(http.StatusNotModified)
+	c.Writer.WriteHeaderNow()
+	return true
+}
+
+// checkIfMatch : errPreconditionFailed when the request has an If-Match header not listing the user's ETag
+func checkIfMatch(c *gin.Context, u *User) error {
+	header := c.GetHeader("If-Match")
+	if header != "" && !etagMatches(header, ETag(u), false) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// preconditionError : A conditional request losing the race to another write gets a 412 like a stale If-Match,
+// the others get ErrVersionConflict's 409
+func preconditionError(c *gin.Context, err error) error {
+	if c.GetHeader("If-Match") != "" && errors.Is(err, ErrVersionConflict) {
+		return errPreconditionFailed.Wrap(err)
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Types of the user lifecycle events
+const (
+	EventCreated         = "user.created"
+	EventUpdated         = "user.updated"
+	EventPasswordChanged = "user.password_changed" // Along with EventUpdated
+	EventDeleted         = "user.deleted"
+	EventRestored        = "user.restored"
+	EventLoggedIn        = "user.logged_in"
+)
+
+// EventTypes : Every event type, in the order they are documented
+var EventTypes = []string{EventCreated, EventUpdated, EventPasswordChanged, EventDeleted, EventRestored, EventLoggedIn}
+
+// Event : Lifecycle event of a user, recorded by the Repository in the transaction of the change and published by
+// the Relay. The user is as it was right after the change, its version orders the events of a user.
+type Event struct {
+	ID         string // Random, the same event delivered twice keeps its ID
+	Type       string // One of EventTypes
+	OccurredAt time.Time
+	TenantID   string // Of the user, set by the Repository when it records the event
+	User       UserInfoPayload
+}
+
+// NewEvent : Event of type eventType about u, occurring now
+func NewEvent(eventType string, u UserInfoPayload) Event {
+	return Event{ID: newEventID(), Type: eventType, OccurredAt: time.Now().UTC(), User: u}
+}
+
+// eventJSON : JSON encoding of the events, the body of the webhook deliveries and of the event bus messages
+type eventJSON struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	TenantID  string    `json:"tenant_id"`
+	Data      struct {
+		User UserInfoPayload `json:"user"`
+	} `json:"data"`
+}
+
+// MarshalJSON : {"id", "type", "created_at", "tenant_id", "data": {"user"}}
+func (e Event) MarshalJSON() ([]byte, error) {
+	v := eventJSON{ID: e.ID, Type: e.Type, CreatedAt: e.OccurredAt, TenantID: e.TenantID}
+	v.Data.User = e.User
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON : See MarshalJSON
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var v eventJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = Event{ID: v.ID, Type: v.Type, OccurredAt: v.CreatedAt, TenantID: v.TenantID, User: v.Data.User}
+	return nil
+}
+
+// Publisher : Receives the events published by the Relay. Delivery is at least once : an event is published again
+// when Publish fails, or when the process stops before it is marked as published.
+type Publisher interface {
+	Publish(context.Context, Event) error
+}
+
+type multiPublisher []Publisher
+
+// MultiPublisher : Publisher publishing to each of publishers, the nil ones are skipped. It fails if any of them
+// fails, after trying them all, so the ones which succeeded get the event again when it is retried.
+func MultiPublisher(publishers ...Publisher) Publisher {
+	var m multiPublisher
+	for _, p := range publishers {
+		if p != nil {
+			m = append(m, p)
+		}
+	}
+	return m
+}
+
+func (m multiPublisher) Publish(ctx context.Context, e Event) error {
+	var first error
+	for _, p := range m {
+		if err := p.Publish(ctx, e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// newEventID : Random 128 bit event ID
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,456 @@
+package user
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// GraphQLSchema : Schema of the users' GraphQL API, served with the resolver of NewGraphQLResolver.
+// The queries need an authenticated user, as do the mutations but createUser.
+const GraphQLSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+scalar Time
+
+type Query {
+	"The authenticated user"
+	me: User!
+	"The user with the given ID, null when there's none"
+	user(id: ID!): User
+	"Pages through the users, sorted by id unless sort says otherwise : username, created_at or updated_at, prefixed with - to sort descending. The emails can't be sorted or filtered on, they aren't disclosed."
+	users(first: Int, after: String, sort: String, filter: UserFilter): UserConnection!
+}
+
+type Mutation {
+	createUser(input: CreateUserInput!): User!
+	"Changes the authenticated user's password, based on version when given"
+	updatePassword(input: UpdatePasswordInput!): User!
+	"Deletes the authenticated user's account, which stays restorable until restorableUntil"
+	deleteUser(id: ID!, version: Int): DeleteUserPayload!
+}
+
+type User {
+	id: ID!
+	username: String!
+	"Only visible to the user, null for the others"
+	email: String
+	status: String!
+	"Bumped by every write"
+	version: Int!
+	createdAt: Time!
+	updatedAt: Time!
+	"The profile fields are null when unset"
+	displayName: String
+	"BCP 47 language tag, e.g. en-US"
+	locale: String
+	"IANA time zone, e.g. Europe/Paris"
+	timezone: String
+	bio: String
+	"Changes with every upload, the avatar is served by GET /v1/user/{id}/avatar. Null without one."
+	avatarId: String
+}
+
+type UserConnection {
+	edges: [UserEdge!]!
+	pageInfo: PageInfo!
+	"Number of users matching the filter, costs an extra query"
+	totalCount: Int!
+}
+
+type UserEdge {
+	cursor: String!
+	node: User!
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	endCursor: String
+}
+
+input UserFilter {
+	usernamePrefix: String
+	createdAfter: Time
+	createdBefore: Time
+	status: String
+}
+
+input CreateUserInput {
+	username: String!
+	email: String!
+	password: String!
+}
+
+input UpdatePasswordInput {
+	currentPassword: String!
+	newPassword: String!
+	version: Int
+}
+
+type DeleteUserPayload {
+	restorableUntil: Time!
+}
+`
+
+type graphQLResolver struct {
+	userService Service
+}
+
+// NewGraphQLResolver : Root resolver of GraphQLSchema, serving the same Service as NewHandler. The users are looked up
+// through the request's Loader when it has one, see LoaderMiddleware.
+func NewGraphQLResolver(userService Service) interface{} {
+	return &graphQLResolver{userService: userService}
+}
+
+// viewer : ID of the authenticated user, auth.ErrUnauthenticated for anonymous requests
+func viewer(ctx context.Context) (uint64, error) {
+	uid, ok := requestctx.UserID(ctx)
+	if !ok {
+		return 0, auth.ErrUnauthenticated
+	}
+	return uid, nil
+}
+
+// load : User uid, batched with the other lookups of the request
+func (r *graphQLResolver) load(ctx context.Context, uid uint64) (*User, error) {
+	if l := LoaderFrom(ctx); l != nil {
+		return l.Load(ctx, uid)
+	}
+	return r.userService.GetUserByID(ctx, uid)
+}
+
+// prime : Caches u in the request's Loader, if any
+func prime(ctx context.Context, u *User) {
+	if l := LoaderFrom(ctx); l != nil {
+		l.Prime(u)
+	}
+}
+
+func (r *graphQLResolver) Me(ctx context.Context) (*userResolver, error) {
+	uid, err := viewer(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.Me")
+	}
+	u, err := r.load(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.Me")
+	}
+	return &userResolver{u}, nil
+}
+
+func (r *graphQLResolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	if _, err := viewer(ctx); err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.User")
+	}
+	uid, err := parseID(args.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.User")
+	}
+	u, err := r.load(ctx, uid)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.User")
+	}
+	return &userResolver{u}, nil
+}
+
+func (r *graphQLResolver) Users(ctx context.Context, args struct {
+	First  *int32
+	After  *string
+	Sort   *string
+	Filter *struct {
+		UsernamePrefix *string
+		CreatedAfter   *graphql.Time
+		CreatedBefore  *graphql.Time
+		Status         *string
+	}
+}) (*connectionResolver, error) {
+	if _, err := viewer(ctx); err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.Users")
+	}
+	var q ListQuery
+	var fields []apperrors.FieldError
+	var err error
+	q.Sort, q.Desc, err = ParseSort(stringValue(args.Sort))
+	if e, ok := apperrors.As(err); ok {
+		fields = append(fields, e.Fields...)
+	}
+	// The order of the users would tell their emails apart, like the admin console's email_domain filter
+	if q.Sort == "email" {
+		fields = append(fields, apperrors.FieldError{Field: "sort", Message: "can't be email, the emails aren't disclosed"})
+	}
+	if args.First != nil {
+		if q.Limit = int(*args.First); q.Limit <= 0 {
+			fields = append(fields, apperrors.FieldError{Field: "first", Message: "must be a positive integer"})
+		}
+	}
+	if args.After != nil {
+		if q.After, err = DecodeCursor(*args.After); err != nil {
+			fields = append(fields, apperrors.FieldError{Field: "after", Message: "must be the endCursor of a previous page of the same listing"})
+		}
+	}
+	if f := args.Filter; f != nil {
+		q.Filter.UsernamePrefix = stringValue(f.UsernamePrefix)
+		q.Filter.Status = stringValue(f.Status)
+		if f.CreatedAfter != nil {
+			q.Filter.CreatedAfter = f.CreatedAfter.Time
+		}
+		if f.CreatedBefore != nil {
+			q.Filter.CreatedBefore = f.CreatedBefore.Time
+		}
+	}
+	if len(fields) > 0 {
+		return nil, errors.Wrap(apperrors.Validation("Invalid listing", fields...), "pkg.user.graphql.Users")
+	}
+	page, err := r.userService.ListUsers(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.Users")
+	}
+	for i := range page.Users {
+		prime(ctx, &page.Users[i])
+	}
+	return &connectionResolver{userService: r.userService, query: q, page: page}, nil
+}
+
+func (r *graphQLResolver) CreateUser(ctx context.Context, args struct {
+	Input struct {
+		Username string
+		Email    string
+		Password string
+	}
+}) (*userResolver, error) {
+	user := User{Password: args.Input.Password}
+	user.Username = args.Input.Username
+	user.Email = args.Input.Email
+	if err := validateStruct(user); err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.CreateUser")
+	}
+	created, err := r.userService.CreateUser(ctx, &user)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.CreateUser")
+	}
+	prime(ctx, created)
+	return &userResolver{created}, nil
+}
+
+func (r *graphQLResolver) UpdatePassword(ctx context.Context, args struct {
+	Input struct {
+		CurrentPassword string
+		NewPassword     string
+		Version         *int32
+	}
+}) (*userResolver, error) {
+	uid, err := viewer(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.UpdatePassword")
+	}
+	current, err := r.userService.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.UpdatePassword")
+	}
+	update := ProfileUpdate{
+		Username:        current.Username,
+		Email:           current.Email,
+		Password:        &args.Input.NewPassword,
+		CurrentPassword: &args.Input.CurrentPassword,
+		Profile:         current.Profile,
+	}
+	updated, err := r.userService.UpdateProfile(ctx, uid, versionValue(args.Input.Version), update)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.UpdatePassword")
+	}
+	prime(ctx, updated)
+	return &userResolver{updated}, nil
+}
+
+func (r *graphQLResolver) DeleteUser(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version *int32
+}) (*deletePayloadResolver, error) {
+	uid, err := viewer(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.DeleteUser")
+	}
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.DeleteUser")
+	}
+	if id != uid {
+		return nil, errors.Wrap(errDeleteForbidden, "pkg.user.graphql.DeleteUser")
+	}
+	restorableUntil, err := r.userService.DeleteUser(ctx, id, versionValue(args.Version))
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.graphql.DeleteUser")
+	}
+	return &deletePayloadResolver{restorableUntil}, nil
+}
+
+// userResolver : Fields of a User
+type userResolver struct {
+	u *User
+}
+
+func (r *userResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatUint(r.u.ID, 10))
+}
+
+func (r *userResolver) Username() string {
+	return r.u.Username
+}
+
+// Email : Field level authorization, the email of a user is only disclosed to the user
+func (r *userResolver) Email(ctx context.Context) *string {
+	if uid, ok := requestctx.UserID(ctx); !ok || uid != r.u.ID {
+		return nil
+	}
+	return &r.u.Email
+}
+
+func (r *userResolver) Status() string {
+	return r.u.Status
+}
+
+func (r *userResolver) Version() int32 {
+	return int32(r.u.Version)
+}
+
+func (r *userResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.u.CreatedAt}
+}
+
+func (r *userResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.u.UpdatedAt}
+}
+
+func (r *userResolver) DisplayName() *string {
+	return optional(r.u.DisplayName)
+}
+
+func (r *userResolver) Locale() *string {
+	return optional(r.u.Locale)
+}
+
+func (r *userResolver) Timezone() *string {
+	return optional(r.u.Timezone)
+}
+
+func (r *userResolver) Bio() *string {
+	return optional(r.u.Bio)
+}
+
+func (r *userResolver) AvatarID() *string {
+	return optional(r.u.AvatarID)
+}
+
+// optional : nil for the empty string, which GraphQL tells apart as null
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// connectionResolver : Page of users listed by query
+type connectionResolver struct {
+	userService Service
+	query       ListQuery
+	page        *ListPage
+}
+
+func (r *connectionResolver) Edges() []*edgeResolver {
+	edges := make([]*edgeResolver, 0, len(r.page.Users))
+	for i := range r.page.Users {
+		u := &r.page.Users[i]
+		edges = append(edges, &edgeResolver{cursor: CursorOf(u, r.query).Encode(), node: &userResolver{u}})
+	}
+	return edges
+}
+
+func (r *connectionResolver) PageInfo() *pageInfoResolver {
+	info := &pageInfoResolver{hasNextPage: r.page.Next != nil}
+	if n := len(r.page.Users); n > 0 {
+		cursor := CursorOf(&r.page.Users[n-1], r.query).Encode()
+		info.endCursor = &cursor
+	}
+	return info
+}
+
+// TotalCount : Only counted when asked for, with a listing of a single user
+func (r *connectionResolver) TotalCount(ctx context.Context) (int32, error) {
+	q := r.query
+	q.After, q.Limit, q.WithTotal = nil, 1, true
+	page, err := r.userService.ListUsers(ctx, q)
+	if err != nil {
+		return 0, errors.Wrap(err, "pkg.user.graphql.TotalCount")
+	}
+	return int32(*page.Total), nil
+}
+
+type edgeResolver struct {
+	cursor string
+	node   *userResolver
+}
+
+func (r *edgeResolver) Cursor() string {
+	return r.cursor
+}
+
+func (r *edgeResolver) Node() *userResolver {
+	return r.node
+}
+
+type pageInfoResolver struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+func (r *pageInfoResolver) HasNextPage() bool {
+	return r.hasNextPage
+}
+
+func (r *pageInfoResolver) EndCursor() *string {
+	return r.endCursor
+}
+
+type deletePayloadResolver struct {
+	restorableUntil time.Time
+}
+
+func (r *deletePayloadResolver) RestorableUntil() graphql.Time {
+	return graphql.Time{Time: r.restorableUntil}
+}
+
+// parseID : User ID held by a GraphQL ID
+func parseID(id graphql.ID) (uint64, error) {
+	uid, err := strconv.ParseUint(string(id), 10, 64)
+	if err != nil || uid == 0 {
+		return 0, errInvalidID
+	}
+	return uid, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// versionValue : Version a write is based on, 0 for unconditional writes
+func versionValue(v *int32) uint64 {
+	if v == nil || *v < 0 {
+		return 0
+	}
+	return uint64(*v)
+}
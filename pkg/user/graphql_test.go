@@ -0,0 +1,78 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// listingService : Service listing alice then bob, a page at a time
+type listingService struct {
+	Service // Only ListUsers is used
+	users   []User
+}
+
+func (s *listingService) ListUsers(_ context.Context, q ListQuery) (*ListPage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	start := 0
+	if q.After != nil {
+		start = int(q.After.ID)
+	}
+	page := &ListPage{Users: s.users[start:]}
+	if len(page.Users) > q.Limit {
+		page.Users = page.Users[:q.Limit]
+		page.Next = CursorOf(&page.Users[q.Limit-1], q)
+	}
+	return page, nil
+}
+
+func TestGraphQLUsersHideTheEmails(t *testing.T) {
+	svc := &listingService{users: make([]User, 2)}
+	for i, name := range []string{"alice", "bob"} {
+		svc.users[i].ID = uint64(i + 1)
+		svc.users[i].Username = name
+		svc.users[i].Email = name + "@example.com"
+	}
+	schema := graphql.MustParseSchema(GraphQLSchema, NewGraphQLResolver(svc), graphql.UseStringDescriptions())
+	ctx := requestctx.WithUserID(context.Background(), 1)
+
+	resp := schema.Exec(ctx, `{ users(first: 1, sort: "username") { edges { cursor node { username email } } pageInfo { endCursor } } }`, "", nil)
+	if len(resp.Errors) > 0 {
+		t.Fatal(resp.Errors)
+	}
+	var data struct {
+		Users struct {
+			Edges []struct {
+				Cursor string
+				Node   struct{ Username, Email *string }
+			}
+			PageInfo struct{ EndCursor string }
+		}
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, edge := range data.Users.Edges {
+		raw, _ := base64.RawURLEncoding.DecodeString(edge.Cursor)
+		if strings.Contains(string(raw), "@") {
+			t.Errorf("cursor %q tells an email", edge.Cursor)
+		}
+	}
+
+	for name, query := range map[string]string{
+		"sorted by email":             `{ users(sort: "email") { edges { cursor } } }`,
+		"sorted by email, descending": `{ users(sort: "-email") { edges { cursor } } }`,
+		"filtered on the email":       `{ users(filter: {emailDomain: "example.com"}) { edges { cursor } } }`,
+	} {
+		if resp := schema.Exec(ctx, query, "", nil); len(resp.Errors) == 0 {
+			t.Errorf("users %s : no error, want it rejected", name)
+		}
+	}
+}
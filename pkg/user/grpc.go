@@ -0,0 +1,229 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/LuD1161/restructuring-tnbt/pkg/userpb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// PublicGRPCMethods : Methods of the gRPC service callable without credentials, like the public HTTP routes
+var PublicGRPCMethods = []string{
+	userpb.UserService_Login_FullMethodName,
+	userpb.UserService_CreateUser_FullMethodName,
+}
+
+// AdminGRPCMethods : Methods of the gRPC service taking the admin token rather than a user's credentials, like the
+// admin console's HTTP routes, see auth.StaticTokenInterceptor
+var AdminGRPCMethods = []string{
+	userpb.UserService_ListUsers_FullMethodName,
+}
+
+type grpcServer struct {
+	userpb.UnimplementedUserServiceServer
+	userService Service
+}
+
+// NewGRPCServer : gRPC counterpart of NewHandler, serving the same Service
+func NewGRPCServer(userService Service) userpb.UserServiceServer {
+	return &grpcServer{userService: userService}
+}
+
+func (s *grpcServer) Login(ctx context.Context, req *userpb.LoginRequest) (*userpb.LoginResponse, error) {
+	token, err := s.userService.Login(ctx, req.GetUsername(), req.GetPassword())
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.grpc.Login")
+	}
+	return &userpb.LoginResponse{Token: token}, nil
+}
+
+func (s *grpcServer) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.User, error) {
+	user := User{Password: req.GetPassword()}
+	user.Username = req.GetUsername()
+	user.Email = req.GetEmail()
+	if err := validateStruct(user); err != nil {
+		return nil, errors.Wrap(err, "pkg.user.grpc.CreateUser")
+	}
+	created, err := s.userService.CreateUser(ctx, &user)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.grpc.CreateUser")
+	}
+	return toProto(created), nil
+}
+
+func (s *grpcServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	if req.GetId() == 0 {
+		return nil, errInvalidID
+	}
+	user, err := s.userService.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.grpc.GetUser")
+	}
+	// Like GetUserByID over HTTP, the email is only disclosed to the user
+	pb := toProto(user)
+	if uid, _ := requestctx.UserID(ctx); uid != user.ID {
+		pb.Email = ""
+	}
+	return pb, nil
+}
+
+func (s *grpcServer) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	if uid, _ := requestctx.UserID(ctx); uid != req.GetId() {
+		return nil, errPatchForbidden
+	}
+	current, err := s.userService.GetUserByID(ctx, req.GetId())
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.grpc.UpdateUser")
+	}
+	update := ProfileUpdate{
+		Username:        current.Username,
+		Email:           current.Email,
+		Password:        req.Password,
+		CurrentPassword: req.CurrentPassword,
+		Profile:         current.Profile,
+	}
+	if req.Username != nil {
+		update.Username = req.GetUsername()
+	}
+	if req.Email != nil {
+		update.Email = req.GetEmail()
+	}
+	if p := req.GetProfile(); p != nil {
+		applyProfileUpdate(&update.Profile, p)
+	}
+	updated, err := s.userService.UpdateProfile(ctx, req.GetId(), req.GetVersion(), update)
+	if err != nil {
+		return nil, versionError(errors.Wrap(err, "pkg.user.grpc.UpdateUser"))
+	}
+	return toProto(updated), nil
+}
+
+func (s *grpcServer) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	if uid, _ := requestctx.UserID(ctx); uid != req.GetId() {
+		return nil, errDeleteForbidden
+	}
+	restorableUntil, err := s.userService.DeleteUser(ctx, req.GetId(), req.GetVersion())
+	if err != nil {
+		return nil, versionError(errors.Wrap(err, "pkg.user.grpc.DeleteUser"))
+	}
+	return &userpb.DeleteUserResponse{RestorableUntil: timestamppb.New(restorableUntil)}, nil
+}
+
+func (s *grpcServer) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	q, err := listQueryFromProto(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.grpc.ListUsers")
+	}
+	page, err := s.userService.ListUsers(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkg.user.grpc.ListUsers")
+	}
+	resp := &userpb.ListUsersResponse{Users: make([]*userpb.User, 0, len(page.Users)), Total: page.Total}
+	for i := range page.Users {
+		resp.Users = append(resp.Users, toProto(&page.Users[i]))
+	}
+	if page.Next != nil {
+		resp.NextPageToken = page.Next.Encode()
+	}
+	return resp, nil
+}
+
+// listQueryFromProto : ListQuery described by the request, see listQuery for the HTTP one
+func listQueryFromProto(req *userpb.ListUsersRequest) (ListQuery, error) {
+	q := ListQuery{Limit: int(req.GetPageSize()), WithTotal: req.GetWithTotal()}
+	var fields []apperrors.FieldError
+	var err error
+	q.Sort, q.Desc, err = ParseSort(req.GetSort())
+	if e, ok := apperrors.As(err); ok {
+		fields = append(fields, e.Fields...)
+	}
+	if req.GetPageSize() < 0 {
+		fields = append(fields, apperrors.FieldError{Field: "page_size", Message: "can't be negative"})
+	}
+	if token := req.GetPageToken(); token != "" {
+		if q.After, err = DecodeCursor(token); err != nil {
+			fields = append(fields, apperrors.FieldError{Field: "page_token", Message: "is invalid"})
+		}
+	}
+	if f := req.GetFilter(); f != nil {
+		q.Filter.UsernamePrefix = f.GetUsernamePrefix()
+		q.Filter.EmailDomain = f.GetEmailDomain()
+		q.Filter.Status = f.GetStatus()
+		if f.CreatedAfter != nil {
+			q.Filter.CreatedAfter = f.GetCreatedAfter().AsTime()
+		}
+		if f.CreatedBefore != nil {
+			q.Filter.CreatedBefore = f.GetCreatedBefore().AsTime()
+		}
+	}
+	if len(fields) > 0 {
+		return q, apperrors.Validation("Invalid listing", fields...)
+	}
+	return q, nil
+}
+
+// versionError : A stale version is a concurrency conflict, which gRPC tells apart from a duplicate
+func versionError(err error) error {
+	if errors.Is(err, ErrVersionConflict) {
+		return status.Error(codes.Aborted, ErrVersionConflict.Message)
+	}
+	return err
+}
+
+func toProto(u *User) *userpb.User {
+	return &userpb.User{
+		Id:        u.ID,
+		Username:  u.Username,
+		Email:     u.Email,
+		Status:    u.Status,
+		Version:   u.Version,
+		CreatedAt: timestamp(u.CreatedAt),
+		UpdatedAt: timestamp(u.UpdatedAt),
+		Profile:   profileToProto(u.Profile),
+	}
+}
+
+func profileToProto(p Profile) *userpb.Profile {
+	// The attributes are decoded from JSON, structpb takes any of their values
+	attributes, _ := structpb.NewStruct(p.Attributes)
+	return &userpb.Profile{
+		DisplayName: p.DisplayName,
+		Locale:      p.Locale,
+		Timezone:    p.Timezone,
+		Bio:         p.Bio,
+		Attributes:  attributes,
+	}
+}
+
+// applyProfileUpdate : Sets the fields of p which the update carries, the service validates them
+func applyProfileUpdate(p *Profile, update *userpb.ProfileUpdate) {
+	if update.DisplayName != nil {
+		p.DisplayName = update.GetDisplayName()
+	}
+	if update.Locale != nil {
+		p.Locale = update.GetLocale()
+	}
+	if update.Timezone != nil {
+		p.Timezone = update.GetTimezone()
+	}
+	if update.Bio != nil {
+		p.Bio = update.GetBio()
+	}
+	if update.Attributes != nil {
+		p.Attributes = update.GetAttributes().AsMap()
+	}
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,97 @@
+package user
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/LuD1161/restructuring-tnbt/pkg/userpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// profileService : Service holding user 1, applying the updates as they are given
+type profileService struct {
+	Service // Not used by UpdateUser
+	user    User
+}
+
+func (s *profileService) GetUserByID(_ context.Context, uid uint64) (*User, error) {
+	if uid != s.user.ID {
+		return nil, ErrUserNotFound
+	}
+	u := s.user
+	return &u, nil
+}
+
+func (s *profileService) UpdateProfile(_ context.Context, uid, _ uint64, p ProfileUpdate) (*User, error) {
+	s.user.Username, s.user.Email, s.user.Profile = p.Username, p.Email, p.Profile
+	return s.GetUserByID(context.Background(), uid)
+}
+
+func TestGRPCProfile(t *testing.T) {
+	svc := &profileService{}
+	svc.user.ID = 1
+	svc.user.Username = "alice"
+	svc.user.Profile = Profile{
+		DisplayName: "Alice", Locale: "fr-CA", Timezone: "America/Toronto", Bio: "Hi",
+		Attributes: Attributes{"department": "R&D", "employee_id": float64(42)},
+	}
+	s := NewGRPCServer(svc)
+	ctx := requestctx.WithUserID(context.Background(), 1)
+
+	got, err := s.GetUser(ctx, &userpb.GetUserRequest{Id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attributes, _ := structpb.NewStruct(map[string]interface{}{"department": "R&D", "employee_id": 42})
+	want := &userpb.Profile{DisplayName: "Alice", Locale: "fr-CA", Timezone: "America/Toronto", Bio: "Hi", Attributes: attributes}
+	if !proto.Equal(got.GetProfile(), want) {
+		t.Errorf("GetUser() profile = %v, want %v", got.GetProfile(), want)
+	}
+
+	t.Run("email only disclosed to the user", func(t *testing.T) {
+		svc.user.Email = "alice@example.com"
+		if got, err := s.GetUser(ctx, &userpb.GetUserRequest{Id: 1}); err != nil || got.GetEmail() != "alice@example.com" {
+			t.Errorf("GetUser() of the caller = %q, %v, want its email", got.GetEmail(), err)
+		}
+		other := requestctx.WithUserID(context.Background(), 2)
+		if got, err := s.GetUser(other, &userpb.GetUserRequest{Id: 1}); err != nil || got.GetEmail() != "" {
+			t.Errorf("GetUser() of another user = %q, %v, want no email", got.GetEmail(), err)
+		}
+	})
+
+	t.Run("only the fields set change", func(t *testing.T) {
+		bio := "Hello"
+		_, err := s.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: 1, Profile: &userpb.ProfileUpdate{Bio: &bio}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := svc.user.Profile
+		if p.Bio != "Hello" || p.DisplayName != "Alice" || p.Locale != "fr-CA" || len(p.Attributes) != 2 {
+			t.Errorf("profile = %+v, want the bio changed only", p)
+		}
+	})
+
+	t.Run("attributes replaced as a whole", func(t *testing.T) {
+		replaced, _ := structpb.NewStruct(map[string]interface{}{"department": "Sales"})
+		_, err := s.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: 1, Profile: &userpb.ProfileUpdate{Attributes: replaced}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (Attributes{"department": "Sales"}); !reflect.DeepEqual(svc.user.Profile.Attributes, want) {
+			t.Errorf("attributes = %v, want %v", svc.user.Profile.Attributes, want)
+		}
+	})
+
+	t.Run("without profile, left as is", func(t *testing.T) {
+		username := "alice2"
+		if _, err := s.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: 1, Username: &username}); err != nil {
+			t.Fatal(err)
+		}
+		if svc.user.Username != "alice2" || svc.user.Profile.DisplayName != "Alice" || svc.user.Profile.Bio != "Hello" {
+			t.Errorf("user = %s with %+v, want the username changed only", svc.user.Username, svc.user.Profile)
+		}
+	})
+}
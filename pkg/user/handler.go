@@ -3,15 +3,18 @@ package user
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
 	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
-	"gopkg.in/go-playground/validator.v9"
 )
 
 // Handler : Handler for User
@@ -21,47 +24,240 @@ type Handler interface {
 	CreateUser(c *gin.Context)
 	UpdateUser(c *gin.Context)
 	DeleteUser(c *gin.Context)
+	RestoreUser(c *gin.Context)
+	ListUsers(c *gin.Context)
+	SearchUsers(c *gin.Context)
+	AutocompleteUsers(c *gin.Context)
+	PatchUser(c *gin.Context)
+	UploadAvatar(c *gin.Context)
+	GetAvatar(c *gin.Context)
+	DeleteAvatar(c *gin.Context)
 }
 
+var (
+	errInvalidID       = apperrors.Validation("Invalid user ID", apperrors.FieldError{Field: "id", Message: "must be a positive integer"})
+	errMalformedBody   = apperrors.Validation("Malformed JSON body")
+	errDeleteForbidden = apperrors.Forbidden("Only your own account can be deleted")
+	errPatchForbidden  = apperrors.Forbidden("Only your own account can be updated")
+	errAvatarForbidden = apperrors.Forbidden("Only your own avatar can be changed")
+	errNoAvatarPart    = apperrors.Validation("Missing avatar", apperrors.FieldError{Field: "avatar", Message: "is required"})
+	errInvalidSize     = apperrors.Validation("Invalid avatar size", apperrors.FieldError{Field: "size", Message: "must be a positive integer"})
+)
+
 type userHandler struct {
 	userService Service
-	log         *logrus.Logger
 }
 
-// NewHandler : Returns handler for new user service
-func NewHandler(userService Service, log *logrus.Logger) Handler {
+// NewHandler : Returns handler for new user service. Errors are rendered by problem.Middleware
+func NewHandler(userService Service) Handler {
 	return &userHandler{
 		userService,
-		log,
 	}
 }
 
 // @Summary Get User by ID
-// @Description Get the user details by ID
+// @Description Get the user details by ID, the email is only disclosed to the user
 // @Tags User
 // @Accept  json
 // @Produce  json
 // @Param   id     path    int     true        "User ID"
 // @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param If-None-Match header string false "ETag of a previous response, answered with a 304 while it is current"
 // @Success 200 {object} UserInfoPayload
+// @Success 304 {string} string "Not Modified"
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
 // @Router /user/{id} [get]
 func (h *userHandler) GetUserByID(c *gin.Context) {
 	uid, err := strconv.ParseUint(c.Param("id"), 10, 64)
-	h.log.Infof("in GetUserByID")
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.GetUserByID").Error(),
-		})
+		problem.Abort(c, errors.Wrap(errInvalidID.Wrap(err), "pkg.user.handler.GetUserByID"))
+		return
+	}
+	user, err := h.userService.GetUserByID(c.Request.Context(), uid)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.GetUserByID"))
+		return
+	}
+	// The ETag only depends on the version, the body on the caller too
+	c.Header("Vary", "Authorization")
+	if notModified(c, user) {
+		return
+	}
+	viewer, _ := auth.UserID(c)
+	c.Header("ETag", ETag(user))
+	c.JSON(http.StatusOK, user.disclosedTo(viewer))
+}
+
+// disclosedTo : What the viewer sees of the user, the email is only disclosed to the user
+func (u *User) disclosedTo(viewer uint64) UserInfoPayload {
+	info := u.UserInfoPayload
+	if viewer != u.ID {
+		info.Email = ""
+	}
+	return info
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description Admin console : page through the users of the tenant, the next page is fetched by passing back next_cursor
+// @Tags User
+// @Produce  json
+// @Param username_prefix query string false "Usernames starting with"
+// @Param email_domain query string false "Email domain, e.g. gmail.com"
+// @Param created_after query string false "RFC 3339 timestamp, inclusive"
+// @Param created_before query string false "RFC 3339 timestamp, exclusive"
+// @Param status query string false "active or disabled"
+// @Param sort query string false "id, username, email, created_at or updated_at, prefixed with - to sort descending"
+// @Param limit query int false "Page size, 20 by default and at most 100"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param total query bool false "Include the total number of matching users"
+// @Param Authorization header string true "Bearer followed by the admin token"
+// @Success 200 {object} ListUsersResponse
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /users [get]
+func (h *userHandler) ListUsers(c *gin.Context) {
+	q, err := listQuery(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.ListUsers"))
 		return
 	}
-	user, err := h.userService.GetUserByID(uid)
+	page, err := h.userService.ListUsers(c.Request.Context(), q)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.GetUserByID").Error(),
-		})
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.ListUsers"))
 		return
 	}
-	c.JSON(http.StatusOK, user.UserInfoPayload)
+	resp := ListUsersResponse{Users: make([]UserInfoPayload, 0, len(page.Users)), Total: page.Total}
+	for _, u := range page.Users {
+		resp.Users = append(resp.Users, u.UserInfoPayload)
+	}
+	if page.Next != nil {
+		resp.NextCursor = page.Next.Encode()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// SearchUsers godoc
+// @Summary Search users
+// @Description Admin console : typeahead search over the usernames and emails of the active users, prefix matches rank first followed by the fuzzy ones
+// @Tags User
+// @Produce  json
+// @Param q query string true "Text to search for"
+// @Param limit query int false "Number of results, 10 by default and at most 50"
+// @Param Authorization header string true "Bearer followed by the admin token"
+// @Success 200 {object} SearchUsersResponse
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /users/search [get]
+func (h *userHandler) SearchUsers(c *gin.Context) {
+	q, err := searchQuery(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.SearchUsers"))
+		return
+	}
+	results, err := h.userService.SearchUsers(c.Request.Context(), q)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.SearchUsers"))
+		return
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+	c.JSON(http.StatusOK, SearchUsersResponse{Results: results})
+}
+
+// AutocompleteUsers godoc
+// @Summary Autocomplete usernames
+// @Description Typeahead search of the other users by username, e.g. for mentions. Only the usernames are matched and
+// @Description the results carry no emails, prefix matches rank first followed by the fuzzy ones.
+// @Tags User
+// @Produce  json
+// @Param q query string true "Text to search for"
+// @Param limit query int false "Number of results, 10 by default and at most 50"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Success 200 {object} SearchUsersResponse
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /users/autocomplete [get]
+func (h *userHandler) AutocompleteUsers(c *gin.Context) {
+	q, err := searchQuery(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.AutocompleteUsers"))
+		return
+	}
+	q.UsernamesOnly = true
+	results, err := h.userService.SearchUsers(c.Request.Context(), q)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.AutocompleteUsers"))
+		return
+	}
+	// The in-memory searcher holds the emails, they aren't disclosed
+	for i := range results {
+		results[i].Email = ""
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+	c.JSON(http.StatusOK, SearchUsersResponse{Results: results})
+}
+
+// searchQuery : SearchQuery described by the query string
+func searchQuery(c *gin.Context) (SearchQuery, error) {
+	q := SearchQuery{Text: c.Query("q")}
+	if limit := c.Query("limit"); limit != "" {
+		var err error
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			return q, apperrors.Validation("Invalid search", apperrors.FieldError{
+				Field: "limit", Message: "must be a positive integer",
+			})
+		}
+	}
+	return q, nil
+}
+
+// listQuery : ListQuery described by the query string
+func listQuery(c *gin.Context) (ListQuery, error) {
+	var q ListQuery
+	var fields []apperrors.FieldError
+	var err error
+	q.Sort, q.Desc, err = ParseSort(c.Query("sort"))
+	if e, ok := apperrors.As(err); ok {
+		fields = append(fields, e.Fields...)
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			fields = append(fields, apperrors.FieldError{Field: "limit", Message: "must be a positive integer"})
+		}
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if q.After, err = DecodeCursor(cursor); err != nil {
+			fields = append(fields, errInvalidCursor.Fields...)
+		}
+	}
+	if total := c.Query("total"); total != "" {
+		if q.WithTotal, err = strconv.ParseBool(total); err != nil {
+			fields = append(fields, apperrors.FieldError{Field: "total", Message: "must be true or false"})
+		}
+	}
+	q.Filter.UsernamePrefix = c.Query("username_prefix")
+	q.Filter.EmailDomain = strings.TrimPrefix(c.Query("email_domain"), "@")
+	q.Filter.Status = c.Query("status")
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"created_after", &q.Filter.CreatedAfter}, {"created_before", &q.Filter.CreatedBefore}} {
+		if v := c.Query(bound.name); v != "" {
+			if *bound.t, err = time.Parse(time.RFC3339, v); err != nil {
+				fields = append(fields, apperrors.FieldError{Field: bound.name, Message: "must be an RFC 3339 timestamp"})
+			}
+		}
+	}
+	if len(fields) > 0 {
+		return q, apperrors.Validation("Invalid listing", fields...)
+	}
+	return q, nil
 }
 
 // FIXME : Probably need to define a USER Response struct -> Done
@@ -73,41 +269,35 @@ func (h *userHandler) GetUserByID(c *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param json body CreateUserPayload true "Create User"
+// @Param Idempotency-Key header string false "Unique key of the request, retries with the same key get the first response replayed"
 // @Success 200 {object} User
+// @Failure 409 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
 // @Router /user/ [post]
 // CreateUser : Creates new user
 func (h *userHandler) CreateUser(c *gin.Context) {
 	body, err := ioutil.ReadAll(c.Request.Body)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.CreateUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(errMalformedBody.Wrap(err), "pkg.user.handler.CreateUser"))
 		return
 	}
 	user := User{}
 	err = json.Unmarshal(body, &user)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.CreateUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(errMalformedBody.Wrap(err), "pkg.user.handler.CreateUser"))
 		return
 	}
 	// FIXME : How to get the Prepare functionality here, currently Prepare sets u.ID = u.ID
 	// instead of u.ID = 0
 	// user.Prepare()
-	v := validator.New()
-	err = v.Struct(user)
+	err = validateStruct(user)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.CreateUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.CreateUser"))
 		return
 	}
-	userCreated, err := h.userService.CreateUser(&user)
+	userCreated, err := h.userService.CreateUser(c.Request.Context(), &user)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.CreateUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.CreateUser"))
 		return
 	}
 	c.Header("Location", fmt.Sprintf("%s%s/%d", c.Request.Host, c.Request.RequestURI, userCreated.ID))
@@ -116,107 +306,205 @@ func (h *userHandler) CreateUser(c *gin.Context) {
 
 // UpdateUser godoc
 // @Summary Update a user
-// @Description Update a user
+// @Description Changes the authenticated user's password, confirmed with the current one
 // @Tags User
 // @Accept  json
 // @Produce  json
-// @Param json body UpdateUserPayload true "Can only update current user's password as of now"
+// @Param json body UpdateUserPayload true "New and current passwords"
 // @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param If-Match header string false "ETag the change is based on, 412 if the user changed since"
 // @Success 200 {object} UserInfoPayload
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
 // @Router /user/ [put]
-// UpdateUser : Updates new user
+// UpdateUser : Changes the password, like a PATCH /user/:id setting it
 func (h *userHandler) UpdateUser(c *gin.Context) {
-	body, err := ioutil.ReadAll(c.Request.Body)
+	updateUser := new(UpdateUserPayload)
+	if err := c.ShouldBindJSON(updateUser); err != nil {
+		problem.Abort(c, errors.Wrap(errMalformedBody.Wrap(err), "pkg.user.handler.UpdateUser"))
+		return
+	}
+	tokenID, err := auth.UserID(c)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.UpdateUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(auth.ErrUnauthenticated.Wrap(err), "pkg.user.handler.UpdateUser"))
 		return
 	}
-	user := new(User)
-	updateUser := new(UpdateUserPayload)
-	// err = json.Unmarshal(body, &user)
-	err = json.Unmarshal(body, &updateUser)
+	if err := validateStruct(updateUser); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.UpdateUser"))
+		return
+	}
+	current, err := h.userService.GetUserByID(c.Request.Context(), tokenID)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.UpdateUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.UpdateUser"))
 		return
 	}
-	tokenID, err := auth.ExtractTokenID(c.Request)
-	// Change this error to unauthorized
+	if err := checkIfMatch(c, current); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.UpdateUser"))
+		return
+	}
+	update := ProfileUpdate{
+		Username:        current.Username,
+		Email:           current.Email,
+		Password:        &updateUser.Password,
+		CurrentPassword: &updateUser.CurrentPassword,
+		Profile:         current.Profile,
+	}
+	// Based on the version If-Match was checked against
+	updatedUser, err := h.userService.UpdateProfile(c.Request.Context(), tokenID, current.Version, update)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.UpdateUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(preconditionError(c, err), "pkg.user.handler.UpdateUser"))
 		return
 	}
-	// FIXME : Check whether it's needed or not
-	user.Password = updateUser.Password
-	h.userService.Prepare(user)
-	v := validator.New()
-	err = v.Struct(updateUser)
+	c.Header("Location", fmt.Sprintf("%s%s/%d", c.Request.Host, c.Request.RequestURI, updatedUser.ID))
+	c.Header("ETag", ETag(updatedUser))
+	c.JSON(http.StatusOK, updatedUser.UserInfoPayload)
+}
+
+// PatchUser godoc
+// @Summary Update a user's profile
+// @Description Applies a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) to
+// @Description {"username","email","password","current_password","display_name","locale","timezone","bio","attributes"}.
+// @Description password and current_password are null in the document, current_password must be set to change the email or the password.
+// @Description attributes must match the JSON Schema of the deployment.
+// @Tags User
+// @Accept  application/merge-patch+json,application/json-patch+json
+// @Produce  json
+// @Param   id     path    int     true        "User ID"
+// @Param json body ProfileUpdate true "Merge patch with the changed fields, or a JSON Patch"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param If-Match header string false "ETag the change is based on, 412 if the user changed since"
+// @Success 200 {object} UserInfoPayload
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 415 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Router /user/{id} [patch]
+func (h *userHandler) PatchUser(c *gin.Context) {
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		h.log.Error(err)
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.UpdateUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(errInvalidID.Wrap(err), "pkg.user.handler.PatchUser"))
 		return
 	}
-	user.ID = tokenID
-	updatedUser, err := h.userService.UpdateUser(user)
+	tokenID, err := auth.UserID(c)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.UpdateUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(auth.ErrUnauthenticated.Wrap(err), "pkg.user.handler.PatchUser"))
 		return
 	}
-	c.Header("Location", fmt.Sprintf("%s%s/%d", c.Request.Host, c.Request.RequestURI, updatedUser.ID))
-	c.JSON(http.StatusOK, user.UserInfoPayload)
+	if tokenID != uid {
+		problem.Abort(c, errors.Wrap(errPatchForbidden, "pkg.user.handler.PatchUser"))
+		return
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(errMalformedBody.Wrap(err), "pkg.user.handler.PatchUser"))
+		return
+	}
+	current, err := h.userService.GetUserByID(c.Request.Context(), uid)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.PatchUser"))
+		return
+	}
+	if err := checkIfMatch(c, current); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.PatchUser"))
+		return
+	}
+	update, err := ApplyPatch(current, c.ContentType(), body)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.PatchUser"))
+		return
+	}
+	// Based on the version the patch was applied to, so concurrent writes can't be lost
+	updated, err := h.userService.UpdateProfile(c.Request.Context(), uid, current.Version, update)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(preconditionError(c, err), "pkg.user.handler.PatchUser"))
+		return
+	}
+	c.Header("ETag", ETag(updated))
+	c.JSON(http.StatusOK, updated.UserInfoPayload)
 }
 
 // DeleteUser godoc
 // @Summary Delete a user
-// @Description Delete a user
+// @Description Deletes the user's own account. It stays restorable (see /user/restore) until restorable_until, then it is purged.
 // @Tags User
-// @Accept  json
 // @Produce  json
-// @Param json body User true "Only own user can be deleted"
-// @Success 200 {object} User
-
+// @Param   id     path    int     true        "User ID"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param If-Match header string false "ETag the change is based on, 412 if the user changed since"
+// @Success 200 {object} DeleteUserResponse
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Router /user/{id} [delete]
 // DeleteUser : Deletes new user
 func (h *userHandler) DeleteUser(c *gin.Context) {
 	uid, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.DeleteUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(errInvalidID.Wrap(err), "pkg.user.handler.DeleteUser"))
 		return
 	}
-	tokenID, err := auth.ExtractTokenID(c.Request)
-	// Change this error to unauthorized
+	tokenID, err := auth.UserID(c)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.DeleteUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(auth.ErrUnauthenticated.Wrap(err), "pkg.user.handler.DeleteUser"))
 		return
 	}
 	if tokenID != uid {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.DeleteUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(errDeleteForbidden, "pkg.user.handler.DeleteUser"))
 		return
 	}
-	status, err := h.userService.DeleteUser(uid)
+	var version uint64
+	if c.GetHeader("If-Match") != "" {
+		current, err := h.userService.GetUserByID(c.Request.Context(), uid)
+		if err == nil {
+			err = checkIfMatch(c, current)
+		}
+		if err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.user.handler.DeleteUser"))
+			return
+		}
+		version = current.Version
+	}
+	restorableUntil, err := h.userService.DeleteUser(c.Request.Context(), uid, version)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.DeleteUser").Error(),
-		})
+		problem.Abort(c, errors.Wrap(preconditionError(c, err), "pkg.user.handler.DeleteUser"))
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"status": status,
-	})
+	c.JSON(http.StatusOK, DeleteUserResponse{RestorableUntil: restorableUntil})
+}
+
+// RestoreUser godoc
+// @Summary Restore a deleted user
+// @Description Restores an account deleted less than the grace period ago, with its username and password
+// @Tags User
+// @Accept  json
+// @Produce  json
+// @Param json body LoginPayload true "Credentials of the deleted account"
+// @Param Idempotency-Key header string false "Unique key of the request, retries with the same key get the first response replayed"
+// @Success 200 {object} UserInfoPayload
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /user/restore [post]
+func (h *userHandler) RestoreUser(c *gin.Context) {
+	credentials := new(LoginPayload)
+	if err := c.ShouldBindJSON(credentials); err != nil {
+		problem.Abort(c, errors.Wrap(errMalformedBody.Wrap(err), "pkg.user.handler.RestoreUser"))
+		return
+	}
+	restored, err := h.userService.RestoreUser(c.Request.Context(), credentials.Username, credentials.Password)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.RestoreUser"))
+		return
+	}
+	c.JSON(http.StatusOK, restored.UserInfoPayload)
 }
 
 // Login godoc
@@ -227,22 +515,20 @@ func (h *userHandler) DeleteUser(c *gin.Context) {
 // @Produce  json
 // @Param json body LoginPayload true "Login to get the JWToken"
 // @Success 200 {string} string "JWToken here"
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
 // @Router /login [post]
 // Login : Login to get a new JWT
 func (h *userHandler) Login(c *gin.Context) {
 	body, err := ioutil.ReadAll(c.Request.Body)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.Login").Error(),
-		})
+		problem.Abort(c, errors.Wrap(errMalformedBody.Wrap(err), "pkg.user.handler.Login"))
 		return
 	}
 	user := new(User)
 	err = json.Unmarshal(body, &user)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.Login").Error(),
-		})
+		problem.Abort(c, errors.Wrap(errMalformedBody.Wrap(err), "pkg.user.handler.Login"))
 		return
 	}
 
@@ -252,14 +538,178 @@ func (h *userHandler) Login(c *gin.Context) {
 	// 	responses.ERROR(w, http.StatusUnprocessableEntity, err)
 	// 	return
 	// }
-	token, err := h.userService.Login(user.Username, user.Password)
+	token, err := h.userService.Login(c.Request.Context(), user.Username, user.Password)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"error": errors.Wrap(err, "pkg.user.handler.Login").Error(),
-		})
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.Login"))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"token": token,
 	})
 }
+
+// UploadAvatar godoc
+// @Summary Upload the user's avatar
+// @Description Replaces the avatar of the user's own account with a JPEG, PNG, GIF or WebP picture, sent as the body or as
+// @Description the "avatar" field of a multipart form. The type is sniffed from the content, the picture is cropped to a square
+// @Description and resized to every size GET /user/{id}/avatar serves.
+// @Tags User
+// @Accept  image/jpeg,image/png,image/gif,image/webp,multipart/form-data
+// @Produce  json
+// @Param   id     path    int     true        "User ID"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param If-Match header string false "ETag the change is based on, 412 if the user changed since"
+// @Success 200 {object} UserInfoPayload
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 413 {object} problem.Problem
+// @Failure 415 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /user/{id}/avatar [put]
+func (h *userHandler) UploadAvatar(c *gin.Context) {
+	uid, version, err := h.ownAvatar(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.UploadAvatar"))
+		return
+	}
+	body := io.Reader(c.Request.Body)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		if body, err = avatarPart(c); err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.user.handler.UploadAvatar"))
+			return
+		}
+	}
+	updated, err := h.userService.SetAvatar(c.Request.Context(), uid, version, body)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(preconditionError(c, err), "pkg.user.handler.UploadAvatar"))
+		return
+	}
+	c.Header("ETag", ETag(updated))
+	c.JSON(http.StatusOK, updated.UserInfoPayload)
+}
+
+// DeleteAvatar godoc
+// @Summary Delete the user's avatar
+// @Description Removes the avatar of the user's own account, if any
+// @Tags User
+// @Produce  json
+// @Param   id     path    int     true        "User ID"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param If-Match header string false "ETag the change is based on, 412 if the user changed since"
+// @Success 200 {object} UserInfoPayload
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Router /user/{id}/avatar [delete]
+func (h *userHandler) DeleteAvatar(c *gin.Context) {
+	uid, version, err := h.ownAvatar(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.DeleteAvatar"))
+		return
+	}
+	updated, err := h.userService.DeleteAvatar(c.Request.Context(), uid, version)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(preconditionError(c, err), "pkg.user.handler.DeleteAvatar"))
+		return
+	}
+	c.Header("ETag", ETag(updated))
+	c.JSON(http.StatusOK, updated.UserInfoPayload)
+}
+
+// ownAvatar : ID of the user whose avatar the request changes, which must be the authenticated one, and the version
+// its If-Match header is based on (0 without one)
+func (h *userHandler) ownAvatar(c *gin.Context) (uint64, uint64, error) {
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, 0, errInvalidID.Wrap(err)
+	}
+	tokenID, err := auth.UserID(c)
+	if err != nil {
+		return 0, 0, auth.ErrUnauthenticated.Wrap(err)
+	}
+	if tokenID != uid {
+		return 0, 0, errAvatarForbidden
+	}
+	if c.GetHeader("If-Match") == "" {
+		return uid, 0, nil
+	}
+	current, err := h.userService.GetUserByID(c.Request.Context(), uid)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := checkIfMatch(c, current); err != nil {
+		return 0, 0, err
+	}
+	return uid, current.Version, nil
+}
+
+// avatarPart : Content of the "avatar" field of a multipart form, streamed rather than buffered by ParseMultipartForm
+func avatarPart(c *gin.Context) (io.Reader, error) {
+	form, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, errMalformedBody.Wrap(err)
+	}
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return nil, errNoAvatarPart
+		}
+		if err != nil {
+			return nil, errMalformedBody.Wrap(err)
+		}
+		if part.FormName() == "avatar" {
+			return part, nil
+		}
+	}
+}
+
+// GetAvatar godoc
+// @Summary Get a user's avatar
+// @Description The avatar of a user, a square JPEG or PNG image
+// @Tags User
+// @Produce  image/jpeg,image/png
+// @Param   id     path    int     true        "User ID"
+// @Param size query int false "Edge in pixels, 512 (the default), 128 or 64"
+// @Param Authorization header string true "JWT header starting with the Bearer"
+// @Param If-None-Match header string false "ETag of a previous response, answered with a 304 while it is current"
+// @Success 200 {string} string "The avatar"
+// @Success 304 {string} string "Not Modified"
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /user/{id}/avatar [get]
+func (h *userHandler) GetAvatar(c *gin.Context) {
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(errInvalidID.Wrap(err), "pkg.user.handler.GetAvatar"))
+		return
+	}
+	var size int
+	if raw := c.Query("size"); raw != "" {
+		if size, err = strconv.Atoi(raw); err != nil || size <= 0 {
+			problem.Abort(c, errors.Wrap(errInvalidSize, "pkg.user.handler.GetAvatar"))
+			return
+		}
+	}
+	avatar, err := h.userService.GetAvatar(c.Request.Context(), uid, size)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.user.handler.GetAvatar"))
+		return
+	}
+	defer avatar.Close()
+	c.Header("ETag", avatar.ETag)
+	// Every upload gets a new ETag, the clients revalidate rather than refetch
+	c.Header("Cache-Control", "private, no-cache")
+	if header := c.GetHeader("If-None-Match"); header != "" && etagMatches(header, avatar.ETag, true) {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	// The extra headers can't be nil, gin adds to them
+	c.DataFromReader(http.StatusOK, avatar.Size, avatar.ContentType, avatar, map[string]string{})
+}
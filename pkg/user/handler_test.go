@@ -0,0 +1,49 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetUserByIDEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := &profileService{}
+	svc.user.ID = 1
+	svc.user.Username = "alice"
+	svc.user.Email = "alice@example.com"
+	r := gin.New()
+	r.Use(problem.Middleware())
+	r.GET("/user/:id", NewHandler(svc).GetUserByID)
+
+	for _, tc := range []struct {
+		name   string
+		viewer uint64
+		email  string
+	}{
+		{"the user", 1, "alice@example.com"},
+		{"another user", 2, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/user/1", nil)
+			req = req.WithContext(requestctx.WithUserID(req.Context(), tc.viewer))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if email, _ := body["email"].(string); email != tc.email || body["username"] != "alice" {
+				t.Errorf("%s sees %s with email %q, want alice with %q", tc.name, body["username"], email, tc.email)
+			}
+		})
+	}
+}
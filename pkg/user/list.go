@@ -0,0 +1,225 @@
+package user
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+)
+
+// Account statuses
+const (
+	StatusActive   = "active"
+	StatusDisabled = "disabled"
+)
+
+// Page sizes of ListUsers
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// SortFields : Columns ListUsers can sort by. Every sort is made unique by the ID, so keyset pagination stays stable.
+var SortFields = []string{"id", "username", "email", "created_at", "updated_at"}
+
+// ListFilter : Conditions on the listed users, zero values don't filter
+type ListFilter struct {
+	UsernamePrefix string
+	EmailDomain    string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	Status         string
+}
+
+// ListQuery : Page of users to list
+type ListQuery struct {
+	Filter    ListFilter
+	Sort      string // One of SortFields
+	Desc      bool
+	Limit     int
+	After     *Cursor // Position of the last user of the previous page, nil for the first page
+	WithTotal bool    // Count the users matching the filter, costs an extra query
+}
+
+// ListPage : Users returned by ListUsers
+type ListPage struct {
+	Users []User
+	Next  *Cursor // nil on the last page
+	Total *int64  // Set when ListQuery.WithTotal is
+}
+
+// cursorSealer : Encrypts the cursors, which hold the sort value of a user (e.g. its email) the caller may not be
+// allowed to see. Keyed with random bytes until ConfigureCursors, the cursors then only work on the instance which
+// issued them.
+var (
+	cursorMu     sync.RWMutex
+	cursorSealer = newCursorSealer(randomSecret())
+)
+
+// ConfigureCursors : Derives the keys of the cursors from secret, shared by the instances serving the same clients
+func ConfigureCursors(secret string) {
+	sealer := newCursorSealer([]byte(secret))
+	cursorMu.Lock()
+	cursorSealer = sealer
+	cursorMu.Unlock()
+}
+
+// sealer : AES-GCM whose nonces are derived from the plaintexts (a synthetic IV) : a cursor is always sealed the same
+// way, and two cursors never share a nonce
+type sealer struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+func newCursorSealer(secret []byte) *sealer {
+	key := sha256.Sum256(append([]byte("pkg.user.Cursor encryption "), secret...))
+	nonceKey := sha256.Sum256(append([]byte("pkg.user.Cursor nonce "), secret...))
+	// Neither fails, AES takes any 32 bytes key and GCM any AES block
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return &sealer{aead: aead, nonceKey: nonceKey[:]}
+}
+
+func (s *sealer) seal(plaintext []byte) []byte {
+	mac := hmac.New(sha256.New, s.nonceKey)
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:s.aead.NonceSize()]
+	return s.aead.Seal(nonce, nonce, plaintext, nil)
+}
+
+func (s *sealer) open(sealed []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return s.aead.Open(nil, sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():], nil)
+}
+
+func randomSecret() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("pkg.user : no randomness for the cursors key, " + err.Error())
+	}
+	return b
+}
+
+// Cursor : Keyset position, the sort value and the ID of a user
+type Cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v,omitempty"`
+	ID    uint64 `json:"id"`
+}
+
+// CursorOf : Position of u in a listing sorted by q
+func CursorOf(u *User, q ListQuery) *Cursor {
+	c := &Cursor{Sort: q.Sort, Desc: q.Desc, ID: u.ID}
+	switch q.Sort {
+	case "username":
+		c.Value = u.Username
+	case "email":
+		c.Value = u.Email
+	case "created_at":
+		c.Value = u.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "updated_at":
+		c.Value = u.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return c
+}
+
+// SortValue : Value of the sort column at the cursor, typed for the query
+func (c *Cursor) SortValue() (interface{}, error) {
+	switch c.Sort {
+	case "created_at", "updated_at":
+		return time.Parse(time.RFC3339Nano, c.Value)
+	default:
+		return c.Value, nil
+	}
+}
+
+// Encode : Opaque representation handed to clients, encrypted so that they can't read the sort value
+func (c *Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	cursorMu.RLock()
+	defer cursorMu.RUnlock()
+	return base64.RawURLEncoding.EncodeToString(cursorSealer.seal(b))
+}
+
+var errInvalidCursor = apperrors.Validation("Invalid cursor", apperrors.FieldError{Field: "cursor", Message: "must be a cursor returned by a previous page of the same listing"})
+
+// DecodeCursor : Reverse of Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor.Wrap(err)
+	}
+	cursorMu.RLock()
+	b, err := cursorSealer.open(sealed)
+	cursorMu.RUnlock()
+	if err != nil {
+		return nil, errInvalidCursor.Wrap(err)
+	}
+	c := new(Cursor)
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, errInvalidCursor.Wrap(err)
+	}
+	return c, nil
+}
+
+// ParseSort : Sort field and direction of "field" (ascending) or "-field" (descending)
+func ParseSort(s string) (string, bool, error) {
+	if s == "" {
+		return "id", false, nil
+	}
+	desc := strings.HasPrefix(s, "-")
+	field := strings.TrimPrefix(s, "-")
+	for _, f := range SortFields {
+		if f == field {
+			return field, desc, nil
+		}
+	}
+	return "", false, apperrors.Validation("Invalid sort", apperrors.FieldError{
+		Field:   "sort",
+		Message: "must be one of " + strings.Join(SortFields, ", ") + ", prefixed with - to sort descending",
+	})
+}
+
+// Normalize : Applies the defaults to q and checks it's consistent
+func (q *ListQuery) Normalize() error {
+	var fields []apperrors.FieldError
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		fields = append(fields, apperrors.FieldError{Field: "limit", Message: "must be at most 100"})
+	}
+	if q.After != nil {
+		if q.After.Sort != q.Sort || q.After.Desc != q.Desc {
+			fields = append(fields, errInvalidCursor.Fields...)
+		} else if _, err := q.After.SortValue(); err != nil {
+			fields = append(fields, errInvalidCursor.Fields...)
+		}
+	}
+	f := q.Filter
+	if f.Status != "" && f.Status != StatusActive && f.Status != StatusDisabled {
+		fields = append(fields, apperrors.FieldError{Field: "status", Message: "must be active or disabled"})
+	}
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() && !f.CreatedAfter.Before(f.CreatedBefore) {
+		fields = append(fields, apperrors.FieldError{Field: "created_before", Message: "must be after created_after"})
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation("Invalid listing", fields...)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package user
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	u := &User{}
+	u.ID = 7
+	u.Email = "alice@example.com"
+	c := CursorOf(u, ListQuery{Sort: "email", Desc: true})
+	encoded := c.Encode()
+
+	raw, _ := base64.RawURLEncoding.DecodeString(encoded)
+	if strings.Contains(encoded, "alice") || strings.Contains(string(raw), "alice") {
+		t.Errorf("cursor %q tells the email", encoded)
+	}
+	if again := c.Encode(); again != encoded {
+		t.Errorf("the same position encodes to %q then %q", encoded, again)
+	}
+	decoded, err := DecodeCursor(encoded)
+	if err != nil || *decoded != *c {
+		t.Fatalf("DecodeCursor() = %+v, %v, want %+v", decoded, err, c)
+	}
+
+	tampered := []byte(encoded)
+	tampered[len(tampered)/2] ^= 'a' ^ 'b'
+	plain := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"email","v":"alice@example.com","id":7}`))
+	for name, s := range map[string]string{"tampered": string(tampered), "forged in plain text": plain, "not base64": "!", "empty": ""} {
+		if _, err := DecodeCursor(s); err == nil {
+			t.Errorf("DecodeCursor() of a %s cursor succeeded", name)
+		}
+	}
+
+	defer ConfigureCursors(string(randomSecret()))
+	ConfigureCursors("another secret")
+	if _, err := DecodeCursor(encoded); err == nil {
+		t.Error("DecodeCursor() succeeded with another key")
+	}
+}
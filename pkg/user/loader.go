@@ -0,0 +1,135 @@
+package user
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loaderWait : How long a Loader gathers the lookups of a batch, resolvers running side by side load within it
+const loaderWait = 2 * time.Millisecond
+
+// Loader : Batches and caches the lookups of users by ID made while serving a request (the DataLoader pattern) : the
+// IDs asked for within loaderWait, up to MaxPageSize of them, are fetched by a single Service.GetUsersByIDs. Every user
+// is fetched at most once per request. Loaders are meant to live as long as the request, see LoaderMiddleware.
+type Loader struct {
+	userService Service
+
+	mu      sync.Mutex
+	results map[uint64]*loadResult
+	pending []*loadResult
+	timer   *time.Timer
+}
+
+// loadResult : Outcome of the lookup of a user, available once done is closed
+type loadResult struct {
+	id   uint64
+	user *User
+	err  error
+	done chan struct{}
+}
+
+// NewLoader : Loader of the users of a single request
+func NewLoader(userService Service) *Loader {
+	return &Loader{userService: userService, results: make(map[uint64]*loadResult)}
+}
+
+// Load : The user with the given ID, ErrUserNotFound if there's none
+func (l *Loader) Load(ctx context.Context, id uint64) (*User, error) {
+	l.mu.Lock()
+	r, ok := l.results[id]
+	if !ok {
+		r = &loadResult{id: id, done: make(chan struct{})}
+		l.results[id] = r
+		l.pending = append(l.pending, r)
+		switch {
+		case len(l.pending) >= MaxPageSize:
+			l.timer.Stop()
+			go l.dispatch(ctx)
+		case len(l.pending) == 1:
+			// The batch runs with the context of its first lookup, all of them are made for the same request
+			l.timer = time.AfterFunc(loaderWait, func() { l.dispatch(ctx) })
+		}
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.user, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Prime : Caches u, e.g. after listing or updating it, so that later loads don't fetch it again
+func (l *Loader) Prime(u *User) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.results[u.ID]; ok {
+		select {
+		case <-r.done:
+		default:
+			// Still being fetched, the batch will complete it
+			return
+		}
+	}
+	r := &loadResult{id: u.ID, user: u, done: make(chan struct{})}
+	close(r.done)
+	l.results[u.ID] = r
+}
+
+// dispatch : Fetches the pending users, the lookups made meanwhile go to the next batch
+func (l *Loader) dispatch(ctx context.Context) {
+	l.mu.Lock()
+	batch := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	ids := make([]uint64, 0, len(batch))
+	for _, r := range batch {
+		ids = append(ids, r.id)
+	}
+	users, err := l.userService.GetUsersByIDs(ctx, ids)
+	found := make(map[uint64]*User, len(users))
+	for i := range users {
+		found[users[i].ID] = &users[i]
+	}
+	for _, r := range batch {
+		switch u, ok := found[r.id]; {
+		case err != nil:
+			r.err = err
+		case !ok:
+			r.err = ErrUserNotFound
+		default:
+			r.user = u
+		}
+		close(r.done)
+	}
+}
+
+// loaderKey : Context key of the request's Loader
+type loaderKey struct{}
+
+// WithLoader : ctx carrying l, see LoaderFrom
+func WithLoader(ctx context.Context, l *Loader) context.Context {
+	return context.WithValue(ctx, loaderKey{}, l)
+}
+
+// LoaderFrom : Loader of the request, nil when there's none
+func LoaderFrom(ctx context.Context) *Loader {
+	l, _ := ctx.Value(loaderKey{}).(*Loader)
+	return l
+}
+
+// LoaderMiddleware : Gives every request a Loader of its own
+func LoaderMiddleware(userService Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(WithLoader(c.Request.Context(), NewLoader(userService)))
+		c.Next()
+	}
+}
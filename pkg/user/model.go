@@ -9,15 +9,32 @@ import "time"
 type User struct {
 	UserInfoPayload
 	Password string `gorm:"size:100;not null;" json:"password" validate:"required,min=8,max=100"`
+	TenantID string `gorm:"size:40;not null;default:'default'" json:"-"` // Set by the Repository, see requestctx.TenantID
+	// ProfileChanged has Repository.UpdateUser write the Profile as is, its emptied fields included
+	ProfileChanged bool `gorm:"-" json:"-"`
 }
 
 // UserInfoPayload Struct
 type UserInfoPayload struct {
-	ID        uint64    `gorm:"primary_key;auto_increment" json:"id"`
-	Username  string    `gorm:"size:255;not null;unique" json:"username" validate:"required,min=4,max=30"`
-	Email     string    `gorm:"size:100;not null;unique" json:"email" validate:"required,email"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID        uint64     `gorm:"primary_key;auto_increment" json:"id"`
+	Username  string     `gorm:"size:255;not null" json:"username" validate:"required,min=4,max=30"`
+	Email     string     `gorm:"size:100;not null" json:"email,omitempty" validate:"required,email"`
+	Status    string     `gorm:"size:20;not null;default:'active'" json:"status" validate:"omitempty,oneof=active disabled"`
+	Version   uint64     `gorm:"not null;default:1" json:"version"` // Bumped by every write, see Repository.UpdateUser
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	DeletedAt *time.Time `gorm:"index" json:"-"` // Soft delete, gorm hides these users from every query but the Unscoped ones
+	Profile
+	AvatarID string `gorm:"size:32;not null;default:''" json:"avatar_id,omitempty"` // Changes with every upload, see Service.SetAvatar
+}
+
+// Profile : What the users tell about themselves, every field is optional
+type Profile struct {
+	DisplayName string     `gorm:"size:100;not null;default:''" json:"display_name" validate:"max=100"`
+	Locale      string     `gorm:"size:35;not null;default:''" json:"locale"`   // BCP 47 language tag, e.g. fr-CA
+	Timezone    string     `gorm:"size:64;not null;default:''" json:"timezone"` // IANA time zone, e.g. Europe/Paris
+	Bio         string     `gorm:"size:1000;not null;default:''" json:"bio" validate:"max=1000"`
+	Attributes  Attributes `gorm:"type:jsonb;not null;default:'{}'" json:"attributes"` // Defined by the deployment, see AttributesSchema
 }
 
 // CreateUserPayload Struct
@@ -25,11 +42,30 @@ type CreateUserPayload struct {
 	Username string `json:"username" validate:"required,min=4,max=30"`
 	Email    string `json:"email" validate:"required,email"`
 	Password string `gorm:"size:100;not null;" json:"password" validate:"required,min=8,max=100"`
+	Profile
 }
 
 // UpdateUserPayload Struct
 type UpdateUserPayload struct {
-	Password string `json:"password" validate:"required,min=8,max=100"`
+	Password        string `json:"password" validate:"required,min=8,max=100"`
+	CurrentPassword string `json:"current_password" validate:"required"`
+}
+
+// ListUsersResponse Struct
+type ListUsersResponse struct {
+	Users      []UserInfoPayload `json:"users"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	Total      *int64            `json:"total,omitempty"`
+}
+
+// SearchUsersResponse Struct
+type SearchUsersResponse struct {
+	Results []SearchResult `json:"results"`
+}
+
+// DeleteUserResponse Struct
+type DeleteUserResponse struct {
+	RestorableUntil time.Time `json:"restorable_until"`
 }
 
 // LoginPayload Struct
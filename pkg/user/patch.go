@@ -0,0 +1,87 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Media types accepted by PATCH /user/:id, plain application/json is read as a merge patch
+const (
+	MergePatchType = "application/merge-patch+json" // RFC 7396
+	JSONPatchType  = "application/json-patch+json"  // RFC 6902
+)
+
+// ProfileUpdate : Fields users can change on their own account, the document PATCH /user/:id applies to.
+// Password and CurrentPassword are null in the document, a patch sets them to change the password
+// and to confirm a change of email or password.
+type ProfileUpdate struct {
+	Username        string  `json:"username" validate:"required,min=4,max=30"`
+	Email           string  `json:"email" validate:"required,email"`
+	Password        *string `json:"password" validate:"omitempty,min=8,max=100"`
+	CurrentPassword *string `json:"current_password"`
+	Profile
+}
+
+// Validate : Checks the username, email and password (when set) of the profile, the rest is checked by the Service
+// which knows the AttributesSchema
+func (p ProfileUpdate) Validate() error {
+	return validateStruct(p)
+}
+
+var (
+	errUnsupportedPatch = apperrors.UnsupportedMediaType("Content-Type must be " + MergePatchType + " or " + JSONPatchType)
+	errInvalidPatch     = apperrors.Validation("Invalid patch")
+	errPatchTestFailed  = apperrors.Conflict("A test operation of the patch failed")
+)
+
+// ApplyPatch : Profile of u once patched by the given body, whose format is told by contentType
+func ApplyPatch(u *User, contentType string, patch []byte) (ProfileUpdate, error) {
+	var update ProfileUpdate
+	doc, err := json.Marshal(ProfileUpdate{Username: u.Username, Email: u.Email, Profile: u.Profile})
+	if err != nil {
+		return update, err
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil && contentType != "" {
+		return update, errUnsupportedPatch.Wrap(err)
+	}
+	switch mediaType {
+	case MergePatchType, "application/json", "":
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	case JSONPatchType:
+		var ops jsonpatch.Patch
+		ops, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			doc, err = ops.Apply(doc)
+		}
+	default:
+		return update, errUnsupportedPatch
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return update, errPatchTestFailed.Wrap(err)
+	}
+	if err != nil {
+		return update, errInvalidPatch.Wrap(err)
+	}
+	return update, decodeProfile(doc, &update)
+}
+
+// decodeProfile : Rejects the members which aren't part of the profile, e.g. read-only ones like id
+func decodeProfile(doc []byte, update *ProfileUpdate) error {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(update); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return apperrors.Validation("Invalid patch", apperrors.FieldError{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}).Wrap(err)
+		}
+		// encoding/json reports unknown fields as `json: unknown field "name"`
+		return apperrors.Validation("Invalid patch", apperrors.FieldError{Field: "patch", Message: "only username, email, password, current_password and the profile fields can be changed"}).Wrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package user
+
+import (
+	"reflect"
+	"strings"
+	"time"
+	_ "time/tzdata" // The time zones are validated even where the system has no database of them, e.g. on Alpine
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"golang.org/x/text/language"
+)
+
+// normalizeProfile : Trims the fields of p and canonicalizes its locale (en-us becomes en-US), then checks them
+// along with its attributes against schema
+func normalizeProfile(p *Profile, schema *AttributesSchema) error {
+	p.DisplayName = strings.TrimSpace(p.DisplayName)
+	p.Locale = strings.TrimSpace(p.Locale)
+	p.Timezone = strings.TrimSpace(p.Timezone)
+	p.Bio = strings.TrimSpace(p.Bio)
+
+	var fields []apperrors.FieldError
+	if err := validateStruct(p); err != nil {
+		if e, ok := apperrors.As(err); ok {
+			fields = append(fields, e.Fields...)
+		}
+	}
+	if p.Locale != "" {
+		tag, err := language.Parse(p.Locale)
+		if err != nil {
+			fields = append(fields, apperrors.FieldError{Field: "locale", Message: "must be a BCP 47 language tag, e.g. en-US"})
+		} else {
+			p.Locale = tag.String()
+		}
+	}
+	// LoadLocation also accepts "Local", which means nothing to the clients
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil || p.Timezone == "Local" {
+			fields = append(fields, apperrors.FieldError{Field: "timezone", Message: "must be an IANA time zone, e.g. Europe/Paris"})
+		}
+	}
+	if err := schema.Validate(p.Attributes); err != nil {
+		e, ok := apperrors.As(err)
+		if !ok {
+			return err
+		}
+		fields = append(fields, e.Fields...)
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation("Invalid profile", fields...)
+	}
+	return nil
+}
+
+// Equal : Whether p and other hold the same values, no attributes being the same as empty ones
+func (p Profile) Equal(other Profile) bool {
+	if len(p.Attributes) == 0 && len(other.Attributes) == 0 {
+		p.Attributes, other.Attributes = nil, nil
+	}
+	return reflect.DeepEqual(p, other)
+}
@@ -0,0 +1,107 @@
+package user
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// purgeBatchSize : Users hard deleted per transaction
+const purgeBatchSize = 100
+
+// PurgeHook : Deletes the data of the given users kept outside of the users table, before they are purged.
+// Hooks must be idempotent : when one fails, the batch is retried on the next run.
+type PurgeHook func(ctx context.Context, uids []uint64) error
+
+// Purger : Hard deletes the users whose deletion grace period is over, along with their dependent data
+type Purger struct {
+	repo  Repository
+	grace time.Duration
+
+	mu    sync.Mutex
+	hooks []PurgeHook
+
+	// Cancelled by Stop, which also aborts a running purge
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewPurger : Purger of the users deleted for longer than grace
+func NewPurger(repo Repository, grace time.Duration, hooks ...PurgeHook) *Purger {
+	// The grace period is the same in every tenant, a run purges them all
+	ctx, cancel := context.WithCancel(requestctx.WithTenant(context.Background(), requestctx.AllTenants))
+	return &Purger{
+		repo:   repo,
+		grace:  grace,
+		hooks:  hooks,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// AddHook : Registers the cleanup of data depending on users
+func (p *Purger) AddHook(hook PurgeHook) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hooks = append(p.hooks, hook)
+}
+
+// Purge : Purges every expired user, batch by batch, and returns how many were purged
+func (p *Purger) Purge(ctx context.Context) (int64, error) {
+	var total int64
+	cutoff := time.Now().Add(-p.grace)
+	for {
+		uids, err := p.repo.DeletedUserIDs(ctx, cutoff, purgeBatchSize)
+		if err != nil {
+			return total, errors.Wrap(err, "pkg.user.Purger.Purge")
+		}
+		if len(uids) == 0 {
+			return total, nil
+		}
+		p.mu.Lock()
+		hooks := p.hooks
+		p.mu.Unlock()
+		for _, hook := range hooks {
+			if err := hook(ctx, uids); err != nil {
+				return total, errors.Wrap(err, "pkg.user.Purger.Purge")
+			}
+		}
+		purged, err := p.repo.PurgeUsers(ctx, uids)
+		total += purged
+		if err != nil {
+			return total, errors.Wrap(err, "pkg.user.Purger.Purge")
+		}
+		if len(uids) < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// Run : Purges every interval until Stop is called
+func (p *Purger) Run(interval time.Duration, log logrus.FieldLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := p.Purge(p.ctx)
+			if err != nil && p.ctx.Err() == nil {
+				log.Errorf("Purging deleted users failed, retrying on the next run : %v", err)
+			}
+			if purged > 0 {
+				log.WithField("purged", purged).Info("Purged deleted users")
+			}
+		}
+	}
+}
+
+// Stop : Stops Run
+func (p *Purger) Stop() {
+	p.cancel()
+}
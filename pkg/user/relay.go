@@ -0,0 +1,139 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/metrics"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// relayBatchSize : Events claimed at once
+	relayBatchSize = 100
+	// relayLeaseMargin : Added to the publish timeout to get the lease of the claimed events
+	relayLeaseMargin = 10 * time.Second
+)
+
+// OutboxEvent : Event waiting in the Outbox
+type OutboxEvent struct {
+	Seq uint64 // Order in which the events were recorded
+	Event
+	Attempts  int // Failed publications so far
+	LastError string
+}
+
+// Outbox : Events recorded by the Repository, kept until the Relay publishes them. Several instances can relay at
+// once, each event is claimed by one of them.
+type Outbox interface {
+	// ClaimEvents : Unpublished events which aren't claimed yet, in the order they were recorded, which the others
+	// can only claim once lease is over
+	ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxEvent, error)
+	MarkPublished(ctx context.Context, seqs []uint64, at time.Time) error
+	MarkFailed(ctx context.Context, seq uint64, lastError string) error // The event is claimed again once its lease is over
+	PurgePublished(ctx context.Context, publishedBefore time.Time) (int64, error)
+}
+
+// Relay : Publishes the events of the Outbox. Events are published in order, a failure stops the batch and its
+// remaining events are retried once their lease is over, possibly after later events : consumers needing the order
+// compare the users' versions.
+type Relay struct {
+	outbox    Outbox
+	publisher Publisher
+	timeout   time.Duration
+	retention time.Duration
+
+	// Cancelled by Stop, which also aborts a running relay
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewRelay : Relay of the events of outbox to publisher. Publishing a batch is bounded by timeout, the published
+// events are kept for retention.
+func NewRelay(outbox Outbox, publisher Publisher, timeout, retention time.Duration) *Relay {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Relay{
+		outbox:    outbox,
+		publisher: publisher,
+		timeout:   timeout,
+		retention: retention,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Publish : Publishes every pending event, batch by batch, and returns how many were published
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		events, err := r.outbox.ClaimEvents(ctx, time.Now(), r.timeout+relayLeaseMargin, relayBatchSize)
+		if err != nil {
+			return total, errors.Wrap(err, "pkg.user.Relay.Publish")
+		}
+		published, err := r.publish(ctx, events)
+		total += published
+		if err != nil {
+			return total, err
+		}
+		if len(events) < relayBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// publish : Publishes the claimed events in order, up to the first failure
+func (r *Relay) publish(ctx context.Context, events []OutboxEvent) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	var seqs []uint64
+	var failed *OutboxEvent
+	var failure error
+	for i := range events {
+		if failure = r.publisher.Publish(ctx, events[i].Event); failure != nil {
+			metrics.EventPublished(metrics.EventOutcomeFailed)
+			failed = &events[i]
+			break
+		}
+		metrics.EventPublished(metrics.EventOutcomePublished)
+		seqs = append(seqs, events[i].Seq)
+	}
+	// Recorded even when the relay is stopping or timed out. When this fails, the events are published again once
+	// their lease is over.
+	if len(seqs) > 0 {
+		if err := r.outbox.MarkPublished(context.Background(), seqs, time.Now()); err != nil {
+			return 0, errors.Wrap(err, "pkg.user.Relay.Publish")
+		}
+	}
+	if failed != nil {
+		if err := r.outbox.MarkFailed(context.Background(), failed.Seq, failure.Error()); err != nil {
+			return len(seqs), errors.Wrap(err, "pkg.user.Relay.Publish")
+		}
+		return len(seqs), errors.Wrapf(failure, "pkg.user.Relay.Publish : event %s", failed.ID)
+	}
+	return len(seqs), nil
+}
+
+// Run : Publishes every interval, and purges the events published for longer than the retention, until Stop is called
+func (r *Relay) Run(interval time.Duration, log logrus.FieldLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Publish(r.ctx); err != nil && r.ctx.Err() == nil {
+				log.Errorf("Publishing user events failed, retrying later : %v", err)
+			}
+			if _, err := r.outbox.PurgePublished(r.ctx, time.Now().Add(-r.retention)); err != nil && r.ctx.Err() == nil {
+				log.Errorf("Purging published user events failed, retrying on the next run : %v", err)
+			}
+		}
+	}
+}
+
+// Stop : Stops Run
+func (r *Relay) Stop() {
+	r.cancel()
+}
@@ -1,11 +1,43 @@
 package user
 
-// Repository : User Repository to perform CRUD operations
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+)
+
+var (
+	// ErrUserNotFound : Returned by the Repository when no user matches the lookup
+	ErrUserNotFound = apperrors.NotFound("User Not Found")
+	// ErrUsernameTaken : Another user has the username
+	ErrUsernameTaken = apperrors.Conflict("Username already taken", apperrors.FieldError{Field: "username", Message: "is already taken"})
+	// ErrVersionConflict : The user changed since the version the write was based on
+	ErrVersionConflict = apperrors.Conflict("The user was modified concurrently, fetch it again and retry")
+	// ErrEmailTaken : Another user has the email
+	ErrEmailTaken = apperrors.Conflict("Email already registered", apperrors.FieldError{Field: "email", Message: "is already taken"})
+)
+
+// Repository : User Repository to perform CRUD operations.
+// Implementations return ErrUserNotFound for missing users, ErrUsernameTaken and ErrEmailTaken for uniqueness violations.
+// Writes bump the user's version. Given a non-zero version they compare-and-swap, returning ErrVersionConflict
+// when the stored version differs.
+// Writes also record their Events in the same transaction, see Outbox : EventPasswordChanged on top of EventUpdated
+// when UpdateUser writes a password.
 type Repository interface {
 	// BeforeSave(*User) error // TBD later : Not sure if this is needed
-	CreateUser(*User) (*User, error)
-	UpdateUser(*User) (*User, error)
-	DeleteUser(uint64) (int64, error)
-	GetUserByID(uint64) (*User, error)
-	GetUserByUsername(string) (*User, error)
+	CreateUser(context.Context, *User) (*User, error)
+	UpdateUser(context.Context, *User) (*User, error)                                   // Writes the non-empty username, email, password and status, and the profile when ProfileChanged, returns the updated user
+	SetAvatar(ctx context.Context, uid, version uint64, avatarID string) (*User, error) // An empty avatarID removes the avatar
+	DeleteUser(ctx context.Context, uid, version uint64) (int64, error)                 // Soft delete, returns the number of users deleted
+	GetUserByID(context.Context, uint64) (*User, error)
+	GetUsersByIDs(context.Context, []uint64) ([]User, error) // In no particular order, the missing users are left out
+	GetUserByUsername(context.Context, string) (*User, error)
+	GetUserByEmail(context.Context, string) (*User, error)
+	ListUsers(context.Context, ListQuery) (*ListPage, error) // q is normalized by the Service
+	GetDeletedUserByUsername(context.Context, string) (*User, error)
+	RestoreUser(ctx context.Context, uid uint64, deletedAfter time.Time) error // ErrUserNotFound unless deleted after deletedAfter
+	DeletedUserIDs(ctx context.Context, deletedBefore time.Time, limit int) ([]uint64, error)
+	PurgeUsers(context.Context, []uint64) (int64, error) // Hard deletes the given soft deleted users
+	RecordEvent(context.Context, Event) error            // Records an event not tied to a write, e.g. EventLoggedIn
 }
@@ -0,0 +1,192 @@
+package user
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/requestctx"
+)
+
+// Sizes of SearchUsers
+const (
+	DefaultSearchLimit = 10
+	MaxSearchLimit     = 50
+	MaxSearchLength    = 100
+)
+
+// SimilarityThreshold : Minimum trigram similarity of a fuzzy match, pg_trgm's default
+const SimilarityThreshold = 0.3
+
+// SearchQuery : Typeahead search over the usernames and emails of the active users
+type SearchQuery struct {
+	Text  string
+	Limit int
+	// UsernamesOnly leaves the emails out of the matches, for the users looking each other up
+	UsernamesOnly bool
+}
+
+// SearchResult : User matching a search, best matches have the highest score
+type SearchResult struct {
+	UserInfoPayload
+	Score float64 `json:"score"`
+}
+
+// Searcher : Ranks the active users against a SearchQuery. Matches are scored the same by every implementation :
+// 2 for a username prefix, 1 for an email prefix, plus the best trigram similarity of the username and the email.
+type Searcher interface {
+	SearchUsers(context.Context, SearchQuery) ([]SearchResult, error)
+}
+
+// Normalize : Applies the defaults to q and checks it's consistent
+func (q *SearchQuery) Normalize() error {
+	q.Text = strings.ToLower(strings.TrimSpace(q.Text))
+	var fields []apperrors.FieldError
+	if q.Text == "" {
+		fields = append(fields, apperrors.FieldError{Field: "q", Message: "is required"})
+	}
+	if len(q.Text) > MaxSearchLength {
+		fields = append(fields, apperrors.FieldError{Field: "q", Message: "must be at most 100 characters long"})
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	if q.Limit > MaxSearchLimit {
+		fields = append(fields, apperrors.FieldError{Field: "limit", Message: "must be at most 50"})
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation("Invalid search", fields...)
+	}
+	return nil
+}
+
+// Score : Relevance of u for the normalized query, and whether it's a match at all
+func Score(u *UserInfoPayload, q SearchQuery) (float64, bool) {
+	text, username, email := q.Text, strings.ToLower(u.Username), strings.ToLower(u.Email)
+	if q.UsernamesOnly {
+		email = ""
+	}
+	var score float64
+	switch {
+	case strings.HasPrefix(username, text):
+		score = 2
+	case email != "" && strings.HasPrefix(email, text):
+		score = 1
+	}
+	similarity := Similarity(username, text)
+	if s := Similarity(email, text); s > similarity {
+		similarity = s
+	}
+	if score == 0 && similarity < SimilarityThreshold {
+		return 0, false
+	}
+	return score + similarity, true
+}
+
+// Similarity : Trigram similarity of a and b, computed like pg_trgm's similarity()
+func Similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	common := 0
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(ta)+len(tb)-common)
+}
+
+// trigrams : pg_trgm splits the text into alphanumeric words, padded with two spaces in front and one behind
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		padded := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// rank : Sorts the results by decreasing score, then by username, and keeps the first limit ones
+func rank(results []SearchResult, limit int) []SearchResult {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Username < results[j].Username
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+type memorySearcher struct {
+	repo Repository
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	snapshots map[string]*searchSnapshot // By tenant
+}
+
+type searchSnapshot struct {
+	users    []UserInfoPayload
+	loadedAt time.Time
+}
+
+// NewMemorySearcher : Searcher for the backends without a search index of their own. It scores a snapshot of the
+// active users of each tenant, loaded through Repository.ListUsers and refreshed once it is older than ttl.
+func NewMemorySearcher(repo Repository, ttl time.Duration) Searcher {
+	return &memorySearcher{repo: repo, ttl: ttl, snapshots: make(map[string]*searchSnapshot)}
+}
+
+func (m *memorySearcher) SearchUsers(ctx context.Context, q SearchQuery) ([]SearchResult, error) {
+	users, err := m.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	for i := range users {
+		if score, ok := Score(&users[i], q); ok {
+			results = append(results, SearchResult{UserInfoPayload: users[i], Score: score})
+		}
+	}
+	return rank(results, q.Limit), nil
+}
+
+// snapshot : Active users of the tenant of ctx, reloaded when the snapshot expired
+func (m *memorySearcher) snapshot(ctx context.Context) ([]UserInfoPayload, error) {
+	tenantID, _ := requestctx.TenantID(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.snapshots[tenantID]; ok && time.Since(s.loadedAt) < m.ttl {
+		return s.users, nil
+	}
+	users := []UserInfoPayload{}
+	q := ListQuery{Filter: ListFilter{Status: StatusActive}, Sort: "id", Limit: MaxPageSize}
+	for {
+		page, err := m.repo.ListUsers(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range page.Users {
+			users = append(users, u.UserInfoPayload)
+		}
+		if page.Next == nil {
+			break
+		}
+		q.After = page.Next
+	}
+	m.snapshots[tenantID] = &searchSnapshot{users: users, loadedAt: time.Now()}
+	return users, nil
+}
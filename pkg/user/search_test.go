@@ -0,0 +1,27 @@
+package user
+
+import "testing"
+
+func TestScore(t *testing.T) {
+	u := &UserInfoPayload{Username: "jonathan", Email: "jdoe@example.com"}
+	for _, tc := range []struct {
+		name  string
+		q     SearchQuery
+		match bool
+		score float64
+	}{
+		{"username prefix", SearchQuery{Text: "jon"}, true, 2},
+		{"email prefix", SearchQuery{Text: "jdoe"}, true, 1},
+		{"email prefix, usernames only", SearchQuery{Text: "jdoe", UsernamesOnly: true}, false, 0},
+		{"email domain, usernames only", SearchQuery{Text: "example", UsernamesOnly: true}, false, 0},
+		{"username prefix, usernames only", SearchQuery{Text: "jon", UsernamesOnly: true}, true, 2},
+		{"no match", SearchQuery{Text: "zzz"}, false, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			score, ok := Score(u, tc.q)
+			if ok != tc.match || (ok && score < tc.score) {
+				t.Errorf("Score() = %v, %v, want a match %v scoring at least %v", score, ok, tc.match, tc.score)
+			}
+		})
+	}
+}
@@ -1,39 +1,78 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"html"
-	"log"
+	"io"
 	"strings"
 	"time"
 
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/blob"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/metrics"
 	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/auth"
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
 	hashing "github.com/LuD1161/restructuring-tnbt/pkg/utils"
-	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidLogin : Returned by Login for an unknown username as well as a wrong password, so both look the same
+	ErrInvalidLogin = apperrors.Unauthorized("Invalid username or password")
+	// ErrWrongPassword : The current password confirming a sensitive change is incorrect
+	ErrWrongPassword = apperrors.Forbidden("Current password is incorrect")
+	// ErrAccountDisabled : The account was deactivated, e.g. deprovisioned through SCIM
+	ErrAccountDisabled = apperrors.Forbidden("Account disabled")
+
+	errRestoreExpired = apperrors.NotFound("The account can no longer be restored")
+
+	errCurrentPasswordRequired = apperrors.Validation("Current password required", apperrors.FieldError{
+		Field: "current_password", Message: "is required to change the email or password",
+	})
 )
 
 // Service : UserService
 type Service interface {
-	Login(username, password string) (string, error) // returns JWToken
-	BeforeSave(*User) error                          // TBD later : Not sure if this is needed
-	Prepare(*User)                                   // TBD later : Not sure how this is needed, if it can be incorporated in UpdateUser
-	CreateUser(*User) (*User, error)
-	UpdateUser(*User) (*User, error)
-	DeleteUser(uint64) (int64, error)
-	GetUserByID(uint64) (*User, error)
+	Login(ctx context.Context, username, password string) (string, error) // returns JWToken
+	BeforeSave(*User) error                                               // TBD later : Not sure if this is needed
+	Prepare(*User)                                                        // TBD later : Not sure how this is needed, if it can be incorporated in UpdateUser
+	CreateUser(context.Context, *User) (*User, error)
+	UpdateUser(context.Context, *User) (*User, error)
+	DeleteUser(ctx context.Context, uid, version uint64) (time.Time, error) // Returns until when the account can be restored
+	RestoreUser(ctx context.Context, username, password string) (*User, error)
+	GetUserByID(context.Context, uint64) (*User, error)
+	GetUsersByIDs(context.Context, []uint64) ([]User, error)
+	ListUsers(context.Context, ListQuery) (*ListPage, error)
+	SearchUsers(context.Context, SearchQuery) ([]SearchResult, error)
+	UpdateProfile(ctx context.Context, uid, version uint64, p ProfileUpdate) (*User, error)
+	SetAvatar(ctx context.Context, uid, version uint64, r io.Reader) (*User, error)
+	DeleteAvatar(ctx context.Context, uid, version uint64) (*User, error)
+	GetAvatar(ctx context.Context, uid uint64, size int) (*Avatar, error) // size 0 is the default one, see avatar.Sizes
+}
+
+// Profiles : What the profiles hold beyond the fixed fields
+type Profiles struct {
+	Attributes    *AttributesSchema // Custom attributes are rejected when nil
+	Avatars       blob.Store        // Avatars are disabled when nil
+	AvatarMaxSize int64             // Largest upload, in bytes
 }
 
 type service struct {
-	repo Repository
-	log  *logrus.Logger
+	repo          Repository
+	searcher      Searcher
+	deletionGrace time.Duration
+	profiles      Profiles
 }
 
-// NewService creates a listing service with the necessary dependencies
-func NewService(repo Repository, log *logrus.Logger) Service {
+// NewService creates a listing service with the necessary dependencies.
+// Deleted accounts can be restored during deletionGrace, see Purger.
+func NewService(repo Repository, searcher Searcher, deletionGrace time.Duration, profiles Profiles) Service {
 	return &service{
 		repo,
-		log,
+		searcher,
+		deletionGrace,
+		profiles,
 	}
 }
 
@@ -52,60 +91,251 @@ func (s *service) Prepare(u *User) {
 func (s *service) BeforeSave(u *User) error {
 	hashedPassword, err := hashing.Hash(u.Password)
 	if err != nil {
-		log.Fatalf("%v", err)
 		return err
 	}
 	u.Password = string(hashedPassword)
 	return nil
+}
 
+// hashPassword : BeforeSave, traced since bcrypt is meant to be slow
+func (s *service) hashPassword(ctx context.Context, u *User) error {
+	_, span := tracing.Tracer().Start(ctx, "bcrypt.Hash")
+	err := s.BeforeSave(u)
+	tracing.End(span, err)
+	return err
+}
+
+// verifyPassword : hashing.VerifyHash, traced since bcrypt is meant to be slow
+func verifyPassword(ctx context.Context, hash, password string) error {
+	_, span := tracing.Tracer().Start(ctx, "bcrypt.Verify")
+	err := hashing.VerifyHash(hash, password)
+	span.End()
+	return err
 }
 
 // CreateUser : Creates the user in database
-func (s *service) CreateUser(u *User) (*User, error) {
-	s.BeforeSave(u)
-	return s.repo.CreateUser(u)
+func (s *service) CreateUser(ctx context.Context, u *User) (*User, error) {
+	u.Status = StatusActive
+	u.Version = 1
+	u.AvatarID = ""
+	if err := normalizeProfile(&u.Profile, s.profiles.Attributes); err != nil {
+		return nil, err
+	}
+	if err := s.checkAvailable(ctx, 0, u); err != nil {
+		return nil, err
+	}
+	if err := s.hashPassword(ctx, u); err != nil {
+		return nil, err
+	}
+	return s.repo.CreateUser(ctx, u)
+}
+
+// UpdateUser : Update user details, compare-and-swap on u.Version unless it is 0
+func (s *service) UpdateUser(ctx context.Context, u *User) (*User, error) {
+	if err := s.hashPassword(ctx, u); err != nil {
+		return nil, err
+	}
+	return s.repo.UpdateUser(ctx, u)
 }
 
-// UpdateUser : Update user details
-func (s *service) UpdateUser(u *User) (*User, error) {
-	s.BeforeSave(u)
-	return s.repo.UpdateUser(u)
+// UpdateProfile : Applies the profile of user uid. Changing the email or the password needs the current password.
+// The update is based on the given version (the current one when 0) and fails with ErrVersionConflict
+// if the user changes in the meantime.
+func (s *service) UpdateProfile(ctx context.Context, uid, version uint64, p ProfileUpdate) (*User, error) {
+	p.Username = strings.TrimSpace(p.Username)
+	p.Email = strings.TrimSpace(p.Email)
+	if err := normalizeProfile(&p.Profile, s.profiles.Attributes); err != nil {
+		return nil, err
+	}
+	if err := validateStruct(p); err != nil {
+		return nil, err
+	}
+	current, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && version != current.Version {
+		return nil, ErrVersionConflict
+	}
+
+	// Only the changed fields are written, see Repository.UpdateUser
+	u := new(User)
+	u.ID = uid
+	u.Version = current.Version
+	if p.Username != current.Username {
+		u.Username = p.Username
+	}
+	if p.Email != current.Email {
+		u.Email = p.Email
+	}
+	if p.Password != nil {
+		u.Password = *p.Password
+	}
+	if !p.Profile.Equal(current.Profile) {
+		u.Profile = p.Profile
+		u.ProfileChanged = true
+	}
+	if u.Username == "" && u.Email == "" && u.Password == "" && !u.ProfileChanged {
+		return current, nil
+	}
+	if u.Email != "" || u.Password != "" {
+		if p.CurrentPassword == nil || *p.CurrentPassword == "" {
+			return nil, errCurrentPasswordRequired
+		}
+		if err := verifyPassword(ctx, current.Password, *p.CurrentPassword); err != nil {
+			logging.FromContext(ctx).WithField("user_id", uid).Warn("Profile update with a wrong current password")
+			return nil, ErrWrongPassword.Wrap(err)
+		}
+	}
+	// Friendlier than waiting for the unique constraints, which still settle the races
+	if err := s.checkAvailable(ctx, uid, u); err != nil {
+		return nil, err
+	}
+	if u.Password != "" {
+		if err := s.hashPassword(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+	return s.repo.UpdateUser(ctx, u)
+}
+
+// checkAvailable : ErrUsernameTaken or ErrEmailTaken when another user than uid has the username or email of u
+func (s *service) checkAvailable(ctx context.Context, uid uint64, u *User) error {
+	for _, check := range []struct {
+		value  string
+		lookup func(context.Context, string) (*User, error)
+		taken  error
+	}{
+		{u.Username, s.repo.GetUserByUsername, ErrUsernameTaken},
+		{u.Email, s.repo.GetUserByEmail, ErrEmailTaken},
+	} {
+		if check.value == "" {
+			continue
+		}
+		other, err := check.lookup(ctx, check.value)
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if other.ID != uid {
+			return check.taken
+		}
+	}
+	return nil
 }
 
 // GetUserByID : Finds a user by ID
-func (s *service) GetUserByID(uid uint64) (*User, error) {
-	s.log.Info("in pkg.user.service.GetUserByID")
-	return s.repo.GetUserByID(uid)
+func (s *service) GetUserByID(ctx context.Context, uid uint64) (*User, error) {
+	return s.repo.GetUserByID(ctx, uid)
+}
+
+// GetUsersByIDs : Finds the users with the given IDs at once, in no particular order. Unknown IDs are skipped.
+func (s *service) GetUsersByIDs(ctx context.Context, uids []uint64) ([]User, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	return s.repo.GetUsersByIDs(ctx, uids)
+}
+
+// ListUsers : Page of users matching the query
+func (s *service) ListUsers(ctx context.Context, q ListQuery) (*ListPage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	return s.repo.ListUsers(ctx, q)
+}
+
+// SearchUsers : Active users best matching the query
+func (s *service) SearchUsers(ctx context.Context, q SearchQuery) ([]SearchResult, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	return s.searcher.SearchUsers(ctx, q)
+}
+
+// DeleteUser : Soft deletes a user, which can be restored until the grace period is over.
+// A non-zero version must match the user's one, see ErrVersionConflict.
+func (s *service) DeleteUser(ctx context.Context, uid, version uint64) (time.Time, error) {
+	deleted, err := s.repo.DeleteUser(ctx, uid, version)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if deleted == 0 {
+		return time.Time{}, ErrUserNotFound
+	}
+	logging.FromContext(ctx).WithField("user_id", uid).Info("User deleted")
+	return time.Now().Add(s.deletionGrace), nil
 }
 
-// DeleteAUser : Deletes a user from the database
-func (s *service) DeleteUser(uid uint64) (int64, error) {
-	return s.repo.DeleteUser(uid)
+// RestoreUser : Restores a deleted account, proving its ownership with the credentials it had
+func (s *service) RestoreUser(ctx context.Context, username, password string) (*User, error) {
+	u, err := s.repo.GetDeletedUserByUsername(ctx, username)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidLogin
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyPassword(ctx, u.Password, password); err != nil {
+		return nil, ErrInvalidLogin.Wrap(err)
+	}
+	if err := s.repo.RestoreUser(ctx, u.ID, time.Now().Add(-s.deletionGrace)); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, errRestoreExpired
+		}
+		return nil, err
+	}
+	logging.FromContext(ctx).WithField("user_id", u.ID).Info("User restored")
+	return s.repo.GetUserByID(ctx, u.ID)
 }
 
 // Login : Returns JWT for login verification
-func (s *service) Login(username, password string) (string, error) {
-	user, err := s.repo.GetUserByUsername(username)
+func (s *service) Login(ctx context.Context, username, password string) (string, error) {
+	log := logging.FromContext(ctx).WithField("username", username)
+	user, err := s.repo.GetUserByUsername(ctx, username)
 
 	if err != nil {
-		logrus.WithField("username", username).Error("Unable to fetch account")
+		log.WithError(err).Warn("Unable to fetch account")
+		if errors.Is(err, ErrUserNotFound) {
+			metrics.LoginFailed(metrics.LoginReasonUnknownUser)
+			return "", ErrInvalidLogin
+		}
+		metrics.LoginFailed(metrics.LoginReasonError)
 		return "", err
 	}
 
 	if user == nil {
-		return "", errors.New("Invalid Username")
+		metrics.LoginFailed(metrics.LoginReasonUnknownUser)
+		return "", ErrInvalidLogin
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		logrus.WithFields(logrus.Fields{"username": username, "error": err.Error()}).Error("Invalid login")
-		return "", err
+	err = verifyPassword(ctx, user.Password, password)
+	if err != nil {
+		log.Warn("Invalid login")
+		metrics.LoginFailed(metrics.LoginReasonInvalidPassword)
+		return "", ErrInvalidLogin.Wrap(err)
+	}
+	// Only told apart once the password is verified, so it doesn't disclose the account's status
+	if user.Status == StatusDisabled {
+		log.Warn("Login to a disabled account")
+		metrics.LoginFailed(metrics.LoginReasonDisabled)
+		return "", ErrAccountDisabled
 	}
 
-	token, err := auth.CreateToken(user.ID)
+	token, err := auth.CreateToken(ctx, user.ID)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"username": username, "error": err}).Error("Unable to generate token")
+		log.WithError(err).Error("Unable to generate token")
+		metrics.LoginFailed(metrics.LoginReasonError)
+		return "", err
 	}
 
+	metrics.LoginSucceeded()
+	// Not worth failing the login over, unlike the events of the changes it isn't part of a transaction
+	if err := s.repo.RecordEvent(ctx, NewEvent(EventLoggedIn, user.UserInfoPayload)); err != nil {
+		log.WithError(err).Error("Recording the login event failed")
+	}
 	return token, nil
 }
@@ -0,0 +1,150 @@
+package user
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// userIDKey : Span attribute holding the ID of the user an operation works on
+	userIDKey = attribute.Key("user.id")
+	// userVersionKey : Span attribute holding the version a write is based on, 0 for unconditional writes
+	userVersionKey = attribute.Key("user.version")
+)
+
+type tracedService struct {
+	next Service
+}
+
+// NewTracedService : Wraps the Service so every call gets its own span
+func NewTracedService(next Service) Service {
+	return &tracedService{next}
+}
+
+func (t *tracedService) Login(ctx context.Context, username, password string) (string, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.Login")
+	token, err := t.next.Login(ctx, username, password)
+	tracing.End(span, err)
+	return token, err
+}
+
+func (t *tracedService) BeforeSave(u *User) error {
+	return t.next.BeforeSave(u)
+}
+
+func (t *tracedService) Prepare(u *User) {
+	t.next.Prepare(u)
+}
+
+func (t *tracedService) CreateUser(ctx context.Context, u *User) (*User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.CreateUser")
+	created, err := t.next.CreateUser(ctx, u)
+	if err == nil {
+		span.SetAttributes(userIDKey.Int64(int64(created.ID)))
+	}
+	tracing.End(span, err)
+	return created, err
+}
+
+func (t *tracedService) UpdateUser(ctx context.Context, u *User) (*User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.UpdateUser")
+	span.SetAttributes(userIDKey.Int64(int64(u.ID)))
+	updated, err := t.next.UpdateUser(ctx, u)
+	tracing.End(span, err)
+	return updated, err
+}
+
+func (t *tracedService) DeleteUser(ctx context.Context, uid, version uint64) (time.Time, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.DeleteUser")
+	span.SetAttributes(userIDKey.Int64(int64(uid)), userVersionKey.Int64(int64(version)))
+	restorableUntil, err := t.next.DeleteUser(ctx, uid, version)
+	tracing.End(span, err)
+	return restorableUntil, err
+}
+
+func (t *tracedService) RestoreUser(ctx context.Context, username, password string) (*User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.RestoreUser")
+	restored, err := t.next.RestoreUser(ctx, username, password)
+	if err == nil {
+		span.SetAttributes(userIDKey.Int64(int64(restored.ID)))
+	}
+	tracing.End(span, err)
+	return restored, err
+}
+
+func (t *tracedService) GetUserByID(ctx context.Context, uid uint64) (*User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.GetUserByID")
+	span.SetAttributes(userIDKey.Int64(int64(uid)))
+	u, err := t.next.GetUserByID(ctx, uid)
+	tracing.End(span, err)
+	return u, err
+}
+
+func (t *tracedService) GetUsersByIDs(ctx context.Context, uids []uint64) ([]User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.GetUsersByIDs")
+	span.SetAttributes(attribute.Int("user.batch.size", len(uids)))
+	users, err := t.next.GetUsersByIDs(ctx, uids)
+	if err == nil {
+		span.SetAttributes(attribute.Int("user.batch.count", len(users)))
+	}
+	tracing.End(span, err)
+	return users, err
+}
+
+func (t *tracedService) ListUsers(ctx context.Context, q ListQuery) (*ListPage, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.ListUsers")
+	span.SetAttributes(attribute.String("user.list.sort", q.Sort), attribute.Int("user.list.limit", q.Limit))
+	page, err := t.next.ListUsers(ctx, q)
+	if err == nil {
+		span.SetAttributes(attribute.Int("user.list.count", len(page.Users)))
+	}
+	tracing.End(span, err)
+	return page, err
+}
+
+func (t *tracedService) SearchUsers(ctx context.Context, q SearchQuery) ([]SearchResult, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.SearchUsers")
+	span.SetAttributes(attribute.Int("user.search.limit", q.Limit))
+	results, err := t.next.SearchUsers(ctx, q)
+	if err == nil {
+		span.SetAttributes(attribute.Int("user.search.count", len(results)))
+	}
+	tracing.End(span, err)
+	return results, err
+}
+
+func (t *tracedService) UpdateProfile(ctx context.Context, uid, version uint64, p ProfileUpdate) (*User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.UpdateProfile")
+	span.SetAttributes(userIDKey.Int64(int64(uid)), userVersionKey.Int64(int64(version)))
+	updated, err := t.next.UpdateProfile(ctx, uid, version, p)
+	tracing.End(span, err)
+	return updated, err
+}
+
+func (t *tracedService) SetAvatar(ctx context.Context, uid, version uint64, r io.Reader) (*User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.SetAvatar")
+	span.SetAttributes(userIDKey.Int64(int64(uid)), userVersionKey.Int64(int64(version)))
+	updated, err := t.next.SetAvatar(ctx, uid, version, r)
+	tracing.End(span, err)
+	return updated, err
+}
+
+func (t *tracedService) DeleteAvatar(ctx context.Context, uid, version uint64) (*User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.DeleteAvatar")
+	span.SetAttributes(userIDKey.Int64(int64(uid)), userVersionKey.Int64(int64(version)))
+	updated, err := t.next.DeleteAvatar(ctx, uid, version)
+	tracing.End(span, err)
+	return updated, err
+}
+
+func (t *tracedService) GetAvatar(ctx context.Context, uid uint64, size int) (*Avatar, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.Service.GetAvatar")
+	span.SetAttributes(userIDKey.Int64(int64(uid)), attribute.Int("user.avatar.size", size))
+	a, err := t.next.GetAvatar(ctx, uid, size)
+	tracing.End(span, err)
+	return a, err
+}
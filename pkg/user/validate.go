@@ -0,0 +1,32 @@
+package user
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// validate : Shared validator reporting the fields by their JSON names
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// validateStruct : apperrors.Validation listing the invalid fields of s, nil if s is valid
+func validateStruct(s interface{}) error {
+	if err := validate.Struct(s); err != nil {
+		return apperrors.FromValidator(err)
+	}
+	return nil
+}
@@ -1,13 +1,27 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/metrics"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var hashCost = bcrypt.DefaultCost
+
+// SetHashCost : Sets the bcrypt cost used by Hash
+func SetHashCost(cost int) {
+	hashCost = cost
+}
 
 // Hash : Returns bcrypt hash of the plaintext string passed
 func Hash(plaintext string) ([]byte, error) {
-	return bcrypt.GenerateFromPassword([]byte(plaintext), bcrypt.DefaultCost)
+	defer metrics.ObservePasswordHash("hash", time.Now())
+	return bcrypt.GenerateFromPassword([]byte(plaintext), hashCost)
 }
 
 // VerifyHash : Verifies string and their hash
 func VerifyHash(hashedString, plaintext string) error {
+	defer metrics.ObservePasswordHash("verify", time.Now())
 	return bcrypt.CompareHashAndPassword([]byte(hashedString), []byte(plaintext))
 }
@@ -0,0 +1,206 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/metrics"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// claimBatchSize : Deliveries claimed at once
+	claimBatchSize = 50
+	// concurrency : Deliveries of a batch attempted in parallel, so that a slow receiver doesn't hold the others back
+	concurrency = 8
+	// leaseMargin : Added to the request timeout to get the lease of a claimed delivery
+	leaseMargin = 30 * time.Second
+	// maxErrorLength : Bytes of the response body kept in LastError
+	maxErrorLength = 512
+)
+
+// Options : Delivery settings
+type Options struct {
+	Timeout        time.Duration // Of every attempt
+	MaxAttempts    int           // The delivery is dead after that many failed attempts
+	InitialBackoff time.Duration // Delay before the second attempt, doubled for every further one
+	MaxBackoff     time.Duration
+}
+
+// Dispatcher : Sends the pending deliveries, retrying the failed ones with an exponential backoff until they succeed or
+// run out of attempts. Several instances can dispatch at once, each delivery is claimed by one of them.
+type Dispatcher struct {
+	repo   Repository
+	client *http.Client
+	opts   Options
+	log    logrus.FieldLogger
+
+	// Cancelled by Stop, which also aborts the running attempts
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewDispatcher : Dispatcher of the deliveries stored in repo
+func NewDispatcher(repo Repository, opts Options, log logrus.FieldLogger) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		repo: repo,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// A redirect is a failure, the subscription's URL must be fixed rather than followed
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		opts:   opts,
+		log:    log,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Dispatch : Attempts every due delivery, batch by batch, and returns how many were attempted
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		deliveries, err := d.repo.ClaimDeliveries(ctx, time.Now(), d.opts.Timeout+leaseMargin, claimBatchSize)
+		if err != nil {
+			return total, errors.Wrap(err, "pkg.webhook.Dispatcher.Dispatch")
+		}
+		subs := make(map[uint64]*Subscription)
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, concurrency)
+		for i := range deliveries {
+			delivery := &deliveries[i]
+			sub, ok := subs[delivery.SubscriptionID]
+			if !ok {
+				sub, err = d.repo.GetSubscription(ctx, delivery.SubscriptionID)
+				if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+					// Left to the lease, the delivery is attempted again once it expires
+					d.log.WithError(err).WithField("delivery_id", delivery.ID).Error("Loading the webhook of a delivery failed")
+					continue
+				}
+				subs[delivery.SubscriptionID] = sub
+			}
+			if sub == nil {
+				// Deleted meanwhile, its deliveries are gone as well
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				d.attempt(ctx, sub, delivery)
+			}()
+		}
+		wg.Wait()
+		total += len(deliveries)
+		if len(deliveries) < claimBatchSize || ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+	}
+}
+
+// attempt : Sends the delivery once and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, sub *Subscription, delivery *Delivery) {
+	log := d.log.WithFields(logrus.Fields{
+		"webhook_id":  sub.ID,
+		"delivery_id": delivery.ID,
+		"event_type":  delivery.EventType,
+	})
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.LastStatusCode, delivery.LastError = 0, ""
+	if !sub.Active {
+		delivery.LastError = "webhook disabled"
+	} else if err := d.send(ctx, sub, delivery); err != nil {
+		delivery.LastError = err.Error()
+	}
+
+	var outcome string
+	switch {
+	case delivery.LastError == "":
+		delivery.Status, delivery.NextAttemptAt, outcome = StatusSucceeded, nil, metrics.WebhookDelivered
+	case delivery.Attempts >= d.opts.MaxAttempts || !sub.Active:
+		delivery.Status, delivery.NextAttemptAt, outcome = StatusDead, nil, metrics.WebhookDead
+		log.WithField("attempts", delivery.Attempts).Warnf("Webhook delivery dead : %s", delivery.LastError)
+	default:
+		next := now.Add(d.backoff(delivery.Attempts))
+		delivery.NextAttemptAt, outcome = &next, metrics.WebhookRetried
+		log.WithField("attempts", delivery.Attempts).Infof("Webhook delivery failed, retrying at %s : %s", next.Format(time.RFC3339), delivery.LastError)
+	}
+	metrics.WebhookAttempted(outcome)
+	// Recorded even when the dispatcher is stopping, so that the attempt isn't lost
+	if err := d.repo.UpdateDelivery(context.Background(), delivery); err != nil {
+		log.WithError(err).Error("Recording a webhook delivery failed")
+	}
+}
+
+// send : POSTs the payload, an error unless the receiver answers with a 2xx
+func (d *Dispatcher) send(ctx context.Context, sub *Subscription, delivery *Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tnbt-webhooks/1")
+	req.Header.Set("X-Webhook-ID", strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, time.Now(), delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	delivery.LastStatusCode = resp.StatusCode
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	message := resp.Status
+	if text := strings.TrimSpace(string(body)); text != "" {
+		message += " : " + text
+	}
+	return errors.New(message)
+}
+
+// backoff : Delay after the given number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.opts.InitialBackoff
+	for i := 1; i < attempts && delay < d.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.opts.MaxBackoff {
+		delay = d.opts.MaxBackoff
+	}
+	return delay
+}
+
+// Run : Dispatches every interval until Stop is called
+func (d *Dispatcher) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.Dispatch(d.ctx); err != nil && d.ctx.Err() == nil {
+				d.log.Errorf("Dispatching webhooks failed, retrying on the next run : %v", err)
+			}
+		}
+	}
+}
+
+// Stop : Stops Run
+func (d *Dispatcher) Stop() {
+	d.cancel()
+}
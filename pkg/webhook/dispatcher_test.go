@@ -0,0 +1,392 @@
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const testSecret = "0123456789abcdef"
+
+// memoryRepository : Repository kept in memory, only what the dispatcher and Redeliver use
+type memoryRepository struct {
+	mu         sync.Mutex
+	subs       map[uint64]*Subscription
+	deliveries []Delivery
+}
+
+func newMemoryRepository(subs ...Subscription) *memoryRepository {
+	r := &memoryRepository{subs: make(map[uint64]*Subscription)}
+	for i := range subs {
+		r.subs[subs[i].ID] = &subs[i]
+	}
+	return r
+}
+
+func (r *memoryRepository) CreateSubscription(_ context.Context, s *Subscription) (*Subscription, error) {
+	panic("not used")
+}
+
+func (r *memoryRepository) GetSubscription(_ context.Context, id uint64) (*Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.subs[id]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	copied := *s
+	return &copied, nil
+}
+
+func (r *memoryRepository) ListSubscriptions(context.Context) ([]Subscription, error) {
+	panic("not used")
+}
+
+func (r *memoryRepository) UpdateSubscription(context.Context, *Subscription) (*Subscription, error) {
+	panic("not used")
+}
+
+func (r *memoryRepository) DeleteSubscription(context.Context, uint64) error {
+	panic("not used")
+}
+
+func (r *memoryRepository) CreateDeliveries(_ context.Context, deliveries []Delivery) ([]Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	created := make([]Delivery, len(deliveries))
+	for i, d := range deliveries {
+		d.ID = uint64(len(r.deliveries) + 1)
+		d.CreatedAt = time.Now()
+		r.deliveries = append(r.deliveries, d)
+		created[i] = d
+	}
+	return created, nil
+}
+
+func (r *memoryRepository) GetDelivery(_ context.Context, subscriptionID, id uint64) (*Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.deliveries {
+		if d.ID == id && d.SubscriptionID == subscriptionID {
+			return &d, nil
+		}
+	}
+	return nil, ErrDeliveryNotFound
+}
+
+func (r *memoryRepository) ListDeliveries(context.Context, uint64, uint64, int) ([]Delivery, error) {
+	panic("not used")
+}
+
+func (r *memoryRepository) ClaimDeliveries(_ context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var claimed []Delivery
+	for i := range r.deliveries {
+		d := &r.deliveries[i]
+		if d.Status != StatusPending || d.NextAttemptAt.After(now) || len(claimed) == limit {
+			continue
+		}
+		leased := now.Add(lease)
+		d.NextAttemptAt = &leased
+		claimed = append(claimed, *d)
+	}
+	return claimed, nil
+}
+
+func (r *memoryRepository) UpdateDelivery(_ context.Context, d *Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries[d.ID-1] = *d
+	return nil
+}
+
+// get : Stored state of delivery id
+func (r *memoryRepository) get(id uint64) Delivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.deliveries[id-1]
+}
+
+// due : Brings the next attempt of the pending deliveries forward to now, instead of waiting for their backoff
+func (r *memoryRepository) due() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for i := range r.deliveries {
+		if r.deliveries[i].Status == StatusPending {
+			r.deliveries[i].NextAttemptAt = &now
+		}
+	}
+}
+
+// receiver : Webhook endpoint answering with status, recording the verification of the requests it gets
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	verified []error
+}
+
+func newReceiver(status int) *receiver {
+	rcv := &receiver{status: status}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.requests = append(rcv.requests, r)
+		rcv.verified = append(rcv.verified, Verify(testSecret, r.Header.Get(SignatureHeader), body, time.Now(), time.Minute))
+		if rcv.status == http.StatusFound {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+			return
+		}
+		w.WriteHeader(rcv.status)
+		_, _ = w.Write([]byte("receiver says " + strconv.Itoa(rcv.status)))
+	}))
+	return rcv
+}
+
+func (rcv *receiver) calls() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return len(rcv.requests)
+}
+
+func (rcv *receiver) answer(status int) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.status = status
+}
+
+var testOptions = Options{
+	Timeout:        5 * time.Second,
+	MaxAttempts:    3,
+	InitialBackoff: time.Minute,
+	MaxBackoff:     time.Hour,
+}
+
+func newTestDispatcher(repo Repository) *Dispatcher {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return NewDispatcher(repo, testOptions, log)
+}
+
+// queue : Pending delivery of a user.created event to subscription 1
+func queue(t *testing.T, repo *memoryRepository) uint64 {
+	now := time.Now()
+	created, err := repo.CreateDeliveries(context.Background(), []Delivery{{
+		SubscriptionID: 1,
+		EventID:        "evt-1",
+		EventType:      "user.created",
+		Payload:        []byte(`{"id":"evt-1","type":"user.created"}`),
+		Status:         StatusPending,
+		NextAttemptAt:  &now,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created[0].ID
+}
+
+func dispatch(t *testing.T, d *Dispatcher, want int) {
+	n, err := d.Dispatch(context.Background())
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if n != want {
+		t.Fatalf("Dispatch() attempted %d deliveries, want %d", n, want)
+	}
+}
+
+func TestDispatchSucceeded(t *testing.T) {
+	rcv := newReceiver(http.StatusNoContent)
+	defer rcv.Close()
+	repo := newMemoryRepository(Subscription{ID: 1, URL: rcv.URL, Secret: testSecret, Active: true})
+	id := queue(t, repo)
+
+	dispatch(t, newTestDispatcher(repo), 1)
+
+	if rcv.calls() != 1 {
+		t.Fatalf("receiver got %d requests, want 1", rcv.calls())
+	}
+	if rcv.verified[0] != nil {
+		t.Errorf("signature of the request : %v", rcv.verified[0])
+	}
+	r := rcv.requests[0]
+	for header, want := range map[string]string{
+		"Content-Type":    "application/json",
+		"X-Webhook-ID":    strconv.FormatUint(id, 10),
+		"X-Webhook-Event": "user.created",
+	} {
+		if got := r.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	d := repo.get(id)
+	if d.Status != StatusSucceeded || d.Attempts != 1 || d.LastStatusCode != http.StatusNoContent || d.LastError != "" {
+		t.Errorf("delivery = %s after %d attempts, last %d %q, want succeeded after 1 with 204",
+			d.Status, d.Attempts, d.LastStatusCode, d.LastError)
+	}
+	if d.NextAttemptAt != nil {
+		t.Errorf("next attempt at %v, want none", d.NextAttemptAt)
+	}
+}
+
+func TestDispatchRetried(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+	}{
+		{"server error", http.StatusInternalServerError},
+		{"client error", http.StatusBadRequest},
+		{"redirect not followed", http.StatusFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rcv := newReceiver(tc.status)
+			defer rcv.Close()
+			repo := newMemoryRepository(Subscription{ID: 1, URL: rcv.URL, Secret: testSecret, Active: true})
+			id := queue(t, repo)
+
+			dispatch(t, newTestDispatcher(repo), 1)
+
+			if rcv.calls() != 1 {
+				t.Fatalf("receiver got %d requests, want 1", rcv.calls())
+			}
+			d := repo.get(id)
+			if d.Status != StatusPending || d.Attempts != 1 || d.LastStatusCode != tc.status {
+				t.Fatalf("delivery = %s after %d attempts, last %d, want pending after 1 with %d",
+					d.Status, d.Attempts, d.LastStatusCode, tc.status)
+			}
+			if !strings.HasPrefix(d.LastError, strconv.Itoa(tc.status)) {
+				t.Errorf("last error = %q, want the status", d.LastError)
+			}
+			if got := d.NextAttemptAt.Sub(*d.LastAttemptAt); got != testOptions.InitialBackoff {
+				t.Errorf("retried after %s, want %s", got, testOptions.InitialBackoff)
+			}
+		})
+	}
+}
+
+func TestDispatchDead(t *testing.T) {
+	rcv := newReceiver(http.StatusServiceUnavailable)
+	defer rcv.Close()
+	repo := newMemoryRepository(Subscription{ID: 1, URL: rcv.URL, Secret: testSecret, Active: true})
+	id := queue(t, repo)
+	d := newTestDispatcher(repo)
+
+	for attempt := 1; attempt < testOptions.MaxAttempts; attempt++ {
+		dispatch(t, d, 1)
+		got := repo.get(id)
+		if got.Status != StatusPending || got.Attempts != attempt {
+			t.Fatalf("delivery = %s after %d attempts, want pending after %d", got.Status, got.Attempts, attempt)
+		}
+		if delay := got.NextAttemptAt.Sub(*got.LastAttemptAt); delay != d.backoff(attempt) {
+			t.Errorf("attempt %d retried after %s, want %s", attempt, delay, d.backoff(attempt))
+		}
+		repo.due()
+	}
+	dispatch(t, d, 1)
+	got := repo.get(id)
+	if got.Status != StatusDead || got.Attempts != testOptions.MaxAttempts || got.NextAttemptAt != nil {
+		t.Fatalf("delivery = %s after %d attempts, next at %v, want dead after %d",
+			got.Status, got.Attempts, got.NextAttemptAt, testOptions.MaxAttempts)
+	}
+
+	// Dead deliveries aren't attempted anymore
+	repo.due()
+	dispatch(t, d, 0)
+	if rcv.calls() != testOptions.MaxAttempts {
+		t.Errorf("receiver got %d requests, want %d", rcv.calls(), testOptions.MaxAttempts)
+	}
+}
+
+func TestDispatchInactive(t *testing.T) {
+	rcv := newReceiver(http.StatusOK)
+	defer rcv.Close()
+	repo := newMemoryRepository(Subscription{ID: 1, URL: rcv.URL, Secret: testSecret, Active: false})
+	id := queue(t, repo)
+
+	dispatch(t, newTestDispatcher(repo), 1)
+
+	if rcv.calls() != 0 {
+		t.Errorf("receiver got %d requests, want none", rcv.calls())
+	}
+	if d := repo.get(id); d.Status != StatusDead || d.LastError != "webhook disabled" {
+		t.Errorf("delivery = %s (%q), want dead as the webhook is disabled", d.Status, d.LastError)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(nil, Options{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}, logrus.New())
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		30: 10 * time.Second,
+	} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestRedeliver(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rcv := newReceiver(http.StatusInternalServerError)
+	defer rcv.Close()
+	repo := newMemoryRepository(Subscription{ID: 1, URL: rcv.URL, Secret: testSecret, Active: true})
+	id := queue(t, repo)
+	d := newTestDispatcher(repo)
+	for i := 0; i < testOptions.MaxAttempts; i++ {
+		repo.due()
+		dispatch(t, d, 1)
+	}
+	if got := repo.get(id); got.Status != StatusDead {
+		t.Fatalf("delivery = %s, want dead", got.Status)
+	}
+
+	r := gin.New()
+	r.Use(problem.Middleware())
+	r.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", NewHandler(repo).Redeliver)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhooks/1/deliveries/"+strconv.FormatUint(id, 10)+"/redeliver", nil))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+
+	rcv.answer(http.StatusOK)
+	dispatch(t, d, 1)
+
+	redelivered := repo.get(id + 1)
+	if redelivered.Status != StatusSucceeded || redelivered.Attempts != 1 {
+		t.Errorf("redelivery = %s after %d attempts, want succeeded after 1", redelivered.Status, redelivered.Attempts)
+	}
+	if redelivered.EventID != "evt-1" || string(redelivered.Payload) != string(repo.get(id).Payload) {
+		t.Errorf("redelivery of %s, want the event and payload of the dead delivery", redelivered.EventID)
+	}
+	if got := repo.get(id); got.Status != StatusDead || got.Attempts != testOptions.MaxAttempts {
+		t.Errorf("dead delivery = %s after %d attempts, want left as is", got.Status, got.Attempts)
+	}
+	if last := rcv.verified[len(rcv.verified)-1]; last != nil {
+		t.Errorf("signature of the redelivery : %v", last)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhooks/1/deliveries/99/redeliver", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d for an unknown delivery, want 404", w.Code)
+	}
+}
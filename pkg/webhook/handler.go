@@ -0,0 +1,465 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+	"github.com/LuD1161/restructuring-tnbt/pkg/logging"
+	"github.com/LuD1161/restructuring-tnbt/pkg/middlewares/problem"
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// minSecretLength : Secrets chosen by the caller must be at least that long
+	minSecretLength = 16
+	// defaultPageSize, maxPageSize : Deliveries listed at once
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Handler : Management of the webhook subscriptions and their deliveries. Errors are rendered by problem.Middleware
+type Handler interface {
+	CreateWebhook(c *gin.Context)
+	ListWebhooks(c *gin.Context)
+	GetWebhook(c *gin.Context)
+	UpdateWebhook(c *gin.Context)
+	DeleteWebhook(c *gin.Context)
+	ListDeliveries(c *gin.Context)
+	GetDelivery(c *gin.Context)
+	Redeliver(c *gin.Context)
+}
+
+var (
+	errMalformedBody = apperrors.Validation("Malformed JSON body")
+	errInvalidID     = apperrors.Validation("Invalid ID", apperrors.FieldError{Field: "id", Message: "must be a positive integer"})
+)
+
+// WebhookPayload : Fields of a subscription, all required on creation but the secret (generated when missing)
+// and active (true by default)
+type WebhookPayload struct {
+	URL    *string  `json:"url"`
+	Events []string `json:"events"`
+	Secret *string  `json:"secret"`
+	Active *bool    `json:"active"`
+}
+
+// CreateWebhookResponse : The subscription along with its secret, which isn't disclosed afterwards
+type CreateWebhookResponse struct {
+	Subscription
+	Secret string `json:"secret"`
+}
+
+// ListWebhooksResponse : Every subscription
+type ListWebhooksResponse struct {
+	Webhooks []Subscription `json:"webhooks"`
+}
+
+// ListDeliveriesResponse : Page of deliveries, the next one is fetched with before=next_before
+type ListDeliveriesResponse struct {
+	Deliveries []Delivery `json:"deliveries"`
+	NextBefore uint64     `json:"next_before,omitempty"`
+}
+
+// DeliveryDetails : Delivery along with its payload
+type DeliveryDetails struct {
+	Delivery
+	Payload json.RawMessage `json:"payload"`
+}
+
+type handler struct {
+	repo Repository
+}
+
+// NewHandler : Handler of the subscriptions stored in repo
+func NewHandler(repo Repository) Handler {
+	return &handler{repo}
+}
+
+// apply : Applies the payload to the subscription, an apperrors.Validation listing the invalid fields
+func (p WebhookPayload) apply(s *Subscription) error {
+	var fields []apperrors.FieldError
+	if p.URL != nil {
+		u, err := url.Parse(*p.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fields = append(fields, apperrors.FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+		}
+		s.URL = *p.URL
+	}
+	if p.Events != nil {
+		events, ok := knownEvents(p.Events)
+		if !ok {
+			fields = append(fields, apperrors.FieldError{Field: "events", Message: "must list some of " + strings.Join(user.EventTypes, ", ")})
+		}
+		s.Events = events
+	}
+	if p.Secret != nil {
+		if len(*p.Secret) < minSecretLength {
+			fields = append(fields, apperrors.FieldError{Field: "secret", Message: "must be at least " + strconv.Itoa(minSecretLength) + " characters long"})
+		}
+		s.Secret = *p.Secret
+	}
+	if p.Active != nil {
+		s.Active = *p.Active
+	}
+	if s.URL == "" && p.URL == nil {
+		fields = append(fields, apperrors.FieldError{Field: "url", Message: "is required"})
+	}
+	if len(s.Events) == 0 && p.Events == nil {
+		fields = append(fields, apperrors.FieldError{Field: "events", Message: "is required"})
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation("Invalid request", fields...)
+	}
+	return nil
+}
+
+// knownEvents : The event types without duplicates, false if one is unknown or there are none
+func knownEvents(events []string) ([]string, bool) {
+	known := make(map[string]bool, len(user.EventTypes))
+	for _, t := range user.EventTypes {
+		known[t] = true
+	}
+	seen := make(map[string]bool, len(events))
+	var unique []string
+	for _, e := range events {
+		if !known[e] {
+			return nil, false
+		}
+		if !seen[e] {
+			seen[e] = true
+			unique = append(unique, e)
+		}
+	}
+	return unique, len(unique) > 0
+}
+
+// newSecret : Random 256 bit secret
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func decode(c *gin.Context, v interface{}) error {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return errMalformedBody.Wrap(err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return errMalformedBody.Wrap(err)
+	}
+	return nil
+}
+
+func parseID(c *gin.Context, param string) (uint64, error) {
+	id, err := strconv.ParseUint(c.Param(param), 10, 64)
+	if err != nil || id == 0 {
+		return 0, errInvalidID
+	}
+	return id, nil
+}
+
+// CreateWebhook godoc
+// @Summary Subscribe to user events
+// @Description Registers a URL receiving the given user events, signed with the secret (generated when missing, only returned here)
+// @Tags Webhooks
+// @Accept  json
+// @Produce  json
+// @Param json body WebhookPayload true "url, events, secret and active"
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Param Idempotency-Key header string false "Unique key of the request, retries with the same key get the first response replayed"
+// @Success 201 {object} CreateWebhookResponse
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /webhooks [post]
+// CreateWebhook : Creates a subscription
+func (h *handler) CreateWebhook(c *gin.Context) {
+	var p WebhookPayload
+	if err := decode(c, &p); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.CreateWebhook"))
+		return
+	}
+	s := &Subscription{Active: true}
+	if err := p.apply(s); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.CreateWebhook"))
+		return
+	}
+	if s.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.CreateWebhook"))
+			return
+		}
+		s.Secret = secret
+	}
+	created, err := h.repo.CreateSubscription(c.Request.Context(), s)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.CreateWebhook"))
+		return
+	}
+	logging.FromContext(c.Request.Context()).WithField("webhook_id", created.ID).Info("Webhook created")
+	c.Header("Location", c.Request.URL.Path+"/"+strconv.FormatUint(created.ID, 10))
+	c.JSON(http.StatusCreated, CreateWebhookResponse{Subscription: *created, Secret: created.Secret})
+}
+
+// ListWebhooks godoc
+// @Summary List the webhooks
+// @Tags Webhooks
+// @Produce  json
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Success 200 {object} ListWebhooksResponse
+// @Failure 401 {object} problem.Problem
+// @Router /webhooks [get]
+// ListWebhooks : Lists every subscription
+func (h *handler) ListWebhooks(c *gin.Context) {
+	subs, err := h.repo.ListSubscriptions(c.Request.Context())
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.ListWebhooks"))
+		return
+	}
+	if subs == nil {
+		subs = []Subscription{}
+	}
+	c.JSON(http.StatusOK, ListWebhooksResponse{Webhooks: subs})
+}
+
+// GetWebhook godoc
+// @Summary Get a webhook
+// @Tags Webhooks
+// @Produce  json
+// @Param   id     path    int     true        "Webhook ID"
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Success 200 {object} Subscription
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /webhooks/{id} [get]
+// GetWebhook : Gets a subscription, without its secret
+func (h *handler) GetWebhook(c *gin.Context) {
+	id, err := parseID(c, "id")
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.GetWebhook"))
+		return
+	}
+	s, err := h.repo.GetSubscription(c.Request.Context(), id)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.GetWebhook"))
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// UpdateWebhook godoc
+// @Summary Update a webhook
+// @Description Changes the given fields : url, events, secret (rotated at once) or active (false pauses the new deliveries)
+// @Tags Webhooks
+// @Accept  json
+// @Produce  json
+// @Param   id     path    int     true        "Webhook ID"
+// @Param json body WebhookPayload true "Fields to change"
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Success 200 {object} Subscription
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /webhooks/{id} [patch]
+// UpdateWebhook : Updates a subscription
+func (h *handler) UpdateWebhook(c *gin.Context) {
+	id, err := parseID(c, "id")
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.UpdateWebhook"))
+		return
+	}
+	var p WebhookPayload
+	if err := decode(c, &p); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.UpdateWebhook"))
+		return
+	}
+	s, err := h.repo.GetSubscription(c.Request.Context(), id)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.UpdateWebhook"))
+		return
+	}
+	if err := p.apply(s); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.UpdateWebhook"))
+		return
+	}
+	updated, err := h.repo.UpdateSubscription(c.Request.Context(), s)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.UpdateWebhook"))
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook
+// @Description Deletes the subscription along with its deliveries, the pending ones are never sent
+// @Tags Webhooks
+// @Param   id     path    int     true        "Webhook ID"
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /webhooks/{id} [delete]
+// DeleteWebhook : Deletes a subscription
+func (h *handler) DeleteWebhook(c *gin.Context) {
+	id, err := parseID(c, "id")
+	if err == nil {
+		err = h.repo.DeleteSubscription(c.Request.Context(), id)
+	}
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.DeleteWebhook"))
+		return
+	}
+	logging.FromContext(c.Request.Context()).WithField("webhook_id", id).Info("Webhook deleted")
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries godoc
+// @Summary List the deliveries of a webhook
+// @Description Newest first, the next page is fetched by passing back next_before as before
+// @Tags Webhooks
+// @Produce  json
+// @Param   id     path    int     true        "Webhook ID"
+// @Param   before query   int     false       "Only the deliveries older than this one"
+// @Param   limit  query   int     false       "Page size, 20 by default, at most 100"
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Success 200 {object} ListDeliveriesResponse
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Router /webhooks/{id}/deliveries [get]
+// ListDeliveries : Delivery log of a subscription
+func (h *handler) ListDeliveries(c *gin.Context) {
+	id, err := parseID(c, "id")
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.ListDeliveries"))
+		return
+	}
+	var before uint64
+	limit := defaultPageSize
+	var fields []apperrors.FieldError
+	if v := c.Query("before"); v != "" {
+		if before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			fields = append(fields, apperrors.FieldError{Field: "before", Message: "must be a delivery ID"})
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			fields = append(fields, apperrors.FieldError{Field: "limit", Message: "must be a positive integer"})
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+	}
+	if len(fields) > 0 {
+		problem.Abort(c, errors.Wrap(apperrors.Validation("Invalid query", fields...), "pkg.webhook.handler.ListDeliveries"))
+		return
+	}
+	ctx := c.Request.Context()
+	if _, err := h.repo.GetSubscription(ctx, id); err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.ListDeliveries"))
+		return
+	}
+	deliveries, err := h.repo.ListDeliveries(ctx, id, before, limit)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.ListDeliveries"))
+		return
+	}
+	resp := ListDeliveriesResponse{Deliveries: deliveries}
+	if resp.Deliveries == nil {
+		resp.Deliveries = []Delivery{}
+	}
+	if len(deliveries) == limit {
+		resp.NextBefore = deliveries[len(deliveries)-1].ID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetDelivery godoc
+// @Summary Get a delivery
+// @Description The delivery along with its payload
+// @Tags Webhooks
+// @Produce  json
+// @Param   id           path    int     true        "Webhook ID"
+// @Param   delivery_id  path    int     true        "Delivery ID"
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Success 200 {object} DeliveryDetails
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /webhooks/{id}/deliveries/{delivery_id} [get]
+// GetDelivery : Gets a delivery of a subscription
+func (h *handler) GetDelivery(c *gin.Context) {
+	d, err := h.delivery(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.GetDelivery"))
+		return
+	}
+	c.JSON(http.StatusOK, DeliveryDetails{Delivery: *d, Payload: d.Payload})
+}
+
+// Redeliver godoc
+// @Summary Redeliver an event
+// @Description Queues a new delivery of the event of a previous one, whatever its status, sent with the current URL and secret
+// @Tags Webhooks
+// @Produce  json
+// @Param   id           path    int     true        "Webhook ID"
+// @Param   delivery_id  path    int     true        "Delivery ID"
+// @Param Authorization header string true "Admin token, starting with the Bearer"
+// @Param Idempotency-Key header string false "Unique key of the request, retries with the same key get the first response replayed"
+// @Success 202 {object} Delivery
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Router /webhooks/{id}/deliveries/{delivery_id}/redeliver [post]
+// Redeliver : Queues the event of a delivery again
+func (h *handler) Redeliver(c *gin.Context) {
+	d, err := h.delivery(c)
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.Redeliver"))
+		return
+	}
+	now := time.Now()
+	created, err := h.repo.CreateDeliveries(c.Request.Context(), []Delivery{{
+		SubscriptionID: d.SubscriptionID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Payload:        d.Payload,
+		Status:         StatusPending,
+		NextAttemptAt:  &now,
+	}})
+	if err != nil {
+		problem.Abort(c, errors.Wrap(err, "pkg.webhook.handler.Redeliver"))
+		return
+	}
+	logging.FromContext(c.Request.Context()).WithFields(map[string]interface{}{
+		"webhook_id":  d.SubscriptionID,
+		"delivery_id": created[0].ID,
+		"redelivers":  d.ID,
+	}).Info("Webhook delivery queued again")
+	c.JSON(http.StatusAccepted, created[0])
+}
+
+// delivery : Delivery of the path
+func (h *handler) delivery(c *gin.Context) (*Delivery, error) {
+	id, err := parseID(c, "id")
+	if err != nil {
+		return nil, err
+	}
+	deliveryID, err := strconv.ParseUint(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		return nil, ErrDeliveryNotFound
+	}
+	return h.repo.GetDelivery(c.Request.Context(), id, deliveryID)
+}
@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/user"
+	"github.com/pkg/errors"
+)
+
+type publisher struct {
+	repo Repository
+}
+
+// NewPublisher : user.Publisher queuing a delivery of every event for each subscription wanting it, which the
+// Dispatcher then sends
+func NewPublisher(repo Repository) user.Publisher {
+	return &publisher{repo}
+}
+
+func (p *publisher) Publish(ctx context.Context, e user.Event) error {
+	subs, err := p.repo.ListSubscriptions(ctx)
+	if err != nil {
+		return errors.Wrap(err, "pkg.webhook.publisher.Publish")
+	}
+	// Receivers can deduplicate on the event ID, which the retries and redeliveries keep
+	body, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "pkg.webhook.publisher.Publish")
+	}
+	now := time.Now()
+	var deliveries []Delivery
+	for i := range subs {
+		if !subs[i].Wants(e.Type) {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			SubscriptionID: subs[i].ID,
+			EventID:        e.ID,
+			EventType:      e.Type,
+			Payload:        body,
+			Status:         StatusPending,
+			NextAttemptAt:  &now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if _, err := p.repo.CreateDeliveries(ctx, deliveries); err != nil {
+		return errors.Wrap(err, "pkg.webhook.publisher.Publish")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SignatureHeader : Header of the deliveries holding their signature, t=<unix timestamp>,v1=<hex HMAC-SHA256>
+const SignatureHeader = "X-Webhook-Signature"
+
+var (
+	errMalformedSignature = errors.New("malformed signature header")
+	errSignatureMismatch  = errors.New("signature mismatch")
+	errSignatureExpired   = errors.New("signature timestamp out of tolerance")
+)
+
+// Sign : Signature header of a payload sent at t. The MAC covers "<timestamp>.<payload>", so that a captured request
+// can't be replayed with another timestamp.
+func Sign(secret string, t time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + mac(secret, timestamp, payload)
+}
+
+// Verify : Checks the signature header of a payload received at now, rejecting the timestamps further than tolerance
+// from it. Receivers written in Go can use it as is.
+func Verify(secret, header string, payload []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return errMalformedSignature
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errMalformedSignature
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return errSignatureExpired
+	}
+	expected := mac(secret, timestamp, payload)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return errSignatureMismatch
+}
+
+func mac(secret, timestamp string, payload []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "0123456789abcdef"
+	payload := []byte(`{"type":"user.created"}`)
+	sent := time.Unix(1792368000, 0)
+	signed := Sign(secret, sent, payload)
+
+	for _, tc := range []struct {
+		name    string
+		secret  string
+		header  string
+		payload []byte
+		now     time.Time
+		want    error
+	}{
+		{"valid", secret, signed, payload, sent, nil},
+		{"within the tolerance", secret, signed, payload, sent.Add(4 * time.Minute), nil},
+		{"clock skew within the tolerance", secret, signed, payload, sent.Add(-4 * time.Minute), nil},
+		{"one of several signatures", secret, signed + ",v1=" + strings.Repeat("0", 64), payload, sent, nil},
+		{"other secret", "fedcba9876543210", signed, payload, sent, errSignatureMismatch},
+		{"tampered payload", secret, signed, []byte(`{"type":"user.deleted"}`), sent, errSignatureMismatch},
+		{"replayed with another timestamp", secret, strings.Replace(signed, "t=1792368000", "t=1792368060", 1), payload, sent, errSignatureMismatch},
+		{"expired", secret, signed, payload, sent.Add(6 * time.Minute), errSignatureExpired},
+		{"from the future", secret, signed, payload, sent.Add(-6 * time.Minute), errSignatureExpired},
+		{"no timestamp", secret, "v1=" + mac(secret, "1792368000", payload), payload, sent, errMalformedSignature},
+		{"no signature", secret, "t=1792368000", payload, sent, errMalformedSignature},
+		{"not key=value", secret, "t=1792368000,garbage", payload, sent, errMalformedSignature},
+		{"empty", secret, "", payload, sent, errMalformedSignature},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := Verify(tc.secret, tc.header, tc.payload, tc.now, 5*time.Minute); err != tc.want {
+				t.Errorf("Verify() = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	header := Sign("0123456789abcdef", time.Unix(1792368000, 0), []byte("{}"))
+	if !strings.HasPrefix(header, "t=1792368000,v1=") {
+		t.Fatalf("Sign() = %q, want t=1792368000,v1=<hex>", header)
+	}
+	if n := len(strings.TrimPrefix(header, "t=1792368000,v1=")); n != 64 {
+		t.Errorf("HMAC-SHA256 of %d hex digits, want 64", n)
+	}
+}
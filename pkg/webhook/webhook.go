@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/LuD1161/restructuring-tnbt/pkg/apperrors"
+)
+
+// Statuses of a delivery
+const (
+	StatusPending   = "pending"   // Waiting for its first or next attempt
+	StatusSucceeded = "succeeded" // The receiver answered with a 2xx
+	StatusDead      = "dead"      // Given up on after the last attempt, until it is redelivered
+)
+
+var (
+	// ErrSubscriptionNotFound : Returned by the Repository when no subscription matches the lookup
+	ErrSubscriptionNotFound = apperrors.NotFound("Webhook Not Found")
+	// ErrDeliveryNotFound : Returned by the Repository when no delivery of the subscription matches the lookup
+	ErrDeliveryNotFound = apperrors.NotFound("Delivery Not Found")
+)
+
+// Subscription : Endpoint receiving the events of the given types
+type Subscription struct {
+	ID        uint64    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"` // user.Event types
+	Secret    string    `json:"-"`      // Key of the HMAC signing the payloads, only disclosed on creation
+	Active    bool      `json:"active"` // Inactive subscriptions get no new deliveries
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Wants : Whether the subscription receives the events of the type
+func (s *Subscription) Wants(eventType string) bool {
+	if !s.Active {
+		return false
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery : Event sent, or to be sent, to a subscription. Its attempts are summed up by the last one.
+type Delivery struct {
+	ID             uint64     `json:"id"`
+	SubscriptionID uint64     `json:"webhook_id"`
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Payload        []byte     `json:"-"` // Body of the requests, the same on every attempt
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"` // Set while pending
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	LastStatusCode int        `json:"last_status_code,omitempty"` // 0 when the request failed before a response
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Repository : Storage of the subscriptions and their deliveries
+type Repository interface {
+	CreateSubscription(context.Context, *Subscription) (*Subscription, error)
+	GetSubscription(context.Context, uint64) (*Subscription, error)
+	ListSubscriptions(context.Context) ([]Subscription, error) // Sorted by ID
+	UpdateSubscription(context.Context, *Subscription) (*Subscription, error)
+	DeleteSubscription(context.Context, uint64) error // Deletes its deliveries as well
+
+	CreateDeliveries(context.Context, []Delivery) ([]Delivery, error)
+	GetDelivery(ctx context.Context, subscriptionID, id uint64) (*Delivery, error)
+	// ListDeliveries : Deliveries of the subscription, newest first, with an ID below beforeID unless it is 0
+	ListDeliveries(ctx context.Context, subscriptionID, beforeID uint64, limit int) ([]Delivery, error)
+	// ClaimDeliveries : Pending deliveries due at now, postponed by lease so that no other instance attempts them
+	// meanwhile. A delivery whose outcome isn't recorded within the lease is attempted again.
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error)
+	// UpdateDelivery : Records the outcome of an attempt : status, attempts, next and last attempt
+	UpdateDelivery(context.Context, *Delivery) error
+}